@@ -19,6 +19,19 @@ type APICreateRoleRequest struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
 	QPS      int    `json:"qps,omitempty"`
+
+	V3Endpoint         string `json:"v3_endpoint,omitempty"`
+	OAuthTokenEndpoint string `json:"oauth_token_endpoint,omitempty"`
+	V2Endpoint         string `json:"v2_endpoint,omitempty"`
+
+	DefaultTTL     string `json:"default_ttl,omitempty"`
+	MaxTTL         string `json:"max_ttl,omitempty"`
+	RenewIncrement string `json:"renew_increment,omitempty"`
+
+	ScopeAllow []string `json:"scope_allow,omitempty"`
+	ScopeDeny  []string `json:"scope_deny,omitempty"`
+
+	RecipientKeyType string `json:"recipient_key_type,omitempty"`
 }
 
 type APIRoleDataExportRequest struct {
@@ -29,6 +42,9 @@ type APIRoleDataExportRequest struct {
 	V3Only          bool   `json:"v3_only,omitempty"`
 	ExplicitQPS     int    `json:"explicit_qps,omitempty"`
 	ForceProxyMode  bool   `json:"force_proxy_mode,omitempty"`
+
+	ScopeAllow []string `json:"scope_allow,omitempty"`
+	ScopeDeny  []string `json:"scope_deny,omitempty"`
 }
 
 type APIConfigRequest struct {
@@ -39,6 +55,14 @@ type APIConfigRequest struct {
 	CLIWriteEnabled        *bool  `json:"enable_cli_v3_write,omitempty"`
 	NetworkLatency         string `json:"net_latency,omitempty"`
 	TLSPinning             string `json:"tls_pinning,omitempty"`
+	V3Endpoint             string `json:"v3_endpoint,omitempty"`
+	OAuthTokenEndpoint     string `json:"oauth_token_endpoint,omitempty"`
+	V2Endpoint             string `json:"v2_endpoint,omitempty"`
+	DefaultTTL             string `json:"default_ttl,omitempty"`
+	MaxTTL                 string `json:"max_ttl,omitempty"`
+	RenewIncrement         string `json:"renew_increment,omitempty"`
+	AllowUnsignedImports   *bool  `json:"allow_unsigned_imports,omitempty"`
+	RecipientKeyType       string `json:"recipient_key_type,omitempty"`
 }
 
 type APIRoleDataImportRequest struct {
@@ -2,6 +2,7 @@ package mashery
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
@@ -63,21 +64,80 @@ type PemContext interface {
 	CarryPEMBlock(block *pem.Block)
 }
 
+type ExporterIdentityContext interface {
+	GetExporterIdentity() *ecdsa.PrivateKey
+	CarryExporterIdentity(pk *ecdsa.PrivateKey)
+}
+
+type ExporterIdentityContainer struct {
+	identity *ecdsa.PrivateKey
+}
+
+func (c *ExporterIdentityContainer) GetExporterIdentity() *ecdsa.PrivateKey {
+	return c.identity
+}
+
+func (c *ExporterIdentityContainer) CarryExporterIdentity(pk *ecdsa.PrivateKey) {
+	c.identity = pk
+}
+
+type TrustedExportersContext interface {
+	GetTrustedExporters() *StoredTrustedExporters
+}
+
+type TrustedExportersContainer struct {
+	exporters StoredTrustedExporters
+}
+
+func (c *TrustedExportersContainer) GetTrustedExporters() *StoredTrustedExporters {
+	return &c.exporters
+}
+
+type RecipientIdentityContext interface {
+	GetRecipientIdentity() *StoredRecipientIdentity
+}
+
+type RecipientIdentityContainer struct {
+	identity StoredRecipientIdentity
+}
+
+func (c *RecipientIdentityContainer) GetRecipientIdentity() *StoredRecipientIdentity {
+	return &c.identity
+}
+
+// RecipientImportContext context of importing the role data received by a recipient identity into a new role
+type RecipientImportContext interface {
+	RoleContext
+	RecipientIdentityContext
+}
+
+type RecipientImportContainer struct {
+	RoleContainer
+	RecipientIdentityContainer
+}
+
 type RoleExportContext interface {
 	RoleContext
+	ExporterIdentityContext
 
 	GetRecipientCertificate() *x509.Certificate
 	GetRecipientName() string
 
 	CarryRecipientCertificate(cert *x509.Certificate)
 	CarryRecipientName(name string)
+
+	// GetExportRecords the exports made while handling the request, keyed by the export ID
+	GetExportRecords() map[string]RoleExportRecord
+	CarryExportRecord(exportID string, rec RoleExportRecord)
 }
 
 type RoleExportContainer struct {
 	RoleContainer
+	ExporterIdentityContainer
 
 	cert      *x509.Certificate
 	recipeint string
+	records   map[string]RoleExportRecord
 }
 
 func (cc *RoleExportContainer) GetRecipientCertificate() *x509.Certificate {
@@ -95,6 +155,32 @@ func (cc *RoleExportContainer) CarryRecipientName(name string) {
 	cc.recipeint = name
 }
 
+func (cc *RoleExportContainer) GetExportRecords() map[string]RoleExportRecord {
+	return cc.records
+}
+
+func (cc *RoleExportContainer) CarryExportRecord(exportID string, rec RoleExportRecord) {
+	if cc.records == nil {
+		cc.records = map[string]RoleExportRecord{}
+	}
+	cc.records[exportID] = rec
+}
+
+// MountBackupContext context of taking and restoring the backup of the mount
+type MountBackupContext interface {
+	ExporterIdentityContext
+	GetMountBackup() *MountBackup
+}
+
+type MountBackupContainer struct {
+	ExporterIdentityContainer
+	backup MountBackup
+}
+
+func (c *MountBackupContainer) GetMountBackup() *MountBackup {
+	return &c.backup
+}
+
 type BackendConfigurationContext interface {
 	GetBackendConfiguration() *BackendConfiguration
 	CarryBackendConfiguration(cfg BackendConfiguration)
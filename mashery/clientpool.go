@@ -0,0 +1,141 @@
+package mashery
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of clients each pool retains. Least recently used clients are closed when the pool grows
+	// beyond this size.
+	maxPooledClients = 128
+)
+
+// ClientPool is a concurrency-safe, bounded pool of Mashery API clients. The pool keeps the clients in the
+// least-recently-used order; the clients that are not used for a while are evicted by the periodic housekeeping.
+type ClientPool[T any] struct {
+	lock sync.Mutex
+
+	maxSize int
+	lru     *list.List
+	entries map[string]*list.Element
+
+	closer func(context.Context, T)
+}
+
+type pooledClient[T any] struct {
+	key      string
+	client   T
+	lastUsed time.Time
+}
+
+// NewClientPool creates a new pool retaining at most maxSize clients. The closer function is invoked for
+// every client leaving the pool.
+func NewClientPool[T any](maxSize int, closer func(context.Context, T)) *ClientPool[T] {
+	return &ClientPool[T]{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		closer:  closer,
+	}
+}
+
+// Get returns the client stored under the key, creating it with the factory if the pool doesn't hold it yet.
+func (p *ClientPool[T]) Get(ctx context.Context, key string, factory func() T) T {
+	p.lock.Lock()
+
+	if elem, ok := p.entries[key]; ok {
+		entry := elem.Value.(*pooledClient[T])
+		entry.lastUsed = time.Now()
+		p.lru.MoveToFront(elem)
+
+		p.lock.Unlock()
+		return entry.client
+	}
+
+	entry := &pooledClient[T]{
+		key:      key,
+		client:   factory(),
+		lastUsed: time.Now(),
+	}
+	p.entries[key] = p.lru.PushFront(entry)
+
+	var evicted []*pooledClient[T]
+	for p.maxSize > 0 && p.lru.Len() > p.maxSize {
+		evicted = append(evicted, p.remove(p.lru.Back()))
+	}
+	p.lock.Unlock()
+
+	p.close(ctx, evicted)
+	return entry.client
+}
+
+// EvictIdle closes and removes the clients that were last used before the cutover time.
+func (p *ClientPool[T]) EvictIdle(ctx context.Context, cutover time.Time) {
+	p.lock.Lock()
+
+	var evicted []*pooledClient[T]
+	for elem := p.lru.Back(); elem != nil; {
+		entry := elem.Value.(*pooledClient[T])
+		if !entry.lastUsed.Before(cutover) {
+			// Remaining elements were used more recently
+			break
+		}
+
+		prev := elem.Prev()
+		evicted = append(evicted, p.remove(elem))
+		elem = prev
+	}
+	p.lock.Unlock()
+
+	p.close(ctx, evicted)
+}
+
+// EvictPrefix closes and removes all clients having keys starting with the prefix.
+func (p *ClientPool[T]) EvictPrefix(ctx context.Context, prefix string) {
+	p.lock.Lock()
+
+	var evicted []*pooledClient[T]
+	for k, elem := range p.entries {
+		if strings.HasPrefix(k, prefix) {
+			evicted = append(evicted, p.remove(elem))
+		}
+	}
+	p.lock.Unlock()
+
+	p.close(ctx, evicted)
+}
+
+// Purge closes and removes all clients from the pool.
+func (p *ClientPool[T]) Purge(ctx context.Context) {
+	p.EvictPrefix(ctx, "")
+}
+
+// Len returns the number of clients currently held in the pool.
+func (p *ClientPool[T]) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.lru.Len()
+}
+
+// remove removes the element from the pool. The caller must hold the lock.
+func (p *ClientPool[T]) remove(elem *list.Element) *pooledClient[T] {
+	entry := p.lru.Remove(elem).(*pooledClient[T])
+	delete(p.entries, entry.key)
+
+	return entry
+}
+
+func (p *ClientPool[T]) close(ctx context.Context, evicted []*pooledClient[T]) {
+	if p.closer == nil {
+		return
+	}
+
+	for _, entry := range evicted {
+		p.closer(ctx, entry.client)
+	}
+}
@@ -0,0 +1,148 @@
+package mashery
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type closeRecorder struct {
+	lock   sync.Mutex
+	closed []string
+}
+
+func (cr *closeRecorder) close(_ context.Context, s string) {
+	cr.lock.Lock()
+	defer cr.lock.Unlock()
+
+	cr.closed = append(cr.closed, s)
+}
+
+func constFactory(s string) func() string {
+	return func() string {
+		return s
+	}
+}
+
+func TestClientPool_WillReuseClient(t *testing.T) {
+	pool := NewClientPool[string](2, nil)
+
+	assert.Equal(t, "a", pool.Get(context.TODO(), "k", constFactory("a")))
+	assert.Equal(t, "a", pool.Get(context.TODO(), "k", constFactory("b")))
+	assert.Equal(t, 1, pool.Len())
+}
+
+func TestClientPool_WillEvictLeastRecentlyUsed(t *testing.T) {
+	rec := closeRecorder{}
+	pool := NewClientPool[string](2, rec.close)
+
+	pool.Get(context.TODO(), "a", constFactory("a"))
+	pool.Get(context.TODO(), "b", constFactory("b"))
+	// Touching a makes b the least recently used client
+	pool.Get(context.TODO(), "a", constFactory("a"))
+	pool.Get(context.TODO(), "c", constFactory("c"))
+
+	assert.Equal(t, 2, pool.Len())
+	assert.Equal(t, []string{"b"}, rec.closed)
+}
+
+func TestClientPool_EvictIdleTracksLastUse(t *testing.T) {
+	rec := closeRecorder{}
+	pool := NewClientPool[string](10, rec.close)
+
+	pool.Get(context.TODO(), "a", constFactory("a"))
+	pool.Get(context.TODO(), "b", constFactory("b"))
+
+	cutover := time.Now().Add(time.Millisecond)
+	time.Sleep(time.Millisecond * 2)
+	pool.Get(context.TODO(), "a", constFactory("a"))
+
+	pool.EvictIdle(context.TODO(), cutover)
+	assert.Equal(t, 1, pool.Len())
+	assert.Equal(t, []string{"b"}, rec.closed)
+}
+
+func TestClientPool_EvictPrefix(t *testing.T) {
+	rec := closeRecorder{}
+	pool := NewClientPool[string](10, rec.close)
+
+	pool.Get(context.TODO(), "uuid::role::1", constFactory("1"))
+	pool.Get(context.TODO(), "uuid::role::2", constFactory("2"))
+	pool.Get(context.TODO(), "uuid::roleB::1", constFactory("3"))
+
+	pool.EvictPrefix(context.TODO(), "uuid::role::")
+	assert.Equal(t, 1, pool.Len())
+	assert.ElementsMatch(t, []string{"1", "2"}, rec.closed)
+
+	pool.Purge(context.TODO())
+	assert.Equal(t, 0, pool.Len())
+}
+
+func TestClientPool_ConcurrentAccess(t *testing.T) {
+	pool := NewClientPool[string](8, nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("k%d", (i+j)%12)
+				pool.Get(context.TODO(), key, constFactory(key))
+				if j%10 == 0 {
+					pool.EvictIdle(context.TODO(), time.Now().Add(-time.Second))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.True(t, pool.Len() <= 8)
+}
+
+func TestGetClientLookupKey_ChangesWithCredentials(t *testing.T) {
+	b := AuthPlugin{backendUUID: "uuid"}
+	role := StoredRole{
+		Name: "role",
+		Keys: RoleKeys{
+			ApiKey:    "key",
+			KeySecret: "secret",
+		},
+	}
+
+	k1 := b.getClientLookupKey(&role)
+	assert.Equal(t, k1, b.getClientLookupKey(&role))
+
+	role.Keys.KeySecret = "rotated"
+	k2 := b.getClientLookupKey(&role)
+	assert.NotEqual(t, k1, k2)
+	assert.Contains(t, k2, b.roleClientKeyPrefix("role"))
+}
+
+func TestAcceptConfigurationUpdate_IsSafeForConcurrentReaders(t *testing.T) {
+	b, _ := makeNew(&logical.BackendConfig{BackendUUID: "uuid"})
+	role := &StoredRole{Name: "role"}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if i == 0 {
+					b.AcceptConfigurationUpdate(context.TODO(), BackendConfiguration{NetworkLatency: j})
+				} else {
+					assert.NotNil(t, b.GetOAuthHelper(role))
+					assert.True(t, len(b.getClientLookupKey(role)) > 0)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 99, b.config().NetworkLatency)
+}
@@ -2,13 +2,20 @@ package mashery
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/transport"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -21,6 +28,19 @@ const (
 	rolePasswordField = "password"
 	roleQpsField      = "qps"
 
+	roleV3EndpointField         = "v3_endpoint"
+	roleOAuthTokenEndpointField = "oauth_token_endpoint"
+	roleV2EndpointField         = "v2_endpoint"
+
+	roleDefaultTTLField     = "default_ttl"
+	roleMaxTTLField         = "max_ttl"
+	roleRenewIncrementField = "renew_increment"
+
+	roleScopeAllowField = "scope_allow"
+	roleScopeDenyField  = "scope_deny"
+
+	roleRecipientKeyTypeField = "recipient_key_type"
+
 	secretAccessToken              = "access_token"
 	secretAccessTokenExpiryTime    = "expiry"
 	secretAccessTokenExpiryEpoch   = "expiry_epoch"
@@ -33,6 +53,13 @@ const (
 	secretInternalTokenExpiryTime = "token_expiry_time"
 )
 
+const (
+	// Lifetime of the access token Mashery issues
+	masheryV3TokenLifetime = time.Hour
+	// Time Mashery accepts the V2 signature for
+	masheryV2SignatureLifetime = time.Minute * 5
+)
+
 const (
 	TLSPinningDefault = iota
 	TLSPinningSystem
@@ -58,11 +85,28 @@ type BackendConfiguration struct {
 	LeafCertPin   transport.TLSCertChainPin `json:"_leaf_pin"`
 	IssuerCertPin transport.TLSCertChainPin `json:"_int_pin"`
 	RootCertPin   transport.TLSCertChainPin `json:"_root_pin"`
+
+	// Mashery API endpoints. Empty values will use the public Mashery hosts.
+	V3Endpoint         string `json:"_v3_ep,omitempty"`
+	OAuthTokenEndpoint string `json:"_oauth_ep,omitempty"`
+	V2Endpoint         string `json:"_v2_ep,omitempty"`
+
+	// Lease settings, in seconds, for the roles that do not configure these. Zero values will use the
+	// built-in defaults.
+	LeaseDefaultTTL     int64 `json:"_l_ttl,omitempty"`
+	LeaseMaxTTL         int64 `json:"_l_max_ttl,omitempty"`
+	LeaseRenewIncrement int64 `json:"_l_inc,omitempty"`
+
+	// Whether to import the role data that is not signed by a trusted exporter
+	AllowUnsignedImports bool `json:"_unsigned_imp,omitempty"`
+
+	// Type of the recipient keys generated for the roles that do not configure it
+	RecipientKeyType string `json:"_rkt,omitempty"`
 }
 
 func (b *AuthPlugin) DoIfCLIWriteEnabled(cb framework.OperationFunc) framework.OperationFunc {
 	return func(ctx context.Context, request *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-		if b.cfg.CLIWriteEnabled {
+		if b.config().CLIWriteEnabled {
 			return cb(ctx, request, data)
 		} else {
 			return logical.ErrorResponse("cli write operations are disabled for V3 API"), nil
@@ -168,6 +212,119 @@ type RoleKeys struct {
 	ForceProxyMode bool   `json:"fpm,omitempty"`
 	Imported       bool   `json:"_imp"`
 	Exportable     bool   `json:"_exp"`
+
+	// Role-specific Mashery API endpoints, taking precedence over the mount configuration
+	V3Endpoint         string `json:"v3e,omitempty"`
+	OAuthTokenEndpoint string `json:"ote,omitempty"`
+	V2Endpoint         string `json:"v2e,omitempty"`
+
+	// Role-specific lease settings in seconds, taking precedence over the mount configuration
+	LeaseDefaultTTL     int64 `json:"lttl,omitempty"`
+	LeaseMaxTTL         int64 `json:"lmttl,omitempty"`
+	LeaseRenewIncrement int64 `json:"linc,omitempty"`
+
+	// Scope rules restricting the V3 operations and V2 methods this role may invoke; see ScopeRule
+	AllowedScope []string `json:"alw,omitempty"`
+	DeniedScope  []string `json:"dny,omitempty"`
+	// Scopes sealed into the exchange by the exporter(s) of an imported role
+	InheritedScope []RoleScope `json:"isc,omitempty"`
+	// Verified identity of the exporter of an imported role, and the fingerprint of the exporter's public key
+	Exporter            string `json:"_exr,omitempty"`
+	ExporterFingerprint string `json:"_exf,omitempty"`
+	// Identifier of the export an imported role was imported from, and the time it had to be imported before
+	ExportID           string `json:"_xid,omitempty"`
+	ExportImportBefore int64  `json:"_xib,omitempty"`
+	// Number of further re-exports an imported role may delegate, and the exports it was delegated through
+	DelegationDepth int              `json:"_xdd,omitempty"`
+	Provenance      []ProvenanceLink `json:"_prv,omitempty"`
+	// Control messages of the exporter applied to an imported role; a revoked role holds no credentials anymore
+	AppliedControls []string `json:"_ctl,omitempty"`
+	Revoked         bool     `json:"_rvk,omitempty"`
+
+	// Type of the key the role data is encrypted for, taking precedence over the mount configuration
+	RecipientKeyType string `json:"rkt,omitempty"`
+
+	// Period in seconds after which the password of the Mashery user is rotated, and the epoch time the password
+	// was last changed
+	PasswordRotationPeriod int64 `json:"prp,omitempty"`
+	PasswordChanged        int64 `json:"pch,omitempty"`
+	// Period in seconds after which the secret of the package key is rotated, and the epoch time the secret was
+	// last changed
+	SecretRotationPeriod int64 `json:"srp,omitempty"`
+	SecretChanged        int64 `json:"sch,omitempty"`
+	// Epoch time of the last failed scheduled rotation of the password and of the secret
+	PasswordRotationFailed int64 `json:"prf,omitempty"`
+	SecretRotationFailed   int64 `json:"srf,omitempty"`
+}
+
+// PasswordRotationDue whether the rotation period of the password has passed by the specified time, and the
+// scheduled rotation is not backing off after a failure
+func (ar *RoleKeys) PasswordRotationDue(t time.Time) bool {
+	return ar.PasswordRotationPeriod > 0 && ar.PasswordChanged+ar.PasswordRotationPeriod <= t.Unix() &&
+		ar.PasswordRotationFailed+scheduledRotationRetryDelay <= t.Unix()
+}
+
+// SecretRotationDue whether the rotation period of the package key secret has passed by the specified time, and
+// the scheduled rotation is not backing off after a failure
+func (ar *RoleKeys) SecretRotationDue(t time.Time) bool {
+	return ar.SecretRotationPeriod > 0 && ar.SecretChanged+ar.SecretRotationPeriod <= t.Unix() &&
+		ar.SecretRotationFailed+scheduledRotationRetryDelay <= t.Unix()
+}
+
+// LeaseShape the settings of the leases issued for a role. Zero values indicate that the built-in default
+// of the specific lease type applies.
+type LeaseShape struct {
+	DefaultTTL     time.Duration
+	MaxTTL         time.Duration
+	RenewIncrement time.Duration
+}
+
+// Validate checks that the lease settings are consistent with each other and with the lifetime of the
+// Mashery access token.
+func (ls LeaseShape) Validate() error {
+	if ls.DefaultTTL < 0 || ls.MaxTTL < 0 || ls.RenewIncrement < 0 {
+		return errors.New("lease durations cannot be negative")
+	}
+	if ls.MaxTTL > 0 && ls.DefaultTTL > ls.MaxTTL {
+		return errors.New(fmt.Sprintf("%s (%s) exceeds %s (%s)", roleDefaultTTLField, ls.DefaultTTL, roleMaxTTLField, ls.MaxTTL))
+	}
+	if ls.MaxTTL > 0 && ls.RenewIncrement > ls.MaxTTL {
+		return errors.New(fmt.Sprintf("%s (%s) exceeds %s (%s)", roleRenewIncrementField, ls.RenewIncrement, roleMaxTTLField, ls.MaxTTL))
+	}
+	if ls.DefaultTTL > masheryV3TokenLifetime {
+		return errors.New(fmt.Sprintf("%s (%s) exceeds the lifetime of Mashery access token (%s)", roleDefaultTTLField, ls.DefaultTTL, masheryV3TokenLifetime))
+	}
+	if ls.RenewIncrement > masheryV3TokenLifetime {
+		return errors.New(fmt.Sprintf("%s (%s) exceeds the lifetime of Mashery access token (%s)", roleRenewIncrementField, ls.RenewIncrement, masheryV3TokenLifetime))
+	}
+
+	return nil
+}
+
+func (bc *BackendConfiguration) LeaseShape() LeaseShape {
+	return LeaseShape{
+		DefaultTTL:     time.Second * time.Duration(bc.LeaseDefaultTTL),
+		MaxTTL:         time.Second * time.Duration(bc.LeaseMaxTTL),
+		RenewIncrement: time.Second * time.Duration(bc.LeaseRenewIncrement),
+	}
+}
+
+// EffectiveLeaseShape lease settings for this role; the settings the role doesn't configure are taken from the
+// mount configuration.
+func (ar *RoleKeys) EffectiveLeaseShape(cfg *BackendConfiguration) LeaseShape {
+	rv := cfg.LeaseShape()
+
+	if ar.LeaseDefaultTTL > 0 {
+		rv.DefaultTTL = time.Second * time.Duration(ar.LeaseDefaultTTL)
+	}
+	if ar.LeaseMaxTTL > 0 {
+		rv.MaxTTL = time.Second * time.Duration(ar.LeaseMaxTTL)
+	}
+	if ar.LeaseRenewIncrement > 0 {
+		rv.RenewIncrement = time.Second * time.Duration(ar.LeaseRenewIncrement)
+	}
+
+	return rv
 }
 
 type RoleUsageTerm struct {
@@ -179,6 +336,191 @@ type RoleUsageTerm struct {
 type RoleDataExchange struct {
 	RoleData  RoleKeys       `json:"d"`
 	UsageTerm *RoleUsageTerm `json:"u,omitempty"`
+	// Scopes the recipient is restricted to. The recipient must observe all of these.
+	Scope []RoleScope `json:"s,omitempty"`
+
+	// Unique identifier of the export; the importers refuse to import the same export twice
+	ExportID string `json:"id,omitempty"`
+	// Unix time the data was exported at, and the time it must be imported before
+	Issued       int64 `json:"iat,omitempty"`
+	ImportBefore int64 `json:"ib,omitempty"`
+
+	// Number of further re-exports the recipient may delegate, and the exports the data was delegated through,
+	// the original export first
+	DelegationDepth int              `json:"dd,omitempty"`
+	Provenance      []ProvenanceLink `json:"p,omitempty"`
+
+	// Role the data was exported from, and the role the exporter named for the recipient. The PEM headers repeat
+	// these for the operator only, as the headers are neither encrypted nor signed.
+	OriginRole    string `json:"or,omitempty"`
+	RecipientRole string `json:"rr,omitempty"`
+}
+
+// NamedRole the role the data is imported into unless the importer names the role: the role the exporter named
+// for the recipient, or else the role the data was exported from.
+func (rde *RoleDataExchange) NamedRole() string {
+	if len(rde.RecipientRole) > 0 {
+		return rde.RecipientRole
+	}
+	return rde.OriginRole
+}
+
+// ProvenanceLink a single export in the chain of exports the role data was delegated through
+type ProvenanceLink struct {
+	// Fingerprint of the public key of the exporter identity that signed the export
+	Exporter string `json:"exf"`
+	ExportID string `json:"id"`
+	Exported int64  `json:"iat"`
+	// Number of further re-exports the recipient of this export was allowed
+	DelegationDepth int `json:"dd,omitempty"`
+}
+
+// TrustedExporter identity of the exporter whose role data this mount will import
+type TrustedExporter struct {
+	Certificate string `json:"crt"`
+	Fingerprint string `json:"fp"`
+	Subject     string `json:"sub"`
+}
+
+// StoredTrustedExporters trust store of the exporters, keyed by the name given by the administrator
+type StoredTrustedExporters struct {
+	Exporters map[string]TrustedExporter `json:"e,omitempty"`
+}
+
+// FindByFingerprint finds the name of the trusted exporter having the specified public key fingerprint.
+func (ste *StoredTrustedExporters) FindByFingerprint(fp string) (string, *TrustedExporter) {
+	for name, exp := range ste.Exporters {
+		if exp.Fingerprint == fp {
+			return name, &exp
+		}
+	}
+
+	return "", nil
+}
+
+// StoredRecipientIdentity recipient identity of the mount. Unlike the recipient key of a role, the identity can
+// receive the data of any number of roles.
+type StoredRecipientIdentity struct {
+	PrivateKey []byte `json:"pk"`
+	Created    int64  `json:"c"`
+}
+
+// ConsumedExport export whose role data was imported into this mount
+type ConsumedExport struct {
+	Role         string `json:"r"`
+	Imported     int64  `json:"t"`
+	ImportBefore int64  `json:"ib,omitempty"`
+	// Epoch time the term granted by the export ends
+	TermExpiry int64 `json:"te,omitempty"`
+}
+
+// importLedgerRetention the time, in seconds, the import ledger keeps the exports that have neither the import
+// deadline nor the term
+const importLedgerRetention int64 = 365 * 24 * 3600
+
+// StoredImportLedger the exports imported into this mount, keyed by the export ID, or by the digest of the role data
+// that carries no export ID. The entries of the exports whose import deadline or term has passed are pruned, as the
+// importer rejects these exports anyway, or the imported role cannot be used. The entries of the exports bound by
+// neither are pruned once importLedgerRetention has passed since the import.
+type StoredImportLedger struct {
+	Consumed map[string]ConsumedExport `json:"c,omitempty"`
+}
+
+// Prune removes the entries of the exports that cannot be imported or used anymore, and the unbounded entries
+// past the retention.
+func (sil *StoredImportLedger) Prune(now time.Time) {
+	for id, v := range sil.Consumed {
+		if (v.ImportBefore > 0 && v.ImportBefore < now.Unix()) || (v.TermExpiry > 0 && v.TermExpiry < now.Unix()) {
+			delete(sil.Consumed, id)
+		} else if v.ImportBefore == 0 && v.TermExpiry == 0 && v.Imported+importLedgerRetention < now.Unix() {
+			delete(sil.Consumed, id)
+		}
+	}
+}
+
+// Merge adds the entries of the other ledger this ledger does not have yet.
+func (sil *StoredImportLedger) Merge(other StoredImportLedger) {
+	for key, v := range other.Consumed {
+		if _, exists := sil.Consumed[key]; !exists {
+			if sil.Consumed == nil {
+				sil.Consumed = map[string]ConsumedExport{}
+			}
+			sil.Consumed[key] = v
+		}
+	}
+}
+
+// Record records the export the role was imported from under the ledger key.
+func (sil *StoredImportLedger) Record(key string, role *StoredRole, now time.Time) {
+	if sil.Consumed == nil {
+		sil.Consumed = map[string]ConsumedExport{}
+	}
+
+	sil.Consumed[key] = ConsumedExport{
+		Role:         role.Name,
+		Imported:     now.Unix(),
+		ImportBefore: role.Keys.ExportImportBefore,
+		TermExpiry:   role.Usage.ExplicitTerm,
+	}
+}
+
+// RoleExportRecord the terms on which the role data was exported to a recipient, and who requested the export
+type RoleExportRecord struct {
+	Exported             int64  `json:"t"`
+	Recipient            string `json:"rcn"`
+	RecipientFingerprint string `json:"rfp"`
+	RecipientRole        string `json:"rr,omitempty"`
+
+	TermExpiry      int64 `json:"etm,omitempty"`
+	NumUses         int64 `json:"enu,omitempty"`
+	MaxQPS          int   `json:"qps,omitempty"`
+	ImportBefore    int64 `json:"ib,omitempty"`
+	V2Capable       bool  `json:"v2"`
+	V3Capable       bool  `json:"v3"`
+	ForceProxyMode  bool  `json:"fpm,omitempty"`
+	Exportable      bool  `json:"exp,omitempty"`
+	DelegationDepth int   `json:"dd,omitempty"`
+	RestrictedScope bool  `json:"rsc,omitempty"`
+
+	// Unix time the exporter revoked the export at
+	Revoked int64 `json:"rvk,omitempty"`
+
+	// Vault entity and the display name of the token that requested the export
+	EntityID    string `json:"eid,omitempty"`
+	RequestedBy string `json:"dn,omitempty"`
+}
+
+// StoredRoleExports the exports of the role data, keyed by the export ID
+type StoredRoleExports struct {
+	Exports map[string]RoleExportRecord `json:"e,omitempty"`
+}
+
+// ActiveExports the IDs of the matching exports that are neither revoked nor past their term, the oldest first.
+func (sre *StoredRoleExports) ActiveExports(now time.Time, match func(rec *RoleExportRecord) bool) []string {
+	var rv []string
+	for id, rec := range sre.Exports {
+		if rec.Revoked == 0 && (rec.TermExpiry == 0 || rec.TermExpiry > now.Unix()) && match(&rec) {
+			rv = append(rv, id)
+		}
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		return sre.Exports[rv[i]].Exported < sre.Exports[rv[j]].Exported
+	})
+	return rv
+}
+
+// RoleControlMessage instruction of the exporter concerning the role data it has exported earlier. The message
+// carries no secrets; it is signed by the exporter, but not encrypted.
+type RoleControlMessage struct {
+	ID       string `json:"mid"`
+	ExportID string `json:"id"`
+	Action   string `json:"a"`
+	Issued   int64  `json:"iat"`
+
+	// New end of the term, and the number of uses added to the quota, of the extended export
+	TermExpiry int64 `json:"etm,omitempty"`
+	AddUses    int64 `json:"au,omitempty"`
 }
 
 // StoredRolePrivateKey stored role private key. Private Keys are written in a separate struct, as these tend to
@@ -196,13 +538,263 @@ type StoredRoleUsage struct {
 	ExplicitNumUses  int64  `json:"enu,omitempty"`
 	RemainingNumUses int64  `json:"_urm"`
 	ExplicitTerm     int64  `json:"etm,omitempty"`
+	// Time the access token was obtained, in nanoseconds since the epoch
+	V3TokenObtainedNano int64 `json:"_v3ton,omitempty"`
 }
 
 // ReplaceAccessToken replace access token and expiry time used in this struct.
 func (sru *StoredRoleUsage) ReplaceAccessToken(tkn string, expiry int64) {
 	sru.V3Token = tkn
 	sru.V3TokenExpiry = expiry
-	sru.V3TokenObtained = time.Now().Unix()
+	now := time.Now()
+	sru.V3TokenObtained = now.Unix()
+	sru.V3TokenObtainedNano = now.UnixNano()
+}
+
+// V3TokenObtainedTime returns the time the access token was obtained. The tokens stored before the time was
+// recorded with sub-second precision are returned with the precision of seconds.
+func (sru *StoredRoleUsage) V3TokenObtainedTime() time.Time {
+	if sru.V3TokenObtainedNano > 0 {
+		return time.Unix(0, sru.V3TokenObtainedNano)
+	}
+	return time.Unix(sru.V3TokenObtained, 0)
+}
+
+// StoredRoleRevocations stores the credentials issued for the role that were revoked before their natural expiry.
+// Only the digests of the revoked credentials are stored.
+type StoredRoleRevocations struct {
+	// Digests of revoked V3 access tokens mapped to the epoch time the token expires
+	V3Tokens map[string]int64 `json:"v3t,omitempty"`
+	// Digests of revoked V2 signatures mapped to the epoch time the signature expires
+	V2Signatures map[string]int64 `json:"v2s,omitempty"`
+	// Epoch time before which all leases issued for this role are revoked
+	RevokedBefore int64 `json:"rvb,omitempty"`
+	// Time before which all leases issued for this role are revoked, in nanoseconds since the epoch
+	RevokedBeforeNano int64 `json:"rvbn,omitempty"`
+}
+
+func revokedCredentialDigest(s string) string {
+	digest := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(digest[:])
+}
+
+func (srr *StoredRoleRevocations) RevokeV3Token(tkn string, expiry int64) {
+	if srr.V3Tokens == nil {
+		srr.V3Tokens = map[string]int64{}
+	}
+	srr.V3Tokens[revokedCredentialDigest(tkn)] = expiry
+}
+
+func (srr *StoredRoleRevocations) V3TokenRevoked(tkn string) bool {
+	_, revoked := srr.V3Tokens[revokedCredentialDigest(tkn)]
+	return revoked
+}
+
+func (srr *StoredRoleRevocations) RevokeV2Signature(sig string, expiry int64) {
+	if srr.V2Signatures == nil {
+		srr.V2Signatures = map[string]int64{}
+	}
+	srr.V2Signatures[revokedCredentialDigest(sig)] = expiry
+}
+
+func (srr *StoredRoleRevocations) V2SignatureRevoked(sig string) bool {
+	_, revoked := srr.V2Signatures[revokedCredentialDigest(sig)]
+	return revoked
+}
+
+// RevokeAllIssuedUntil revokes all credentials issued for the role up to (and including) the specified time.
+func (srr *StoredRoleRevocations) RevokeAllIssuedUntil(t time.Time) {
+	srr.RevokedBefore = t.Unix()
+	srr.RevokedBeforeNano = t.UnixNano()
+}
+
+// IssuedBeforeRevocation checks whether the credential issued at the specified time was revoked. The credentials
+// issued within the same second as the revocation, but after it, remain valid, unless the revocation was stored
+// with the precision of seconds only.
+func (srr *StoredRoleRevocations) IssuedBeforeRevocation(t time.Time) bool {
+	if srr.RevokedBeforeNano > 0 {
+		return t.UnixNano() <= srr.RevokedBeforeNano
+	}
+	return srr.RevokedBefore > 0 && t.Unix() <= srr.RevokedBefore
+}
+
+// Merge adds the revocations of the other record to this one. A credential revoked by either record remains
+// revoked, and the later of the revoke-all times applies.
+func (srr *StoredRoleRevocations) Merge(other StoredRoleRevocations) {
+	for k, exp := range other.V3Tokens {
+		if srr.V3Tokens == nil {
+			srr.V3Tokens = map[string]int64{}
+		}
+		if exp > srr.V3Tokens[k] {
+			srr.V3Tokens[k] = exp
+		}
+	}
+	for k, exp := range other.V2Signatures {
+		if srr.V2Signatures == nil {
+			srr.V2Signatures = map[string]int64{}
+		}
+		if exp > srr.V2Signatures[k] {
+			srr.V2Signatures[k] = exp
+		}
+	}
+
+	if other.RevokedBefore > srr.RevokedBefore {
+		srr.RevokedBefore = other.RevokedBefore
+		srr.RevokedBeforeNano = other.RevokedBeforeNano
+	} else if other.RevokedBefore == srr.RevokedBefore && srr.RevokedBeforeNano > 0 &&
+		(other.RevokedBeforeNano == 0 || other.RevokedBeforeNano > srr.RevokedBeforeNano) {
+		// The revocation stored with the precision of seconds covers the whole second
+		srr.RevokedBeforeNano = other.RevokedBeforeNano
+	}
+}
+
+// Prune removes revoked credentials that have expired by the specified time, as these cannot be used anymore.
+func (srr *StoredRoleRevocations) Prune(t time.Time) {
+	for k, exp := range srr.V3Tokens {
+		if exp < t.Unix() {
+			delete(srr.V3Tokens, k)
+		}
+	}
+	for k, exp := range srr.V2Signatures {
+		if exp < t.Unix() {
+			delete(srr.V2Signatures, k)
+		}
+	}
+}
+
+// RetiredRecipientKey a previous recipient key of the role. The key can still decrypt the role data exported to
+// it until the grace period ends.
+type RetiredRecipientKey struct {
+	Key        []byte `json:"k"`
+	Created    int64  `json:"c,omitempty"`
+	RetiredAt  int64  `json:"ra"`
+	GraceUntil int64  `json:"g"`
+}
+
+// StoredRecipientKeys history of the role's recipient keys
+type StoredRecipientKeys struct {
+	// Epoch time the current key was created; zero for the keys created before the creation time was recorded
+	CurrentCreated int64                 `json:"cc,omitempty"`
+	Retired        []RetiredRecipientKey `json:"r,omitempty"`
+}
+
+// Prune destroys the retired keys whose grace period has ended by the specified time, returning whether any key
+// was destroyed.
+func (srk *StoredRecipientKeys) Prune(t time.Time) bool {
+	var rv []RetiredRecipientKey
+	for _, k := range srk.Retired {
+		if k.GraceUntil > t.Unix() {
+			rv = append(rv, k)
+		}
+	}
+
+	pruned := len(rv) != len(srk.Retired)
+	srk.Retired = rv
+	return pruned
+}
+
+// RoleKeysVersion version of the role keys, as these were saved. The keys are encrypted with the versions key of the
+// role; the remaining fields describe the change and carry no secrets.
+type RoleKeysVersion struct {
+	Version int   `json:"v"`
+	Saved   int64 `json:"s"`
+	// Path and the operation of the request that saved the keys
+	Path      string `json:"p,omitempty"`
+	Operation string `json:"o,omitempty"`
+	// Vault entity and the display name of the token that saved the keys
+	EntityID    string `json:"eid,omitempty"`
+	RequestedBy string `json:"dn,omitempty"`
+	// Version these keys were rolled back to; zero if the keys were not rolled back
+	RestoredFrom int `json:"rf,omitempty"`
+
+	Keys []byte `json:"k"`
+}
+
+// StoredRoleVersions the most recent versions of the role keys, the oldest first
+type StoredRoleVersions struct {
+	Latest   int               `json:"l"`
+	Versions []RoleKeysVersion `json:"r,omitempty"`
+}
+
+// Append numbers the version as the latest one, and retains up to max most recent versions.
+func (srv *StoredRoleVersions) Append(v RoleKeysVersion, max int) {
+	srv.Latest++
+	v.Version = srv.Latest
+
+	srv.Versions = append(srv.Versions, v)
+	if len(srv.Versions) > max {
+		srv.Versions = srv.Versions[len(srv.Versions)-max:]
+	}
+}
+
+// Find finds the retained version; nil is returned if the version was never saved, or is not retained anymore.
+func (srv *StoredRoleVersions) Find(version int) *RoleKeysVersion {
+	for i := range srv.Versions {
+		if srv.Versions[i].Version == version {
+			return &srv.Versions[i]
+		}
+	}
+	return nil
+}
+
+// PendingRoleKeys credentials staged to replace the active credentials of the role. The credentials left empty keep
+// their active values when the pending keys are promoted.
+type PendingRoleKeys struct {
+	AreaId    string `json:"aid,omitempty"`
+	AreaNid   int    `json:"nid,omitempty"`
+	ApiKey    string `json:"key,omitempty"`
+	KeySecret string `json:"srt,omitempty"`
+	Username  string `json:"usr,omitempty"`
+	Password  string `json:"pwd,omitempty"`
+	// Epoch time the keys were staged
+	Staged int64 `json:"stg"`
+}
+
+// ApplyTo replaces the active credentials with the staged ones
+func (pk *PendingRoleKeys) ApplyTo(keys *RoleKeys) {
+	if len(pk.AreaId) > 0 {
+		keys.AreaId = pk.AreaId
+	}
+	if pk.AreaNid > 0 {
+		keys.AreaNid = pk.AreaNid
+	}
+	if len(pk.ApiKey) > 0 {
+		keys.ApiKey = pk.ApiKey
+	}
+	if len(pk.KeySecret) > 0 {
+		keys.KeySecret = pk.KeySecret
+	}
+	if len(pk.Username) > 0 {
+		keys.Username = pk.Username
+	}
+	if len(pk.Password) > 0 {
+		keys.Password = pk.Password
+	}
+}
+
+// StagedFields names of the fields the pending keys replace
+func (pk *PendingRoleKeys) StagedFields() []string {
+	var rv []string
+	if len(pk.AreaId) > 0 {
+		rv = append(rv, roleAreaIdField)
+	}
+	if pk.AreaNid > 0 {
+		rv = append(rv, roleAreaNidField)
+	}
+	if len(pk.ApiKey) > 0 {
+		rv = append(rv, roleApiKeField)
+	}
+	if len(pk.KeySecret) > 0 {
+		rv = append(rv, roleSecretField)
+	}
+	if len(pk.Username) > 0 {
+		rv = append(rv, roleUsernameField)
+	}
+	if len(pk.Password) > 0 {
+		rv = append(rv, rolePasswordField)
+	}
+
+	return rv
 }
 
 // StoredRole Authentication role data that is stored within Vault encrypted storage
@@ -210,9 +802,18 @@ type StoredRole struct {
 	Keys       RoleKeys
 	Usage      StoredRoleUsage
 	PrivateKey []byte
+	// Creation time and the retired keys of the recipient key
+	RecipientKeys StoredRecipientKeys
+	// Credentials staged to replace the active ones; nil if none were staged, or these were not read
+	PendingKeys *PendingRoleKeys
 
 	Name        string
 	StoragePath string
+
+	// Access token Mashery has rejected while serving this request; it must not be re-used from the storage.
+	rejectedV3Token string
+	// Version of the keys the role is being rolled back to, recorded with the saved keys
+	restoredVersion int
 }
 
 func (sr *StoredRoleUsage) HasUsageQuota() bool {
@@ -244,6 +845,7 @@ func (sr *StoredRoleUsage) ResetToken() {
 	sr.V3Token = ""
 	sr.V3TokenExpiry = -1
 	sr.V3TokenObtained = -1
+	sr.V3TokenObtainedNano = 0
 }
 
 func (sr *StoredRoleUsage) V3TokenNeedsRenew() bool {
@@ -258,6 +860,18 @@ func (ar *StoredRoleUsage) ExpiryTimeString() string {
 	}
 }
 
+// RemainingDelegationDepth the number of further re-exports the role data may be delegated through. The data
+// imported before the delegation depth was recorded may be re-exported once.
+func (ar *RoleKeys) RemainingDelegationDepth() int {
+	if !ar.Exportable {
+		return 0
+	} else if ar.DelegationDepth > 0 {
+		return ar.DelegationDepth
+	} else {
+		return 1
+	}
+}
+
 func (ar *RoleKeys) IsV2Capable() bool {
 	return ar.AreaNid > 0 && ar.SuppliesKeyAndSecret()
 }
@@ -272,6 +886,53 @@ func (ar *RoleKeys) IsV3Capable() bool {
 		len(ar.Username) > 0 && len(ar.Password) > 0
 }
 
+// EffectiveV3Endpoint V3 API base URL for this role; an empty string indicates the default Mashery host.
+func (ar *RoleKeys) EffectiveV3Endpoint(cfg *BackendConfiguration) string {
+	if len(ar.V3Endpoint) > 0 {
+		return ar.V3Endpoint
+	}
+	return cfg.V3Endpoint
+}
+
+// EffectiveOAuthTokenEndpoint OAuth token URL for this role; an empty string indicates the default Mashery host.
+func (ar *RoleKeys) EffectiveOAuthTokenEndpoint(cfg *BackendConfiguration) string {
+	if len(ar.OAuthTokenEndpoint) > 0 {
+		return ar.OAuthTokenEndpoint
+	}
+	return cfg.OAuthTokenEndpoint
+}
+
+// EffectiveV2Endpoint V2 JSON-RPC base URL for this role; an empty string indicates the default Mashery host.
+func (ar *RoleKeys) EffectiveV2Endpoint(cfg *BackendConfiguration) string {
+	if len(ar.V2Endpoint) > 0 {
+		return ar.V2Endpoint
+	}
+	return cfg.V2Endpoint
+}
+
+// v2AreaEndpoint V2 JSON-RPC URL of the role's area. The area NID is appended to the base URL, similar
+// to the public https://api.mashery.com/v2/json-rpc/:nid endpoint.
+func (ar *RoleKeys) v2AreaEndpoint(cfg *BackendConfiguration) string {
+	if base := ar.EffectiveV2Endpoint(cfg); len(base) > 0 {
+		return fmt.Sprintf("%s/%d", strings.TrimSuffix(base, "/"), ar.AreaNid)
+	}
+	return ""
+}
+
+// clientFingerprint identifies the credentials and endpoints the API clients of this role are built from.
+func (ar *RoleKeys) clientFingerprint(cfg *BackendConfiguration) string {
+	hash := sha256.New()
+	for _, v := range []string{
+		ar.AreaId, strconv.Itoa(ar.AreaNid), ar.ApiKey, ar.KeySecret, ar.Username, ar.Password,
+		strconv.Itoa(ar.MaxQPS), ar.EffectiveV3Endpoint(cfg), ar.v2AreaEndpoint(cfg),
+	} {
+		hash.Write([]byte(v))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 func (ar *StoredRoleUsage) AfterExpiryTerm(t time.Time) bool {
 	return ar.ExplicitTerm > 0 && t.Unix() > ar.ExplicitTerm
 }
@@ -302,10 +963,15 @@ func (ar *StoredRole) CreateRoleDataExchange(term time.Duration) RoleDataExchang
 			Username:  ar.Keys.Username,
 			Password:  ar.Keys.Password,
 			MaxQPS:    ar.Keys.MaxQPS,
+
+			V3Endpoint:         ar.Keys.V3Endpoint,
+			OAuthTokenEndpoint: ar.Keys.OAuthTokenEndpoint,
+			V2Endpoint:         ar.Keys.V2Endpoint,
 		},
 		UsageTerm: &RoleUsageTerm{
 			ExplicitTerm: exp,
 		},
+		Scope: ar.Keys.EffectiveScope(),
 	}
 }
 
@@ -320,6 +986,16 @@ func (ar *StoredRole) Import(role RoleDataExchange) {
 	ar.Keys.ForceProxyMode = role.RoleData.ForceProxyMode
 	ar.Keys.Imported = true
 	ar.Keys.Exportable = role.RoleData.Exportable
+	ar.Keys.V3Endpoint = role.RoleData.V3Endpoint
+	ar.Keys.OAuthTokenEndpoint = role.RoleData.OAuthTokenEndpoint
+	ar.Keys.V2Endpoint = role.RoleData.V2Endpoint
+	ar.Keys.InheritedScope = role.Scope
+	ar.Keys.ExportID = role.ExportID
+	ar.Keys.ExportImportBefore = role.ImportBefore
+	ar.Keys.DelegationDepth = role.DelegationDepth
+	ar.Keys.Provenance = role.Provenance
+	ar.Keys.AppliedControls = nil
+	ar.Keys.Revoked = false
 
 	if role.UsageTerm != nil {
 		ar.Usage.ExplicitTerm = role.UsageTerm.ExplicitTerm
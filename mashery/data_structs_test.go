@@ -76,3 +76,23 @@ func TestStoredRoleWillImportExportedData(t *testing.T) {
 	assert.Equal(t, int64(500), sr.Usage.ExplicitNumUses)
 	assert.Equal(t, int64(500), sr.Usage.RemainingNumUses)
 }
+
+func TestRoleEndpointsOverrideMountConfiguration(t *testing.T) {
+	cfg := mashery.BackendConfiguration{
+		V3Endpoint:         "https://mount/v3/rest",
+		OAuthTokenEndpoint: "https://mount/v3/token",
+		V2Endpoint:         "https://mount/v2/json-rpc",
+	}
+
+	keys := mashery.RoleKeys{}
+	assert.Equal(t, "https://mount/v3/rest", keys.EffectiveV3Endpoint(&cfg))
+	assert.Equal(t, "https://mount/v3/token", keys.EffectiveOAuthTokenEndpoint(&cfg))
+	assert.Equal(t, "https://mount/v2/json-rpc", keys.EffectiveV2Endpoint(&cfg))
+
+	keys.V3Endpoint = "https://role/v3/rest"
+	keys.OAuthTokenEndpoint = "https://role/v3/token"
+	keys.V2Endpoint = "https://role/v2/json-rpc"
+	assert.Equal(t, "https://role/v3/rest", keys.EffectiveV3Endpoint(&cfg))
+	assert.Equal(t, "https://role/v3/token", keys.EffectiveOAuthTokenEndpoint(&cfg))
+	assert.Equal(t, "https://role/v2/json-rpc", keys.EffectiveV2Endpoint(&cfg))
+}
@@ -0,0 +1,263 @@
+package mashery
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const (
+	roleDataFormatHeader = "Format"
+
+	// Role data encrypted directly with the recipient's RSA key. The blocks of this format carry no format header.
+	roleDataFormatV1 = "1"
+	// Role data encrypted with a random AES-GCM content key, which is wrapped with the recipient's RSA key.
+	roleDataFormatV2 = "2"
+	// Role data encrypted with an AES-GCM content key derived from ECDH between an ephemeral key and the
+	// recipient's EC or X25519 key (ECIES).
+	roleDataFormatV3 = "3"
+
+	envelopeContentKeySize = 32
+)
+
+// envelopeAdditionalData binds the encrypted content to the format it was sealed in.
+var envelopeAdditionalData = []byte(masheryRoleDataPEMBlockName + " v" + roleDataFormatV2)
+var eciesAdditionalData = []byte(masheryRoleDataPEMBlockName + " v" + roleDataFormatV3)
+
+// sealRoleDataForRecipient encrypts the payload for the recipient's public key, returning the format of the
+// encrypted data.
+func sealRoleDataForRecipient(recipient crypto.PublicKey, payload []byte, label []byte) (string, []byte, error) {
+	switch pub := recipient.(type) {
+	case *rsa.PublicKey:
+		dat, err := sealRoleDataEnvelope(pub, payload, label)
+		return roleDataFormatV2, dat, err
+	case *ecdsa.PublicKey:
+		ecdhPub, err := pub.ECDH()
+		if err != nil {
+			return "", nil, err
+		}
+		dat, err := sealRoleDataECIES(ecdhPub, payload, label)
+		return roleDataFormatV3, dat, err
+	case *ecdh.PublicKey:
+		dat, err := sealRoleDataECIES(pub, payload, label)
+		return roleDataFormatV3, dat, err
+	default:
+		return "", nil, errors.New(fmt.Sprintf("unsupported recipient key type %T", recipient))
+	}
+}
+
+// sealRoleDataEnvelope encrypts the payload with a random AES-GCM content key, and wraps the content key with
+// RSA-OAEP for the recipient. The envelope is laid out as:
+// - 2 bytes: length of the wrapped content key, big endian
+// - wrapped content key
+// - GCM nonce
+// - GCM cipher text of the payload
+func sealRoleDataEnvelope(recipient *rsa.PublicKey, payload []byte, oaepLabel []byte) ([]byte, error) {
+	contentKey := make([]byte, envelopeContentKeySize)
+	if _, err := io.ReadFull(rand.Reader, contentKey); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient, contentKey, oaepLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newEnvelopeCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	rv := make([]byte, 2, 2+len(wrappedKey)+len(nonce)+len(payload)+gcm.Overhead())
+	binary.BigEndian.PutUint16(rv, uint16(len(wrappedKey)))
+	rv = append(rv, wrappedKey...)
+	rv = append(rv, nonce...)
+
+	return gcm.Seal(rv, nonce, payload, envelopeAdditionalData), nil
+}
+
+// openRoleDataEnvelope unwraps the content key with the recipient's private key, and decrypts the payload.
+func openRoleDataEnvelope(pk *rsa.PrivateKey, envelope []byte, oaepLabel []byte) ([]byte, error) {
+	if len(envelope) < 2 {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(envelope))
+	envelope = envelope[2:]
+	if len(envelope) < keyLen {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	contentKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, pk, envelope[:keyLen], oaepLabel)
+	if err != nil {
+		return nil, err
+	}
+	envelope = envelope[keyLen:]
+
+	gcm, err := newEnvelopeCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < gcm.NonceSize() {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	return gcm.Open(nil, envelope[:gcm.NonceSize()], envelope[gcm.NonceSize():], envelopeAdditionalData)
+}
+
+func newEnvelopeCipher(contentKey []byte) (cipher.AEAD, error) {
+	if len(contentKey) != envelopeContentKeySize {
+		return nil, errors.New(fmt.Sprintf("content key has unexpected size %d", len(contentKey)))
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealRoleDataECIES encrypts the payload with the content key derived, using HKDF-SHA256, from the ECDH secret
+// shared between a random ephemeral key and the recipient's key. The label is mixed into the derivation. The
+// envelope is laid out as:
+// - 2 bytes: length of the ephemeral public key, big endian
+// - ephemeral public key
+// - GCM nonce
+// - GCM cipher text of the payload
+func sealRoleDataECIES(recipient *ecdh.PublicKey, payload []byte, label []byte) ([]byte, error) {
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPub := ephemeral.PublicKey().Bytes()
+	gcm, err := newECIESCipher(secret, ephemeralPub, recipient.Bytes(), label)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	rv := make([]byte, 2, 2+len(ephemeralPub)+len(nonce)+len(payload)+gcm.Overhead())
+	binary.BigEndian.PutUint16(rv, uint16(len(ephemeralPub)))
+	rv = append(rv, ephemeralPub...)
+	rv = append(rv, nonce...)
+
+	return gcm.Seal(rv, nonce, payload, eciesAdditionalData), nil
+}
+
+// openRoleDataECIES derives the content key from the ephemeral public key and the recipient's private key, and
+// decrypts the payload.
+func openRoleDataECIES(pk *ecdh.PrivateKey, envelope []byte, label []byte) ([]byte, error) {
+	if len(envelope) < 2 {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(envelope))
+	envelope = envelope[2:]
+	if len(envelope) < keyLen {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	ephemeralPub, err := pk.Curve().NewPublicKey(envelope[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	envelope = envelope[keyLen:]
+
+	secret, err := pk.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newECIESCipher(secret, ephemeralPub.Bytes(), pk.PublicKey().Bytes(), label)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelope) < gcm.NonceSize() {
+		return nil, errors.New("envelope is truncated")
+	}
+
+	return gcm.Open(nil, envelope[:gcm.NonceSize()], envelope[gcm.NonceSize():], eciesAdditionalData)
+}
+
+// newECIESCipher derives the content key from the ECDH shared secret. The derivation is bound to the ephemeral
+// and the recipient's public keys.
+func newECIESCipher(secret []byte, ephemeralPub []byte, recipientPub []byte, label []byte) (cipher.AEAD, error) {
+	info := make([]byte, 0, len(eciesAdditionalData)+len(ephemeralPub)+len(recipientPub))
+	info = append(info, eciesAdditionalData...)
+	info = append(info, ephemeralPub...)
+	info = append(info, recipientPub...)
+
+	contentKey := make([]byte, envelopeContentKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, label, info), contentKey); err != nil {
+		return nil, err
+	}
+
+	return newEnvelopeCipher(contentKey)
+}
+
+// decryptRoleData decrypts the role data according to the format of the PEM block.
+func decryptRoleData(pk crypto.PrivateKey, format string, data []byte, oaepLabel []byte) ([]byte, error) {
+	switch format {
+	case "", roleDataFormatV1, roleDataFormatV2:
+		rsaKey, ok := pk.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("role data format %s requires an RSA recipient key", formatOrV1(format)))
+		}
+		if format == roleDataFormatV2 {
+			return openRoleDataEnvelope(rsaKey, data, oaepLabel)
+		}
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, rsaKey, data, oaepLabel)
+	case roleDataFormatV3:
+		ecdhKey, err := recipientECDHKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		return openRoleDataECIES(ecdhKey, data, oaepLabel)
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported role data format %s", format))
+	}
+}
+
+func formatOrV1(format string) string {
+	if len(format) == 0 {
+		return roleDataFormatV1
+	}
+	return format
+}
+
+func recipientECDHKey(pk crypto.PrivateKey) (*ecdh.PrivateKey, error) {
+	switch k := pk.(type) {
+	case *ecdsa.PrivateKey:
+		return k.ECDH()
+	case *ecdh.PrivateKey:
+		return k, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("role data format %s requires an EC or X25519 recipient key", roleDataFormatV3))
+	}
+}
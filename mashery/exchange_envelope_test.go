@@ -0,0 +1,99 @@
+package mashery
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRoleDataEnvelope_RoundTrip(t *testing.T) {
+	pk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	// The payload exceeds the size RSA-OAEP could encrypt directly
+	payload := bytes.Repeat([]byte("role data "), 1000)
+
+	envelope, err := sealRoleDataEnvelope(&pk.PublicKey, payload, []byte("label"))
+	assert.Nil(t, err)
+
+	plain, err := openRoleDataEnvelope(pk, envelope, []byte("label"))
+	assert.Nil(t, err)
+	assert.Equal(t, payload, plain)
+
+	_, err = openRoleDataEnvelope(pk, envelope, []byte("other label"))
+	assert.NotNil(t, err)
+}
+
+func TestRoleDataEnvelope_DetectsTampering(t *testing.T) {
+	pk, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	envelope, err := sealRoleDataEnvelope(&pk.PublicKey, []byte("role data"), nil)
+	assert.Nil(t, err)
+
+	envelope[len(envelope)-1] ^= 0x01
+	_, err = openRoleDataEnvelope(pk, envelope, nil)
+	assert.NotNil(t, err)
+
+	_, err = openRoleDataEnvelope(pk, envelope[:10], nil)
+	assert.Equal(t, "envelope is truncated", err.Error())
+}
+
+func TestDecryptRoleData_UnsupportedFormat(t *testing.T) {
+	pk, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	_, err := decryptRoleData(pk, "99", []byte("data"), nil)
+	assert.Equal(t, "unsupported role data format 99", err.Error())
+}
+
+func TestRenderEncryptedRoleData_WritesFormatHeader(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	assert.Equal(t, roleDataFormatV2, pemOut.Headers[roleDataFormatHeader])
+}
+
+func TestImportPEMBlock_WillImportV1Format(t *testing.T) {
+	sourceRole := StoredRole{
+		PrivateKey: randomPrivateKey(),
+	}
+	pk, err := x509.ParsePKCS1PrivateKey(sourceRole.PrivateKey)
+	assert.Nil(t, err)
+
+	exportedRole := createRoleWithFilledRoleKeys()
+	jsonDat, _ := json.Marshal(exportedRole.CreateRoleDataExchange(0))
+
+	// Blocks created before the format header was introduced were encrypted directly with the recipient's key
+	dat, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &pk.PublicKey, GZipCompress(jsonDat), nil)
+	assert.Nil(t, err)
+	pemBlock := &pem.Block{Type: masheryRoleDataPEMBlockName, Bytes: dat, Headers: map[string]string{}}
+
+	_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+	lr, err := importPEMEncodedExchangeData(pemBlock)(nil, importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, exportedRole.Keys.ApiKey, importRoleRequest.heap.GetRole().Keys.ApiKey)
+}
+
+func TestRoleDataECIES_DetectsTampering(t *testing.T) {
+	pk, _ := ecdh.P256().GenerateKey(rand.Reader)
+
+	envelope, err := sealRoleDataECIES(pk.PublicKey(), []byte("role data"), []byte("label"))
+	assert.Nil(t, err)
+
+	plain, err := openRoleDataECIES(pk, envelope, []byte("label"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("role data"), plain)
+
+	_, err = openRoleDataECIES(pk, envelope, []byte("other label"))
+	assert.NotNil(t, err)
+
+	envelope[len(envelope)-1] ^= 0x01
+	_, err = openRoleDataECIES(pk, envelope, []byte("label"))
+	assert.NotNil(t, err)
+
+	_, err = openRoleDataECIES(pk, envelope[:10], nil)
+	assert.Equal(t, "envelope is truncated", err.Error())
+}
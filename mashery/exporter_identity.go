@@ -0,0 +1,173 @@
+package mashery
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/errwrap"
+	"time"
+)
+
+const (
+	masheryExporterIdentityPEMBlockName = "MASHERY EXPORTER IDENTITY"
+
+	// Fingerprint of the exporter's public key
+	roleDataExporterHeader = "Exporter"
+	// Exporter's signature of the role data
+	roleDataSignatureHeader = "Signature"
+
+	exporterIdentityValidity = time.Hour * 24 * 365
+)
+
+func generateExporterIdentityKey() ([]byte, error) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.MarshalPKCS8PrivateKey(pk)
+}
+
+func parseExporterIdentityKey(der []byte) (*ecdsa.PrivateKey, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errwrap.Wrapf("exporter identity key is not understood: {{err}}", err)
+	}
+
+	if ecKey, ok := key.(*ecdsa.PrivateKey); ok {
+		return ecKey, nil
+	}
+	return nil, errors.New("exporter identity key is not an ECDSA key")
+}
+
+// publicKeyFingerprint SHA-256 fingerprint of the DER-encoded public key, in hexadecimal notation.
+func publicKeyFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// errSignatureMismatch the signature is well-formed, but was not made over the data by the exporter
+var errSignatureMismatch = errors.New("signature does not match")
+
+// errTrustedExporterExpired the certificate of the trusted exporter has expired since it was trusted.
+var errTrustedExporterExpired = errors.New("certificate of the exporter has expired")
+
+// exportedDataSigningDigest digest of the data the exporter signs. The digest covers the type of the PEM block, the
+// exchange format, and the exporter's fingerprint, so that neither can be replaced in the PEM headers.
+func exportedDataSigningDigest(blockType string, format string, fingerprint string, data []byte) []byte {
+	hash := sha256.New()
+	for _, v := range [][]byte{[]byte(blockType), []byte(format), []byte(fingerprint), data} {
+		hash.Write(v)
+		hash.Write([]byte{0})
+	}
+
+	return hash.Sum(nil)
+}
+
+// signExportedData signs the data of the PEM block, returning the fingerprint of the exporter and the signature.
+func signExportedData(pk *ecdsa.PrivateKey, blockType string, format string, data []byte) (string, string, error) {
+	fingerprint, err := publicKeyFingerprint(&pk.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	sig, err := ecdsa.SignASN1(rand.Reader, pk, exportedDataSigningDigest(blockType, format, fingerprint, data))
+	if err != nil {
+		return "", "", err
+	}
+
+	return fingerprint, base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// verifyExportedDataSignature verifies that the data of the PEM block was signed by the exporter owning the
+// public key.
+func verifyExportedDataSignature(pub crypto.PublicKey, blockType string, format string, fingerprint string, data []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errwrap.Wrapf("signature is not base64-encoded: {{err}}", err)
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New(fmt.Sprintf("unsupported exporter key type %T", pub))
+	}
+
+	if !ecdsa.VerifyASN1(ecPub, exportedDataSigningDigest(blockType, format, fingerprint, data), sig) {
+		return errSignatureMismatch
+	}
+
+	return nil
+}
+
+// signRoleData signs the encrypted role data, returning the fingerprint of the exporter and the signature.
+func signRoleData(pk *ecdsa.PrivateKey, format string, data []byte) (string, string, error) {
+	return signExportedData(pk, masheryRoleDataPEMBlockName, format, data)
+}
+
+// verifyRoleDataSignature verifies that the role data was signed by the exporter owning the public key.
+func verifyRoleDataSignature(pub crypto.PublicKey, format string, fingerprint string, data []byte, signature string) error {
+	err := verifyExportedDataSignature(pub, masheryRoleDataPEMBlockName, format, fingerprint, data, signature)
+	if err == errSignatureMismatch {
+		return errors.New("signature does not match the role data")
+	}
+	return err
+}
+
+// createExporterIdentityCertificate creates the self-signed certificate that the importing Vault administrators
+// add to their trust store.
+func createExporterIdentityCertificate(pk *ecdsa.PrivateKey, cn string) (string, error) {
+	template := createRoleCertificateTemplate(cn, time.Now().Add(-time.Minute), time.Now().Add(exporterIdentityValidity))
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &pk.PublicKey, pk)
+	if err != nil {
+		return "", errwrap.Wrapf("cannot generate x509 certificate ({{err}})", err)
+	}
+
+	out := &bytes.Buffer{}
+	_ = pem.Encode(out, &pem.Block{
+		Type:  masheryExporterIdentityPEMBlockName,
+		Bytes: derBytes,
+		Headers: map[string]string{
+			"NotAfter":    template.NotAfter.String(),
+			"Common-Name": cn,
+		},
+	})
+
+	return out.String(), nil
+}
+
+// parseExporterIdentityCertificate parses the PEM-encoded certificate of the exporter identity.
+func parseExporterIdentityCertificate(pemStr string) (*x509.Certificate, error) {
+	blk, _ := pem.Decode([]byte(pemStr))
+	if blk == nil {
+		return nil, errors.New("input does not contain a valid PEM block")
+	}
+	if blk.Type != masheryExporterIdentityPEMBlockName && blk.Type != "CERTIFICATE" {
+		return nil, errors.New(fmt.Sprintf("unexpected PEM block type %s", blk.Type))
+	}
+
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("certificate cannot be parsed: {{err}}", err)
+	}
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+		return nil, errors.New("exporter identity must carry an ECDSA public key")
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,265 @@
+package mashery
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/errwrap"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	masheryMountBackupPEMBlockName = "MASHERY MOUNT BACKUP"
+
+	// Backup encrypted with an AES-GCM key derived from the operator's passphrase
+	mountBackupFormatPassphrase = "passphrase"
+
+	mountBackupSaltHeader = "Salt"
+
+	// scrypt parameters recommended for interactive use
+	mountBackupScryptN = 32768
+	mountBackupScryptR = 8
+	mountBackupScryptP = 1
+
+	mountBackupSaltSize = 16
+	mountBackupKeySize  = 32
+
+	// Path of the roles, relative to the storage root of the mount
+	mountBackupRolePrefix = "role/"
+)
+
+var mountBackupAdditionalData = []byte(masheryMountBackupPEMBlockName + " " + mountBackupFormatPassphrase)
+
+// MountBackup archive of the storage of a mount. The entries are keyed by their path relative to the storage root
+// of the mount, so that they can be restored into a mount having a different backend UUID.
+type MountBackup struct {
+	Created int64             `json:"c"`
+	Entries map[string][]byte `json:"e"`
+}
+
+// RoleNames names of the roles contained in the backup
+func (mb *MountBackup) RoleNames() []string {
+	var rv []string
+	for path := range mb.Entries {
+		if strings.HasPrefix(path, mountBackupRolePrefix) && strings.HasSuffix(path, storedRoleKeyPathSuffix) {
+			rv = append(rv, strings.TrimSuffix(strings.TrimPrefix(path, mountBackupRolePrefix), storedRoleKeyPathSuffix))
+		}
+	}
+
+	sort.Strings(rv)
+	return rv
+}
+
+// sealMountBackupForRecipient encrypts the backup for the public key of the operator's certificate. Anyone holding
+// the certificate can encrypt the data for it, so the encrypted backup is signed with the exporter identity of the
+// mount.
+func sealMountBackupForRecipient(backup *MountBackup, recipient crypto.PublicKey, signer *ecdsa.PrivateKey) (string, error) {
+	payload, err := marshalMountBackup(backup)
+	if err != nil {
+		return "", err
+	}
+
+	// The backup may be restored into a mount having a different OAEP label; no label is applied.
+	format, dat, err := sealRoleDataForRecipient(recipient, payload, nil)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, signature, err := signExportedData(signer, masheryMountBackupPEMBlockName, format, dat)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeMountBackup(backup, dat, map[string]string{
+		roleDataFormatHeader:    format,
+		roleDataExporterHeader:  fingerprint,
+		roleDataSignatureHeader: signature,
+	}), nil
+}
+
+// verifyMountBackupSignature verifies that the backup encrypted for a certificate was signed by the exporter owning
+// the public key.
+func verifyMountBackupSignature(pub crypto.PublicKey, blk *pem.Block) error {
+	err := verifyExportedDataSignature(pub, masheryMountBackupPEMBlockName, blk.Headers[roleDataFormatHeader],
+		blk.Headers[roleDataExporterHeader], blk.Bytes, blk.Headers[roleDataSignatureHeader])
+	if err == errSignatureMismatch {
+		return errors.New("signature does not match the backup")
+	}
+	return err
+}
+
+// sealMountBackupWithPassphrase encrypts the backup with the key derived from the passphrase.
+func sealMountBackupWithPassphrase(backup *MountBackup, passphrase string) (string, error) {
+	payload, err := marshalMountBackup(backup)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, mountBackupSaltSize)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := newMountBackupPassphraseCipher(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return encodeMountBackup(backup, gcm.Seal(nonce, nonce, payload, mountBackupAdditionalData), map[string]string{
+		roleDataFormatHeader:  mountBackupFormatPassphrase,
+		mountBackupSaltHeader: base64.StdEncoding.EncodeToString(salt),
+	}), nil
+}
+
+// decodeMountBackupBlock decodes the PEM-encoded backup.
+func decodeMountBackupBlock(pemStr string) (*pem.Block, error) {
+	blk, _ := pem.Decode([]byte(pemStr))
+	if blk == nil {
+		return nil, errors.New("input does not contain a valid PEM block")
+	} else if blk.Type != masheryMountBackupPEMBlockName {
+		return nil, errors.New(fmt.Sprintf("unexpected PEM block type %s", blk.Type))
+	}
+
+	return blk, nil
+}
+
+// openMountBackupWithKey decrypts the backup encrypted for the operator's certificate.
+func openMountBackupWithKey(blk *pem.Block, pk crypto.PrivateKey) (*MountBackup, error) {
+	if blk.Headers[roleDataFormatHeader] == mountBackupFormatPassphrase {
+		return nil, errors.New("backup is encrypted with a passphrase")
+	}
+
+	payload, err := decryptRoleData(pk, blk.Headers[roleDataFormatHeader], blk.Bytes, nil)
+	if err != nil {
+		return nil, errwrap.Wrapf("cannot decrypt the backup: {{err}}", err)
+	}
+
+	return unmarshalMountBackup(payload)
+}
+
+// openMountBackupWithPassphrase decrypts the backup encrypted with the passphrase.
+func openMountBackupWithPassphrase(blk *pem.Block, passphrase string) (*MountBackup, error) {
+	if blk.Headers[roleDataFormatHeader] != mountBackupFormatPassphrase {
+		return nil, errors.New("backup is encrypted for a certificate")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(blk.Headers[mountBackupSaltHeader])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("backup carries no valid salt")
+	}
+
+	gcm, err := newMountBackupPassphraseCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(blk.Bytes) < gcm.NonceSize() {
+		return nil, errors.New("backup is truncated")
+	}
+
+	payload, err := gcm.Open(nil, blk.Bytes[:gcm.NonceSize()], blk.Bytes[gcm.NonceSize():], mountBackupAdditionalData)
+	if err != nil {
+		return nil, errors.New("cannot decrypt the backup: passphrase is incorrect or the backup was modified")
+	}
+
+	return unmarshalMountBackup(payload)
+}
+
+func newMountBackupPassphraseCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, mountBackupScryptN, mountBackupScryptR, mountBackupScryptP, mountBackupKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// parseMountBackupCertificate parses the certificate the operator encrypts the backup for. Both the certificates
+// of the recipients and standard X.509 certificates are accepted.
+func parseMountBackupCertificate(pemStr string) (*x509.Certificate, error) {
+	blk, _ := pem.Decode([]byte(pemStr))
+	if blk == nil {
+		return nil, errors.New("input does not contain a valid PEM block")
+	} else if blk.Type != masheryRoleRecipientPEMBlockName && blk.Type != "CERTIFICATE" {
+		return nil, errors.New(fmt.Sprintf("unexpected PEM block type %s", blk.Type))
+	}
+
+	cert, err := parseRecipientCertificateDER(blk.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("certificate cannot be parsed: {{err}}", err)
+	} else if time.Now().After(cert.NotAfter) {
+		return nil, errors.New("certificate has expired")
+	} else if !isSupportedRecipientPublicKey(cert.PublicKey) {
+		return nil, errors.New(fmt.Sprintf("certificate carries an unsupported %T key", cert.PublicKey))
+	}
+
+	return cert, nil
+}
+
+// parseMountBackupPrivateKey parses the PEM-encoded private key of the operator's certificate.
+func parseMountBackupPrivateKey(pemStr string) (crypto.PrivateKey, error) {
+	blk, _ := pem.Decode([]byte(pemStr))
+	if blk == nil {
+		return nil, errors.New("input does not contain a valid PEM block")
+	}
+
+	if ecKey, err := x509.ParseECPrivateKey(blk.Bytes); err == nil {
+		return ecKey, nil
+	}
+	return parseRecipientKey(blk.Bytes)
+}
+
+func marshalMountBackup(backup *MountBackup) ([]byte, error) {
+	jsonDat, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	return GZipCompress(jsonDat), nil
+}
+
+func unmarshalMountBackup(payload []byte) (*MountBackup, error) {
+	jsonDat, err := GZipDecompress(payload)
+	if err != nil {
+		return nil, errwrap.Wrapf("cannot decompress the backup: {{err}}", err)
+	}
+
+	rv := MountBackup{}
+	if err = json.Unmarshal(jsonDat, &rv); err != nil {
+		return nil, errwrap.Wrapf("cannot parse the backup: {{err}}", err)
+	}
+	return &rv, nil
+}
+
+func encodeMountBackup(backup *MountBackup, dat []byte, headers map[string]string) string {
+	headers["Date"] = time.Unix(backup.Created, 0).UTC().Format(time.RFC3339)
+	headers["Entries"] = strconv.Itoa(len(backup.Entries))
+	headers["Roles"] = strconv.Itoa(len(backup.RoleNames()))
+
+	out := &bytes.Buffer{}
+	_ = pem.Encode(out, &pem.Block{Type: masheryMountBackupPEMBlockName, Bytes: dat, Headers: headers})
+	return out.String()
+}
@@ -0,0 +1,107 @@
+package mashery
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func createTestMountBackup() *MountBackup {
+	return &MountBackup{
+		Created: time.Now().Unix(),
+		Entries: map[string][]byte{
+			"config":            []byte("{}"),
+			"role/a/key":        []byte("{\"k\":\"a\"}"),
+			"role/a/usage":      []byte("{}"),
+			"role/b/key":        []byte("{\"k\":\"b\"}"),
+			"exporter/identity": {0x01, 0x02},
+		},
+	}
+}
+
+func createTestOperatorCertificate(t *testing.T) (string, []byte) {
+	der, err := generateRecipientKey(recipientKeyTypeP256)
+	assert.Nil(t, err)
+	pk, err := parseRecipientKey(der)
+	assert.Nil(t, err)
+
+	template := createRoleCertificateTemplate("Operator", time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	certDer, err := createRecipientCertificate(&template, pk)
+	assert.Nil(t, err)
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer})),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestMountBackup_RoleNames(t *testing.T) {
+	assert.Equal(t, []string{"a", "b"}, createTestMountBackup().RoleNames())
+}
+
+func TestMountBackup_PassphraseRoundTrip(t *testing.T) {
+	backup := createTestMountBackup()
+
+	out, err := sealMountBackupWithPassphrase(backup, "correct horse")
+	assert.Nil(t, err)
+
+	blk, err := decodeMountBackupBlock(out)
+	assert.Nil(t, err)
+	assert.Equal(t, mountBackupFormatPassphrase, blk.Headers[roleDataFormatHeader])
+	assert.Equal(t, "5", blk.Headers["Entries"])
+	assert.Equal(t, "2", blk.Headers["Roles"])
+
+	restored, err := openMountBackupWithPassphrase(blk, "correct horse")
+	assert.Nil(t, err)
+	assert.Equal(t, backup, restored)
+
+	_, err = openMountBackupWithPassphrase(blk, "wrong horse")
+	assert.Equal(t, "cannot decrypt the backup: passphrase is incorrect or the backup was modified", err.Error())
+
+	_, err = openMountBackupWithKey(blk, testExporterIdentity)
+	assert.Equal(t, "backup is encrypted with a passphrase", err.Error())
+}
+
+func TestMountBackup_CertificateRoundTrip(t *testing.T) {
+	backup := createTestMountBackup()
+	certPEM, keyPEM := createTestOperatorCertificate(t)
+
+	cert, err := parseMountBackupCertificate(certPEM)
+	assert.Nil(t, err)
+
+	out, err := sealMountBackupForRecipient(backup, cert.PublicKey, testExporterIdentity)
+	assert.Nil(t, err)
+
+	blk, err := decodeMountBackupBlock(out)
+	assert.Nil(t, err)
+	assert.Nil(t, verifyMountBackupSignature(&testExporterIdentity.PublicKey, blk))
+	assert.NotNil(t, verifyMountBackupSignature(&randomExporterIdentity().PublicKey, blk))
+
+	pk, err := parseMountBackupPrivateKey(string(keyPEM))
+	assert.Nil(t, err)
+
+	restored, err := openMountBackupWithKey(blk, pk)
+	assert.Nil(t, err)
+	assert.Equal(t, backup, restored)
+
+	_, err = openMountBackupWithPassphrase(blk, "passphrase")
+	assert.Equal(t, "backup is encrypted for a certificate", err.Error())
+}
+
+func TestParseMountBackupPrivateKey_AcceptsSEC1Keys(t *testing.T) {
+	der, _ := x509.MarshalECPrivateKey(testExporterIdentity)
+
+	pk, err := parseMountBackupPrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	assert.Nil(t, err)
+	assert.True(t, testExporterIdentity.Equal(pk))
+}
+
+func TestDecodeMountBackupBlock_RejectsOtherBlocks(t *testing.T) {
+	certPEM, _ := createTestOperatorCertificate(t)
+
+	_, err := decodeMountBackupBlock(certPEM)
+	assert.Equal(t, "unexpected PEM block type CERTIFICATE", err.Error())
+
+	_, err = decodeMountBackupBlock("garbage")
+	assert.Equal(t, "input does not contain a valid PEM block", err.Error())
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/transport"
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"net/url"
 	"reflect"
@@ -34,6 +36,43 @@ func copyIntFieldIfDefined(d *framework.FieldData, fld string, dest *int) {
 	}
 }
 
+// copyDurationFieldIfDefined copies the duration field, expressed in seconds, if it is defined in the request.
+func copyDurationFieldIfDefined(d *framework.FieldData, fld string, dest *int64) {
+	if v, ok := d.GetOk(fld); ok {
+		*dest = int64(v.(int))
+	}
+}
+
+// copyRotationPeriodFieldIfDefined copies the rotation period of a credential, expressed in seconds, if it is
+// defined in the request. The period of a credential whose change was not recorded yet starts now.
+func copyRotationPeriodFieldIfDefined(d *framework.FieldData, fld string, minPeriod int64, period *int64, changed *int64) error {
+	copyDurationFieldIfDefined(d, fld, period)
+	if *period > 0 {
+		if *period < minPeriod {
+			return fmt.Errorf("%s cannot be shorter than %s", fld, formatLeaseDuration(minPeriod))
+		}
+		if *changed == 0 {
+			*changed = time.Now().Unix()
+		}
+	}
+
+	return nil
+}
+
+// copyScopeFieldIfDefined copies the scope rules if the field is defined in the request. An empty list clears
+// the rules.
+func copyScopeFieldIfDefined(d *framework.FieldData, fld string, dest *[]string) error {
+	if v, ok := d.GetOk(fld); ok {
+		rules, err := ParseScopeRules(v.([]string))
+		if err != nil {
+			return err
+		}
+		*dest = rules
+	}
+
+	return nil
+}
+
 func copyBooleanFieldIfDefined(d *framework.FieldData, fld string, dest *bool) {
 	if v, ok := d.GetOk(fld); ok {
 		if val, ok := v.(bool); ok {
@@ -53,6 +92,14 @@ func formatOptionalSecretValue(str []byte) string {
 	}
 }
 
+func formatLeaseDuration(secs int64) string {
+	if secs > 0 {
+		return (time.Second * time.Duration(secs)).String()
+	} else {
+		return "default"
+	}
+}
+
 func formatTLSPinningOption(opt int) string {
 	switch opt {
 	case TLSPinningDefault:
@@ -105,6 +152,56 @@ func appendNoEmpty(val string, key string, builder *strings.Builder) {
 	}
 }
 
+// parseEndpointURL validates the supplied Mashery endpoint URL. An empty input clears the endpoint.
+func parseEndpointURL(input string) (string, error) {
+	if len(input) == 0 {
+		return "", nil
+	}
+
+	if u, err := url.Parse(input); err != nil {
+		return "", errwrap.Wrapf("illegal endpoint URL: {{err}}", err)
+	} else if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.New(fmt.Sprintf("endpoint URL %s must use http or https scheme", input))
+	} else if len(u.Hostname()) == 0 {
+		return "", errors.New(fmt.Sprintf("endpoint URL %s does not contain a host name", input))
+	}
+
+	return input, nil
+}
+
+// copyEndpointFieldIfDefined validates and copies the endpoint URL into the destination, if the field was supplied.
+func copyEndpointFieldIfDefined(d *framework.FieldData, fld string, dest *string) error {
+	if v, ok := d.GetOk(fld); ok {
+		if str, ok := v.(string); ok {
+			if endpoint, err := parseEndpointURL(str); err != nil {
+				return err
+			} else {
+				*dest = endpoint
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyRecipientKeyTypeFieldIfDefined copies the recipient key type if the field is defined in the request. An empty
+// value clears the key type.
+func copyRecipientKeyTypeFieldIfDefined(d *framework.FieldData, fld string, dest *string) error {
+	if v, ok := d.GetOk(fld); ok {
+		if str, ok := v.(string); ok {
+			if len(str) == 0 {
+				*dest = ""
+			} else if keyType, err := ParseRecipientKeyType(str); err != nil {
+				return err
+			} else {
+				*dest = keyType
+			}
+		}
+	}
+
+	return nil
+}
+
 type StringConsumer func(string) error
 
 func consumeStringFieldIfDefined(d *framework.FieldData, fld string, consumer StringConsumer) error {
@@ -135,8 +232,15 @@ func intKeyOf(dat map[string]interface{}, key string) (int, error) {
 	if val != nil {
 		if iVal, ok := val.(int); ok {
 			return iVal, nil
+		} else if lVal, ok := val.(int64); ok {
+			return int(lVal), nil
 		} else if fVal, ok := val.(float64); ok {
 			return int(fVal), nil
+		} else if nVal, ok := val.(json.Number); ok {
+			if lVal, err := nVal.Int64(); err == nil {
+				return int(lVal), nil
+			}
+			return -1, errors.New(fmt.Sprintf("key `%s` is not an integer number", key))
 		} else {
 			return -1, errors.New(fmt.Sprintf("key `%s` is not a recognizable number, but %s", key, reflect.TypeOf(val)))
 		}
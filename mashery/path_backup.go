@@ -0,0 +1,168 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynBackup  = "Take the encrypted backup of the mount"
+	helpDescBackup = `
+Serialize the configuration, the roles, and the identities of this mount into a single encrypted archive. The
+archive is encrypted either for the certificate of the operator or with the key derived from a passphrase. The
+archive encrypted for a certificate is signed with the exporter identity of this mount.
+`
+	helpSynRestore  = "Restore the encrypted backup into this mount"
+	helpDescRestore = `
+Restore the backup taken from this or another mount. The configuration and the identities of the mount are
+replaced only if the overwrite_config parameter is set; existing roles are replaced only if the overwrite
+parameter is set. The backup encrypted for a certificate must be signed by this mount or by a trusted exporter.
+`
+)
+
+var pathBackupFields = map[string]*framework.FieldSchema{
+	pemContainerField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded certificate the backup is encrypted for",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "PEM-encoded certificate of the operator",
+		},
+	},
+	mountBackupPassphraseField: {
+		Type:        framework.TypeString,
+		Description: "Passphrase the backup is encrypted with",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Passphrase",
+			Sensitive: true,
+		},
+	},
+}
+
+var pathRestoreFields = map[string]*framework.FieldSchema{
+	pemContainerField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded backup, obtained from the backup path",
+		Required:    true,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "PEM-encoded backup",
+		},
+	},
+	mountBackupPassphraseField: {
+		Type:        framework.TypeString,
+		Description: "Passphrase the backup was encrypted with",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "Passphrase",
+			Sensitive: true,
+		},
+	},
+	mountBackupRecipientField: {
+		Type:        framework.TypeString,
+		Description: "Recipient identity of this mount the backup was encrypted for",
+		Required:    false,
+	},
+	mountBackupPrivateKeyField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded private key of the certificate the backup was encrypted for",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name:      "PEM-encoded private key",
+			Sensitive: true,
+		},
+	},
+	mountBackupOverwriteField: {
+		Type:        framework.TypeBool,
+		Description: "Replace the roles that already exist on this mount",
+		Required:    false,
+		Default:     false,
+	},
+	mountBackupOverwriteConfigField: {
+		Type:        framework.TypeBool,
+		Description: "Replace the configuration and the identities of this mount",
+		Required:    false,
+		Default:     false,
+	},
+}
+
+func pathBackup(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "backup",
+		Fields:  pathBackupFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.backupMount,
+				Summary:  "Takes the encrypted backup of the mount",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynBackup,
+		HelpDescription: helpDescBackup,
+	}
+}
+
+func pathRestore(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "restore",
+		Fields:  pathRestoreFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.restoreMount,
+				Summary:  "Restores the encrypted backup into this mount",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRestore,
+		HelpDescription: helpDescRestore,
+	}
+}
+
+func (b *AuthPlugin) handleMountBackupOperation(ctx context.Context, req *logical.Request, d *framework.FieldData, chain TransformerFunc[MountBackupContext]) (*logical.Response, error) {
+	var container MountBackupContext = &MountBackupContainer{}
+	return handleOperationWithContainer(ctx, b, req, d, container, b.mountStorageRoot(), chain)
+}
+
+func (b *AuthPlugin) backupMount(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		retrieveExporterIdentity[MountBackupContext],
+		collectMountBackup[MountBackupContext],
+		renderEncryptedMountBackup,
+	)
+
+	return b.handleMountBackupOperation(ctx, req, d, chain)
+}
+
+func (b *AuthPlugin) restoreMount(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// The identities and the keys of the roles are replaced at once
+	b.exporterIdentityLock.Lock()
+	defer b.exporterIdentityLock.Unlock()
+	b.trustedExportersLock.Lock()
+	defer b.trustedExportersLock.Unlock()
+	b.recipientKeysLock.Lock()
+	defer b.recipientKeysLock.Unlock()
+	b.recipientIdentitiesLock.Lock()
+	defer b.recipientIdentitiesLock.Unlock()
+	b.importLedgerLock.Lock()
+	defer b.importLedgerLock.Unlock()
+	b.revocationsLock.Lock()
+	defer b.revocationsLock.Unlock()
+
+	chain := SimpleChain(
+		openMountBackupFromRequest,
+		checkMountBackupEntries,
+		checkMountBackupRestorable,
+		writeMountBackup,
+		applyRestoredConfiguration,
+		renderRestoredMount,
+	)
+
+	return b.handleMountBackupOperation(ctx, req, d, chain)
+}
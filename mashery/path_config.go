@@ -17,6 +17,8 @@ const (
 	netLatencyField       = "net_latency"
 	tlsPinningField       = "tls_pinning"
 
+	allowUnsignedImportsField = "allow_unsigned_imports"
+
 	tlsPinningDefaultOpt  = "default"
 	tlsPinningSystemOpt   = "system"
 	tlsPinningCustomOpt   = "custom"
@@ -61,6 +63,11 @@ var pathBackendConfigFields = map[string]*framework.FieldSchema{
 		Description: "Whether to enable CLI write for V3 write-type commands",
 		Required:    false,
 	},
+	allowUnsignedImportsField: {
+		Type:        framework.TypeBool,
+		Description: "Whether to import role data that is not signed by its exporter, including the data exported by earlier versions",
+		Required:    false,
+	},
 	netLatencyField: {
 		Type:        framework.TypeString,
 		Description: "Network latency between Vault and Mashery",
@@ -73,6 +80,34 @@ var pathBackendConfigFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: "Concatenated PEM file of Root CA certificates to use when connecting to Mashery. Set to - to delegate Root CAs to system",
 	},
+	roleV3EndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V3 API base URL. Defaults to https://api.mashery.com/v3/rest",
+	},
+	roleOAuthTokenEndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V3 OAuth token URL. Defaults to https://api.mashery.com/v3/token",
+	},
+	roleV2EndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V2 JSON-RPC base URL; the area NID is appended. Defaults to https://api.mashery.com/v2/json-rpc",
+	},
+	roleDefaultTTLField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Default TTL of the leases. Defaults to 1 minute for V2 and 15 minutes for V3 leases",
+	},
+	roleMaxTTLField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Maximum TTL of the leases. Defaults to 2 minutes for V2 leases and to the access token lifetime for V3 leases",
+	},
+	roleRecipientKeyTypeField: {
+		Type:        framework.TypeString,
+		Description: "Type of the recipient keys generated for the roles: rsa, ec-p256, ec-p384, or x25519. Defaults to rsa",
+	},
+	roleRenewIncrementField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Increment applied when renewing V3 leases. Defaults to 15 minutes",
+	},
 }
 
 func pathConfig(b *AuthPlugin) *framework.Path {
@@ -141,21 +176,31 @@ func pathCertConfig(b *AuthPlugin, suffix string, pinner targetCertPinningSelect
 }
 
 func (b *AuthPlugin) readConfiguration(_ context.Context, _ *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	cfg := b.config()
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"build version":                  "0.5",
-			oaepLabelField + " (effective)":  formatOptionalSecretValue(b.cfg.EffectiveOAEPLabel()),
-			proxyServerField:                 b.cfg.ProxyServer,
-			proxyServerAuthField:             b.cfg.ProxyServerAuth,
-			proxyServerCredsField:            b.cfg.ProxyServerCreds,
-			cliWriteField:                    b.cfg.CLIWriteEnabled,
-			netLatencyField + " (effective)": b.cfg.EffectiveNetworkLatency().String(),
-			tlsPinningField + " (effective)": formatTLSPinningOption(b.cfg.EffectiveTLSPinning()),
-			tlsPinningField + " (desired)":   formatTLSPinningOption(b.cfg.TLSPinning),
-			rootCAField:                      formatRootCA(&b.cfg),
-			"mashery leaf cert":              formatCertPin(b.cfg.LeafCertPin),
-			"mashery issuer cert":            formatCertPin(b.cfg.IssuerCertPin),
-			"mashery root cert":              formatCertPin(b.cfg.RootCertPin),
+			"build version":                            "0.5",
+			oaepLabelField + " (effective)":            formatOptionalSecretValue(cfg.EffectiveOAEPLabel()),
+			proxyServerField:                           cfg.ProxyServer,
+			proxyServerAuthField:                       cfg.ProxyServerAuth,
+			proxyServerCredsField:                      cfg.ProxyServerCreds,
+			cliWriteField:                              cfg.CLIWriteEnabled,
+			allowUnsignedImportsField:                  cfg.AllowUnsignedImports,
+			netLatencyField + " (effective)":           cfg.EffectiveNetworkLatency().String(),
+			tlsPinningField + " (effective)":           formatTLSPinningOption(cfg.EffectiveTLSPinning()),
+			tlsPinningField + " (desired)":             formatTLSPinningOption(cfg.TLSPinning),
+			rootCAField:                                formatRootCA(cfg),
+			"mashery leaf cert":                        formatCertPin(cfg.LeafCertPin),
+			"mashery issuer cert":                      formatCertPin(cfg.IssuerCertPin),
+			"mashery root cert":                        formatCertPin(cfg.RootCertPin),
+			roleV3EndpointField:                        cfg.V3Endpoint,
+			roleOAuthTokenEndpointField:                cfg.OAuthTokenEndpoint,
+			roleV2EndpointField:                        cfg.V2Endpoint,
+			roleDefaultTTLField:                        formatLeaseDuration(cfg.LeaseDefaultTTL),
+			roleMaxTTLField:                            formatLeaseDuration(cfg.LeaseMaxTTL),
+			roleRenewIncrementField:                    formatLeaseDuration(cfg.LeaseRenewIncrement),
+			roleRecipientKeyTypeField + " (effective)": cfg.EffectiveRecipientKeyType(),
 		},
 	}, nil
 }
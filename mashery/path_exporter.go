@@ -0,0 +1,174 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	trustedExporterNameField = "name"
+
+	helpSynExporterPEM  = "Export the identity certificate of this mount"
+	helpDescExporterPEM = `
+Retrieve the certificate of the identity this mount signs the exported role data with. The administrators of the
+importing Vault add this certificate to the trusted exporters of their mount.
+`
+	helpSynTrustedExporters  = "Exporters whose role data this mount imports"
+	helpDescTrustedExporters = `
+Manage the trust store of the exporters. Role data can only be imported if it is signed by this mount or by one of
+the trusted exporters.
+`
+)
+
+var pathExporterPemFields = map[string]*framework.FieldSchema{
+	pemCommonNameField: {
+		Type:        framework.TypeString,
+		Description: "Common name to specify in the certificate",
+		Required:    false,
+		Default:     "Mashery Exporter",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Common Name",
+		},
+	},
+}
+
+var pathTrustedExporterFields = map[string]*framework.FieldSchema{
+	trustedExporterNameField: {
+		Type:        framework.TypeString,
+		Description: "Name of the trusted exporter",
+		Required:    true,
+	},
+	pemContainerField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded identity certificate of the exporter, obtained from exporter/pem path",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "PEM-encoded identity certificate of the exporter",
+		},
+	},
+}
+
+func pathExporterPEM(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "exporter/pem",
+		Fields:  pathExporterPemFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readExporterIdentity,
+				Summary:  "Retrieves the identity certificate of this mount",
+			},
+		},
+
+		HelpSynopsis:    helpSynExporterPEM,
+		HelpDescription: helpDescExporterPEM,
+	}
+}
+
+func pathTrustedExportersList(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/trusted-exporters/?",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.listTrustedExporters,
+				Summary:  "List trusted exporters",
+			},
+		},
+
+		HelpSynopsis:    helpSynTrustedExporters,
+		HelpDescription: helpDescTrustedExporters,
+	}
+}
+
+func pathTrustedExporter(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/trusted-exporters/" + framework.GenericNameRegex(trustedExporterNameField),
+		Fields:  pathTrustedExporterFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.storeTrustedExporter,
+				Summary:  "Trust the exporter",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.storeTrustedExporter,
+				Summary:  "Update the identity certificate of the trusted exporter",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readTrustedExporter,
+				Summary:  "Read the trusted exporter",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.deleteTrustedExporter,
+				Summary:  "Stop trusting the exporter",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynTrustedExporters,
+		HelpDescription: helpDescTrustedExporters,
+	}
+}
+
+func (b *AuthPlugin) readExporterIdentity(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		retrieveExporterIdentity[ExporterIdentityContext],
+		renderExporterIdentity,
+	)
+
+	var container ExporterIdentityContext = &ExporterIdentityContainer{}
+	return handleOperationWithContainer(ctx, b, req, d, container, b.exporterIdentityPath(), chain)
+}
+
+func (b *AuthPlugin) handleTrustedExportersOperation(ctx context.Context, req *logical.Request, d *framework.FieldData, chain TransformerFunc[TrustedExportersContext]) (*logical.Response, error) {
+	var container TrustedExportersContext = &TrustedExportersContainer{}
+	return handleOperationWithContainer(ctx, b, req, d, container, b.trustedExportersPath(), chain)
+}
+
+func (b *AuthPlugin) listTrustedExporters(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readTrustedExporters[TrustedExportersContext],
+		renderTrustedExportersList,
+	)
+
+	return b.handleTrustedExportersOperation(ctx, req, d, chain)
+}
+
+func (b *AuthPlugin) storeTrustedExporter(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.trustedExportersLock.Lock()
+	defer b.trustedExportersLock.Unlock()
+
+	chain := SimpleChain(
+		readTrustedExporters[TrustedExportersContext],
+		addTrustedExporterFromRequest,
+		saveTrustedExporters[TrustedExportersContext],
+		renderTrustedExporter,
+	)
+
+	return b.handleTrustedExportersOperation(ctx, req, d, chain)
+}
+
+func (b *AuthPlugin) readTrustedExporter(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readTrustedExporters[TrustedExportersContext],
+		renderTrustedExporter,
+	)
+
+	return b.handleTrustedExportersOperation(ctx, req, d, chain)
+}
+
+func (b *AuthPlugin) deleteTrustedExporter(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.trustedExportersLock.Lock()
+	defer b.trustedExportersLock.Unlock()
+
+	chain := SimpleChain(
+		readTrustedExporters[TrustedExportersContext],
+		removeTrustedExporter,
+		saveTrustedExporters[TrustedExportersContext],
+	)
+
+	return b.handleTrustedExportersOperation(ctx, req, d, chain)
+}
@@ -54,6 +54,7 @@ func (b *AuthPlugin) proxyV2Request(ctx context.Context, req *logical.Request, d
 	sr.Append(
 		readRole[RoleContext](true),
 		allowOnlyV2CapableRole[RoleContext],
+		blockV2MethodOutOfScope[RoleContext](v2Request.Method),
 		blockUsageExceedingLimits[RoleContext],
 		decreaseRemainingUsageQuota[RoleContext],
 	)
@@ -151,26 +151,31 @@ func (b *AuthPlugin) proxyV3Request(ctx context.Context, req *logical.Request, d
 		"method", methSwitch)
 
 	var fetchFunc TransformerFunc[WildcardAPIResponseContext]
+	var httpMethod string
 
 	switch methSwitch {
 	case ProxyMethodGet:
 		b.Logger().Trace("Executing V3 GET proxy")
 		fetchFunc = b.fetchV3Resource(path, vals)
+		httpMethod = "GET"
 	case ProxyMethodPost:
 		b.Logger().Trace("Executing V3 POST proxy")
 		fetchFunc = b.writeToV3Resource(path, methodPOST, req.Data)
+		httpMethod = "POST"
 	case ProxyMethodPut:
 		b.Logger().Trace("Executing V3 PUT proxy")
 		fetchFunc = b.writeToV3Resource(path, methodPUT, req.Data)
+		httpMethod = "PUT"
 	case ProxyMethodDelete:
 		b.Logger().Trace("Executing V3 DELETE proxy")
 		fetchFunc = b.deleteV3Resource(path)
+		httpMethod = "DELETE"
 	default:
 		b.Logger().Error("cannot establish how to proxy this request: unsupported method switch")
 		return nil, errors.New(fmt.Sprintf("unrecognized method swtich: %d", methSwitch))
 	}
 
-	sr := b.makeBaseV3InvocationChain()
+	sr := b.makeBaseV3InvocationChain(httpMethod, path)
 	sr.Append(
 		fetchFunc,
 		// No error bouncing in proxy mode as the vault is performing only the authentication.
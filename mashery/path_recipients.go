@@ -0,0 +1,263 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	recipientIdentityNameField = "name"
+
+	helpSynRecipients  = "Recipient identities of this mount"
+	helpDescRecipients = `
+Manage the recipient identities of this mount. Unlike the certificate of a role, the certificate of a recipient
+identity can receive the data of any number of roles; importing the data through the identity creates the role.
+`
+	helpSynRecipientPEM  = "Export the certificate of the recipient identity"
+	helpDescRecipientPEM = `
+Retrieve the certificate the exporters encrypt the role data for. The exporter specifies the role the data is
+imported into with the recipient_role parameter of the export.
+`
+	helpSynRecipientImport  = "Import encrypted role data into a new role"
+	helpDescRecipientImport = `
+Import the role data encrypted for the recipient identity. The role is created; its name is taken from the roleName
+parameter or, if it is not given, from the role named by the exporter. Existing roles are not overwritten.
+`
+)
+
+var pathRecipientFields = map[string]*framework.FieldSchema{
+	recipientIdentityNameField: {
+		Type:        framework.TypeString,
+		Description: "Name of the recipient identity",
+		Required:    true,
+	},
+	roleRecipientKeyTypeField: {
+		Type:        framework.TypeString,
+		Description: "Type of the key of the recipient identity: rsa, ec-p256, ec-p384, or x25519. Defaults to the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Recipient key type",
+		},
+	},
+}
+
+var pathRecipientPemFields = map[string]*framework.FieldSchema{
+	recipientIdentityNameField: {
+		Type:        framework.TypeString,
+		Description: "Name of the recipient identity",
+		Required:    true,
+	},
+	pemCommonNameField: {
+		Type:        framework.TypeString,
+		Description: "Common name to specify in the certificate",
+		Required:    false,
+		Default:     "Mashery Recipient",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Common Name",
+		},
+	},
+}
+
+var pathRecipientImportFields = map[string]*framework.FieldSchema{
+	recipientIdentityNameField: {
+		Type:        framework.TypeString,
+		Description: "Name of the recipient identity",
+		Required:    true,
+	},
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role to create; defaults to the role named by the exporter",
+		Required:    false,
+	},
+	pemContainerField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded encrypted role data, obtained using the export path of the exporter",
+		Required:    true,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "PEM-encoded data intended for this recipient identity",
+		},
+	},
+}
+
+func pathRecipientsList(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "recipients/?",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.listRecipientIdentities,
+				Summary:  "List recipient identities",
+			},
+		},
+
+		HelpSynopsis:    helpSynRecipients,
+		HelpDescription: helpDescRecipients,
+	}
+}
+
+func pathRecipient(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "recipients/" + framework.GenericNameRegex(recipientIdentityNameField),
+		Fields:  pathRecipientFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.CreateOperation: &framework.PathOperation{
+				Callback: b.storeRecipientIdentity,
+				Summary:  "Create the recipient identity",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.storeRecipientIdentity,
+				Summary:  "Update the recipient identity; a different key type replaces its key",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readRecipientIdentity,
+				Summary:  "Read the recipient identity",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.deleteRecipientIdentity,
+				Summary:  "Destroy the recipient identity",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRecipients,
+		HelpDescription: helpDescRecipients,
+	}
+}
+
+func pathRecipientPEM(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "recipients/" + framework.GenericNameRegex(recipientIdentityNameField) + "/pem",
+		Fields:  pathRecipientPemFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readRecipientIdentityPEM,
+				Summary:  "Retrieves the PEM certificate of the recipient identity",
+			},
+		},
+
+		HelpSynopsis:    helpSynRecipientPEM,
+		HelpDescription: helpDescRecipientPEM,
+	}
+}
+
+func pathRecipientImport(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "recipients/" + framework.GenericNameRegex(recipientIdentityNameField) + "/import",
+		Fields:  pathRecipientImportFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.importThroughRecipientIdentity,
+				Summary:  "Imports the role data into a new role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRecipientImport,
+		HelpDescription: helpDescRecipientImport,
+	}
+}
+
+func (b *AuthPlugin) handleRecipientIdentityOperation(ctx context.Context, req *logical.Request, d *framework.FieldData, chain TransformerFunc[RecipientIdentityContext], container RecipientIdentityContext) (*logical.Response, error) {
+	path := b.recipientIdentityPath(d.Get(recipientIdentityNameField).(string))
+	return handleOperationWithContainer(ctx, b, req, d, container, path, chain)
+}
+
+func (b *AuthPlugin) listRecipientIdentities(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	if entities, err := req.Storage.List(ctx, b.recipientIdentitiesRoot()); err != nil {
+		return nil, err
+	} else {
+		return logical.ListResponse(entities), nil
+	}
+}
+
+func (b *AuthPlugin) storeRecipientIdentity(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.recipientIdentitiesLock.Lock()
+	defer b.recipientIdentitiesLock.Unlock()
+
+	chain := SimpleChain(
+		readRecipientIdentity[RecipientIdentityContext](false),
+		generateRecipientIdentityKey,
+		saveRecipientIdentity,
+		renderRecipientIdentity,
+	)
+
+	return b.handleRecipientIdentityOperation(ctx, req, d, chain, &RecipientIdentityContainer{})
+}
+
+func (b *AuthPlugin) readRecipientIdentity(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRecipientIdentity[RecipientIdentityContext](true),
+		renderRecipientIdentity,
+	)
+
+	return b.handleRecipientIdentityOperation(ctx, req, d, chain, &RecipientIdentityContainer{})
+}
+
+func (b *AuthPlugin) readRecipientIdentityPEM(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRecipientIdentity[RecipientIdentityContext](true),
+		renderRecipientIdentityCertificate,
+	)
+
+	return b.handleRecipientIdentityOperation(ctx, req, d, chain, &RecipientIdentityContainer{})
+}
+
+func (b *AuthPlugin) deleteRecipientIdentity(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.recipientIdentitiesLock.Lock()
+	defer b.recipientIdentitiesLock.Unlock()
+
+	if err := req.Storage.Delete(ctx, b.recipientIdentityPath(d.Get(recipientIdentityNameField).(string))); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (b *AuthPlugin) importThroughRecipientIdentity(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	bundle, err := retrieveImportPEMBundleFromRequest(d)
+	if err != nil {
+		return logical.ErrorResponse("input does not contain a valid PEM block (%s)", err.Error()), nil
+	}
+
+	// Serializes the creation of the roles
+	b.recipientIdentitiesLock.Lock()
+	defer b.recipientIdentitiesLock.Unlock()
+	b.importLedgerLock.Lock()
+	defer b.importLedgerLock.Unlock()
+
+	// The identity is read first, as the name of the role is taken from the block encrypted for the identity
+	pemBlock := &pem.Block{}
+	identityContainer := &RecipientIdentityContainer{}
+	if resp, err := b.handleRecipientIdentityOperation(ctx, req, d, SimpleChain(
+		readRecipientIdentity[RecipientIdentityContext](true),
+		selectRoleDataBlockForIdentity(bundle, pemBlock),
+	), identityContainer); resp != nil || err != nil {
+		return resp, err
+	}
+
+	name, err := importedRoleName(d, pemBlock)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	explicitRole := name == d.Get(roleName).(string)
+
+	chain := SimpleChain(
+		createImportedRole(name),
+		verifyRoleDataExporter[RecipientImportContext](pemBlock),
+		importPEMEncodedExchangeDataForIdentity(pemBlock, explicitRole),
+		blockReplayedExport[RecipientImportContext](pemBlock),
+		recordImportedExport[RecipientImportContext](pemBlock),
+		saveRoleKeys[RecipientImportContext],
+		saveRoleKeysVersion[RecipientImportContext],
+		saveRoleUsage[RecipientImportContext],
+		renderImportedRole,
+	)
+
+	var container RecipientImportContext = &RecipientImportContainer{RecipientIdentityContainer: *identityContainer}
+	return handleOperationWithContainer(ctx, b, req, d, container, b.rolesStorageRoot()+name, chain)
+}
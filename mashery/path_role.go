@@ -92,6 +92,90 @@ var pathRoleFields = map[string]*framework.FieldSchema{
 		},
 		Default: 2,
 	},
+	roleV3EndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V3 API base URL for this role. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "V3 API endpoint",
+		},
+	},
+	roleOAuthTokenEndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V3 OAuth token URL for this role. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "OAuth token endpoint",
+		},
+	},
+	roleV2EndpointField: {
+		Type:        framework.TypeString,
+		Description: "Mashery V2 JSON-RPC base URL for this role; the area NID is appended. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "V2 API endpoint",
+		},
+	},
+	roleDefaultTTLField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Default TTL of the leases issued for this role. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Lease default TTL",
+		},
+	},
+	roleMaxTTLField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Maximum TTL of the leases issued for this role. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Lease max TTL",
+		},
+	},
+	roleRenewIncrementField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Increment applied when renewing V3 leases of this role. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Lease renew increment",
+		},
+	},
+	roleRecipientKeyTypeField: {
+		Type:        framework.TypeString,
+		Description: "Type of the key the role data is encrypted for when exported to this role: rsa, ec-p256, ec-p384, or x25519. Overrides the mount configuration",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Recipient key type",
+		},
+	},
+	roleScopeAllowField: {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Operations this role is limited to, e.g. 'GET services/**' or 'V2 object.query'",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Allowed scope",
+		},
+	},
+	roleScopeDenyField: {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Operations this role may not perform, e.g. 'DELETE **'",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Denied scope",
+		},
+	},
+	passwordRotationPeriodField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Period after which the password of the Mashery user is rotated automatically. Requires V3 credentials",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Password rotation period",
+		},
+	},
+	secretRotationPeriodField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Period after which the secret of the package key is rotated automatically. Requires V3 credentials",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Secret rotation period",
+		},
+	},
+	verifyField: {
+		Type:        framework.TypeBool,
+		Description: "Verify the credentials with Mashery before storing these",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Verify credentials",
+		},
+	},
 }
 
 // pathRole creates the process for the roles/{roleName} path supporting the "push"-mode credentials storage
@@ -136,36 +220,64 @@ func (b *AuthPlugin) roleExistenceCheck(ctx context.Context,
 
 func (b *AuthPlugin) handleWriteRoleKeys(ctx context.Context, req *logical.Request,
 	data *framework.FieldData) (*logical.Response, error) {
-	chain := SimpleChain(
+	steps := []TransformerFunc[RoleContext]{
 		readRole[RoleContext](false),
 		blockOperationOnImportedRole[RoleContext],
 		updateRoleKeysFromRequest,
+	}
+	steps = appendRoleKeysSaving(data, steps,
 		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		evictPooledRoleClients[RoleContext],
 		setInitialRoleUsage[RoleContext],
 		saveRoleUsage[RoleContext],
 	)
 
-	return handleRoleBoundOperation(ctx, b, req, data, chain)
+	return handleRoleBoundOperation(ctx, b, req, data, SimpleChain(steps...))
 }
 
 func (b *AuthPlugin) handleReadRoleData(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	chain := SimpleChain(
 		readRole[RoleContext](true),
+		readPendingRoleKeys[RoleContext](false),
 		renderRole)
 
 	return handleRoleBoundOperation(ctx, b, req, data, chain)
 }
 
 func (b *AuthPlugin) handleUpdateRoleData(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	chain := SimpleChain(
+	// The update must not be overwritten by promoting the keys staged earlier
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	steps := []TransformerFunc[RoleContext]{
 		readRole[RoleContext](true),
 		blockOperationOnImportedRole[RoleContext],
+		readPendingRoleKeys[RoleContext](false),
+		blockOperationWithPendingKeys,
 		updateRoleKeysFromRequest,
+	}
+	steps = appendRoleKeysSaving(data, steps,
 		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		evictPooledRoleClients[RoleContext],
 		// no Usage reset
 	)
 
-	return handleRoleBoundOperation(ctx, b, req, data, chain)
+	return handleRoleBoundOperation(ctx, b, req, data, SimpleChain(steps...))
+}
+
+// appendRoleKeysSaving appends the steps saving the role keys. If requested, the credentials are verified before
+// these are saved.
+func appendRoleKeysSaving(data *framework.FieldData, steps []TransformerFunc[RoleContext], saving ...TransformerFunc[RoleContext]) []TransformerFunc[RoleContext] {
+	if !data.Get(verifyField).(bool) {
+		return append(steps, saving...)
+	}
+
+	report := &CredentialVerification{}
+	steps = append(steps, verifyRoleCredentialsInto(report))
+	steps = append(steps, saving...)
+	return append(steps, renderCredentialVerification(report))
 }
 
 func (b *AuthPlugin) handleDeleteRoleData(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -176,7 +288,18 @@ func (b *AuthPlugin) handleDeleteRoleData(ctx context.Context, req *logical.Requ
 		return nil, errwrap.Wrapf("failed to delete role private key: {{err}}", err)
 	} else if err := req.Storage.Delete(ctx, roleRoot+storedRoleKeyPathSuffix); err != nil {
 		return nil, errwrap.Wrapf("failed to delete role key data: {{err}}", err)
+	} else if err := req.Storage.Delete(ctx, roleRoot+storedRoleRevocationsPathSuffix); err != nil {
+		return nil, errwrap.Wrapf("failed to delete role revocations: {{err}}", err)
+	} else if err := req.Storage.Delete(ctx, roleRoot+storedRoleRecipientKeysPathSuffix); err != nil {
+		return nil, errwrap.Wrapf("failed to delete role recipient keys: {{err}}", err)
+	} else if err := req.Storage.Delete(ctx, roleRoot+storedRoleExportsPathSuffix); err != nil {
+		return nil, errwrap.Wrapf("failed to delete role exports: {{err}}", err)
+	} else if err := req.Storage.Delete(ctx, roleRoot+storedRolePendingKeysPathSuffix); err != nil {
+		return nil, errwrap.Wrapf("failed to delete role pending keys: {{err}}", err)
+	} else if err := req.Storage.Delete(ctx, roleRoot+storedRoleVersionsPathSuffix); err != nil {
+		return nil, errwrap.Wrapf("failed to delete role key versions: {{err}}", err)
 	}
 
+	b.evictRoleClients(ctx, b.roleName(data))
 	return nil, nil
 }
@@ -0,0 +1,131 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRoleExportControl  = "Signed control messages for the export of the role data"
+	helpDescRoleExportControl = `
+Create a signed control message that revokes the export, extends its term, or adds uses to it. The recipient applies
+the message to the imported role without any secrets being sent again.
+`
+
+	helpSynRoleControl  = "Apply signed control messages of the exporter"
+	helpDescRoleControl = `
+Apply the control message of the exporter to the imported role. The message must be signed by the trusted exporter
+the role was imported from, and must concern the export the role was imported from.
+`
+)
+
+var pathRoleExportControlFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	exportIDField: {
+		Type:        framework.TypeString,
+		Description: "ID of the export",
+		Required:    true,
+	},
+	roleControlActionField: {
+		Type:          framework.TypeString,
+		Description:   "Action of the control message: revoke or extend",
+		Required:      true,
+		AllowedValues: []interface{}{roleControlActionRevoke, roleControlActionExtend},
+	},
+	explicitTermField: {
+		Type:        framework.TypeString,
+		Description: "New term of the export, counted from now",
+		Required:    false,
+	},
+	roleControlAddUsesField: {
+		Type:        framework.TypeInt,
+		Description: "Number of uses to add to the export",
+		Required:    false,
+	},
+}
+
+var pathRoleControlFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	pemContainerField: {
+		Type:        framework.TypeString,
+		Description: "PEM-encoded control message",
+		Required:    true,
+	},
+}
+
+func pathRoleExportControl(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/exports/" + framework.GenericNameRegex(exportIDField) + "/control",
+		Fields:  pathRoleExportControlFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.createRoleControlMessage,
+				Summary:  "Create a signed control message for the export",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleExportControl,
+		HelpDescription: helpDescRoleExportControl,
+	}
+}
+
+func pathRoleControl(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/control",
+		Fields:  pathRoleControlFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.applyRoleControlMessage,
+				Summary:  "Apply the signed control message of the exporter",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleControl,
+		HelpDescription: helpDescRoleControl,
+	}
+}
+
+func (b *AuthPlugin) createRoleControlMessage(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleExportContext](true),
+		retrieveExporterIdentity[RoleExportContext],
+		renderRoleControlMessage,
+	)
+
+	var container RoleExportContext = &RoleExportContainer{}
+	return handleOperationWithContainer(ctx, b, req, d, container, b.storagePathForRole(d), chain)
+}
+
+func (b *AuthPlugin) applyRoleControlMessage(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	blk, msg, err := parseRoleControlBlock(d.Get(pemContainerField).(string))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	b.importLedgerLock.Lock()
+	defer b.importLedgerLock.Unlock()
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		verifyRoleControlMessage(blk, msg),
+		applyRoleControlMessage(msg),
+		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		evictPooledRoleClients[RoleContext],
+		saveRoleUsage[RoleContext],
+		renderAppliedRoleControl(msg),
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
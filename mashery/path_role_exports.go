@@ -0,0 +1,82 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	exportIDField = "export_id"
+
+	helpSynRoleExports  = "Exports of the role data"
+	helpDescRoleExports = `
+List the exports of the role data: who received the credentials of this role, and on which terms. Before rotating
+the credentials of the role, the administrator can find every recipient that holds a copy of these.
+`
+)
+
+var pathRoleExportsFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	exportIDField: {
+		Type:        framework.TypeString,
+		Description: "ID of the export",
+		Required:    false,
+	},
+}
+
+func pathRoleExportsList(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/exports/?",
+		Fields:  pathRoleExportsFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.listRoleExports,
+				Summary:  "List the exports of the role data",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleExports,
+		HelpDescription: helpDescRoleExports,
+	}
+}
+
+func pathRoleExport(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/exports/" + framework.GenericNameRegex(exportIDField),
+		Fields:  pathRoleExportsFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readRoleExport,
+				Summary:  "Read the recipient and the terms of the export",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleExports,
+		HelpDescription: helpDescRoleExports,
+	}
+}
+
+func (b *AuthPlugin) listRoleExports(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		renderRoleExportsList,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
+
+func (b *AuthPlugin) readRoleExport(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		renderRoleExport,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
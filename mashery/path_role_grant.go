@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/masherytypes"
 	"github.com/hashicorp/vault/sdk/framework"
@@ -19,6 +20,14 @@ const (
 	secretMasheryV3Access = "v3_access"
 	secretMasheryV2Access = "v2_access"
 
+	// Lease increment applied to the V3 access leases when neither the caller nor the role configuration
+	// specify the increment.
+	v3LeaseIncrement = time.Minute * 15
+
+	// Default lease settings of the V2 access leases
+	v2LeaseDefaultTTL = time.Minute
+	v2LeaseMaxTTL     = time.Minute * 2
+
 	helpSyncRoleGrant = "Retrieve and share Mashery API access credentials by value"
 	helpDescRoleGrant = `
 The path allows extracting the direct value of the Mashery V2 and/or V3 credentials that can be used in the
@@ -32,7 +41,7 @@ the token is valid. The /grant API always returns a new token.
 `
 )
 
-func v2AccessSecret() *framework.Secret {
+func v2AccessSecret(b *AuthPlugin) *framework.Secret {
 	return &framework.Secret{
 		Type: secretMasheryV2Access,
 		Fields: map[string]*framework.FieldSchema{
@@ -53,13 +62,13 @@ func v2AccessSecret() *framework.Secret {
 				Description: "Maximum QPS this key can achieve",
 			},
 		},
-		DefaultDuration: time.Minute,
-		Renew:           noopRenewRevoke,
-		Revoke:          noopRenewRevoke,
+		DefaultDuration: v2LeaseDefaultTTL,
+		Renew:           b.renewV2AccessLease,
+		Revoke:          b.revokeV2AccessLease,
 	}
 }
 
-func v3AccessSecret() *framework.Secret {
+func v3AccessSecret(b *AuthPlugin) *framework.Secret {
 	return &framework.Secret{
 		Type: secretMasheryV3Access,
 		Fields: map[string]*framework.FieldSchema{
@@ -75,10 +84,10 @@ func v3AccessSecret() *framework.Secret {
 
 		DefaultDuration: time.Minute * 15,
 
-		// The access token lease is not revocable and not renewable, since the access token is cannot be revoked.
-		// The noop revoke is supplied nevertheless to avoid excessive error logging
-		Revoke: noopRenewRevoke,
-		Renew:  noopRenewRevoke,
+		// Mashery cannot revoke the access token. Revoking the lease records the revoked token, so that the
+		// proxy paths will not use it. Renewing the lease exchanges the refresh token for a fresh access token.
+		Revoke: b.revokeV3AccessLease,
+		Renew:  b.renewV3AccessLease,
 	}
 }
 
@@ -143,6 +152,7 @@ func (b *AuthPlugin) issueGrant(ctx context.Context, request *logical.Request, d
 	baseChecks.Append(
 		readRole[RoleContext](true),
 		blockOperationOnForceProxyRole[RoleContext],
+		blockOperationOnScopedRole[RoleContext],
 		blockUsageExceedingLimits[RoleContext],
 		blockRoleIncapableOf[RoleContext](params.apiVersion),
 		decreaseRemainingUsageQuota[RoleContext],
@@ -184,28 +194,174 @@ func (b *AuthPlugin) issueGrant(ctx context.Context, request *logical.Request, d
 	}
 }
 
-func (b *AuthPlugin) createV3LeasedResponse(tkn *masherytypes.TimedAccessTokenResponse, v3Rec *StoredRole) *logical.Response {
-	exp := time.Now().Add(time.Second * time.Duration(tkn.ExpiresIn))
+// renewV3AccessLease renews the V3 access lease by exchanging the refresh token stored in the lease for a new
+// access token. The renewal is subject to the same checks as the grant, and it consumes the role's usage quota.
+func (b *AuthPlugin) renewV3AccessLease(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	storagePath, roleData, ok := b.leaseRoleData(req)
+	if !ok {
+		return logical.ErrorResponse("lease does not refer to a role of this secret engine"), nil
+	}
 
-	b.Logger().Info("Maximum token expiry time", "exp", exp.Unix())
+	baseChecks := SimpleRunner[RoleContext]{}
+	baseChecks.Append(
+		readRole[RoleContext](true),
+		blockRevokedLease[RoleContext],
+		blockOperationOnForceProxyRole[RoleContext],
+		blockOperationOnScopedRole[RoleContext],
+		blockUsageExceedingLimits[RoleContext],
+		blockRoleIncapableOf[RoleContext](3),
+		decreaseRemainingUsageQuota[RoleContext],
+	)
 
-	v3Secret := b.Secret(secretMasheryV3Access)
-	response := v3Secret.Response(map[string]interface{}{
+	var container V3TokenContext
+	container = &V3TokenContextContainer{}
+
+	mr := mapRoleContextToV3TokenContext(&baseChecks)
+	mr.Append(
+		exchangeV3RefreshToken,
+		revokeExchangedV3Token,
+		renderV3LeaseRenewal,
+	)
+
+	return handleOperationWithContainer(ctx, b, req, roleData, container, storagePath, mr.Run)
+}
+
+// renewV2AccessLease renews the V2 access lease, unless the lease was revoked.
+func (b *AuthPlugin) renewV2AccessLease(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	storagePath, roleData, ok := b.leaseRoleData(req)
+	if !ok {
+		return logical.ErrorResponse("lease does not refer to a role of this secret engine"), nil
+	}
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		blockRevokedLease[RoleContext],
+		renderV2LeaseRenewal,
+	)
+
+	var container RoleContext
+	container = &RoleContainer{}
+
+	return handleOperationWithContainer(ctx, b, req, roleData, container, storagePath, chain)
+}
+
+// v3TokenUsableLifetime the time the access token can be used for, leaving a margin before the token expires.
+func v3TokenUsableLifetime(tkn *masherytypes.TimedAccessTokenResponse) time.Duration {
+	rv := time.Duration(math.Round(0.9*float64(time.Second)*float64(tkn.ExpiresIn))) - time.Since(tkn.Obtained)
+	return rv.Round(time.Second)
+}
+
+// capLeaseToRoleTerm caps the lease duration, counted from the lease issue time, so that the lease does not
+// outlive the term of the role.
+func capLeaseToRoleTerm(issueTime time.Time, d time.Duration, role *StoredRole) time.Duration {
+	if role.Usage.ExplicitTerm > 0 {
+		if termTTL := time.Unix(role.Usage.ExplicitTerm, 0).Sub(issueTime); termTTL < d {
+			return termTTL
+		}
+	}
+
+	return d
+}
+
+// v3LeaseMaxTTL computes the maximum TTL of the V3 access lease issued at the specified time. Unless the maximum
+// TTL is configured, the lease may not outlive the access token it carries. The lease never outlives the term of
+// the role.
+func v3LeaseMaxTTL(issueTime time.Time, tkn *masherytypes.TimedAccessTokenResponse, shape LeaseShape, role *StoredRole) time.Duration {
+	rv := time.Since(issueTime) + v3TokenUsableLifetime(tkn)
+	if shape.MaxTTL > 0 {
+		rv = shape.MaxTTL
+	}
+
+	return capLeaseToRoleTerm(issueTime, rv, role)
+}
+
+// v3LeaseTTL caps the desired TTL of the V3 lease to the usable lifetime of its access token and to the maximum
+// TTL of the lease.
+func v3LeaseTTL(desired time.Duration, tkn *masherytypes.TimedAccessTokenResponse, elapsed, maxTTL time.Duration) time.Duration {
+	rv := desired
+	if usable := v3TokenUsableLifetime(tkn); rv > usable {
+		rv = usable
+	}
+	if remaining := maxTTL - elapsed; rv > remaining {
+		rv = remaining
+	}
+
+	return rv.Round(time.Second)
+}
+
+func v3LeaseData(tkn *masherytypes.TimedAccessTokenResponse, role *StoredRole) map[string]interface{} {
+	return map[string]interface{}{
 		secretAccessToken:            tkn.AccessToken,
 		secretAccessTokenExpiryTime:  tkn.ExpiryTime(),
 		secretAccessTokenExpiryEpoch: tkn.ExpiryTime().Unix(),
-		roleQpsField:                 v3Rec.Keys.MaxQPS,
-	}, map[string]interface{}{
+		roleQpsField:                 role.Keys.MaxQPS,
+	}
+}
+
+// createV3LeaseRenewal renders the renewed lease. The lease keeps its identity and issue time, while the internal
+// data is updated to track the refresh token and the expiry time of the exchanged access token.
+func (b *AuthPlugin) createV3LeaseRenewal(secret *logical.Secret, tkn *masherytypes.TimedAccessTokenResponse, role *StoredRole) *logical.Response {
+	issueTime := secret.IssueTime
+	if issueTime.IsZero() {
+		issueTime = time.Now()
+	}
+
+	if len(tkn.RefreshToken) > 0 {
+		secret.InternalData[secretInternalRefreshToken] = tkn.RefreshToken
+	}
+	secret.InternalData[secretInternalTokenExpiryTime] = tkn.ExpiryTime().Unix()
+
+	shape := role.Keys.EffectiveLeaseShape(b.config())
+
+	increment := secret.Increment
+	if increment <= 0 {
+		increment = shape.RenewIncrement
+	}
+	if increment <= 0 {
+		increment = v3LeaseIncrement
+	}
+
+	secret.LeaseOptions.MaxTTL = v3LeaseMaxTTL(issueTime, tkn, shape, role)
+	secret.LeaseOptions.TTL = v3LeaseTTL(increment, tkn, time.Since(issueTime), secret.LeaseOptions.MaxTTL)
+	secret.LeaseOptions.Renewable = true
+
+	b.Logger().Info(fmt.Sprintf("Renewed lease TTL %s, max TTL %s", secret.LeaseOptions.TTL, secret.LeaseOptions.MaxTTL))
+
+	return &logical.Response{
+		Secret: secret,
+		Data:   v3LeaseData(tkn, role),
+	}
+}
+
+func (b *AuthPlugin) createV3LeasedResponse(tkn *masherytypes.TimedAccessTokenResponse, v3Rec *StoredRole) *logical.Response {
+	exp := tkn.ExpiryTime()
+
+	b.Logger().Info("Maximum token expiry time", "exp", exp.Unix())
+
+	v3Secret := b.Secret(secretMasheryV3Access)
+	response := v3Secret.Response(v3LeaseData(tkn, v3Rec), map[string]interface{}{
 		secretInternalRoleStoragePath: v3Rec.StoragePath,
 		secretInternalRefreshToken:    tkn.RefreshToken,
 		secretInternalTokenExpiryTime: exp.Unix(),
 	})
 
-	b.Logger().Info(fmt.Sprintf("Usable token time in seconds: %d, based on %d seconds before exipry time", tkn.ExpiresIn, tkn.ExpiresIn))
+	shape := v3Rec.Keys.EffectiveLeaseShape(b.config())
+
+	ttl := shape.DefaultTTL
+	if ttl <= 0 {
+		ttl = v3Secret.DefaultDuration
+	}
+
+	increment := shape.RenewIncrement
+	if increment <= 0 {
+		increment = v3LeaseIncrement
+	}
 
-	response.Secret.LeaseOptions.MaxTTL = time.Duration(math.Round(0.9 * float64(time.Second) * float64(time.Duration(tkn.ExpiresIn))))
-	response.Secret.LeaseOptions.Increment = time.Minute * 15
-	response.Secret.LeaseOptions.Renewable = true
+	response.Secret.LeaseOptions.MaxTTL = v3LeaseMaxTTL(time.Now(), tkn, shape, v3Rec)
+	response.Secret.LeaseOptions.TTL = v3LeaseTTL(ttl, tkn, 0, response.Secret.LeaseOptions.MaxTTL)
+	response.Secret.LeaseOptions.Increment = increment
+	// Only the leases carrying the refresh token can be renewed
+	response.Secret.LeaseOptions.Renewable = len(tkn.RefreshToken) > 0
 
 	b.Logger().Info(fmt.Sprintf("Response TTL %s", response.Secret.LeaseOptions.TTL))
 	b.Logger().Info(fmt.Sprintf("Response Max TTL %s", response.Secret.LeaseOptions.MaxTTL))
@@ -213,9 +369,8 @@ func (b *AuthPlugin) createV3LeasedResponse(tkn *masherytypes.TimedAccessTokenRe
 	return response
 }
 
-func (b *AuthPlugin) v2SignatureFor(role *StoredRole) string {
-	now := time.Now()
-	rawSig := fmt.Sprintf("%s%s%d", role.Keys.ApiKey, role.Keys.KeySecret, now.Unix())
+func v2Signature(apiKey, secret string, t time.Time) string {
+	rawSig := fmt.Sprintf("%s%s%d", apiKey, secret, t.Unix())
 
 	hash := md5.New()
 	hash.Write([]byte(rawSig))
@@ -223,3 +378,42 @@ func (b *AuthPlugin) v2SignatureFor(role *StoredRole) string {
 
 	return signature
 }
+
+// v2SigningAuthorizer signs each V2 call at the time the call is made. Unlike updating the signature
+// before each call, this is safe for the clients that are shared between concurrent requests. The call whose
+// context carries the revocations of the role is never signed with a revoked signature.
+type v2SigningAuthorizer struct {
+	apiKey string
+	secret string
+}
+
+func newV2SigningAuthorizer(role *StoredRole) *v2SigningAuthorizer {
+	return &v2SigningAuthorizer{
+		apiKey: role.Keys.ApiKey,
+		secret: role.Keys.KeySecret,
+	}
+}
+
+func (v *v2SigningAuthorizer) HeaderAuthorization(_ context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+func (v *v2SigningAuthorizer) QueryStringAuthorization(ctx context.Context) (map[string]string, error) {
+	t := time.Now()
+	if rev, ok := ctx.Value(v2RevocationsContextKey{}).(*StoredRoleRevocations); ok {
+		var unrevoked bool
+		// The V2 client makes the call without the authorization then, which Mashery refuses
+		if t, unrevoked = unrevokedV2SigningTime(rev, v.apiKey, v.secret, t); !unrevoked {
+			return nil, errors.New("V2 signature has been revoked; retry the call later")
+		}
+	}
+
+	return map[string]string{
+		"apikey": v.apiKey,
+		"sig":    v2Signature(v.apiKey, v.secret, t),
+	}, nil
+}
+
+func (v *v2SigningAuthorizer) Close() {
+	// Nothing to do
+}
@@ -2,12 +2,18 @@ package mashery
 
 import (
 	"context"
+	"encoding/pem"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
 
 const (
-	roleNamePEMHeader = "Role"
+	roleNamePEMHeader      = "Role"
+	recipientRolePEMHeader = "Recipient Role"
+	originRolePEMHeader    = "Origin Role"
+
+	// Recipient role of the data exported to a certificate that doesn't specify it
+	unspecifiedRecipientRole = "---not specified---"
 
 	pemCommonNameField   = "cn"
 	pemContainerField    = "pem"
@@ -18,6 +24,9 @@ const (
 	onlyV3Field          = "v3_only"
 	forceProxyModeField  = "force_proxy_mode"
 	exportableField      = "exportable"
+	delegationDepthField = "delegation_depth"
+	recipientRoleField   = "recipient_role"
+	importBeforeField    = "import_before"
 
 	masheryRoleRecipientPEMBlockName = "MASHERY ROLE RECIPIENT"
 	masheryRoleDataPEMBlockName      = "MASHERY ROLE DATA"
@@ -25,6 +34,11 @@ const (
 	helpSynRolePEM  = "Export role certificate"
 	helpDescRolePEM = `
 Retrieve the role certificate for encrypting the data exchanges of Mashery credentials in transit.
+`
+	helpSynRolePEMRotate  = "Rotate role recipient key"
+	helpDescRolePEMRotate = `
+Replace the recipient key of the role with a new key. The previous key remains usable for importing the data
+exported to its certificate until the grace period ends; afterwards, the previous key is destroyed.
 `
 	helpSynRoleExport  = "Export encrypted role data"
 	helpDescRoleExport = `
@@ -53,6 +67,31 @@ var pathRolePemReadFields = map[string]*framework.FieldSchema{
 	},
 }
 
+var pathRolePemRotateFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Mashery area logical name",
+	},
+	pemCommonNameField: {
+		Type:        framework.TypeString,
+		Description: "Common name to specify in the certificate of the new key",
+		Required:    false,
+		Default:     "Bearer",
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Common Name",
+		},
+	},
+	recipientKeyGracePeriodField: {
+		Type:        framework.TypeDurationSecond,
+		Description: "Time the previous key can still be used to import the role data; 0 destroys it immediately",
+		Required:    false,
+		Default:     int(defaultRecipientKeyGracePeriod.Seconds()),
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Grace period",
+		},
+	},
+}
+
 var pathRolePemImportFields = map[string]*framework.FieldSchema{
 	roleName: {
 		Type:        framework.TypeString,
@@ -67,6 +106,11 @@ var pathRolePemImportFields = map[string]*framework.FieldSchema{
 			Name: "PEM-encoded data intended for this role",
 		},
 	},
+	inspectField: {
+		Type:        framework.TypeBool,
+		Description: "Report what the data contains and what importing it would change, without importing it",
+		Required:    false,
+	},
 }
 
 var pathRoleExportFields = map[string]*framework.FieldSchema{
@@ -77,12 +121,22 @@ var pathRoleExportFields = map[string]*framework.FieldSchema{
 	},
 	pemContainerField: {
 		Type:        framework.TypeString,
-		Description: "PEM-encoded certificate data, obtained from ./pem path",
-		Required:    true,
+		Description: "PEM-encoded certificate data, obtained from ./pem path. Required unless the recipients are supplied",
+		Required:    false,
 		DisplayAttrs: &framework.DisplayAttributes{
 			Name: "PEM-encoded certificate of the recipient",
 		},
 	},
+	recipientsField: {
+		Type:        framework.TypeSlice,
+		Description: "Recipients to export a bundle to; each recipient is an object carrying the pem and, optionally, the export settings that override the settings of this export",
+		Required:    false,
+	},
+	recipientRoleField: {
+		Type:        framework.TypeString,
+		Description: "Role the recipient identity should import the data into",
+		Required:    false,
+	},
 	explicitTermField: {
 		Type:        framework.TypeString,
 		Description: "The term that the recipient can use the exported data",
@@ -96,6 +150,14 @@ var pathRoleExportFields = map[string]*framework.FieldSchema{
 		Description: "Number of times the recipient can use the exported data",
 		Required:    false,
 	},
+	importBeforeField: {
+		Type:        framework.TypeString,
+		Description: "Period, or the date, by which the recipient must import the exported data",
+		Required:    false,
+		DisplayAttrs: &framework.DisplayAttributes{
+			Name: "Import deadline",
+		},
+	},
 	onlyV2Field: {
 		Type:        framework.TypeBool,
 		Description: "Disable V2 for the recipient",
@@ -122,6 +184,21 @@ var pathRoleExportFields = map[string]*framework.FieldSchema{
 		Description: "Allows the recipient to re-export the role further",
 		Required:    false,
 	},
+	delegationDepthField: {
+		Type:        framework.TypeInt,
+		Description: "Number of further re-exports the recipient may delegate the role through",
+		Required:    false,
+	},
+	roleScopeAllowField: {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Operations the recipient is limited to, e.g. 'GET services/**' or 'V2 object.query'",
+		Required:    false,
+	},
+	roleScopeDenyField: {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Operations the recipient may not perform, e.g. 'DELETE **'",
+		Required:    false,
+	},
 }
 
 func pathRoleImpExpGetPEM(b *AuthPlugin) *framework.Path {
@@ -143,6 +220,25 @@ func pathRoleImpExpGetPEM(b *AuthPlugin) *framework.Path {
 	}
 }
 
+func pathRoleImpExpRotatePEM(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/pem/rotate",
+		Fields:  pathRolePemRotateFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRolePEMRotate,
+				Summary:  "Rotates the recipient key and retrieves the new PEM certificate",
+			},
+		},
+
+		ExistenceCheck: b.roleExistenceCheck,
+
+		HelpSynopsis:    helpSynRolePEMRotate,
+		HelpDescription: helpDescRolePEMRotate,
+	}
+}
+
 func pathRoleImpExpExport(b *AuthPlugin) *framework.Path {
 	return &framework.Path{
 		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/export",
@@ -195,22 +291,59 @@ func (b *AuthPlugin) pathRoleExport(ctx context.Context, req *logical.Request,
 		readRole[RoleExportContext](true),
 		blockNonExportableRole,
 		readRecipientCertificate,
-		renderEncryptedRoleData,
+		retrieveExporterIdentity[RoleExportContext],
+		recordRoleExports(renderEncryptedRoleData),
 	)
 
+	if _, ok := d.GetOk(recipientsField); ok {
+		readChain = SimpleChain(
+			readRole[RoleExportContext](true),
+			blockNonExportableRole,
+			retrieveExporterIdentity[RoleExportContext],
+			recordRoleExports(renderEncryptedRoleDataBundle),
+		)
+	}
+
 	var container RoleExportContext = &RoleExportContainer{}
 	return handleOperationWithContainer(ctx, b, req, d, container, b.storagePathForRole(d), readChain)
 }
 
 func (b *AuthPlugin) pathRoleImport(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	if pemBlock, pemErr := retrieveImportPEMBlockFromRequest(d); pemErr != nil {
+	if bundle, pemErr := retrieveImportPEMBundleFromRequest(d); pemErr != nil {
 		return logical.ErrorResponse("input does not contain a valid PEM block (%s)", pemErr.Error()), nil
 	} else {
+		pemBlock := &pem.Block{}
+
+		if d.Get(inspectField).(bool) {
+			chain := SimpleChain(
+				readRole[RoleContext](true),
+				readRecipientKeysForInspection,
+				selectRoleDataBlockForRole(bundle, pemBlock),
+				verifyRoleDataExporter[RoleContext](pemBlock),
+				inspectPEMEncodedExchangeData(pemBlock),
+			)
+
+			return handleRoleBoundOperation(ctx, b, req, d, chain)
+		}
+
+		// The recipient keys may be generated or pruned
+		b.recipientKeysLock.Lock()
+		defer b.recipientKeysLock.Unlock()
+		b.importLedgerLock.Lock()
+		defer b.importLedgerLock.Unlock()
+
 		chain := SimpleChain(
 			readRole[RoleContext](true),
 			retrievePrivateKey[RoleContext],
+			retrieveRetiredRecipientKeys[RoleContext],
+			selectRoleDataBlockForRole(bundle, pemBlock),
+			verifyRoleDataExporter[RoleContext](pemBlock),
 			importPEMEncodedExchangeData(pemBlock),
+			blockReplayedExport[RoleContext](pemBlock),
+			recordImportedExport[RoleContext](pemBlock),
 			saveRoleKeys[RoleContext],
+			saveRoleKeysVersion[RoleContext],
+			evictPooledRoleClients[RoleContext],
 			saveRoleUsage[RoleContext],
 		)
 
@@ -219,9 +352,28 @@ func (b *AuthPlugin) pathRoleImport(ctx context.Context, req *logical.Request, d
 }
 
 func (b *AuthPlugin) pathRolePEMRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// The recipient keys may be generated or pruned
+	b.recipientKeysLock.Lock()
+	defer b.recipientKeysLock.Unlock()
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		retrievePrivateKey[RoleContext],
+		retrieveRetiredRecipientKeys[RoleContext],
+		renderRoleCertificate,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
+
+func (b *AuthPlugin) pathRolePEMRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	b.recipientKeysLock.Lock()
+	defer b.recipientKeysLock.Unlock()
+
 	chain := SimpleChain(
 		readRole[RoleContext](true),
 		retrievePrivateKey[RoleContext],
+		rotateRecipientKey,
 		renderRoleCertificate,
 	)
 
@@ -0,0 +1,106 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+)
+
+const (
+	helpSynRoleLeasesRevokeAll  = "Revoke all credentials issued for this role"
+	helpDescRoleLeasesRevokeAll = `
+The path revokes all leases issued for this role so far: these leases cannot be renewed anymore, and the V3 access
+token cached by this role is discarded. Note that Mashery does not support revoking an access token; the tokens
+that were already handed out will remain accepted by Mashery until they expire.
+`
+)
+
+func pathRoleLeasesRevokeAll(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/leases/revoke-all",
+		Fields: map[string]*framework.FieldSchema{
+			roleName: {
+				Type:        framework.TypeString,
+				Description: "Role name",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.revokeAllRoleLeases,
+				Summary:  "Revoke all credentials issued for this role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRoleLeasesRevokeAll,
+		HelpDescription: helpDescRoleLeasesRevokeAll,
+	}
+}
+
+func (b *AuthPlugin) revokeAllRoleLeases(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		revokeAllRoleLeases,
+		forgetUsedToken,
+		saveRoleUsage[RoleContext],
+		evictPooledRoleClients[RoleContext],
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
+
+// leaseRoleData resolves the storage path of the role the lease was issued for, and the field data identifying
+// this role. The last value is false if the lease does not refer to a role of this mount.
+func (b *AuthPlugin) leaseRoleData(req *logical.Request) (string, *framework.FieldData, bool) {
+	storagePath, _ := req.Secret.InternalData[secretInternalRoleStoragePath].(string)
+	if !strings.HasPrefix(storagePath, b.rolesStorageRoot()) || len(storagePath) == len(b.rolesStorageRoot()) {
+		return "", nil, false
+	}
+
+	roleData := &framework.FieldData{
+		Raw: map[string]interface{}{
+			roleName: strings.TrimPrefix(storagePath, b.rolesStorageRoot()),
+		},
+		Schema: pathRoleGrantFields,
+	}
+
+	return storagePath, roleData, true
+}
+
+func (b *AuthPlugin) revokeV3AccessLease(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	return b.revokeLease(ctx, req, revokeLeasedV3Token)
+}
+
+func (b *AuthPlugin) revokeV2AccessLease(ctx context.Context, req *logical.Request, _ *framework.FieldData) (*logical.Response, error) {
+	return b.revokeLease(ctx, req, revokeLeasedV2Signature)
+}
+
+// revokeLease records the revocation of the credentials carried by the lease. The revocation of the lease
+// issued for a role that has since been deleted succeeds without further action.
+func (b *AuthPlugin) revokeLease(ctx context.Context, req *logical.Request, revokeFunc TransformerFunc[RoleContext]) (*logical.Response, error) {
+	storagePath, roleData, ok := b.leaseRoleData(req)
+	if !ok {
+		b.Logger().Warn("revoked lease does not refer to a role; nothing to revoke")
+		return nil, nil
+	}
+
+	if exists, err := b.checkObjectExistsInStorage(ctx, req, storagePath+storedRoleKeyPathSuffix); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	chain := SimpleChain(
+		readRole[RoleContext](false),
+		revokeFunc,
+	)
+
+	var container RoleContext
+	container = &RoleContainer{}
+
+	return handleOperationWithContainer(ctx, b, req, roleData, container, storagePath, chain)
+}
@@ -0,0 +1,79 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRolePasswordRotate  = "Rotate the password of the Mashery user of this role"
+	helpDescRolePasswordRotate = `
+The path changes the password of the Mashery user of this role to a generated one. The password is changed through
+the Mashery V3 API using the credentials of this role, and is stored only after Mashery has granted an access token
+for it. The V3 access token cached by this role is discarded, and the pooled Mashery API clients of this role are
+closed.
+
+The password is not rotated while the role has active exports carrying it, as the recipients cannot use these
+exports once the password is rotated, unless the force parameter is set. The scheduled rotation is not forced.
+
+The password can also be rotated on schedule by setting password_rotation_period on the role.
+`
+)
+
+var pathRolePasswordRotateFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	forceRotationField: {
+		Type:        framework.TypeBool,
+		Description: "Rotate the password even though the active exports of this role carry it",
+		Required:    false,
+		Default:     false,
+	},
+}
+
+func pathRolePasswordRotate(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/rotate-password",
+		Fields:  pathRolePasswordRotateFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.rotateRolePassword,
+				Summary:  "Rotate the password of the Mashery user of this role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRolePasswordRotate,
+		HelpDescription: helpDescRolePasswordRotate,
+	}
+}
+
+func (b *AuthPlugin) rotateRolePassword(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		blockOperationOnImportedRole[RoleContext],
+		allowOnlyV3CapableRole[RoleContext],
+		readPendingRoleKeys[RoleContext](false),
+		blockOperationWithPendingKeys,
+		blockRotationOfExportedCredential(passwordRotation),
+		rotateRoleCredential(passwordRotation),
+		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		forgetUsedToken,
+		saveRoleUsage[RoleContext],
+		evictPooledRoleClients[RoleContext],
+		deletePendingRoleKeys,
+		warnOfRotatedExports(passwordRotation, renderRotatedPassword),
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
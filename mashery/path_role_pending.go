@@ -0,0 +1,182 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRolePendingKeys  = "Stage the credentials replacing the active credentials of this role"
+	helpDescRolePendingKeys = `
+The path stages the new Mashery credentials of this role, e.g. a new package key secret or a new user password,
+next to the active credentials. The role keeps using the active credentials until the pending ones are promoted.
+The credentials that are not staged keep their active values.
+`
+
+	helpSynRolePendingKeysPromote  = "Make the pending credentials of this role the active ones"
+	helpDescRolePendingKeysPromote = `
+The path replaces the active credentials of this role with the pending ones. The V3 access token cached by this role
+is discarded, and the pooled Mashery API clients of this role are closed, so that the next calls use the promoted
+credentials. With verify=true, the pending credentials are promoted only if Mashery accepts these.
+`
+
+	helpSynRolePendingKeysAbort  = "Discard the pending credentials of this role"
+	helpDescRolePendingKeysAbort = `
+The path discards the pending credentials of this role. The active credentials remain unchanged.
+`
+)
+
+var pathRolePendingKeysFields = map[string]*framework.FieldSchema{
+	roleName:          pathRoleFields[roleName],
+	roleAreaIdField:   pathRoleFields[roleAreaIdField],
+	roleAreaNidField:  pathRoleFields[roleAreaNidField],
+	roleApiKeField:    pathRoleFields[roleApiKeField],
+	roleSecretField:   pathRoleFields[roleSecretField],
+	roleUsernameField: pathRoleFields[roleUsernameField],
+	rolePasswordField: pathRoleFields[rolePasswordField],
+}
+
+func pathRolePendingKeys(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/pending",
+		Fields:  pathRolePendingKeysFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.stagePendingRoleKeys,
+				Summary:  "Stage the credentials replacing the active credentials of this role",
+			},
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readPendingRoleKeys,
+				Summary:  "Read which credentials of this role are pending",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRolePendingKeys,
+		HelpDescription: helpDescRolePendingKeys,
+	}
+}
+
+func pathRolePendingKeysPromote(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/pending/promote",
+		Fields: map[string]*framework.FieldSchema{
+			roleName: {
+				Type:        framework.TypeString,
+				Description: "Role name",
+				Required:    true,
+			},
+			verifyField: {
+				Type:        framework.TypeBool,
+				Description: "Verify the pending credentials with Mashery before promoting these",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.promotePendingRoleKeys,
+				Summary:  "Make the pending credentials of this role the active ones",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRolePendingKeysPromote,
+		HelpDescription: helpDescRolePendingKeysPromote,
+	}
+}
+
+func pathRolePendingKeysAbort(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/pending/abort",
+		Fields: map[string]*framework.FieldSchema{
+			roleName: {
+				Type:        framework.TypeString,
+				Description: "Role name",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.abortPendingRoleKeys,
+				Summary:  "Discard the pending credentials of this role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRolePendingKeysAbort,
+		HelpDescription: helpDescRolePendingKeysAbort,
+	}
+}
+
+func (b *AuthPlugin) stagePendingRoleKeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		blockOperationOnImportedRole[RoleContext],
+		stagePendingRoleKeysFromRequest,
+		savePendingRoleKeys,
+		renderPendingRoleKeys,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
+
+func (b *AuthPlugin) readPendingRoleKeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		readPendingRoleKeys[RoleContext](true),
+		renderPendingRoleKeys,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
+
+func (b *AuthPlugin) promotePendingRoleKeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	var report *CredentialVerification
+
+	steps := []TransformerFunc[RoleContext]{
+		readRole[RoleContext](true),
+		blockOperationOnImportedRole[RoleContext],
+		readPendingRoleKeys[RoleContext](true),
+		applyPendingRoleKeys,
+	}
+	if data.Get(verifyField).(bool) {
+		report = &CredentialVerification{}
+		steps = append(steps, verifyRoleCredentialsInto(report))
+	}
+	steps = append(steps,
+		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		forgetUsedToken,
+		saveRoleUsage[RoleContext],
+		evictPooledRoleClients[RoleContext],
+		deletePendingRoleKeys,
+		renderPromotedRoleKeys(report),
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, SimpleChain(steps...))
+}
+
+func (b *AuthPlugin) abortPendingRoleKeys(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		readPendingRoleKeys[RoleContext](true),
+		deletePendingRoleKeys,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
@@ -0,0 +1,82 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRoleSecretRotate  = "Rotate the secret of the package key of this role"
+	helpDescRoleSecretRotate = `
+The path changes the secret of the package key of this role to a generated one. The secret is changed through the
+Mashery V3 API using the credentials of this role, and is stored only after Mashery has accepted the V3 and V2
+credentials carrying it. As Mashery propagates the new secret with a delay, the verification is repeated for up to
+a minute; should Mashery not accept the new secret by then, the secret is changed back.
+
+The V3 access token cached by this role remains in use until it expires, and the calls that are in progress complete
+with the clients they have started with.
+
+The secret is not rotated while the role has active exports, as every export carries the secret, and the recipients
+cannot use these exports once the secret is rotated, unless the force parameter is set. The scheduled rotation is
+not forced.
+
+The secret can also be rotated on schedule by setting secret_rotation_period on the role.
+`
+)
+
+var pathRoleSecretRotateFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	forceRotationField: {
+		Type:        framework.TypeBool,
+		Description: "Rotate the secret even though the active exports of this role carry it",
+		Required:    false,
+		Default:     false,
+	},
+}
+
+func pathRoleSecretRotate(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/rotate-secret",
+		Fields:  pathRoleSecretRotateFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.rotateRoleKeySecret,
+				Summary:  "Rotate the secret of the package key of this role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRoleSecretRotate,
+		HelpDescription: helpDescRoleSecretRotate,
+	}
+}
+
+func (b *AuthPlugin) rotateRoleKeySecret(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+
+	// The access token cached by the role remains valid, and the pooled clients of the role are not closed, so that
+	// the calls in progress are not broken. The clients created from the new secret are pooled separately.
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		blockOperationOnImportedRole[RoleContext],
+		allowOnlyV3CapableRole[RoleContext],
+		readPendingRoleKeys[RoleContext](false),
+		blockOperationWithPendingKeys,
+		blockRotationOfExportedCredential(secretRotation),
+		rotateRoleCredential(secretRotation),
+		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		deletePendingRoleKeys,
+		warnOfRotatedExports(secretRotation, renderRotatedSecret),
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
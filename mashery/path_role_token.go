@@ -55,9 +55,11 @@ func (b *AuthPlugin) readRoleToken(ctx context.Context, request *logical.Request
 	baseChecks.Append(
 		readRole[RoleContext](true),
 		blockOperationOnForceProxyRole[RoleContext],
+		blockOperationOnScopedRole[RoleContext],
 		blockUsageExceedingLimits[RoleContext],
 		blockRoleIncapableOf[RoleContext](3),
 		decreaseRemainingUsageQuota[RoleContext],
+		discardRevokedV3Token[RoleContext],
 		b.ensureAccessTokenValidWithRoleContext,
 	)
 
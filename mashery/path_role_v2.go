@@ -82,6 +82,7 @@ func (b *AuthPlugin) executeV2Write(ctx context.Context, req *logical.Request, d
 		readRole[APIResponseContext[v2client.V2Result]](true),
 		blockUsageExceedingLimits[APIResponseContext[v2client.V2Result]],
 		allowOnlyV2CapableRole[APIResponseContext[v2client.V2Result]],
+		blockV2MethodOutOfScope[APIResponseContext[v2client.V2Result]](v2Request.Method),
 		decreaseRemainingUsageQuota[APIResponseContext[v2client.V2Result]],
 		executeV2CallUsing(v2Request),
 		renderV2Response,
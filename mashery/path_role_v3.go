@@ -162,6 +162,8 @@ func (b *AuthPlugin) v3ObjectExists(ctx context.Context, req *logical.Request, d
 		readRole[RoleContext](true),
 		blockUsageExceedingLimits[RoleContext],
 		allowOnlyV3CapableRole[RoleContext],
+		blockV3OperationOutOfScope[RoleContext]("GET", path),
+		discardRevokedV3Token[RoleContext],
 
 		// Counter is not decreased at this point
 	)
@@ -198,7 +200,12 @@ func (b *AuthPlugin) executeV3Write(ctx context.Context, req *logical.Request, d
 		return nil, errors.New("you need to post a JSON object on this path")
 	}
 
-	sr := b.makeBaseV3InvocationChain()
+	httpMethod := "POST"
+	if meth == methodPUT {
+		httpMethod = "PUT"
+	}
+
+	sr := b.makeBaseV3InvocationChain(httpMethod, path)
 	sr.Append(
 		b.writeToV3Resource(path, meth, postObj),
 		bounceErrorCodes,
@@ -211,7 +218,7 @@ func (b *AuthPlugin) executeV3Write(ctx context.Context, req *logical.Request, d
 func (b *AuthPlugin) executeV3Delete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	path := "/" + d.Get(pathField).(string)
 
-	sr := b.makeBaseV3InvocationChain()
+	sr := b.makeBaseV3InvocationChain("DELETE", path)
 	sr.Append(
 		b.deleteV3Resource(path),
 		bounceErrorCodes,
@@ -237,7 +244,7 @@ func (b *AuthPlugin) executeV3Get(ctx context.Context, req *logical.Request, d *
 		renderingFunc = renderV3ObjectCountResponse
 	}
 
-	sr := b.makeBaseV3InvocationChain()
+	sr := b.makeBaseV3InvocationChain("GET", path)
 	sr.Append(
 		b.fetchV3Resource(path, vals),
 		bounceErrorCodes,
@@ -247,12 +254,15 @@ func (b *AuthPlugin) executeV3Get(ctx context.Context, req *logical.Request, d *
 	return handleWildcardAPIRoleBoundOperation(ctx, b, req, d, sr.Run)
 }
 
-func (b *AuthPlugin) makeBaseV3InvocationChain() SimpleRunner[WildcardAPIResponseContext] {
+// makeBaseV3InvocationChain creates the checks preceding the invocation of the V3 method on the path.
+func (b *AuthPlugin) makeBaseV3InvocationChain(method string, path string) SimpleRunner[WildcardAPIResponseContext] {
 	rv := SimpleRunner[WildcardAPIResponseContext]{}
 	rv.Append(
 		readRole[WildcardAPIResponseContext](true),
 		blockUsageExceedingLimits[WildcardAPIResponseContext],
 		allowOnlyV3CapableRole[WildcardAPIResponseContext],
+		blockV3OperationOutOfScope[WildcardAPIResponseContext](method, path),
+		discardRevokedV3Token[WildcardAPIResponseContext],
 		b.ensureAccessTokenValid,
 		decreaseRemainingUsageQuota[WildcardAPIResponseContext],
 	)
@@ -268,7 +278,7 @@ func (b *AuthPlugin) executeV3List(ctx context.Context, req *logical.Request, d
 	}
 	vals := buildQueryString(d, offsetField, limitField, selectFieldsField, filterField, sortField)
 
-	sr := b.makeBaseV3InvocationChain()
+	sr := b.makeBaseV3InvocationChain("GET", path)
 	sr.Append(
 		b.fetchV3Resource(path, vals),
 		bounceErrorCodes,
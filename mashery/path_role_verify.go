@@ -0,0 +1,50 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRoleVerify  = "Verify the credentials of this role with Mashery"
+	helpDescRoleVerify = `
+The path verifies the stored credentials of this role: the V3 credentials by obtaining an access token, and the V2
+credentials by a signed call that changes nothing. The response reports the outcome for each capability, including
+the error codes Mashery has responded with, and whether the area UUID and the area NID identify the same area.
+`
+)
+
+func pathRoleVerify(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/verify",
+		Fields: map[string]*framework.FieldSchema{
+			roleName: {
+				Type:        framework.TypeString,
+				Description: "Role name",
+				Required:    true,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.verifyRoleCredentials,
+				Summary:  "Verify the credentials of this role with Mashery",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRoleVerify,
+		HelpDescription: helpDescRoleVerify,
+	}
+}
+
+func (b *AuthPlugin) verifyRoleCredentials(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		renderRoleCredentialVerification,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, data, chain)
+}
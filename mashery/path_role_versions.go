@@ -0,0 +1,149 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	helpSynRoleVersions  = "Versions of the keys of this role"
+	helpDescRoleVersions = `
+List and read the most recent versions of the keys of this role. A version is recorded each time the keys are saved,
+e.g. when the role is updated, re-imported, or its credentials are rotated. The credentials of a version are masked.
+`
+
+	helpSynRoleRollback  = "Restore a version of the keys of this role"
+	helpDescRoleRollback = `
+The path replaces the keys of this role with a retained version. The restored keys are recorded as the latest version.
+The V3 access token cached by this role is discarded, and the pooled Mashery API clients of this role are closed, so
+that the next calls use the restored credentials. With verify=true, the version is restored only if Mashery accepts
+its credentials.
+`
+)
+
+var pathRoleVersionsFields = map[string]*framework.FieldSchema{
+	roleName: {
+		Type:        framework.TypeString,
+		Description: "Role name",
+		Required:    true,
+	},
+	roleVersionField: {
+		Type:        framework.TypeInt,
+		Description: "Version of the role keys",
+		Required:    false,
+	},
+}
+
+func pathRoleVersionsList(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/versions/?",
+		Fields:  pathRoleVersionsFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.listRoleVersions,
+				Summary:  "List the retained versions of the role keys",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleVersions,
+		HelpDescription: helpDescRoleVersions,
+	}
+}
+
+func pathRoleVersion(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/versions/" + framework.GenericNameRegex(roleVersionField),
+		Fields:  pathRoleVersionsFields,
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.readRoleVersion,
+				Summary:  "Read the version of the role keys, with the credentials masked",
+			},
+		},
+
+		HelpSynopsis:    helpSynRoleVersions,
+		HelpDescription: helpDescRoleVersions,
+	}
+}
+
+func pathRoleRollback(b *AuthPlugin) *framework.Path {
+	return &framework.Path{
+		Pattern: "roles/" + framework.GenericNameRegex(roleName) + "/rollback",
+		Fields: map[string]*framework.FieldSchema{
+			roleName: pathRoleVersionsFields[roleName],
+			roleVersionField: {
+				Type:        framework.TypeInt,
+				Description: "Version of the role keys to restore",
+				Required:    true,
+			},
+			verifyField: {
+				Type:        framework.TypeBool,
+				Description: "Verify the credentials of the version with Mashery before restoring these",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.rollbackRoleKeys,
+				Summary:  "Restore a version of the keys of this role",
+			},
+		},
+
+		ExistenceCheck: alwaysExist,
+
+		HelpSynopsis:    helpSynRoleRollback,
+		HelpDescription: helpDescRoleRollback,
+	}
+}
+
+func (b *AuthPlugin) listRoleVersions(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		renderRoleVersionsList,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
+
+func (b *AuthPlugin) readRoleVersion(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	chain := SimpleChain(
+		readRole[RoleContext](true),
+		renderRoleVersion,
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, chain)
+}
+
+func (b *AuthPlugin) rollbackRoleKeys(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// The rollback must neither interleave with a rotation, nor with a control message revoking the imported role
+	b.pendingKeysLock.Lock()
+	defer b.pendingKeysLock.Unlock()
+	b.importLedgerLock.Lock()
+	defer b.importLedgerLock.Unlock()
+
+	var report *CredentialVerification
+
+	steps := []TransformerFunc[RoleContext]{
+		readRole[RoleContext](true),
+		readPendingRoleKeys[RoleContext](false),
+		blockOperationWithPendingKeys,
+		restoreRoleKeysVersion,
+	}
+	if d.Get(verifyField).(bool) {
+		report = &CredentialVerification{}
+		steps = append(steps, verifyRoleCredentialsInto(report))
+	}
+	steps = append(steps,
+		saveRoleKeys[RoleContext],
+		saveRoleKeysVersion[RoleContext],
+		forgetUsedToken,
+		saveRoleUsage[RoleContext],
+		evictPooledRoleClients[RoleContext],
+		renderRestoredRoleKeys(report),
+	)
+
+	return handleRoleBoundOperation(ctx, b, req, d, SimpleChain(steps...))
+}
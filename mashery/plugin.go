@@ -21,70 +21,97 @@ const (
 	httpIdle = time.Minute * -15
 )
 
-type V3ClientAndAuthorizer struct {
-	client   v3client.WildcardClient
-	lastUsed time.Time
-}
-
-type V2ClientAndAuthorizer struct {
-	client        v2client.Client
-	tokenProvider *v2client.V2Authorizer
-	lastUsed      time.Time
-}
-
 type AuthPlugin struct {
 	*framework.Backend
 
-	cfg               BackendConfiguration
-	v3OauthHelper     *v3client.V3OAuthHelper
-	v3OAuthHelperInit sync.Once
-
-	v3Clients   map[string]V3ClientAndAuthorizer
-	v2Clients   map[string]V2ClientAndAuthorizer
-	backendUUID string
+	// Configuration of the mount; it is replaced as a whole under the lock
+	cfg     BackendConfiguration
+	cfgLock sync.RWMutex
+
+	// OAuth helpers, keyed by the token endpoint they are connecting to
+	v3OAuthHelpers     map[string]*v3client.V3OAuthHelper
+	v3OAuthHelpersLock sync.Mutex
+
+	v3Clients        *ClientPool[v3client.WildcardClient]
+	v3TokenRefreshes SingleFlight[StoredRoleUsage]
+	v2Clients        *ClientPool[v2client.Client]
+	backendUUID      string
+
+	// Serializes the updates of the revoked credentials
+	revocationsLock sync.Mutex
+	// Serializes the generation of the exporter identity and the updates of the trusted exporters
+	exporterIdentityLock sync.Mutex
+	trustedExportersLock sync.Mutex
+	// Serializes the rotations of the role recipient keys
+	recipientKeysLock sync.Mutex
+	// Serializes the updates of the recipient identities, and the creation of the roles imported through these
+	recipientIdentitiesLock sync.Mutex
+	// Serializes the imports, so that the same export cannot be imported concurrently
+	importLedgerLock sync.Mutex
+	// Serializes the updates of the export ledgers of the roles
+	roleExportsLock sync.Mutex
+	// Serializes staging, promoting, and aborting the pending keys of the roles
+	pendingKeysLock sync.Mutex
+	// Serializes the updates of the key versions of the roles
+	roleVersionsLock sync.Mutex
 
 	vaultStorage VaultStorage
 }
 
-func (b *AuthPlugin) GetOAuthHelper() *v3client.V3OAuthHelper {
-	b.v3OAuthHelperInit.Do(func() {
-		params := v3client.OAuthHelperParams{
-			HTTPClientParams: transport.HTTPClientParams{
-				TLSConfig:            b.cfg.EffectiveTLSConfiguration(),
-				ProxyServer:          b.cfg.ProxyServerURL(),
-				ProxyAuthType:        b.cfg.ProxyServerAuth,
-				ProxyAuthCredentials: b.cfg.ProxyServerCreds,
-			},
-		}
-
-		// Make sure the helper will delegate this to the system.
-		if b.cfg.EffectiveTLSPinning() == TLSPinningSystem {
-			params.HTTPClientParams.TLSConfigDelegateSystem = true
-		}
+// config returns the snapshot of the configuration of the mount. The snapshot is not affected by the configuration
+// updates that follow.
+func (b *AuthPlugin) config() *BackendConfiguration {
+	b.cfgLock.RLock()
+	defer b.cfgLock.RUnlock()
 
-		b.v3OauthHelper = v3client.NewOAuthHelper(params)
-	})
-
-	return b.v3OauthHelper
+	cfg := b.cfg
+	return &cfg
 }
 
-func (b *AuthPlugin) AcceptConfigurationUpdate(ctx context.Context, newCfg BackendConfiguration) {
-	b.cfg = newCfg
+// GetOAuthHelper returns the OAuth helper connecting to the token endpoint that is effective for this role.
+func (b *AuthPlugin) GetOAuthHelper(role *StoredRole) *v3client.V3OAuthHelper {
+	cfg := b.config()
+	endpoint := role.Keys.EffectiveOAuthTokenEndpoint(cfg)
 
-	b.v3OAuthHelperInit = sync.Once{}
-	for k := range b.v2Clients {
-		cl := b.v2Clients[k]
-		delete(b.v2Clients, k)
+	b.v3OAuthHelpersLock.Lock()
+	defer b.v3OAuthHelpersLock.Unlock()
 
-		cl.client.Close(ctx)
+	if helper := b.v3OAuthHelpers[endpoint]; helper != nil {
+		return helper
 	}
 
-	for k := range b.v3Clients {
-		cl := b.v3Clients[k]
-		delete(b.v3Clients, k)
+	params := v3client.OAuthHelperParams{
+		HTTPClientParams: transport.HTTPClientParams{
+			TLSConfig:            cfg.EffectiveTLSConfiguration(),
+			ProxyServer:          cfg.ProxyServerURL(),
+			ProxyAuthType:        cfg.ProxyServerAuth,
+			ProxyAuthCredentials: cfg.ProxyServerCreds,
+		},
+		MasheryTokenEndpoint: endpoint,
+	}
 
-		cl.client.Close(ctx)
+	// Make sure the helper will delegate this to the system.
+	if cfg.EffectiveTLSPinning() == TLSPinningSystem {
+		params.HTTPClientParams.TLSConfigDelegateSystem = true
 	}
+
+	helper := v3client.NewOAuthHelper(params)
+	b.v3OAuthHelpers[endpoint] = helper
+
+	return helper
+}
+
+func (b *AuthPlugin) AcceptConfigurationUpdate(ctx context.Context, newCfg BackendConfiguration) {
+	b.cfgLock.Lock()
+	b.cfg = newCfg
+	b.cfgLock.Unlock()
+
+	b.v3OAuthHelpersLock.Lock()
+	b.v3OAuthHelpers = map[string]*v3client.V3OAuthHelper{}
+	b.v3OAuthHelpersLock.Unlock()
+
+	b.v2Clients.Purge(ctx)
+	b.v3Clients.Purge(ctx)
 }
 
 var contextTokenProvider v3client.V3AccessTokenProvider
@@ -93,72 +120,69 @@ func init() {
 	contextTokenProvider = v3client.NewContextTokenProvider()
 }
 
-func (b *AuthPlugin) GetMasheryV3Client(role *StoredRole) v3client.WildcardClient {
-	key := fmt.Sprintf("%s::%s", b.backendUUID, role.Name)
+func newV3ClientPool() *ClientPool[v3client.WildcardClient] {
+	return NewClientPool(maxPooledClients, func(ctx context.Context, cl v3client.WildcardClient) {
+		cl.Close(ctx)
+	})
+}
 
-	if cl := b.v3Clients[key]; cl.client != nil {
-		cl.lastUsed = time.Now()
-		return cl.client
-	} else {
+func newV2ClientPool() *ClientPool[v2client.Client] {
+	return NewClientPool(maxPooledClients, func(ctx context.Context, cl v2client.Client) {
+		cl.Close(ctx)
+	})
+}
+
+func (b *AuthPlugin) GetMasheryV3Client(ctx context.Context, role *StoredRole) v3client.WildcardClient {
+	return b.v3Clients.Get(ctx, b.getClientLookupKey(role), func() v3client.WildcardClient {
+		cfg := b.config()
 		params := v3client.Params{
-			// Mashery V3 configuration needs to be added!
+			MashEndpoint: role.Keys.EffectiveV3Endpoint(cfg),
 
 			HTTPClientParams: transport.HTTPClientParams{
-				TLSConfig:               b.cfg.EffectiveTLSConfiguration(),
-				TLSConfigDelegateSystem: b.cfg.EffectiveTLSPinning() == TLSPinningSystem,
+				TLSConfig:               cfg.EffectiveTLSConfiguration(),
+				TLSConfigDelegateSystem: cfg.EffectiveTLSPinning() == TLSPinningSystem,
 			},
 			Authorizer:    contextTokenProvider,
 			QPS:           int64(role.Keys.MaxQPS),
 			AvgNetLatency: time.Millisecond * 172,
 		}
 
-		var clInst = v3client.NewWildcardClient(params)
-
-		cl := V3ClientAndAuthorizer{
-			client:   clInst,
-			lastUsed: time.Now(),
-		}
-
-		b.v3Clients[key] = cl
-		return clInst
-	}
-
+		return v3client.NewWildcardClient(params)
+	})
 }
 
-func (b *AuthPlugin) GetMasheryV2Client(role *StoredRole) v2client.Client {
-	key := b.getClientLookupKey(role)
-
-	if cl := b.v2Clients[key]; cl.client != nil {
-		cl.lastUsed = time.Now()
-		cl.tokenProvider.UpdateSignature(b.v2SignatureFor(role))
-		return cl.client
-	} else {
-		b.Logger().Info("Constructing the V2 client", "token", role.Usage.V3Token)
-		var provider = v2client.NewV2Authorizer(role.Keys.ApiKey)
-		provider.UpdateSignature(b.v2SignatureFor(role))
+func (b *AuthPlugin) GetMasheryV2Client(ctx context.Context, role *StoredRole) v2client.Client {
+	return b.v2Clients.Get(ctx, b.getClientLookupKey(role), func() v2client.Client {
+		b.Logger().Info("Constructing the V2 client", "role", role.Name)
 
 		v2Params := v2client.Params{
-			AreaNID:        role.Keys.AreaNid,
-			Authorizer:     provider,
-			QPS:            int64(role.Keys.MaxQPS),
-			TravelTimeComp: time.Millisecond * 172,
+			AreaNID:         role.Keys.AreaNid,
+			Authorizer:      newV2SigningAuthorizer(role),
+			QPS:             int64(role.Keys.MaxQPS),
+			TravelTimeComp:  time.Millisecond * 172,
+			MasheryEndpoint: role.Keys.v2AreaEndpoint(b.config()),
 		}
-		var clInst = v2client.NewHTTPClient(v2Params)
 
-		cl := V2ClientAndAuthorizer{
-			tokenProvider: provider,
-			client:        clInst,
-			lastUsed:      time.Now(),
-		}
-
-		b.v2Clients[key] = cl
-		return clInst
-	}
+		return v2client.NewHTTPClient(v2Params)
+	})
 }
 
+// getClientLookupKey key of the role's clients in the client pools. The key changes whenever the role's
+// credentials or endpoints change, so that a client built from outdated credentials is never re-used.
 func (b *AuthPlugin) getClientLookupKey(role *StoredRole) string {
-	key := fmt.Sprintf("%s::%s", b.backendUUID, role.Name)
-	return key
+	return b.roleClientKeyPrefix(role.Name) + role.Keys.clientFingerprint(b.config())
+}
+
+func (b *AuthPlugin) roleClientKeyPrefix(name string) string {
+	return fmt.Sprintf("%s::%s::", b.backendUUID, name)
+}
+
+// evictRoleClients closes all pooled clients of the role.
+func (b *AuthPlugin) evictRoleClients(ctx context.Context, name string) {
+	prefix := b.roleClientKeyPrefix(name)
+
+	b.v2Clients.EvictPrefix(ctx, prefix)
+	b.v3Clients.EvictPrefix(ctx, prefix)
 }
 
 func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend, error) {
@@ -175,34 +199,30 @@ func (b *AuthPlugin) initOnMount(ctx context.Context, request *logical.Initializ
 	if cfg, err := b.loadBackendConfiguration(ctx, request.Storage); err != nil {
 		return err
 	} else {
+		b.cfgLock.Lock()
 		b.cfg = cfg
+		b.cfgLock.Unlock()
 		return nil
 	}
 }
 
-// Housekeeping performs a housekeeping, freeing the client objects that are not actively used.
-func (b *AuthPlugin) Housekeeping(ctx context.Context, _ *logical.Request) error {
+// Housekeeping performs a housekeeping, freeing the client objects that are not actively used, destroying the
+// retired recipient keys whose grace period has ended, and rotating the credentials of the roles whose rotation
+// period has passed.
+func (b *AuthPlugin) Housekeeping(ctx context.Context, req *logical.Request) error {
 	lastUseCutover := time.Now().Add(httpIdle)
-	for k := range b.v3Clients {
-		if b.v3Clients[k].lastUsed.Before(lastUseCutover) {
-			o := b.v3Clients[k]
-
-			delete(b.v3Clients, k)
-
-			o.client.Close(ctx)
-		}
-	}
 
-	for k := range b.v2Clients {
-		if b.v2Clients[k].lastUsed.Before(lastUseCutover) {
-			o := b.v2Clients[k]
-			delete(b.v2Clients, k)
+	b.v3Clients.EvictIdle(ctx, lastUseCutover)
+	b.v2Clients.EvictIdle(ctx, lastUseCutover)
 
-			o.client.Close(ctx)
-		}
+	pruneErr := b.pruneRetiredRecipientKeys(ctx, req)
+	passwordErr := b.rotateScheduledRolePasswords(ctx, req)
+	if err := b.rotateScheduledRoleKeySecrets(ctx, req); err != nil {
+		return err
+	} else if passwordErr != nil {
+		return passwordErr
 	}
-
-	return nil
+	return pruneErr
 }
 
 func makeNew(conf *logical.BackendConfig) (*AuthPlugin, error) {
@@ -210,12 +230,11 @@ func makeNew(conf *logical.BackendConfig) (*AuthPlugin, error) {
 	vaultStorage := VaultStorageImpl{}
 
 	retVal := AuthPlugin{
-		v3OauthHelper:     nil,
-		v3OAuthHelperInit: sync.Once{},
-		backendUUID:       conf.BackendUUID,
-		v2Clients:         map[string]V2ClientAndAuthorizer{},
-		v3Clients:         map[string]V3ClientAndAuthorizer{},
-		vaultStorage:      &vaultStorage,
+		v3OAuthHelpers: map[string]*v3client.V3OAuthHelper{},
+		backendUUID:    conf.BackendUUID,
+		v2Clients:      newV2ClientPool(),
+		v3Clients:      newV3ClientPool(),
+		vaultStorage:   &vaultStorage,
 	}
 
 	retVal.Backend = &framework.Backend{
@@ -239,10 +258,34 @@ func makeNew(conf *logical.BackendConfig) (*AuthPlugin, error) {
 			pathRolesRoot(&retVal),
 			pathRole(&retVal),
 			pathRoleImpExpGetPEM(&retVal),
+			pathRoleImpExpRotatePEM(&retVal),
 			pathRoleImpExpExport(&retVal),
 			pathRoleImpExpImport(&retVal),
+			pathRoleExportsList(&retVal),
+			pathRoleExport(&retVal),
+			pathRoleExportControl(&retVal),
+			pathRoleControl(&retVal),
+			pathRecipientsList(&retVal),
+			pathRecipient(&retVal),
+			pathRecipientPEM(&retVal),
+			pathRecipientImport(&retVal),
+			pathExporterPEM(&retVal),
+			pathTrustedExportersList(&retVal),
+			pathTrustedExporter(&retVal),
+			pathBackup(&retVal),
+			pathRestore(&retVal),
 			pathRoleGrant(&retVal),
 			pathRoleToken(&retVal),
+			pathRoleLeasesRevokeAll(&retVal),
+			pathRoleVerify(&retVal),
+			pathRolePendingKeys(&retVal),
+			pathRolePendingKeysPromote(&retVal),
+			pathRolePendingKeysAbort(&retVal),
+			pathRolePasswordRotate(&retVal),
+			pathRoleSecretRotate(&retVal),
+			pathRoleVersionsList(&retVal),
+			pathRoleVersion(&retVal),
+			pathRoleRollback(&retVal),
 
 			// Support several flavours of accepting the V2 method.
 			pathRoleV2(&retVal, "roles/"+framework.GenericNameRegex(roleName)+"/v2"),
@@ -255,8 +298,8 @@ func makeNew(conf *logical.BackendConfig) (*AuthPlugin, error) {
 			pathProxyV3WithExplicitMethod(&retVal),
 		},
 		Secrets: []*framework.Secret{
-			v2AccessSecret(),
-			v3AccessSecret(),
+			v2AccessSecret(&retVal),
+			v3AccessSecret(&retVal),
 		},
 	}
 
@@ -320,6 +363,10 @@ func (b *AuthPlugin) DefaultBackendConfiguration() BackendConfiguration {
 }
 
 func (b *AuthPlugin) InitialRole(data *framework.FieldData) StoredRole {
+	return b.initialRoleNamed(b.roleName(data))
+}
+
+func (b *AuthPlugin) initialRoleNamed(name string) StoredRole {
 	return StoredRole{
 		Keys: RoleKeys{
 			MaxQPS:         defaultQPSValue,
@@ -328,8 +375,8 @@ func (b *AuthPlugin) InitialRole(data *framework.FieldData) StoredRole {
 			Imported:       false,
 		},
 		Usage:       StoredRoleUsage{},
-		Name:        b.roleName(data),
-		StoragePath: b.storagePathForRole(data),
+		Name:        name,
+		StoragePath: b.rolesStorageRoot() + name,
 	}
 }
 
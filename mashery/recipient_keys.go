@@ -0,0 +1,246 @@
+package mashery
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	recipientKeyTypeRSA    = "rsa"
+	recipientKeyTypeP256   = "ec-p256"
+	recipientKeyTypeP384   = "ec-p384"
+	recipientKeyTypeX25519 = "x25519"
+
+	// Roles created before the key type could be selected use RSA recipient keys
+	defaultRecipientKeyType = recipientKeyTypeRSA
+)
+
+const (
+	recipientKeyIDLength = 16
+)
+
+var recipientKeyTypes = []string{recipientKeyTypeRSA, recipientKeyTypeP256, recipientKeyTypeP384, recipientKeyTypeX25519}
+
+// ParseRecipientKeyType validates the recipient key type, returning it in the normalized form.
+func ParseRecipientKeyType(s string) (string, error) {
+	kt := strings.ToLower(strings.TrimSpace(s))
+	for _, v := range recipientKeyTypes {
+		if kt == v {
+			return kt, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("unsupported recipient key type %s; use one of %s", s, strings.Join(recipientKeyTypes, ", ")))
+}
+
+// generateRecipientKey generates the recipient private key of the desired type. RSA keys are marshalled as
+// PKCS#1, as the roles created by earlier versions store these; other keys are marshalled as PKCS#8.
+func generateRecipientKey(keyType string) ([]byte, error) {
+	var key interface{}
+	var err error
+
+	switch keyType {
+	case recipientKeyTypeRSA:
+		rsaKey, rsaErr := rsa.GenerateKey(rand.Reader, 4096)
+		if rsaErr != nil {
+			return nil, rsaErr
+		}
+		return x509.MarshalPKCS1PrivateKey(rsaKey), nil
+	case recipientKeyTypeP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case recipientKeyTypeP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case recipientKeyTypeX25519:
+		key, err = ecdh.X25519().GenerateKey(rand.Reader)
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported recipient key type %s", keyType))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+// parseRecipientKey parses the stored recipient private key: *rsa.PrivateKey, *ecdsa.PrivateKey, or
+// *ecdh.PrivateKey for X25519 keys.
+func parseRecipientKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		switch k := key.(type) {
+		case *rsa.PrivateKey, *ecdsa.PrivateKey:
+			return k, nil
+		case *ecdh.PrivateKey:
+			if k.Curve() == ecdh.X25519() {
+				return k, nil
+			}
+		}
+	}
+
+	return nil, errors.New("private key data structure is not understood")
+}
+
+// recipientKeyTypeOf the type of the recipient private key, or an empty string if the key is not supported.
+func recipientKeyTypeOf(pk crypto.PrivateKey) string {
+	switch k := pk.(type) {
+	case *rsa.PrivateKey:
+		return recipientKeyTypeRSA
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return recipientKeyTypeP256
+		case elliptic.P384():
+			return recipientKeyTypeP384
+		}
+	case *ecdh.PrivateKey:
+		if k.Curve() == ecdh.X25519() {
+			return recipientKeyTypeX25519
+		}
+	}
+
+	return ""
+}
+
+// isSupportedRecipientPublicKey checks whether the role data can be encrypted for the public key.
+func isSupportedRecipientPublicKey(pub crypto.PublicKey) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return true
+	case *ecdsa.PublicKey:
+		return k.Curve == elliptic.P256() || k.Curve == elliptic.P384()
+	case *ecdh.PublicKey:
+		return k.Curve() == ecdh.X25519()
+	default:
+		return false
+	}
+}
+
+// recipientKeyID identifier of the recipient key: the leading part of the fingerprint of its public key.
+func recipientKeyID(pk crypto.PrivateKey) (string, error) {
+	key, ok := pk.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return "", errors.New(fmt.Sprintf("unsupported recipient key type %T", pk))
+	}
+
+	return recipientPublicKeyID(key.Public())
+}
+
+// recipientPublicKeyID identifier of the recipient key, computed from the public key of the recipient certificate.
+func recipientPublicKeyID(pub crypto.PublicKey) (string, error) {
+	fp, err := publicKeyFingerprint(pub)
+	if err != nil {
+		return "", err
+	}
+	return fp[:recipientKeyIDLength], nil
+}
+
+// createRecipientCertificate creates the recipient certificate carrying the public key of the recipient private
+// key. The RSA and EC certificates are self-signed; the X25519 key cannot sign, see createX25519RecipientCertificate.
+func createRecipientCertificate(template *x509.Certificate, pk crypto.PrivateKey) ([]byte, error) {
+	switch k := pk.(type) {
+	case *rsa.PrivateKey:
+		template.KeyUsage = x509.KeyUsageDataEncipherment
+		return x509.CreateCertificate(rand.Reader, template, template, &k.PublicKey, k)
+	case *ecdsa.PrivateKey:
+		template.KeyUsage = x509.KeyUsageKeyAgreement
+		return x509.CreateCertificate(rand.Reader, template, template, &k.PublicKey, k)
+	case *ecdh.PrivateKey:
+		if k.Curve() == ecdh.X25519() {
+			template.KeyUsage = x509.KeyUsageKeyAgreement
+			return createX25519RecipientCertificate(template, k.PublicKey())
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("unsupported recipient key type %T", pk))
+}
+
+// createX25519RecipientCertificate creates the certificate carrying the X25519 public key (RFC 8410), signed by a
+// one-off Ed25519 issuer key. The crypto/x509 package does not issue certificates for X25519 keys: the certificate
+// is issued for the Ed25519 key of the issuer, whose subject public key info is then replaced with the equally long
+// one of the X25519 key, and signed again. Like the self-signed certificates, it vouches only for carrying the key.
+func createX25519RecipientCertificate(template *x509.Certificate, pub *ecdh.PublicKey) ([]byte, error) {
+	issuerPub, issuerKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	issuer := *template
+	issuer.Subject.CommonName = template.Subject.CommonName + " Issuer"
+
+	der, err := x509.CreateCertificate(rand.Reader, template, &issuer, issuerPub, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	} else if len(spki) != len(cert.RawSubjectPublicKeyInfo) || bytes.Count(cert.RawTBSCertificate, cert.RawSubjectPublicKeyInfo) != 1 {
+		return nil, errors.New("X25519 public key cannot be placed into the certificate")
+	}
+	tbs := bytes.Replace(cert.RawTBSCertificate, cert.RawSubjectPublicKeyInfo, spki, 1)
+
+	signed := struct {
+		TBSCertificate     asn1.RawValue
+		SignatureAlgorithm pkix.AlgorithmIdentifier
+		SignatureValue     asn1.BitString
+	}{}
+	if _, err = asn1.Unmarshal(der, &signed); err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(issuerKey, tbs)
+	signed.TBSCertificate = asn1.RawValue{FullBytes: tbs}
+	signed.SignatureValue = asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}
+
+	return asn1.Marshal(signed)
+}
+
+// parseRecipientCertificateDER parses the certificate carrying the recipient public key. The crypto/x509 package
+// leaves the public key of the X25519 certificates unparsed; it is parsed from the subject public key info.
+func parseRecipientCertificateDER(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.PublicKey == nil {
+		if pub, pubErr := x509.ParsePKIXPublicKey(cert.RawSubjectPublicKeyInfo); pubErr == nil {
+			cert.PublicKey = pub
+		}
+	}
+	return cert, nil
+}
+
+// EffectiveRecipientKeyType type of the recipient keys generated for the roles of this mount.
+func (bc *BackendConfiguration) EffectiveRecipientKeyType() string {
+	if len(bc.RecipientKeyType) > 0 {
+		return bc.RecipientKeyType
+	}
+	return defaultRecipientKeyType
+}
+
+// EffectiveRecipientKeyType type of the recipient key generated for this role.
+func (ar *RoleKeys) EffectiveRecipientKeyType(cfg *BackendConfiguration) string {
+	if len(ar.RecipientKeyType) > 0 {
+		return ar.RecipientKeyType
+	}
+	return cfg.EffectiveRecipientKeyType()
+}
@@ -0,0 +1,192 @@
+package mashery
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestParseRecipientKeyType(t *testing.T) {
+	kt, err := ParseRecipientKeyType(" EC-P384 ")
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeP384, kt)
+
+	_, err = ParseRecipientKeyType("dsa")
+	assert.Equal(t, "unsupported recipient key type dsa; use one of rsa, ec-p256, ec-p384, x25519", err.Error())
+}
+
+func TestGenerateRecipientKey_AllTypes(t *testing.T) {
+	for _, keyType := range []string{recipientKeyTypeP256, recipientKeyTypeP384, recipientKeyTypeX25519} {
+		der, err := generateRecipientKey(keyType)
+		assert.Nil(t, err)
+
+		pk, err := parseRecipientKey(der)
+		assert.Nil(t, err)
+		assert.Equal(t, keyType, recipientKeyTypeOf(pk))
+	}
+
+	pk, err := parseRecipientKey(randomPrivateKey())
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeRSA, recipientKeyTypeOf(pk))
+}
+
+func TestCreateRecipientCertificate_CarriesX25519Key(t *testing.T) {
+	der, _ := generateRecipientKey(recipientKeyTypeX25519)
+	pk, err := parseRecipientKey(der)
+	assert.Nil(t, err)
+
+	template := createRoleCertificateTemplate("x25519", time.Now(), time.Now().Add(time.Hour))
+	certDer, err := createRecipientCertificate(&template, pk)
+	assert.Nil(t, err)
+
+	cert, err := parseRecipientCertificateDER(certDer)
+	assert.Nil(t, err)
+	pub, ok := cert.PublicKey.(*ecdh.PublicKey)
+	assert.True(t, ok)
+	assert.True(t, pk.(*ecdh.PrivateKey).PublicKey().Equal(pub))
+	assert.Equal(t, x509.KeyUsageKeyAgreement, cert.KeyUsage)
+	assert.Equal(t, "x25519", cert.Subject.CommonName)
+
+	// The certificate is signed by a separate Ed25519 key
+	assert.Equal(t, x509.PureEd25519, cert.SignatureAlgorithm)
+	assert.Equal(t, "x25519 Issuer", cert.Issuer.CommonName)
+	assert.Equal(t, ed25519.SignatureSize, len(cert.Signature))
+}
+
+func TestImportPEMBlock_WillImportForECRecipients(t *testing.T) {
+	for _, keyType := range []string{recipientKeyTypeP256, recipientKeyTypeP384, recipientKeyTypeX25519} {
+		recipientKey, _ := generateRecipientKey(keyType)
+		sourceRole, exportedRole, pemOut := setupTestRoleDataExportFor(recipientKey, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+		assert.Equal(t, roleDataFormatV3, pemOut.Headers[roleDataFormatHeader])
+
+		_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+		lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+		assert.Nil(t, lr)
+		assert.Nil(t, err)
+		assert.Equal(t, exportedRole.Keys.ApiKey, importRoleRequest.heap.GetRole().Keys.ApiKey)
+
+		// The data cannot be decrypted with another key of the same type
+		otherKey, _ := generateRecipientKey(keyType)
+		_, otherRoleRequest := setupRoleRequestMockHaving(StoredRole{PrivateKey: otherKey})
+		lr, err = importPEMEncodedExchangeData(pemOut)(nil, otherRoleRequest)
+		assert.Nil(t, err)
+		assert.True(t, lr.IsError())
+	}
+}
+
+func TestImportPEMBlock_RejectsFormatOfOtherKeyType(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+
+	recipientKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, importRoleRequest := setupRoleRequestMockHaving(StoredRole{PrivateKey: recipientKey})
+	lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+	assert.Nil(t, err)
+	assert.Equal(t, "was unable to decrypt the Mashery role data (role data format 2 requires an RSA recipient key)", lr.Error().Error())
+}
+
+func TestRetrievePrivateKey_GeneratesKeyOfConfiguredType(t *testing.T) {
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	reqCtx.plugin.cfg.RecipientKeyType = recipientKeyTypeX25519
+	emulStore.On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).Return(nil, nil)
+	emulStore.On("Get", mock.Anything, roleRecipientKeysPath(reqCtx)).Return(nil, nil)
+	emulStore.On("Put", mock.Anything, storageEntryBearingData()).Return(nil)
+
+	lr, err := retrievePrivateKey(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	pk, err := getPrivateKey(reqCtx.heap.GetRole())
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeX25519, recipientKeyTypeOf(pk))
+}
+
+func TestRetrievePrivateKey_KeepsExistingKeyWhenMountTypeChanges(t *testing.T) {
+	pkData := randomPrivateKey()
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	reqCtx.plugin.cfg.RecipientKeyType = recipientKeyTypeP256
+	emulStore.On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).
+		Return(createBinaryStorageEntryFrom(t, rolePrivateKeyPath(reqCtx), pkData), nil)
+
+	lr, err := retrievePrivateKey(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, pkData, reqCtx.heap.GetRole().PrivateKey)
+}
+
+func TestRetrievePrivateKey_ReplacesKeyWhenRoleTypeChanges(t *testing.T) {
+	pkData := randomPrivateKey()
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{Keys: RoleKeys{RecipientKeyType: recipientKeyTypeP384}})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	emulStore.On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).
+		Return(createBinaryStorageEntryFrom(t, rolePrivateKeyPath(reqCtx), pkData), nil)
+	emulStore.On("Get", mock.Anything, roleRecipientKeysPath(reqCtx)).Return(nil, nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleRecipientKeysPath(reqCtx))).Return(nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(rolePrivateKeyPath(reqCtx))).Return(errors.New("sample rejection"))
+
+	lr, err := retrievePrivateKey(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Equal(t, "sample rejection", err.Error())
+}
+
+func TestReadRecipientCertificate_ECKeys(t *testing.T) {
+	for _, keyType := range []string{recipientKeyTypeP256, recipientKeyTypeX25519} {
+		der, _ := generateRecipientKey(keyType)
+		sourceReq := RoleRequestMockBuilder[RoleContext]{
+			container:   &RoleContainer{role: &StoredRole{PrivateKey: der}},
+			fieldSchema: pathRolePemReadFields,
+		}
+		_, rolePEMReadRequest := sourceReq.Build()
+
+		lr, err := renderRoleCertificate(nil, rolePEMReadRequest)
+		assert.Nil(t, err)
+
+		builder := RoleRequestMockBuilder[RoleExportContext]{
+			container:   &RoleExportContainer{},
+			data:        map[string]interface{}{pemContainerField: lr.Data[pemContainerField]},
+			fieldSchema: pathRoleExportFields,
+		}
+		_, reqCtx := builder.Build()
+
+		lr, err = readRecipientCertificate(nil, reqCtx)
+		assert.Nil(t, lr)
+		assert.Nil(t, err)
+		assert.True(t, isSupportedRecipientPublicKey(reqCtx.heap.GetRecipientCertificate().PublicKey))
+	}
+}
+
+func TestUpdateRoleKeysFromRequest_RecipientKeyType(t *testing.T) {
+	mockBuilder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{},
+		},
+		data: map[string]interface{}{
+			roleRecipientKeyTypeField: "X25519",
+		},
+		fieldSchema: pathRoleFields,
+	}
+
+	reqCtx := mockBuilder.Request()
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeX25519, reqCtx.heap.GetRole().Keys.RecipientKeyType)
+
+	mockBuilder.data = map[string]interface{}{
+		roleRecipientKeyTypeField: "ec-p521",
+	}
+	lr, err = updateRoleKeysFromRequest(context.TODO(), mockBuilder.Request())
+	assert.Nil(t, err)
+	assert.Equal(t, "invalid recipient_key_type: unsupported recipient key type ec-p521; use one of rsa, ec-p256, ec-p384, x25519", lr.Error().Error())
+}
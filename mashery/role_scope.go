@@ -0,0 +1,260 @@
+package mashery
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	// scopeMethodAny matches any V3 HTTP method
+	scopeMethodAny = "*"
+	// scopeMethodV2 denotes the rule matching V2 JSON-RPC method names
+	scopeMethodV2 = "V2"
+)
+
+var scopeV3Methods = map[string]bool{
+	"GET":          true,
+	"POST":         true,
+	"PUT":          true,
+	"DELETE":       true,
+	scopeMethodAny: true,
+}
+
+// ScopeRule a rule restricting the operations a role may perform. V3 rules match the HTTP method and the
+// path of the V3 resource, e.g. `GET services/**`; V2 rules match the name of the JSON-RPC method, e.g.
+// `V2 object.query`.
+//
+// Within the pattern, `*` matches (a part of) a single path segment, while `**` matches any number of segments.
+// The segments of V3 paths are separated by slashes; the segments of V2 method names are separated by dots.
+type ScopeRule struct {
+	Method  string
+	Pattern string
+}
+
+func (sr ScopeRule) String() string {
+	return sr.Method + " " + sr.Pattern
+}
+
+func (sr ScopeRule) IsV2() bool {
+	return sr.Method == scopeMethodV2
+}
+
+// ParseScopeRule parses the rule in the form `<METHOD> <pattern>`. The method is either a V3 HTTP method, `*`
+// for any V3 method, or `V2` for V2 JSON-RPC method names.
+func ParseScopeRule(s string) (ScopeRule, error) {
+	parts := strings.Fields(s)
+	if len(parts) != 2 {
+		return ScopeRule{}, errors.New(fmt.Sprintf("rule '%s' must have the form '<METHOD> <pattern>'", s))
+	}
+
+	rv := ScopeRule{
+		Method:  strings.ToUpper(parts[0]),
+		Pattern: strings.Trim(parts[1], "/"),
+	}
+
+	if !rv.IsV2() && !scopeV3Methods[rv.Method] {
+		return rv, errors.New(fmt.Sprintf("rule '%s' specifies unsupported method %s", s, parts[0]))
+	}
+	if len(rv.Pattern) == 0 {
+		return rv, errors.New(fmt.Sprintf("rule '%s' specifies an empty pattern", s))
+	}
+
+	for _, seg := range rv.segments(rv.Pattern) {
+		if seg == "**" {
+			continue
+		}
+		if _, err := path.Match(seg, ""); err != nil {
+			return rv, errors.New(fmt.Sprintf("rule '%s' has malformed pattern segment '%s'", s, seg))
+		}
+	}
+
+	return rv, nil
+}
+
+// ParseScopeRules parses the rules, returning these in the normalized form.
+func ParseScopeRules(in []string) ([]string, error) {
+	var rv []string
+	for _, s := range in {
+		if len(strings.TrimSpace(s)) == 0 {
+			continue
+		}
+
+		if rule, err := ParseScopeRule(s); err != nil {
+			return nil, err
+		} else {
+			rv = append(rv, rule.String())
+		}
+	}
+
+	return rv, nil
+}
+
+func (sr ScopeRule) segments(s string) []string {
+	if sr.IsV2() {
+		return strings.Split(s, ".")
+	}
+	return strings.Split(s, "/")
+}
+
+// MatchesV3 checks whether this rule matches the V3 operation. The path is expected in the clean form.
+func (sr ScopeRule) MatchesV3(method string, p string) bool {
+	if sr.IsV2() {
+		return false
+	}
+	if sr.Method != scopeMethodAny && sr.Method != strings.ToUpper(method) {
+		return false
+	}
+
+	return matchScopeSegments(sr.segments(sr.Pattern), sr.segments(p))
+}
+
+// MatchesV2 checks whether this rule matches the V2 JSON-RPC method.
+func (sr ScopeRule) MatchesV2(method string) bool {
+	return sr.IsV2() && matchScopeSegments(sr.segments(sr.Pattern), sr.segments(method))
+}
+
+func matchScopeSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchScopeSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+
+	return matchScopeSegments(pattern[1:], segments[1:])
+}
+
+// cleanV3ScopePath brings the V3 path into the form the scope rules are matched against: without leading and
+// trailing slashes and without relative elements that could otherwise be used to escape the scope.
+func cleanV3ScopePath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
+
+func scopeRules(in []string, v2 bool) []ScopeRule {
+	var rv []ScopeRule
+	for _, s := range in {
+		// The rules are validated when these are stored
+		if rule, err := ParseScopeRule(s); err == nil && rule.IsV2() == v2 {
+			rv = append(rv, rule)
+		}
+	}
+
+	return rv
+}
+
+// RoleScope a set of allow and deny rules. A deny rule always takes precedence. If the scope has allow rules
+// for the API, then the operation must match at least one of these; the API without allow rules is restricted
+// only by the deny rules.
+type RoleScope struct {
+	Allow []string `json:"a,omitempty"`
+	Deny  []string `json:"d,omitempty"`
+}
+
+func (rs RoleScope) IsEmpty() bool {
+	return len(rs.Allow) == 0 && len(rs.Deny) == 0
+}
+
+// PermitsV3Operation checks whether this scope permits the V3 operation.
+func (rs RoleScope) PermitsV3Operation(method string, p string) bool {
+	p = cleanV3ScopePath(p)
+
+	for _, rule := range scopeRules(rs.Deny, false) {
+		if rule.MatchesV3(method, p) {
+			return false
+		}
+	}
+
+	allowed := scopeRules(rs.Allow, false)
+	for _, rule := range allowed {
+		if rule.MatchesV3(method, p) {
+			return true
+		}
+	}
+
+	return len(allowed) == 0
+}
+
+// PermitsV2Method checks whether this scope permits invoking the V2 JSON-RPC method.
+func (rs RoleScope) PermitsV2Method(method string) bool {
+	for _, rule := range scopeRules(rs.Deny, true) {
+		if rule.MatchesV2(method) {
+			return false
+		}
+	}
+
+	allowed := scopeRules(rs.Allow, true)
+	for _, rule := range allowed {
+		if rule.MatchesV2(method) {
+			return true
+		}
+	}
+
+	return len(allowed) == 0
+}
+
+// OwnScope the scope rules configured for this role.
+func (ar *RoleKeys) OwnScope() RoleScope {
+	return RoleScope{
+		Allow: ar.AllowedScope,
+		Deny:  ar.DeniedScope,
+	}
+}
+
+// EffectiveScope all scopes restricting this role: the scopes sealed by the exporter(s) of the role, followed
+// by the role's own scope.
+func (ar *RoleKeys) EffectiveScope() []RoleScope {
+	var rv []RoleScope
+	for _, sc := range ar.InheritedScope {
+		if !sc.IsEmpty() {
+			rv = append(rv, sc)
+		}
+	}
+	if own := ar.OwnScope(); !own.IsEmpty() {
+		rv = append(rv, own)
+	}
+
+	return rv
+}
+
+// HasScope checks whether the role restricts the operations it can perform.
+func (ar *RoleKeys) HasScope() bool {
+	return len(ar.EffectiveScope()) > 0
+}
+
+// PermitsV3Operation checks whether the V3 operation is permitted by every scope restricting this role. As each
+// scope can only narrow the operations further, an imported role cannot widen the scope sealed by its exporter.
+func (ar *RoleKeys) PermitsV3Operation(method string, p string) bool {
+	for _, sc := range ar.EffectiveScope() {
+		if !sc.PermitsV3Operation(method, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PermitsV2Method checks whether the V2 JSON-RPC method is permitted by every scope restricting this role.
+func (ar *RoleKeys) PermitsV2Method(method string) bool {
+	for _, sc := range ar.EffectiveScope() {
+		if !sc.PermitsV2Method(method) {
+			return false
+		}
+	}
+
+	return true
+}
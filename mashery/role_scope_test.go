@@ -0,0 +1,88 @@
+package mashery
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseScopeRule(t *testing.T) {
+	rule, err := ParseScopeRule("get /services/**/")
+	assert.Nil(t, err)
+	assert.Equal(t, "GET services/**", rule.String())
+
+	rule, err = ParseScopeRule("v2 object.query")
+	assert.Nil(t, err)
+	assert.True(t, rule.IsV2())
+
+	_, err = ParseScopeRule("services/**")
+	assert.NotNil(t, err)
+	_, err = ParseScopeRule("PATCH services/**")
+	assert.Equal(t, "rule 'PATCH services/**' specifies unsupported method PATCH", err.Error())
+	_, err = ParseScopeRule("GET services/[a")
+	assert.NotNil(t, err)
+}
+
+func TestParseScopeRules_SkipsEmptyRules(t *testing.T) {
+	rules, err := ParseScopeRules([]string{"", "put packages/*/plans/*"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"PUT packages/*/plans/*"}, rules)
+}
+
+func TestScopeRule_MatchesV3(t *testing.T) {
+	rule, _ := ParseScopeRule("PUT packages/*/plans/*")
+	assert.True(t, rule.MatchesV3("put", "packages/abc/plans/def"))
+	assert.False(t, rule.MatchesV3("PUT", "packages/abc/plans"))
+	assert.False(t, rule.MatchesV3("PUT", "packages/abc/plans/def/services"))
+	assert.False(t, rule.MatchesV3("POST", "packages/abc/plans/def"))
+
+	rule, _ = ParseScopeRule("* services/**")
+	assert.True(t, rule.MatchesV3("DELETE", "services"))
+	assert.True(t, rule.MatchesV3("GET", "services/abc/endpoints/def"))
+	assert.False(t, rule.MatchesV3("GET", "servicesX"))
+
+	rule, _ = ParseScopeRule("GET **/endpoints")
+	assert.True(t, rule.MatchesV3("GET", "services/abc/endpoints"))
+	assert.False(t, rule.MatchesV3("GET", "services/abc/endpoints/def"))
+}
+
+func TestScopeRule_MatchesV2(t *testing.T) {
+	rule, _ := ParseScopeRule("V2 object.*")
+	assert.True(t, rule.MatchesV2("object.query"))
+	assert.False(t, rule.MatchesV2("key.create"))
+	assert.False(t, rule.MatchesV3("GET", "object.query"))
+}
+
+func TestRoleKeys_PermitsV3Operation(t *testing.T) {
+	keys := RoleKeys{
+		AllowedScope: []string{"GET services/**", "PUT packages/*/plans/*"},
+		DeniedScope:  []string{"* services/secret/**"},
+	}
+
+	assert.True(t, keys.PermitsV3Operation("GET", "/services/abc"))
+	assert.True(t, keys.PermitsV3Operation("PUT", "/packages/a/plans/b"))
+	assert.False(t, keys.PermitsV3Operation("DELETE", "/services/abc"))
+	assert.False(t, keys.PermitsV3Operation("GET", "/members"))
+	assert.False(t, keys.PermitsV3Operation("GET", "/services/secret/endpoints"))
+	// Relative path elements cannot escape the scope
+	assert.False(t, keys.PermitsV3Operation("GET", "/services/../members"))
+
+	// V2 rules do not restrict V3 operations
+	keys = RoleKeys{AllowedScope: []string{"V2 object.query"}}
+	assert.True(t, keys.PermitsV3Operation("DELETE", "/services/abc"))
+}
+
+func TestRoleKeys_PermitsV2Method(t *testing.T) {
+	keys := RoleKeys{
+		AllowedScope: []string{"GET services/**", "V2 object.query", "V2 key.*"},
+		DeniedScope:  []string{"V2 key.delete"},
+	}
+
+	assert.True(t, keys.PermitsV2Method("object.query"))
+	assert.True(t, keys.PermitsV2Method("key.create"))
+	assert.False(t, keys.PermitsV2Method("key.delete"))
+	assert.False(t, keys.PermitsV2Method("application.create"))
+
+	keys = RoleKeys{}
+	assert.False(t, keys.HasScope())
+	assert.True(t, keys.PermitsV2Method("application.create"))
+}
@@ -0,0 +1,48 @@
+package mashery
+
+import "sync"
+
+// SingleFlight coalesces concurrent invocations of a function sharing the same key: only one invocation
+// runs, while the other callers wait for it to complete and receive the same result.
+type SingleFlight[T any] struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+type flightCall[T any] struct {
+	wg  sync.WaitGroup
+	val T
+	err error
+}
+
+// Do executes the function, unless an execution for the same key is already in flight. In the latter case,
+// Do waits for the in-flight execution and returns its result.
+func (g *SingleFlight[T]) Do(key string, fn func() (T, error)) (T, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall[T]{}
+	}
+
+	if c, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		c.wg.Wait()
+
+		return c.val, c.err
+	}
+
+	c := &flightCall[T]{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+
+		c.wg.Done()
+	}()
+
+	c.val, c.err = fn()
+	return c.val, c.err
+}
@@ -0,0 +1,53 @@
+package mashery
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlight_CoalescesConcurrentCalls(t *testing.T) {
+	g := SingleFlight[string]{}
+
+	var invocations int32
+	release := make(chan struct{})
+
+	wg := sync.WaitGroup{}
+	results := make([]string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.Do("role", func() (string, error) {
+				atomic.AddInt32(&invocations, 1)
+				<-release
+				return "token", nil
+			})
+		}(i)
+	}
+
+	// Let all callers join the flight before it completes
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), invocations)
+	for _, r := range results {
+		assert.Equal(t, "token", r)
+	}
+}
+
+func TestSingleFlight_RunsAgainAfterCompletion(t *testing.T) {
+	g := SingleFlight[int]{}
+
+	v, err := g.Do("role", func() (int, error) { return 1, nil })
+	assert.Equal(t, 1, v)
+	assert.Nil(t, err)
+
+	_, err = g.Do("role", func() (int, error) { return 0, errors.New("sample failure") })
+	assert.NotNil(t, err)
+	assert.Equal(t, "sample failure", err.Error())
+}
@@ -80,6 +80,28 @@ func parseBackEndConfigurationFunc(_ context.Context, reqCtx *RequestHandlerCont
 	}
 
 	copyBooleanFieldIfDefined(d, cliWriteField, &be.CLIWriteEnabled)
+	copyBooleanFieldIfDefined(d, allowUnsignedImportsField, &be.AllowUnsignedImports)
+
+	if err := copyEndpointFieldIfDefined(d, roleV3EndpointField, &be.V3Endpoint); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+	if err := copyEndpointFieldIfDefined(d, roleOAuthTokenEndpointField, &be.OAuthTokenEndpoint); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+	if err := copyEndpointFieldIfDefined(d, roleV2EndpointField, &be.V2Endpoint); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	if err := copyRecipientKeyTypeFieldIfDefined(d, roleRecipientKeyTypeField, &be.RecipientKeyType); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
+
+	copyDurationFieldIfDefined(d, roleDefaultTTLField, &be.LeaseDefaultTTL)
+	copyDurationFieldIfDefined(d, roleMaxTTLField, &be.LeaseMaxTTL)
+	copyDurationFieldIfDefined(d, roleRenewIncrementField, &be.LeaseRenewIncrement)
+	if err := be.LeaseShape().Validate(); err != nil {
+		parseErrors = append(parseErrors, err)
+	}
 
 	if v, ok := d.GetOk(netLatencyField); ok {
 		latExp := v.(string)
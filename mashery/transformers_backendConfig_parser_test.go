@@ -72,6 +72,69 @@ func TestParseBackEndConfigurationFunc_MalformedProxyURL(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestParseBackEndConfigurationFunc_Endpoints(t *testing.T) {
+	container := BackendConfigurationContainer{}
+
+	var reqCtx = setupConfigRequestMockWithData[BackendConfigurationContext](&container,
+		map[string]interface{}{
+			roleV3EndpointField:         "https://eu.mashery.example/v3/rest",
+			roleOAuthTokenEndpointField: "https://eu.mashery.example/v3/token",
+			roleV2EndpointField:         "http://localhost:8080/v2/json-rpc",
+		}, pathBackendConfigFields)
+
+	lr, err := parseBackEndConfigurationFunc(nil, reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	cfg := container.GetBackendConfiguration()
+	assert.Equal(t, "https://eu.mashery.example/v3/rest", cfg.V3Endpoint)
+	assert.Equal(t, "https://eu.mashery.example/v3/token", cfg.OAuthTokenEndpoint)
+	assert.Equal(t, "http://localhost:8080/v2/json-rpc", cfg.V2Endpoint)
+}
+
+func TestParseBackEndConfigurationFunc_MalformedEndpoint(t *testing.T) {
+	container := BackendConfigurationContainer{}
+
+	var reqCtx = setupConfigRequestMockWithData[BackendConfigurationContext](&container,
+		map[string]interface{}{
+			roleV3EndpointField: "ftp://eu.mashery.example/v3/rest",
+		}, pathBackendConfigFields)
+
+	lr, err := parseBackEndConfigurationFunc(nil, reqCtx)
+	assert.Nil(t, err)
+	assert.NotNil(t, lr)
+	assert.Equal(t, "incorrect input: endpoint URL ftp://eu.mashery.example/v3/rest must use http or https scheme", lr.Error().Error())
+}
+
+func TestParseBackEndConfigurationFunc_LeaseSettings(t *testing.T) {
+	container := BackendConfigurationContainer{}
+
+	var reqCtx = setupConfigRequestMockWithData[BackendConfigurationContext](&container,
+		map[string]interface{}{
+			roleDefaultTTLField:     "5m",
+			roleMaxTTLField:         "4h",
+			roleRenewIncrementField: "30m",
+		}, pathBackendConfigFields)
+
+	lr, err := parseBackEndConfigurationFunc(nil, reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	cfg := container.GetBackendConfiguration()
+	assert.Equal(t, int64(300), cfg.LeaseDefaultTTL)
+	assert.Equal(t, int64(14400), cfg.LeaseMaxTTL)
+	assert.Equal(t, int64(1800), cfg.LeaseRenewIncrement)
+
+	reqCtx = setupConfigRequestMockWithData[BackendConfigurationContext](&container,
+		map[string]interface{}{
+			roleRenewIncrementField: "5h",
+		}, pathBackendConfigFields)
+
+	lr, err = parseBackEndConfigurationFunc(nil, reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "incorrect input: renew_increment (5h0m0s) exceeds max_ttl (4h0m0s)", lr.Error().Error())
+}
+
 func TestParseBackEndConfigurationFunc_MalformedDuration(t *testing.T) {
 	container := BackendConfigurationContainer{}
 	reqCtx := setupConfigRequestMockWithData[BackendConfigurationContext](&container,
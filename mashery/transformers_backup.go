@@ -0,0 +1,410 @@
+package mashery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	mountBackupPassphraseField = "passphrase"
+	mountBackupRecipientField  = "recipient"
+	mountBackupPrivateKeyField = "private_key"
+	mountBackupOverwriteField  = "overwrite"
+
+	mountBackupOverwriteConfigField = "overwrite_config"
+)
+
+// mountStorageRoot the root of the storage of this mount. The paths in the backup are relative to the root.
+func (b *AuthPlugin) mountStorageRoot() string {
+	return b.backendUUID + "/"
+}
+
+// isMountConfigEntry checks whether the path, relative to the storage root, holds the configuration or an identity
+// of the mount.
+func (b *AuthPlugin) isMountConfigEntry(path string) bool {
+	root := b.mountStorageRoot()
+	return path == strings.TrimPrefix(b.configPath(), root) ||
+		path == strings.TrimPrefix(b.exporterIdentityPath(), root) ||
+		path == strings.TrimPrefix(b.trustedExportersPath(), root) ||
+		strings.HasPrefix(path, strings.TrimPrefix(b.recipientIdentitiesRoot(), root))
+}
+
+// mountBackupRoleEntrySuffixes the entries this mount stores for each role
+var mountBackupRoleEntrySuffixes = []string{
+	storedRoleKeyPathSuffix,
+	storedRolePrivateKeyPathSuffix,
+	storedRoleUsageKeyPathSuffix,
+	storedRoleRevocationsPathSuffix,
+	storedRoleRecipientKeysPathSuffix,
+	storedRoleExportsPathSuffix,
+	storedRolePendingKeysPathSuffix,
+}
+
+// isMountBackupEntry checks whether the path, relative to the storage root, is one of the entries a backup may
+// restore: the configuration, the identities, the import ledger, or an entry of a role.
+func (b *AuthPlugin) isMountBackupEntry(path string) bool {
+	root := b.mountStorageRoot()
+	recipientsPrefix := strings.TrimPrefix(b.recipientIdentitiesRoot(), root)
+
+	switch {
+	case path == strings.TrimPrefix(b.configPath(), root),
+		path == strings.TrimPrefix(b.exporterIdentityPath(), root),
+		path == strings.TrimPrefix(b.trustedExportersPath(), root),
+		path == strings.TrimPrefix(b.importLedgerPath(), root):
+		return true
+	case strings.HasPrefix(path, recipientsPrefix):
+		return validRoleName.MatchString(strings.TrimPrefix(path, recipientsPrefix))
+	case strings.HasPrefix(path, mountBackupRolePrefix):
+		for _, suffix := range mountBackupRoleEntrySuffixes {
+			if strings.HasSuffix(path, suffix) {
+				name := strings.TrimSuffix(strings.TrimPrefix(path, mountBackupRolePrefix), suffix)
+				if validRoleName.MatchString(name) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// collectMountBackup reads all entries stored by this mount: the configuration, the roles, and the identities. The
+// versions of the role keys are left out, together with the secret these are encrypted with: the versions could not
+// be decrypted in another mount, and restoring these with the secret would bring the secret into every backup.
+func collectMountBackup[T MountBackupContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	root := reqCtx.plugin.mountStorageRoot()
+	keys, err := logical.CollectKeysWithPrefix(ctx, reqCtx.request.Storage, root)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := reqCtx.heap.GetMountBackup()
+	backup.Created = time.Now().Unix()
+	backup.Entries = map[string][]byte{}
+
+	for _, key := range keys {
+		if key == reqCtx.plugin.roleVersionsSealPath() || strings.HasSuffix(key, storedRoleVersionsPathSuffix) {
+			continue
+		}
+
+		if found, dat, err := reqCtx.ReadBinaryPath(ctx, key); err != nil {
+			return nil, err
+		} else if found {
+			backup.Entries[strings.TrimPrefix(key, root)] = dat
+		}
+	}
+
+	return nil, nil
+}
+
+// renderEncryptedMountBackup encrypts the backup either for the operator's certificate or with the passphrase.
+func renderEncryptedMountBackup(_ context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	certPEM, _ := reqCtx.data.GetOk(pemContainerField)
+	passphrase, _ := reqCtx.data.GetOk(mountBackupPassphraseField)
+
+	backup := reqCtx.heap.GetMountBackup()
+
+	var out string
+	var err error
+
+	if certPEM != nil && passphrase != nil {
+		return logical.ErrorResponse("specify either the certificate or the passphrase to encrypt the backup with, not both"), nil
+	} else if certPEM != nil {
+		cert, certErr := parseMountBackupCertificate(certPEM.(string))
+		if certErr != nil {
+			return logical.ErrorResponse("invalid certificate: %s", certErr.Error()), nil
+		}
+		out, err = sealMountBackupForRecipient(backup, cert.PublicKey, reqCtx.heap.GetExporterIdentity())
+	} else if passphrase != nil && len(passphrase.(string)) > 0 {
+		out, err = sealMountBackupWithPassphrase(backup, passphrase.(string))
+	} else {
+		return logical.ErrorResponse("specify the certificate or the passphrase to encrypt the backup with"), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			pemContainerField: out,
+			"entries":         len(backup.Entries),
+			"roles":           backup.RoleNames(),
+		},
+	}, nil
+}
+
+// verifyMountBackupExporter verifies that the backup encrypted for a certificate was signed by this mount or by
+// a trusted exporter. The backup encrypted with a passphrase is authenticated by its encryption.
+func verifyMountBackupExporter(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext], blk *pem.Block) (*logical.Response, error) {
+	fingerprint := blk.Headers[roleDataExporterHeader]
+	if len(fingerprint) == 0 || len(blk.Headers[roleDataSignatureHeader]) == 0 {
+		return logical.ErrorResponse("backup is not signed by its exporter"), nil
+	}
+
+	name, exporterKey, err := findTrustedExporterKey(ctx, reqCtx, fingerprint)
+	if err == errTrustedExporterExpired {
+		return logical.ErrorResponse("backup is signed by exporter %s, whose certificate has expired (sha256:%s)", name, fingerprint), nil
+	} else if err != nil {
+		return nil, err
+	} else if exporterKey == nil {
+		return logical.ErrorResponse("backup is signed by an untrusted exporter (sha256:%s)", fingerprint), nil
+	}
+
+	if err = verifyMountBackupSignature(exporterKey, blk); err != nil {
+		return logical.ErrorResponse("backup signature is invalid: %s", err.Error()), nil
+	}
+	return nil, nil
+}
+
+// openMountBackupFromRequest decrypts the backup with the passphrase, with the key of the recipient identity of
+// this mount, or with the private key supplied by the operator. The signature of the backup encrypted for
+// a certificate is verified before the backup is decrypted.
+func openMountBackupFromRequest(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	blk, err := decodeMountBackupBlock(reqCtx.data.Get(pemContainerField).(string))
+	if err != nil {
+		return logical.ErrorResponse("invalid backup: %s", err.Error()), nil
+	}
+
+	passphrase, hasPassphrase := reqCtx.data.GetOk(mountBackupPassphraseField)
+	recipient, hasRecipient := reqCtx.data.GetOk(mountBackupRecipientField)
+	keyPEM, hasKey := reqCtx.data.GetOk(mountBackupPrivateKeyField)
+
+	var backup *MountBackup
+
+	switch {
+	case hasPassphrase && !hasRecipient && !hasKey:
+		backup, err = openMountBackupWithPassphrase(blk, passphrase.(string))
+	case hasRecipient && !hasPassphrase && !hasKey:
+		if lr, verifyErr := verifyMountBackupExporter(ctx, reqCtx, blk); lr != nil || verifyErr != nil {
+			return lr, verifyErr
+		}
+
+		identity := StoredRecipientIdentity{}
+		if found, readErr := reqCtx.ReadPath(ctx, reqCtx.plugin.recipientIdentityPath(recipient.(string)), &identity); readErr != nil {
+			return nil, readErr
+		} else if !found {
+			return logical.ErrorResponse("recipient identity %s is not found", recipient), nil
+		}
+
+		pk, keyErr := parseRecipientKey(identity.PrivateKey)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		backup, err = openMountBackupWithKey(blk, pk)
+	case hasKey && !hasPassphrase && !hasRecipient:
+		if lr, verifyErr := verifyMountBackupExporter(ctx, reqCtx, blk); lr != nil || verifyErr != nil {
+			return lr, verifyErr
+		}
+
+		pk, keyErr := parseMountBackupPrivateKey(keyPEM.(string))
+		if keyErr != nil {
+			return logical.ErrorResponse("invalid private key: %s", keyErr.Error()), nil
+		}
+		backup, err = openMountBackupWithKey(blk, pk)
+	default:
+		return logical.ErrorResponse("specify exactly one of %s, %s, or %s to decrypt the backup with",
+			mountBackupPassphraseField, mountBackupRecipientField, mountBackupPrivateKeyField), nil
+	}
+
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	*reqCtx.heap.GetMountBackup() = *backup
+	return nil, nil
+}
+
+// checkMountBackupEntries refuses the backup containing the entries that this mount does not store, so that the
+// restore cannot write anywhere else in the storage of the mount.
+func checkMountBackupEntries(_ context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	var unknown []string
+	for path := range reqCtx.heap.GetMountBackup().Entries {
+		if !reqCtx.plugin.isMountBackupEntry(path) {
+			unknown = append(unknown, path)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return logical.ErrorResponse("backup contains unexpected entries %s", strings.Join(unknown, ", ")), nil
+	}
+	return nil, nil
+}
+
+// checkMountBackupRestorable refuses to replace the configuration, the identities, and the roles that already exist
+// on this mount, unless the operator explicitly allows it.
+func checkMountBackupRestorable(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	if lr, err := checkMountBackupConfigRestorable(ctx, reqCtx); lr != nil || err != nil {
+		return lr, err
+	}
+
+	if reqCtx.data.Get(mountBackupOverwriteField).(bool) {
+		return nil, nil
+	}
+
+	existing, err := reqCtx.request.Storage.List(ctx, reqCtx.plugin.rolesStorageRoot())
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, name := range reqCtx.heap.GetMountBackup().RoleNames() {
+		for _, v := range existing {
+			if strings.TrimSuffix(v, "/") == name {
+				conflicts = append(conflicts, name)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		return logical.ErrorResponse("roles %s already exist; set %s to replace them", strings.Join(conflicts, ", "), mountBackupOverwriteField), nil
+	}
+	return nil, nil
+}
+
+// checkMountBackupConfigRestorable refuses to replace the configuration and the identities of this mount with the
+// different ones contained in the backup, unless the operator explicitly allows it.
+func checkMountBackupConfigRestorable(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	if reqCtx.data.Get(mountBackupOverwriteConfigField).(bool) {
+		return nil, nil
+	}
+
+	root := reqCtx.plugin.mountStorageRoot()
+	var conflicts []string
+	for path, dat := range reqCtx.heap.GetMountBackup().Entries {
+		if !reqCtx.plugin.isMountConfigEntry(path) {
+			continue
+		}
+
+		if found, stored, err := reqCtx.ReadBinaryPath(ctx, root+path); err != nil {
+			return nil, err
+		} else if found && !bytes.Equal(stored, dat) {
+			conflicts = append(conflicts, path)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return logical.ErrorResponse("entries %s of this mount would be replaced; set %s to replace them", strings.Join(conflicts, ", "), mountBackupOverwriteConfigField), nil
+	}
+	return nil, nil
+}
+
+// writeMountBackup writes the entries of the backup under the storage root of this mount. The entries of the
+// replaced roles that the backup does not contain are removed, so that the restored roles match the backup. The
+// import ledger and the revocations of the roles are merged with the ones stored by this mount instead, so that
+// restoring an older backup does not allow importing the consumed exports again, or using the revoked credentials.
+func writeMountBackup(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	root := reqCtx.plugin.mountStorageRoot()
+	backup := reqCtx.heap.GetMountBackup()
+
+	for _, name := range backup.RoleNames() {
+		rolePrefix := mountBackupRolePrefix + name + "/"
+
+		stored, err := reqCtx.request.Storage.List(ctx, root+rolePrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range stored {
+			if "/"+v == storedRoleRevocationsPathSuffix {
+				continue
+			}
+			if _, ok := backup.Entries[rolePrefix+v]; !ok {
+				if err = reqCtx.request.Storage.Delete(ctx, root+rolePrefix+v); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(backup.Entries))
+	for path := range backup.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		dat, err := mergeRestoredEntry(ctx, reqCtx, path, backup.Entries[path])
+		if err != nil {
+			return nil, err
+		}
+		if err = reqCtx.WriteBinaryPath(ctx, root+path, dat); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeRestoredEntry merges the restored import ledger or the revocations of a role with the ones stored at the
+// path; other entries are restored as they are.
+func mergeRestoredEntry(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext], path string, dat []byte) ([]byte, error) {
+	root := reqCtx.plugin.mountStorageRoot()
+
+	switch {
+	case path == strings.TrimPrefix(reqCtx.plugin.importLedgerPath(), root):
+		stored, restored := StoredImportLedger{}, StoredImportLedger{}
+		if err := readRestoredEntry(ctx, reqCtx, root+path, &stored, dat, &restored); err != nil {
+			return nil, err
+		}
+
+		stored.Merge(restored)
+		stored.Prune(time.Now())
+		return json.Marshal(&stored)
+	case strings.HasPrefix(path, mountBackupRolePrefix) && strings.HasSuffix(path, storedRoleRevocationsPathSuffix):
+		stored, restored := StoredRoleRevocations{}, StoredRoleRevocations{}
+		if err := readRestoredEntry(ctx, reqCtx, root+path, &stored, dat, &restored); err != nil {
+			return nil, err
+		}
+
+		stored.Merge(restored)
+		stored.Prune(time.Now())
+		return json.Marshal(&stored)
+	}
+
+	return dat, nil
+}
+
+// readRestoredEntry decodes the entry stored at the path, if any, and the entry restored from the backup.
+func readRestoredEntry(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext], path string, stored interface{}, dat []byte, restored interface{}) error {
+	if _, err := reqCtx.ReadPath(ctx, path, stored); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(dat, restored); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("cannot decode backup entry %s: {{err}}", strings.TrimPrefix(path, reqCtx.plugin.mountStorageRoot())), err)
+	}
+	return nil
+}
+
+// applyRestoredConfiguration reloads the restored configuration of the mount; the pooled clients are discarded.
+func applyRestoredConfiguration(ctx context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	cfg, err := reqCtx.plugin.loadBackendConfiguration(ctx, reqCtx.request.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx.plugin.AcceptConfigurationUpdate(ctx, cfg)
+	return nil, nil
+}
+
+func renderRestoredMount(_ context.Context, reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	backup := reqCtx.heap.GetMountBackup()
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"backup_created": time.Unix(backup.Created, 0).UTC().Format(time.RFC3339),
+			"entries":        len(backup.Entries),
+			"roles":          backup.RoleNames(),
+		},
+	}, nil
+}
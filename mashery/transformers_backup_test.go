@@ -0,0 +1,416 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	v3client "github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func setupMountBackupRequest(backendUUID string, storage logical.Storage, data map[string]interface{}, schema map[string]*framework.FieldSchema) *RequestHandlerContext[MountBackupContext] {
+	plugin := &AuthPlugin{
+		backendUUID:    backendUUID,
+		vaultStorage:   &VaultStorageImpl{},
+		v2Clients:      newV2ClientPool(),
+		v3Clients:      newV3ClientPool(),
+		v3OAuthHelpers: map[string]*v3client.V3OAuthHelper{},
+	}
+
+	return &RequestHandlerContext[MountBackupContext]{
+		request: &logical.Request{
+			Storage: storage,
+			Data:    data,
+		},
+		data: &framework.FieldData{
+			Raw:    data,
+			Schema: schema,
+		},
+		plugin:      plugin,
+		storagePath: plugin.mountStorageRoot(),
+		heap:        &MountBackupContainer{},
+	}
+}
+
+func putTestStorageEntry(t *testing.T, storage logical.Storage, path string, obj interface{}) {
+	se, err := logical.StorageEntryJSON(path, obj)
+	assert.Nil(t, err)
+	assert.Nil(t, storage.Put(context.TODO(), se))
+}
+
+func takeTestMountBackup(t *testing.T, storage logical.Storage) string {
+	reqCtx := setupMountBackupRequest("source", storage, map[string]interface{}{
+		mountBackupPassphraseField: "passphrase",
+	}, pathBackupFields)
+
+	lr, err := SimpleChain(collectMountBackup[MountBackupContext], renderEncryptedMountBackup)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{"a"}, lr.Data["roles"])
+
+	return lr.Data[pemContainerField].(string)
+}
+
+func takeTestCertificateMountBackup(t *testing.T, storage logical.Storage, certPEM string) string {
+	reqCtx := setupMountBackupRequest("source", storage, map[string]interface{}{
+		pemContainerField: certPEM,
+	}, pathBackupFields)
+
+	lr, err := SimpleChain(
+		retrieveExporterIdentity[MountBackupContext],
+		collectMountBackup[MountBackupContext],
+		renderEncryptedMountBackup,
+	)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	return lr.Data[pemContainerField].(string)
+}
+
+// trustTestSourceMount adds the exporter identity of the source mount to the trusted exporters of the target mount.
+func trustTestSourceMount(t *testing.T, source logical.Storage, target logical.Storage) {
+	se, err := source.Get(context.TODO(), "source/exporter/identity")
+	assert.Nil(t, err)
+	pk, err := parseExporterIdentityKey(se.Value)
+	assert.Nil(t, err)
+
+	certPEM, _ := createExporterIdentityCertificate(pk, "Source")
+	fp, _ := publicKeyFingerprint(&pk.PublicKey)
+	putTestStorageEntry(t, target, "target/trusted-exporters", &StoredTrustedExporters{
+		Exporters: map[string]TrustedExporter{
+			"source": {Certificate: certPEM, Fingerprint: fp},
+		},
+	})
+}
+
+func restoreTestMountBackup(reqCtx *RequestHandlerContext[MountBackupContext]) (*logical.Response, error) {
+	return SimpleChain(
+		openMountBackupFromRequest,
+		checkMountBackupEntries,
+		checkMountBackupRestorable,
+		writeMountBackup,
+		applyRestoredConfiguration,
+		renderRestoredMount,
+	)(context.TODO(), reqCtx)
+}
+
+func setupTestSourceMount(t *testing.T) logical.Storage {
+	storage := &logical.InmemStorage{}
+	putTestStorageEntry(t, storage, "source/config", &BackendConfiguration{NetworkLatency: 42})
+	putTestStorageEntry(t, storage, "source/role/a/key", &RoleKeys{AreaNid: 10})
+	putTestStorageEntry(t, storage, "source/role/a/usage", &StoredRoleUsage{})
+	// Entries of other mounts are not included
+	putTestStorageEntry(t, storage, "other/role/b/key", &RoleKeys{AreaNid: 20})
+
+	return storage
+}
+
+func TestMountBackup_RestoresIntoOtherMount(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	target := &logical.InmemStorage{}
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 3, lr.Data["entries"])
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.ElementsMatch(t, []string{"target/config", "target/role/a/key", "target/role/a/usage"}, keys)
+
+	restoredKeys := RoleKeys{}
+	found, err := reqCtx.ReadPath(context.TODO(), "target/role/a/key", &restoredKeys)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 10, restoredKeys.AreaNid)
+
+	assert.Equal(t, 42, reqCtx.plugin.cfg.NetworkLatency)
+}
+
+func TestMountBackup_LeavesOutVersions(t *testing.T) {
+	storage := setupTestSourceMount(t)
+	assert.Nil(t, storage.Put(context.TODO(), &logical.StorageEntry{Key: "source/versions-seal", Value: make([]byte, roleVersionsKeySize)}))
+	putTestStorageEntry(t, storage, "source/role/a/versions", &StoredRoleVersions{Latest: 1})
+
+	reqCtx := setupMountBackupRequest("source", storage, map[string]interface{}{}, pathBackupFields)
+	lr, err := collectMountBackup[MountBackupContext](context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+
+	entries := reqCtx.heap.GetMountBackup().Entries
+	assert.Contains(t, entries, "role/a/key")
+	assert.NotContains(t, entries, "versions-seal")
+	assert.NotContains(t, entries, "role/a/versions")
+}
+
+func TestMountBackup_RefusesToReplaceExistingRoles(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	target := &logical.InmemStorage{}
+	putTestStorageEntry(t, target, "target/role/a/key", &RoleKeys{AreaNid: 30})
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "roles a already exist; set overwrite to replace them", lr.Error().Error())
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.Equal(t, []string{"target/role/a/key"}, keys)
+}
+
+func TestMountBackup_OverwriteRemovesStaleRoleEntries(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	target := &logical.InmemStorage{}
+	putTestStorageEntry(t, target, "target/role/a/key", &RoleKeys{AreaNid: 30})
+	putTestStorageEntry(t, target, "target/role/a/pk", &RoleKeys{})
+	putTestStorageEntry(t, target, "target/role/c/key", &RoleKeys{AreaNid: 40})
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+		mountBackupOverwriteField:  true,
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.ElementsMatch(t, []string{"target/config", "target/role/a/key", "target/role/a/usage", "target/role/c/key"}, keys)
+}
+
+func TestMountBackup_MergesImportLedgerAndRevocations(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	source := setupTestSourceMount(t)
+	putTestStorageEntry(t, source, "source/import-ledger", &StoredImportLedger{
+		Consumed: map[string]ConsumedExport{"restored": {Role: "a", Imported: time.Now().Unix()}},
+	})
+	sourceRev := StoredRoleRevocations{}
+	sourceRev.RevokeV3Token("restored-token", expiry)
+	putTestStorageEntry(t, source, "source/role/a/revocations", &sourceRev)
+	archive := takeTestMountBackup(t, source)
+
+	target := &logical.InmemStorage{}
+	putTestStorageEntry(t, target, "target/import-ledger", &StoredImportLedger{
+		Consumed: map[string]ConsumedExport{"consumed": {Role: "b", Imported: time.Now().Unix()}},
+	})
+	targetRev := StoredRoleRevocations{}
+	targetRev.RevokeV3Token("revoked-token", expiry)
+	targetRev.RevokeAllIssuedUntil(time.Now())
+	putTestStorageEntry(t, target, "target/role/a/key", &RoleKeys{AreaNid: 30})
+	putTestStorageEntry(t, target, "target/role/a/revocations", &targetRev)
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+		mountBackupOverwriteField:  true,
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	ledger := StoredImportLedger{}
+	_, err = reqCtx.ReadPath(context.TODO(), "target/import-ledger", &ledger)
+	assert.Nil(t, err)
+	assert.Contains(t, ledger.Consumed, "restored")
+	assert.Contains(t, ledger.Consumed, "consumed")
+
+	rev := StoredRoleRevocations{}
+	_, err = reqCtx.ReadPath(context.TODO(), "target/role/a/revocations", &rev)
+	assert.Nil(t, err)
+	assert.True(t, rev.V3TokenRevoked("restored-token"))
+	assert.True(t, rev.V3TokenRevoked("revoked-token"))
+	assert.Equal(t, targetRev.RevokedBeforeNano, rev.RevokedBeforeNano)
+}
+
+func TestMountBackup_KeepsRevocationsMissingFromBackup(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	target := &logical.InmemStorage{}
+	targetRev := StoredRoleRevocations{}
+	targetRev.RevokeV3Token("revoked-token", time.Now().Add(time.Hour).Unix())
+	putTestStorageEntry(t, target, "target/role/a/key", &RoleKeys{AreaNid: 30})
+	putTestStorageEntry(t, target, "target/role/a/revocations", &targetRev)
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+		mountBackupOverwriteField:  true,
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	rev := StoredRoleRevocations{}
+	found, err := reqCtx.ReadPath(context.TODO(), "target/role/a/revocations", &rev)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.True(t, rev.V3TokenRevoked("revoked-token"))
+}
+
+func TestMountBackup_RefusesUnexpectedEntries(t *testing.T) {
+	for _, path := range []string{"versions-seal", "role/a/versions", "role/a/unknown", "role/../key", "recipients/a/b", "other/role/a/key"} {
+		archive, err := sealMountBackupWithPassphrase(&MountBackup{
+			Created: time.Now().Unix(),
+			Entries: map[string][]byte{
+				"role/a/key": []byte("{}"),
+				path:         []byte("{}"),
+			},
+		}, "passphrase")
+		assert.Nil(t, err)
+
+		target := &logical.InmemStorage{}
+		reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+			pemContainerField:          archive,
+			mountBackupPassphraseField: "passphrase",
+		}, pathRestoreFields)
+
+		lr, err := restoreTestMountBackup(reqCtx)
+		assert.Nil(t, err)
+		assert.True(t, lr.IsError())
+		assert.Equal(t, "backup contains unexpected entries "+path, lr.Error().Error())
+
+		keys, _ := logical.CollectKeys(context.TODO(), target)
+		assert.Empty(t, keys)
+	}
+}
+
+func TestMountBackup_RestoreRequiresSingleDecryptionMethod(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	reqCtx := setupMountBackupRequest("target", &logical.InmemStorage{}, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+		mountBackupRecipientField:  "operator",
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "specify exactly one of passphrase, recipient, or private_key to decrypt the backup with", lr.Error().Error())
+}
+
+func TestMountBackup_RestoresWithRecipientIdentity(t *testing.T) {
+	certPEM, keyPEM := createTestOperatorCertificate(t)
+	source := setupTestSourceMount(t)
+
+	archive := takeTestCertificateMountBackup(t, source, certPEM)
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	target := &logical.InmemStorage{}
+	putTestStorageEntry(t, target, "target/recipients/operator", &StoredRecipientIdentity{PrivateKey: keyBlock.Bytes})
+	trustTestSourceMount(t, source, target)
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:         archive,
+		mountBackupRecipientField: "operator",
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{"a"}, lr.Data["roles"])
+}
+
+func TestRenderEncryptedMountBackup_RequiresProtection(t *testing.T) {
+	reqCtx := setupMountBackupRequest("source", &logical.InmemStorage{}, map[string]interface{}{}, pathBackupFields)
+
+	lr, err := renderEncryptedMountBackup(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "specify the certificate or the passphrase to encrypt the backup with", lr.Error().Error())
+}
+
+func TestMountBackup_RefusesBackupOfUntrustedExporter(t *testing.T) {
+	certPEM, keyPEM := createTestOperatorCertificate(t)
+	archive := takeTestCertificateMountBackup(t, setupTestSourceMount(t), certPEM)
+
+	target := &logical.InmemStorage{}
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPrivateKeyField: string(keyPEM),
+	}, pathRestoreFields)
+
+	blk, _ := decodeMountBackupBlock(archive)
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "backup is signed by an untrusted exporter (sha256:"+blk.Headers[roleDataExporterHeader]+")", lr.Error().Error())
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.Empty(t, keys)
+}
+
+func TestMountBackup_RefusesForgedBackup(t *testing.T) {
+	certPEM, keyPEM := createTestOperatorCertificate(t)
+	source := setupTestSourceMount(t)
+	archive := takeTestCertificateMountBackup(t, source, certPEM)
+
+	// The holder of the certificate encrypts different data, but cannot sign it as the source mount
+	cert, _ := parseMountBackupCertificate(certPEM)
+	forged, err := sealMountBackupForRecipient(&MountBackup{
+		Entries: map[string][]byte{"role/x/key": []byte("{}")},
+	}, cert.PublicKey, randomExporterIdentity())
+	assert.Nil(t, err)
+
+	blk, _ := decodeMountBackupBlock(archive)
+	forgedBlk, _ := decodeMountBackupBlock(forged)
+	forgedBlk.Headers[roleDataExporterHeader] = blk.Headers[roleDataExporterHeader]
+
+	target := &logical.InmemStorage{}
+	trustTestSourceMount(t, source, target)
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          string(pem.EncodeToMemory(forgedBlk)),
+		mountBackupPrivateKeyField: string(keyPEM),
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "backup signature is invalid: signature does not match the backup", lr.Error().Error())
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.Equal(t, []string{"target/trusted-exporters"}, keys)
+}
+
+func TestMountBackup_RefusesToReplaceConfiguration(t *testing.T) {
+	archive := takeTestMountBackup(t, setupTestSourceMount(t))
+
+	target := &logical.InmemStorage{}
+	putTestStorageEntry(t, target, "target/config", &BackendConfiguration{NetworkLatency: 7})
+
+	reqCtx := setupMountBackupRequest("target", target, map[string]interface{}{
+		pemContainerField:          archive,
+		mountBackupPassphraseField: "passphrase",
+	}, pathRestoreFields)
+
+	lr, err := restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "entries config of this mount would be replaced; set overwrite_config to replace them", lr.Error().Error())
+
+	keys, _ := logical.CollectKeys(context.TODO(), target)
+	assert.Equal(t, []string{"target/config"}, keys)
+
+	reqCtx.request.Data[mountBackupOverwriteConfigField] = true
+	lr, err = restoreTestMountBackup(reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 42, reqCtx.plugin.cfg.NetworkLatency)
+}
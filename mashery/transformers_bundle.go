@@ -0,0 +1,172 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"time"
+)
+
+const (
+	// Identifier of the recipient key the role data is encrypted for
+	roleDataRecipientKeyHeader = "Recipient-Key"
+
+	recipientsField = "recipients"
+)
+
+// renderEncryptedRoleDataBundle encrypts the role data for each of the recipients, returning the bundle of the
+// signed PEM blocks. The settings of the export apply to every recipient, unless the recipient overrides these.
+func renderEncryptedRoleDataBundle(_ context.Context, reqCtx *RequestHandlerContext[RoleExportContext]) (*logical.Response, error) {
+	settings, err := parseDesiredRoleExport(reqCtx.data)
+	if err != nil {
+		return logical.ErrorResponse("invalid export configuration: %s", err), nil
+	}
+
+	recipients, _ := reqCtx.data.Get(recipientsField).([]interface{})
+	if len(recipients) == 0 {
+		return logical.ErrorResponse("no recipients supplied"), nil
+	}
+
+	var warnings []string
+	var summary []map[string]interface{}
+	bundle := strings.Builder{}
+
+	for idx, raw := range recipients {
+		entry, ok := raw.(map[string]interface{})
+		if !ok {
+			return logical.ErrorResponse("recipient %d: expected an object", idx+1), nil
+		}
+
+		d := &framework.FieldData{Raw: entry, Schema: pathRoleExportFields}
+		if err = d.Validate(); err != nil {
+			return logical.ErrorResponse("recipient %d: %s", idx+1, err.Error()), nil
+		}
+
+		recipientSettings, err := parseDesiredRoleExportOver(settings, d)
+		if err == nil {
+			recipientSettings, err = narrowDesiredRoleExport(reqCtx.heap.GetRole(), recipientSettings)
+		}
+		if err != nil {
+			return logical.ErrorResponse("recipient %d: invalid export configuration: %s", idx+1, err), nil
+		}
+
+		explicitRole, _ := d.Get(recipientRoleField).(string)
+		cert, recipientRole, err := parseRecipientCertificate(d.Get(pemContainerField).(string), explicitRole)
+		if err != nil {
+			return logical.ErrorResponse("recipient %d: %s", idx+1, err.Error()), nil
+		}
+
+		recipientKey, err := recipientPublicKeyID(cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range summary {
+			if other["key_id"] == recipientKey {
+				return logical.ErrorResponse("recipient %d: recipient key %s is already in the bundle", idx+1, recipientKey), nil
+			}
+		}
+
+		pemOut, exportID, err := exportRoleDataToRecipient(reqCtx, cert, recipientRole, recipientSettings)
+		if err != nil {
+			return nil, err
+		}
+		bundle.WriteString(pemOut)
+
+		summary = append(summary, map[string]interface{}{
+			"recipient":      cert.Subject.String(),
+			"recipient_role": recipientRole,
+			"key_id":         recipientKey,
+			exportIDField:    exportID,
+		})
+		if recipientSettings.desiredTerm < 0 {
+			warnings = append(warnings, fmt.Sprintf("recipient %d: explicit term is in the past", idx+1))
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			pemContainerField: bundle.String(),
+			recipientsField:   summary,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// selectRoleDataBlock selects the block encrypted for one of the recipient keys. Data exported to a single
+// recipient consists of a single block, which is selected regardless of its recipient key header.
+func selectRoleDataBlock(bundle []*pem.Block, keyIDs []string) (*pem.Block, error) {
+	if len(bundle) == 1 {
+		return bundle[0], nil
+	}
+
+	for _, blk := range bundle {
+		for _, keyID := range keyIDs {
+			if blk.Headers[roleDataRecipientKeyHeader] == keyID {
+				return blk, nil
+			}
+		}
+	}
+
+	return nil, errors.New("role data bundle contains no data for this recipient")
+}
+
+// selectRoleDataBlockForRole selects the block of the bundle encrypted for the role's recipient key, or for one
+// of its retired keys still within their grace period. The selected block is copied into the specified block.
+func selectRoleDataBlockForRole(bundle []*pem.Block, into *pem.Block) TransformerFunc[RoleContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		var keyIDs []string
+		if pk, err := getPrivateKey(role); err != nil {
+			return nil, err
+		} else if keyID, err := recipientKeyID(pk); err != nil {
+			return nil, err
+		} else {
+			keyIDs = append(keyIDs, keyID)
+		}
+
+		now := time.Now().Unix()
+		for _, k := range role.RecipientKeys.Retired {
+			if k.GraceUntil <= now {
+				continue
+			}
+			if pk, err := parseRecipientKey(k.Key); err == nil {
+				if keyID, err := recipientKeyID(pk); err == nil {
+					keyIDs = append(keyIDs, keyID)
+				}
+			}
+		}
+
+		if blk, err := selectRoleDataBlock(bundle, keyIDs); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		} else {
+			*into = *blk
+		}
+		return nil, nil
+	}
+}
+
+// selectRoleDataBlockForIdentity selects the block of the bundle encrypted for the recipient identity.
+func selectRoleDataBlockForIdentity(bundle []*pem.Block, into *pem.Block) TransformerFunc[RecipientIdentityContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RecipientIdentityContext]) (*logical.Response, error) {
+		pk, err := parseRecipientKey(reqCtx.heap.GetRecipientIdentity().PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		keyID, err := recipientKeyID(pk)
+		if err != nil {
+			return nil, err
+		}
+
+		if blk, err := selectRoleDataBlock(bundle, []string{keyID}); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		} else {
+			*into = *blk
+		}
+		return nil, nil
+	}
+}
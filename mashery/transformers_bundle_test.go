@@ -0,0 +1,163 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func recipientCertificatePEM(recipientKey []byte) string {
+	sourceReq := RoleRequestMockBuilder[RoleContext]{
+		container:   &RoleContainer{role: &StoredRole{PrivateKey: recipientKey}},
+		fieldSchema: pathRolePemReadFields,
+	}
+
+	_, reqCtx := sourceReq.Build()
+	lr, _ := renderRoleCertificate(nil, reqCtx)
+	return lr.Data[pemContainerField].(string)
+}
+
+func setupRoleDataBundleExport(data map[string]interface{}) *RequestHandlerContext[RoleExportContext] {
+	exportRole := createRoleWithFilledRoleKeys()
+	exportReq := RoleRequestMockBuilder[RoleExportContext]{
+		container: &RoleExportContainer{
+			RoleContainer:             RoleContainer{role: &exportRole},
+			ExporterIdentityContainer: ExporterIdentityContainer{identity: testExporterIdentity},
+		},
+		fieldSchema: pathRoleExportFields,
+		data:        data,
+	}
+
+	return exportReq.Request()
+}
+
+func decodeRoleDataBundle(t *testing.T, bundle string) []*pem.Block {
+	var rv []*pem.Block
+	rest := []byte(bundle)
+	for blk, remainder := pem.Decode(rest); blk != nil; blk, remainder = pem.Decode(remainder) {
+		assert.Equal(t, masheryRoleDataPEMBlockName, blk.Type)
+		rv = append(rv, blk)
+	}
+	return rv
+}
+
+func TestRenderEncryptedRoleDataBundle_EncryptsForEachRecipient(t *testing.T) {
+	firstKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	secondKey, _ := generateRecipientKey(recipientKeyTypeX25519)
+
+	reqCtx := setupRoleDataBundleExport(map[string]interface{}{
+		explicitQpsField: 7,
+		recipientsField: []interface{}{
+			map[string]interface{}{
+				pemContainerField: recipientCertificatePEM(firstKey),
+			},
+			map[string]interface{}{
+				pemContainerField:    recipientCertificatePEM(secondKey),
+				explicitQpsField:     3,
+				explicitNumUsesField: 10,
+				forceProxyModeField:  true,
+				recipientRoleField:   "emea",
+			},
+		},
+	})
+
+	lr, err := renderEncryptedRoleDataBundle(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 2, len(lr.Data[recipientsField].([]map[string]interface{})))
+
+	bundle := decodeRoleDataBundle(t, lr.Data[pemContainerField].(string))
+	assert.Equal(t, 2, len(bundle))
+
+	firstPK, _ := parseRecipientKey(firstKey)
+	firstKeyID, _ := recipientKeyID(firstPK)
+	secondPK, _ := parseRecipientKey(secondKey)
+	secondKeyID, _ := recipientKeyID(secondPK)
+
+	assert.Equal(t, firstKeyID, bundle[0].Headers[roleDataRecipientKeyHeader])
+	assert.Equal(t, "7", bundle[0].Headers["Max QPS"])
+	assert.Equal(t, "∞", bundle[0].Headers["Uses"])
+	assert.Equal(t, "false", bundle[0].Headers["Forced Proxy Mode"])
+
+	assert.Equal(t, secondKeyID, bundle[1].Headers[roleDataRecipientKeyHeader])
+	assert.Equal(t, "3", bundle[1].Headers["Max QPS"])
+	assert.Equal(t, "max 10 uses", bundle[1].Headers["Uses"])
+	assert.Equal(t, "true", bundle[1].Headers["Forced Proxy Mode"])
+	assert.Equal(t, "emea", bundle[1].Headers[recipientRolePEMHeader])
+
+	// Each recipient can decrypt its own block only
+	_, err = decryptRoleData(secondPK, bundle[1].Headers[roleDataFormatHeader], bundle[1].Bytes, nil)
+	assert.Nil(t, err)
+	_, err = decryptRoleData(secondPK, bundle[0].Headers[roleDataFormatHeader], bundle[0].Bytes, nil)
+	assert.NotNil(t, err)
+}
+
+func TestRenderEncryptedRoleDataBundle_RejectsDuplicateRecipient(t *testing.T) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	certPEM := recipientCertificatePEM(key)
+
+	reqCtx := setupRoleDataBundleExport(map[string]interface{}{
+		recipientsField: []interface{}{
+			map[string]interface{}{pemContainerField: certPEM},
+			map[string]interface{}{pemContainerField: certPEM},
+		},
+	})
+
+	lr, err := renderEncryptedRoleDataBundle(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Contains(t, lr.Error().Error(), "recipient 2: recipient key")
+}
+
+func TestRenderEncryptedRoleDataBundle_RejectsMalformedRecipient(t *testing.T) {
+	reqCtx := setupRoleDataBundleExport(map[string]interface{}{
+		recipientsField: []interface{}{
+			map[string]interface{}{pemContainerField: "garbage"},
+		},
+	})
+
+	lr, err := renderEncryptedRoleDataBundle(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "recipient 1: supplied PEM data bears no PEM block", lr.Error().Error())
+}
+
+func TestRetrieveImportPEMBundleFromRequest_ReadsAllBlocks(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{
+		pemContainerField: createRoleDataExchangePEMBlock([]byte("first"), map[string]string{}) +
+			createRoleDataExchangePEMBlock([]byte("second"), map[string]string{}),
+	}, pathRolePemImportFields)
+
+	bundle, err := retrieveImportPEMBundleFromRequest(reqCtx.data)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(bundle))
+	assert.Equal(t, []byte("second"), bundle[1].Bytes)
+}
+
+func TestSelectRoleDataBlockForRole(t *testing.T) {
+	currentKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	pk, _ := parseRecipientKey(currentKey)
+	keyID, _ := recipientKeyID(pk)
+
+	bundle := []*pem.Block{
+		{Bytes: []byte("other"), Headers: map[string]string{roleDataRecipientKeyHeader: "0123456789abcdef"}},
+		{Bytes: []byte("own"), Headers: map[string]string{roleDataRecipientKeyHeader: keyID}},
+	}
+
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{PrivateKey: currentKey})
+	selected := &pem.Block{}
+	lr, err := selectRoleDataBlockForRole(bundle, selected)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("own"), selected.Bytes)
+
+	lr, err = selectRoleDataBlockForRole(bundle[:1], selected)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Equal(t, []byte("other"), selected.Bytes)
+
+	otherKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, reqCtx = setupRoleRequestMockHaving(StoredRole{PrivateKey: otherKey})
+	lr, err = selectRoleDataBlockForRole(bundle, selected)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "role data bundle contains no data for this recipient", lr.Error().Error())
+}
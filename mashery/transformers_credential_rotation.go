@@ -0,0 +1,266 @@
+package mashery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"time"
+)
+
+// credentialRotation changes a single credential of the role, e.g. the password of the Mashery user, in Mashery.
+type credentialRotation struct {
+	// Name of the credential in the messages
+	credential string
+	// Generates the new value of the credential
+	generate func() (string, error)
+	// Current value of the credential
+	current func(keys *RoleKeys) string
+	// Pending keys holding the new value of the credential
+	stage func(value string) *PendingRoleKeys
+	// Changes the credential in Mashery, authorizing the call with the access token. The change Mashery has clearly
+	// refused yields a rejectedChangeError.
+	change func(ctx context.Context, b *AuthPlugin, role *StoredRole, accessToken string, value string) error
+	// Verifies that Mashery accepts the role carrying the new value
+	verify func(ctx context.Context, b *AuthPlugin, candidate *StoredRole) error
+	// Delays after which the failed verification is repeated, as Mashery may take a while to propagate the change
+	propagation []time.Duration
+	// Whether the export carries the credential; the recipient cannot use the export once the credential is rotated
+	exported func(rec *RoleExportRecord) bool
+}
+
+// forceRotationField rotates the credential that the active exports of the role carry
+const forceRotationField = "force"
+
+// verifyChange verifies that Mashery accepts the new value, repeating the failed verification after each of the
+// propagation delays. The error of the last verification is returned.
+func (rotation *credentialRotation) verifyChange(ctx context.Context, b *AuthPlugin, candidate *StoredRole) error {
+	err := rotation.verify(ctx, b, candidate)
+	for _, delay := range rotation.propagation {
+		if err == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		err = rotation.verify(ctx, b, candidate)
+	}
+
+	return err
+}
+
+// rejectedChangeError the change of a credential that Mashery has refused, or that was not asked of Mashery at all.
+// The credential remains unchanged in Mashery.
+type rejectedChangeError struct {
+	msg string
+}
+
+func (e *rejectedChangeError) Error() string {
+	return e.msg
+}
+
+func rejectedChange(format string, a ...interface{}) error {
+	return &rejectedChangeError{msg: fmt.Sprintf(format, a...)}
+}
+
+// blockOperationWithPendingKeys refuses to replace the credentials that were staged by the operator
+func blockOperationWithPendingKeys(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	if role.PendingKeys != nil {
+		return logical.ErrorResponse("role %s has pending keys; promote or abort these first", role.Name), nil
+	}
+
+	return nil, nil
+}
+
+// blockRotationOfExportedCredential refuses to rotate the credential that the active exports of the role carry, as
+// the recipients cannot use these exports anymore once the credential is rotated, unless the rotation is forced.
+func blockRotationOfExportedCredential(rotation credentialRotation) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		if reqCtx.data.Get(forceRotationField).(bool) {
+			return nil, nil
+		}
+
+		exp, err := readRoleExports(ctx, reqCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids := exp.ActiveExports(time.Now(), rotation.exported); len(ids) > 0 {
+			return logical.ErrorResponse("exports %s of role %s carry its %s, and cannot be used once the %s is rotated; set %s to rotate it anyway",
+				strings.Join(ids, ", "), reqCtx.heap.GetRole().Name, rotation.credential, rotation.credential, forceRotationField), nil
+		}
+		return nil, nil
+	}
+}
+
+// warnOfRotatedExports warns of the active exports of the role carrying the credential that the forced rotation has
+// rotated.
+func warnOfRotatedExports(rotation credentialRotation, render TransformerFunc[RoleContext]) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		resp, err := render(ctx, reqCtx)
+		if err != nil || resp == nil || resp.IsError() {
+			return resp, err
+		}
+
+		exp, err := readRoleExports(ctx, reqCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		if ids := exp.ActiveExports(time.Now(), rotation.exported); len(ids) > 0 {
+			resp.Data["affected_exports"] = ids
+			resp.AddWarning(fmt.Sprintf("exports %s carry the previous %s, and cannot be used anymore", strings.Join(ids, ", "), rotation.credential))
+		}
+		return resp, nil
+	}
+}
+
+// rotateRoleCredential changes the credential of the role to a generated one, and verifies that Mashery accepts the
+// new value. The new value is staged as the pending keys of the role before Mashery is asked to change it: should the
+// rotation be interrupted, the value Mashery has accepted is not lost. The pending keys are discarded only if Mashery
+// has clearly refused the change; a change that failed otherwise, e.g. on a timeout, may have been applied. If Mashery
+// does not accept the new value once the change had time to propagate, the credential is changed back.
+func rotateRoleCredential(rotation credentialRotation) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+		b := reqCtx.plugin
+
+		creds := role.asV3Credentials()
+		tkn, err := b.GetOAuthHelper(role).RetrieveAccessTokenFor(&creds)
+		if err != nil {
+			return logical.ErrorResponse("the current %s of role %s is not accepted by Mashery: %s", rotation.credential, role.Name, err.Error()), nil
+		}
+
+		value, err := rotation.generate()
+		if err != nil {
+			return nil, err
+		}
+
+		role.PendingKeys = rotation.stage(value)
+		role.PendingKeys.Staged = time.Now().Unix()
+		if _, err = savePendingRoleKeys(ctx, reqCtx); err != nil {
+			return nil, err
+		}
+
+		if err = rotation.change(ctx, b, role, tkn.AccessToken, value); err != nil {
+			var rejected *rejectedChangeError
+			if !errors.As(err, &rejected) {
+				b.Logger().Warn("credential may have been changed; the new value is kept as the pending keys", "role", role.Name, "credential", rotation.credential, "error", err)
+				return logical.ErrorResponse("%s of role %s may have been changed by Mashery (%s); the new %s is kept as the pending keys of the role: promote these if Mashery accepts the new %s, or abort these otherwise",
+					rotation.credential, role.Name, err.Error(), rotation.credential, rotation.credential), nil
+			}
+			if _, delErr := deletePendingRoleKeys(ctx, reqCtx); delErr != nil {
+				return nil, delErr
+			}
+			return logical.ErrorResponse("%s of role %s was not changed: %s", rotation.credential, role.Name, err.Error()), nil
+		}
+
+		candidate := *role
+		role.PendingKeys.ApplyTo(&candidate.Keys)
+		if err = rotation.verifyChange(ctx, b, &candidate); err != nil {
+			if revertErr := rotation.change(ctx, b, role, tkn.AccessToken, rotation.current(&role.Keys)); revertErr != nil {
+				return logical.ErrorResponse("the new %s of role %s is not accepted by Mashery (%s), and the %s could not be reverted (%s); the new %s is kept as the pending keys of the role",
+					rotation.credential, role.Name, err.Error(), rotation.credential, revertErr.Error(), rotation.credential), nil
+			}
+			if _, delErr := deletePendingRoleKeys(ctx, reqCtx); delErr != nil {
+				return nil, delErr
+			}
+			return logical.ErrorResponse("the new %s of role %s is not accepted by Mashery, and the %s was reverted: %s",
+				rotation.credential, role.Name, rotation.credential, err.Error()), nil
+		}
+
+		return applyPendingRoleKeys(ctx, reqCtx)
+	}
+}
+
+// scheduledRotationRetryDelay the time, in seconds, the scheduled rotation of a credential backs off after it has
+// failed. Each failed run may change the credential in Mashery and change it back.
+const scheduledRotationRetryDelay int64 = 3600
+
+// rotationSchedule the scheduled rotation of a single credential of the roles
+type rotationSchedule struct {
+	// Name of the credential in the messages
+	credential string
+	// Path of the role the rotation is recorded as, e.g. /rotate-password
+	path string
+	// Whether the rotation of the role is due by the specified time
+	due func(keys *RoleKeys, t time.Time) bool
+	// Records the time the rotation of the role has failed
+	failed func(keys *RoleKeys, t time.Time)
+	// Operation rotating the credential of the role, and its fields
+	rotate framework.OperationFunc
+	schema map[string]*framework.FieldSchema
+}
+
+// rotateScheduledRoleCredential rotates the credential of the roles whose rotation period has passed. A role whose
+// credential cannot be rotated does not prevent the rotation of the other roles; the failure is recorded in the keys
+// of the role, and the rotation is retried once scheduledRotationRetryDelay has passed.
+func (b *AuthPlugin) rotateScheduledRoleCredential(ctx context.Context, req *logical.Request, schedule rotationSchedule) error {
+	// The secondaries cannot write the storage; the primary rotates the credentials.
+	if sys := b.System(); sys != nil && sys.ReplicationState().HasState(consts.ReplicationPerformanceSecondary|consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	names, err := req.Storage.List(ctx, b.rolesStorageRoot())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var rv error
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, "/")
+		keysPath := b.rolesStorageRoot() + name + storedRoleKeyPathSuffix
+
+		keys := RoleKeys{}
+		if found, readErr := b.vaultStorage.Read(ctx, req.Storage, keysPath, &keys); readErr != nil {
+			rv = readErr
+			continue
+		} else if !found || keys.Imported || !keys.IsV3Capable() || !schedule.due(&keys, now) {
+			continue
+		}
+
+		data := &framework.FieldData{
+			Raw:    map[string]interface{}{roleName: name},
+			Schema: schedule.schema,
+		}
+		// The rotation is performed as the update of the rotation path, so that it is recorded as such
+		rotationReq := *req
+		rotationReq.Path = "roles/" + name + schedule.path
+		rotationReq.Operation = logical.UpdateOperation
+
+		resp, rotateErr := schedule.rotate(ctx, &rotationReq, data)
+		if rotateErr != nil {
+			b.Logger().Error("scheduled "+schedule.credential+" rotation failed", "role", name, "error", rotateErr)
+			rv = rotateErr
+		} else if resp.IsError() {
+			b.Logger().Warn("scheduled "+schedule.credential+" rotation failed", "role", name, "error", resp.Error())
+		} else {
+			b.Logger().Info(schedule.credential+" was rotated on schedule", "role", name)
+			continue
+		}
+
+		// The keys are read again, as the failed rotation may have changed these
+		if found, readErr := b.vaultStorage.Read(ctx, req.Storage, keysPath, &keys); readErr != nil {
+			rv = readErr
+		} else if found {
+			schedule.failed(&keys, now)
+			if writeErr := b.vaultStorage.Persist(ctx, req.Storage, keysPath, &keys); writeErr != nil {
+				rv = writeErr
+			}
+		}
+	}
+
+	if rv != nil {
+		return fmt.Errorf("some of the scheduled %s rotations have failed: %s", schedule.credential, rv.Error())
+	}
+	return nil
+}
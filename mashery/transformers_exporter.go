@@ -0,0 +1,225 @@
+package mashery
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const thisMountExporterName = "this mount"
+
+func (b *AuthPlugin) exporterIdentityPath() string {
+	return b.backendUUID + "/exporter/identity"
+}
+
+func (b *AuthPlugin) trustedExportersPath() string {
+	return b.backendUUID + "/trusted-exporters"
+}
+
+// readExporterIdentity reads the identity key this mount signs the exported role data with. If the key does not
+// exist yet, it is generated when generate is true; otherwise nil is returned.
+func readExporterIdentity[T any](ctx context.Context, reqCtx *RequestHandlerContext[T], generate bool) (*ecdsa.PrivateKey, error) {
+	path := reqCtx.plugin.exporterIdentityPath()
+
+	if generate {
+		reqCtx.plugin.exporterIdentityLock.Lock()
+		defer reqCtx.plugin.exporterIdentityLock.Unlock()
+	}
+
+	found, der, err := reqCtx.ReadBinaryPath(ctx, path)
+	if err != nil {
+		return nil, err
+	} else if !found {
+		if !generate {
+			return nil, nil
+		}
+
+		if der, err = generateExporterIdentityKey(); err != nil {
+			return nil, err
+		}
+		if err = reqCtx.WriteBinaryPath(ctx, path, der); err != nil {
+			return nil, err
+		}
+	}
+
+	return parseExporterIdentityKey(der)
+}
+
+// retrieveExporterIdentity carries the identity key of this mount, generating the key on the first use.
+func retrieveExporterIdentity[T ExporterIdentityContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	pk, err := readExporterIdentity(ctx, reqCtx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx.heap.CarryExporterIdentity(pk)
+	return nil, nil
+}
+
+func renderExporterIdentity(_ context.Context, reqCtx *RequestHandlerContext[ExporterIdentityContext]) (*logical.Response, error) {
+	pk := reqCtx.heap.GetExporterIdentity()
+
+	cn := "Mashery Exporter"
+	if val, ok := reqCtx.data.GetOk(pemCommonNameField); ok {
+		cn = val.(string)
+	}
+
+	certPEM, err := createExporterIdentityCertificate(pk, cn)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := publicKeyFingerprint(&pk.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			pemContainerField:    certPEM,
+			certFingerprintField: fp,
+		},
+	}, nil
+}
+
+func readTrustedExporters[T TrustedExportersContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	_, err := reqCtx.ReadPath(ctx, reqCtx.plugin.trustedExportersPath(), reqCtx.heap.GetTrustedExporters())
+	return nil, err
+}
+
+func saveTrustedExporters[T TrustedExportersContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	return nil, reqCtx.WritePath(ctx, reqCtx.plugin.trustedExportersPath(), reqCtx.heap.GetTrustedExporters())
+}
+
+func addTrustedExporterFromRequest(_ context.Context, reqCtx *RequestHandlerContext[TrustedExportersContext]) (*logical.Response, error) {
+	pemStr, _ := reqCtx.data.Get(pemContainerField).(string)
+	cert, err := parseExporterIdentityCertificate(pemStr)
+	if err != nil {
+		return logical.ErrorResponse("invalid exporter identity: %s", err.Error()), nil
+	} else if time.Now().After(cert.NotAfter) {
+		return logical.ErrorResponse("invalid exporter identity: certificate has expired"), nil
+	}
+
+	fp, err := publicKeyFingerprint(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	name := reqCtx.data.Get(trustedExporterNameField).(string)
+	store := reqCtx.heap.GetTrustedExporters()
+	if other, _ := store.FindByFingerprint(fp); len(other) > 0 && other != name {
+		return logical.ErrorResponse("this exporter identity is already trusted as %s", other), nil
+	}
+
+	if store.Exporters == nil {
+		store.Exporters = map[string]TrustedExporter{}
+	}
+	store.Exporters[name] = TrustedExporter{
+		Certificate: pemStr,
+		Fingerprint: fp,
+		Subject:     cert.Subject.String(),
+	}
+
+	return nil, nil
+}
+
+func removeTrustedExporter(_ context.Context, reqCtx *RequestHandlerContext[TrustedExportersContext]) (*logical.Response, error) {
+	delete(reqCtx.heap.GetTrustedExporters().Exporters, reqCtx.data.Get(trustedExporterNameField).(string))
+	return nil, nil
+}
+
+func renderTrustedExporter(_ context.Context, reqCtx *RequestHandlerContext[TrustedExportersContext]) (*logical.Response, error) {
+	exp, ok := reqCtx.heap.GetTrustedExporters().Exporters[reqCtx.data.Get(trustedExporterNameField).(string)]
+	if !ok {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			certFingerprintField: exp.Fingerprint,
+			"subject":            exp.Subject,
+			pemContainerField:    exp.Certificate,
+		},
+	}, nil
+}
+
+func renderTrustedExportersList(_ context.Context, reqCtx *RequestHandlerContext[TrustedExportersContext]) (*logical.Response, error) {
+	var names []string
+	for name := range reqCtx.heap.GetTrustedExporters().Exporters {
+		names = append(names, name)
+	}
+
+	return logical.ListResponse(names), nil
+}
+
+// verifyRoleDataExporter verifies that the role data was signed by this mount or by a trusted exporter. The
+// verified identity of the exporter is recorded with the role.
+func verifyRoleDataExporter[T RoleContext](pemBlock *pem.Block) TransformerFunc[T] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		signature := pemBlock.Headers[roleDataSignatureHeader]
+		fingerprint := pemBlock.Headers[roleDataExporterHeader]
+		if len(signature) == 0 || len(fingerprint) == 0 {
+			if reqCtx.plugin.config().AllowUnsignedImports {
+				reqCtx.plugin.Logger().Warn(fmt.Sprintf("importing unsigned role data into role %s", role.Name))
+				role.Keys.Exporter = ""
+				role.Keys.ExporterFingerprint = ""
+				return nil, nil
+			}
+
+			return logical.ErrorResponse("role data is not signed by its exporter"), nil
+		}
+
+		name, exporterKey, err := findTrustedExporterKey(ctx, reqCtx, fingerprint)
+		if err == errTrustedExporterExpired {
+			return logical.ErrorResponse("role data is signed by exporter %s, whose certificate has expired (sha256:%s)", name, fingerprint), nil
+		} else if err != nil {
+			return nil, err
+		} else if exporterKey == nil {
+			return logical.ErrorResponse("role data is signed by an untrusted exporter (sha256:%s)", fingerprint), nil
+		}
+
+		if err = verifyRoleDataSignature(exporterKey, pemBlock.Headers[roleDataFormatHeader], fingerprint, pemBlock.Bytes, signature); err != nil {
+			return logical.ErrorResponse("role data signature is invalid: %s", err.Error()), nil
+		}
+
+		role.Keys.Exporter = fmt.Sprintf("%s (sha256:%s)", name, fingerprint)
+		role.Keys.ExporterFingerprint = fingerprint
+		return nil, nil
+	}
+}
+
+// findTrustedExporterKey finds the public key of the trusted exporter having the fingerprint. The identity of
+// this mount is always trusted. The trusted exporter whose certificate has expired yields errTrustedExporterExpired.
+func findTrustedExporterKey[T any](ctx context.Context, reqCtx *RequestHandlerContext[T], fingerprint string) (string, crypto.PublicKey, error) {
+	if own, err := readExporterIdentity(ctx, reqCtx, false); err != nil {
+		return "", nil, err
+	} else if own != nil {
+		if ownFp, err := publicKeyFingerprint(&own.PublicKey); err != nil {
+			return "", nil, err
+		} else if ownFp == fingerprint {
+			return thisMountExporterName, &own.PublicKey, nil
+		}
+	}
+
+	store := StoredTrustedExporters{}
+	if _, err := reqCtx.ReadPath(ctx, reqCtx.plugin.trustedExportersPath(), &store); err != nil {
+		return "", nil, err
+	}
+
+	if name, exp := store.FindByFingerprint(fingerprint); exp != nil {
+		cert, err := parseExporterIdentityCertificate(exp.Certificate)
+		if err != nil {
+			return "", nil, err
+		} else if time.Now().After(cert.NotAfter) {
+			return name, nil, errTrustedExporterExpired
+		}
+		return name, cert.PublicKey, nil
+	}
+
+	return "", nil, nil
+}
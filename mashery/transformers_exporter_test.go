@@ -0,0 +1,257 @@
+package mashery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+var testExporterIdentity = randomExporterIdentity()
+
+func randomExporterIdentity() *ecdsa.PrivateKey {
+	der, _ := generateExporterIdentityKey()
+	pk, _ := parseExporterIdentityKey(der)
+	return pk
+}
+
+func TestRoleDataSignature_RoundTrip(t *testing.T) {
+	data := []byte("encrypted role data")
+
+	fp, sig, err := signRoleData(testExporterIdentity, roleDataFormatV2, data)
+	assert.Nil(t, err)
+	assert.Nil(t, verifyRoleDataSignature(&testExporterIdentity.PublicKey, roleDataFormatV2, fp, data, sig))
+
+	// The signature covers the data, the format, and the fingerprint of the exporter
+	assert.NotNil(t, verifyRoleDataSignature(&testExporterIdentity.PublicKey, roleDataFormatV2, fp, []byte("other data"), sig))
+	assert.NotNil(t, verifyRoleDataSignature(&testExporterIdentity.PublicKey, roleDataFormatV1, fp, data, sig))
+	assert.NotNil(t, verifyRoleDataSignature(&testExporterIdentity.PublicKey, roleDataFormatV2, "00"+fp, data, sig))
+	assert.NotNil(t, verifyRoleDataSignature(&randomExporterIdentity().PublicKey, roleDataFormatV2, fp, data, sig))
+}
+
+func TestRenderEncryptedRoleData_SignsRoleData(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+	assert.Equal(t, fp, pemOut.Headers[roleDataExporterHeader])
+	assert.Nil(t, verifyRoleDataSignature(&testExporterIdentity.PublicKey, roleDataFormatV2, fp, pemOut.Bytes, pemOut.Headers[roleDataSignatureHeader]))
+}
+
+func TestExporterIdentityCertificate_RoundTrip(t *testing.T) {
+	certPEM, err := createExporterIdentityCertificate(testExporterIdentity, "Exporter")
+	assert.Nil(t, err)
+
+	cert, err := parseExporterIdentityCertificate(certPEM)
+	assert.Nil(t, err)
+	assert.Equal(t, "Exporter", cert.Subject.CommonName)
+	assert.True(t, testExporterIdentity.PublicKey.Equal(cert.PublicKey))
+}
+
+func TestParseExporterIdentityCertificate_RejectsRSAKeys(t *testing.T) {
+	sourceReq := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{
+				PrivateKey: randomPrivateKey(),
+			},
+		},
+		fieldSchema: pathRolePemReadFields,
+	}
+
+	_, reqCtx := sourceReq.Build()
+	lr, _ := renderRoleCertificate(nil, reqCtx)
+
+	// The role certificate is re-labelled as a certificate, so that only the key type differs
+	blk, _ := pem.Decode([]byte(lr.Data[pemContainerField].(string)))
+	blk.Type = "CERTIFICATE"
+
+	_, err := parseExporterIdentityCertificate(string(pem.EncodeToMemory(blk)))
+	assert.Equal(t, "exporter identity must carry an ECDSA public key", err.Error())
+}
+
+func setupTrustedExporterRequest(name string, certPEM string) *RequestHandlerContext[TrustedExportersContext] {
+	builder := RoleRequestMockBuilder[TrustedExportersContext]{
+		container: &TrustedExportersContainer{},
+		data: map[string]interface{}{
+			trustedExporterNameField: name,
+			pemContainerField:        certPEM,
+		},
+		fieldSchema: pathTrustedExporterFields,
+	}
+
+	return builder.Request()
+}
+
+func TestAddTrustedExporterFromRequest(t *testing.T) {
+	certPEM, _ := createExporterIdentityCertificate(testExporterIdentity, "Exporter")
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+
+	reqCtx := setupTrustedExporterRequest("partner", certPEM)
+	lr, err := addTrustedExporterFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	exp := reqCtx.heap.GetTrustedExporters().Exporters["partner"]
+	assert.Equal(t, fp, exp.Fingerprint)
+	assert.Equal(t, "CN=Exporter,O=Mashery API HashiCorp Vault Authentication Backend", exp.Subject)
+
+	lr, err = renderTrustedExporter(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, fp, lr.Data[certFingerprintField])
+}
+
+func TestAddTrustedExporterFromRequest_RejectsSameIdentityUnderOtherName(t *testing.T) {
+	certPEM, _ := createExporterIdentityCertificate(testExporterIdentity, "Exporter")
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+
+	reqCtx := setupTrustedExporterRequest("other", certPEM)
+	reqCtx.heap.GetTrustedExporters().Exporters = map[string]TrustedExporter{
+		"partner": {Certificate: certPEM, Fingerprint: fp},
+	}
+
+	lr, err := addTrustedExporterFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "this exporter identity is already trusted as partner", lr.Error().Error())
+}
+
+func TestAddTrustedExporterFromRequest_RejectsGarbage(t *testing.T) {
+	reqCtx := setupTrustedExporterRequest("partner", "garbage")
+
+	lr, err := addTrustedExporterFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "invalid exporter identity: input does not contain a valid PEM block", lr.Error().Error())
+}
+
+func TestVerifyRoleDataExporter_RejectsUnsignedData(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	delete(pemOut.Headers, roleDataSignatureHeader)
+
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data is not signed by its exporter", lr.Error().Error())
+}
+
+func TestVerifyRoleDataExporter_AllowsUnsignedDataIfConfigured(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	delete(pemOut.Headers, roleDataSignatureHeader)
+
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{Keys: RoleKeys{Exporter: "previous"}})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	reqCtx.plugin.cfg.AllowUnsignedImports = true
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, "", reqCtx.heap.GetRole().Keys.Exporter)
+}
+
+func TestVerifyRoleDataExporter_RejectsUntrustedExporter(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.exporterIdentityPath()).Return(nil, nil)
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.trustedExportersPath()).Return(nil, nil)
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data is signed by an untrusted exporter (sha256:"+pemOut.Headers[roleDataExporterHeader]+")", lr.Error().Error())
+}
+
+func setupTrustedTestExporter(t *testing.T) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleContext]) {
+	certPEM, _ := createExporterIdentityCertificate(testExporterIdentity, "Exporter")
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+
+	store := StoredTrustedExporters{
+		Exporters: map[string]TrustedExporter{
+			"partner": {Certificate: certPEM, Fingerprint: fp},
+		},
+	}
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.exporterIdentityPath()).Return(nil, nil)
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.trustedExportersPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.trustedExportersPath(), &store), nil)
+
+	return emulStore, reqCtx
+}
+
+func TestVerifyRoleDataExporter_AcceptsTrustedExporter(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	emulStore, reqCtx := setupTrustedTestExporter(t)
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, "partner (sha256:"+pemOut.Headers[roleDataExporterHeader]+")", reqCtx.heap.GetRole().Keys.Exporter)
+}
+
+func TestVerifyRoleDataExporter_RejectsExpiredTrustedExporter(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+
+	template := createRoleCertificateTemplate("Exporter", time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	der, _ := x509.CreateCertificate(rand.Reader, &template, &template, &testExporterIdentity.PublicKey, testExporterIdentity)
+	certPEM := string(pem.EncodeToMemory(&pem.Block{Type: masheryExporterIdentityPEMBlockName, Bytes: der}))
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+
+	store := StoredTrustedExporters{
+		Exporters: map[string]TrustedExporter{
+			"partner": {Certificate: certPEM, Fingerprint: fp},
+		},
+	}
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.exporterIdentityPath()).Return(nil, nil)
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.trustedExportersPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.trustedExportersPath(), &store), nil)
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data is signed by exporter partner, whose certificate has expired (sha256:"+fp+")", lr.Error().Error())
+	assert.Equal(t, "", reqCtx.heap.GetRole().Keys.Exporter)
+}
+
+func TestVerifyRoleDataExporter_RejectsTamperedData(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	pemOut.Bytes[len(pemOut.Bytes)-1] ^= 0x01
+
+	_, reqCtx := setupTrustedTestExporter(t)
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data signature is invalid: signature does not match the role data", lr.Error().Error())
+	assert.Equal(t, "", reqCtx.heap.GetRole().Keys.Exporter)
+}
+
+func TestVerifyRoleDataExporter_TrustsOwnIdentity(t *testing.T) {
+	_, _, pemOut := setupTestRoleDataExport()
+	der, _ := x509.MarshalPKCS8PrivateKey(testExporterIdentity)
+
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.exporterIdentityPath()).
+		Return(createBinaryStorageEntryFrom(t, reqCtx.plugin.exporterIdentityPath(), der), nil)
+
+	lr, err := verifyRoleDataExporter[RoleContext](pemOut)(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, thisMountExporterName+" (sha256:"+pemOut.Headers[roleDataExporterHeader]+")", reqCtx.heap.GetRole().Keys.Exporter)
+}
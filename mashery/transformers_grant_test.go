@@ -1,8 +1,16 @@
 package mashery
 
 import (
+	"context"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/masherytypes"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestReadGrantRequestParams_SimpleV2(t *testing.T) {
@@ -45,3 +53,172 @@ func TestReadGrantRequestParams_WillRejectUnkonwnAPIVersion(t *testing.T) {
 	assert.Equal(t, 3, gr.apiVersion)
 	assert.False(t, gr.asLease)
 }
+
+func setupV3LeaseRenewalRequest(role StoredRole, internalData map[string]interface{}) *RequestHandlerContext[V3TokenContext] {
+	builder := RoleRequestMockBuilder[V3TokenContext]{
+		container: &V3TokenContextContainer{
+			RoleContainer: RoleContainer{role: &role},
+		},
+	}
+
+	reqCtx := builder.Request()
+	reqCtx.request.Secret = &logical.Secret{
+		LeaseOptions: logical.LeaseOptions{
+			IssueTime: time.Now().Add(-time.Hour),
+		},
+		InternalData: internalData,
+	}
+	reqCtx.plugin.Backend = &framework.Backend{}
+	reqCtx.plugin.v3OAuthHelpers = map[string]*v3client.V3OAuthHelper{}
+
+	return reqCtx
+}
+
+func TestExchangeV3RefreshToken_RequiresRefreshToken(t *testing.T) {
+	reqCtx := setupV3LeaseRenewalRequest(StoredRole{}, map[string]interface{}{})
+
+	lr, err := exchangeV3RefreshToken(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+}
+
+func TestExchangeV3RefreshToken_WillExchange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh-1" {
+			w.WriteHeader(400)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-2","refresh_token":"refresh-2","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	role := StoredRole{
+		Keys: RoleKeys{
+			ApiKey:             "key",
+			KeySecret:          "secret",
+			OAuthTokenEndpoint: srv.URL,
+		},
+	}
+	reqCtx := setupV3LeaseRenewalRequest(role, map[string]interface{}{
+		secretInternalRefreshToken: "refresh-1",
+	})
+
+	lr, err := exchangeV3RefreshToken(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, "access-2", reqCtx.heap.GetV3TokenResponse().AccessToken)
+
+	lr, err = renderV3LeaseRenewal(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "access-2", lr.Data[secretAccessToken])
+	assert.Equal(t, "refresh-2", lr.Secret.InternalData[secretInternalRefreshToken])
+	assert.Equal(t, v3LeaseIncrement, lr.Secret.TTL)
+	assert.True(t, lr.Secret.Renewable)
+	// The lease may be extended by the lifetime of the exchanged token, counting from its original issue time
+	assert.True(t, lr.Secret.MaxTTL > time.Hour+time.Minute*50)
+}
+
+func TestCreateV3LeaseRenewal_WillNotOutliveRoleTerm(t *testing.T) {
+	role := StoredRole{
+		Usage: StoredRoleUsage{
+			ExplicitTerm: time.Now().Add(time.Minute * 5).Unix(),
+		},
+	}
+	reqCtx := setupV3LeaseRenewalRequest(role, map[string]interface{}{
+		secretInternalRefreshToken: "refresh-1",
+	})
+	reqCtx.request.Secret.Increment = time.Hour * 4
+
+	tkn := masherytypes.AccessTokenResponse{
+		AccessToken: "access-2",
+		ExpiresIn:   3600,
+	}
+
+	lr := reqCtx.plugin.createV3LeaseRenewal(reqCtx.request.Secret, tkn.ObtainedNow(), &role)
+
+	// Refresh token is retained if Mashery doesn't rotate it
+	assert.Equal(t, "refresh-1", lr.Secret.InternalData[secretInternalRefreshToken])
+	// The lease may not be extended beyond the term of the role
+	assert.True(t, lr.Secret.MaxTTL <= time.Hour+time.Minute*5)
+	assert.True(t, lr.Secret.TTL <= time.Minute*5)
+}
+
+func TestCreateV3LeaseRenewal_CapsIncrementToTokenLifetime(t *testing.T) {
+	reqCtx := setupV3LeaseRenewalRequest(StoredRole{}, map[string]interface{}{})
+	reqCtx.request.Secret.Increment = time.Hour * 4
+
+	tkn := masherytypes.AccessTokenResponse{
+		AccessToken: "access-2",
+		ExpiresIn:   3600,
+	}
+
+	lr := reqCtx.plugin.createV3LeaseRenewal(reqCtx.request.Secret, tkn.ObtainedNow(), &StoredRole{})
+	assert.Equal(t, time.Minute*54, lr.Secret.TTL)
+}
+
+func TestCreateV3LeaseRenewal_AppliesRoleLeaseShape(t *testing.T) {
+	role := StoredRole{
+		Keys: RoleKeys{
+			LeaseMaxTTL:         int64((time.Hour * 8).Seconds()),
+			LeaseRenewIncrement: int64((time.Minute * 30).Seconds()),
+		},
+	}
+	reqCtx := setupV3LeaseRenewalRequest(role, map[string]interface{}{})
+
+	tkn := masherytypes.AccessTokenResponse{
+		AccessToken: "access-2",
+		ExpiresIn:   3600,
+	}
+
+	lr := reqCtx.plugin.createV3LeaseRenewal(reqCtx.request.Secret, tkn.ObtainedNow(), &role)
+	assert.Equal(t, time.Minute*30, lr.Secret.TTL)
+	assert.Equal(t, time.Hour*8, lr.Secret.MaxTTL)
+}
+
+func TestCreateV3LeasedResponse_AppliesLeaseShape(t *testing.T) {
+	b := AuthPlugin{
+		Backend: &framework.Backend{
+			Secrets: []*framework.Secret{v3AccessSecret(nil)},
+		},
+		cfg: BackendConfiguration{
+			LeaseDefaultTTL: int64((time.Minute * 5).Seconds()),
+			LeaseMaxTTL:     int64((time.Hour * 2).Seconds()),
+		},
+	}
+	role := StoredRole{
+		Keys: RoleKeys{
+			LeaseDefaultTTL: int64((time.Minute * 20).Seconds()),
+		},
+	}
+
+	tkn := masherytypes.AccessTokenResponse{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresIn:    3600,
+	}
+
+	lr := b.createV3LeasedResponse(tkn.ObtainedNow(), &role)
+	// Role settings take precedence over the mount settings
+	assert.Equal(t, time.Minute*20, lr.Secret.TTL)
+	assert.Equal(t, time.Hour*2, lr.Secret.MaxTTL)
+	assert.True(t, lr.Secret.Renewable)
+
+	// Lease carrying no refresh token cannot be renewed
+	tkn.RefreshToken = ""
+	lr = b.createV3LeasedResponse(tkn.ObtainedNow(), &role)
+	assert.False(t, lr.Secret.Renewable)
+}
+
+func TestV2LeaseTTLs(t *testing.T) {
+	maxTTL, ttl := v2LeaseTTLs(LeaseShape{}, &StoredRole{})
+	assert.Equal(t, v2LeaseMaxTTL, maxTTL)
+	assert.Equal(t, v2LeaseDefaultTTL, ttl)
+
+	// V2 lease may not outlive the signature
+	maxTTL, ttl = v2LeaseTTLs(LeaseShape{DefaultTTL: time.Minute * 30, MaxTTL: time.Hour}, &StoredRole{})
+	assert.Equal(t, masheryV2SignatureLifetime, maxTTL)
+	assert.Equal(t, masheryV2SignatureLifetime, ttl)
+}
@@ -0,0 +1,166 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const inspectField = "inspect"
+
+// maskIdentifier masks all but the outer quarters of the identifier, so that the operator can recognize it
+// without it being disclosed.
+func maskIdentifier(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+
+	n := len(id) / 4
+	return id[:n] + strings.Repeat("*", len(id)-2*n) + id[len(id)-n:]
+}
+
+// readRecipientKeysForInspection reads the private key and the retired keys of the role. Unlike retrievePrivateKey
+// and retrieveRetiredRecipientKeys, it never creates, replaces, or destroys the keys.
+func readRecipientKeysForInspection(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	if found, pkBinary, err := reqCtx.ReadBinaryPath(ctx, rolePrivateKeyPath(reqCtx)); err != nil {
+		return nil, err
+	} else if !found {
+		return logical.ErrorResponse("role %s has no recipient key yet; no data could have been exported to it", role.Name), nil
+	} else {
+		role.PrivateKey = pkBinary
+	}
+
+	if err := readRecipientKeys(ctx, reqCtx); err != nil {
+		return nil, err
+	}
+	role.RecipientKeys.Prune(time.Now())
+
+	return nil, nil
+}
+
+// inspectPEMEncodedExchangeData decrypts the role data and reports what importing it would do, without storing
+// anything.
+func inspectPEMEncodedExchangeData(pemBlock *pem.Block) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		plainText, lr, err := decryptPEMEncodedExchangeData(reqCtx, pemBlock)
+		if lr != nil || err != nil {
+			return lr, err
+		}
+
+		exp, err := parseRoleDataExchange(plainText)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		current := reqCtx.heap.GetRole()
+		candidate := *current
+		candidate.Import(exp)
+
+		var problems []string
+		if len(exp.ExportID) == 0 {
+			problems = append(problems, "role data carries no export ID; ask the exporter to export it again")
+		} else {
+			ledger := StoredImportLedger{}
+			if _, err := reqCtx.ReadPath(ctx, reqCtx.plugin.importLedgerPath(), &ledger); err != nil {
+				return nil, err
+			} else if consumed, ok := ledger.Consumed[exp.ExportID]; ok {
+				problems = append(problems, fmt.Sprintf("role data of export %s was already imported into role %s on %s",
+					exp.ExportID, consumed.Role, time.Unix(consumed.Imported, 0).UTC().Format(time.RFC3339)))
+			}
+		}
+		if exp.ImportBefore > 0 && time.Now().Unix() > exp.ImportBefore {
+			problems = append(problems, fmt.Sprintf("role data had to be imported before %s", time.Unix(exp.ImportBefore, 0).UTC().Format(time.RFC3339)))
+		}
+		if err = verifyRoleDataProvenance(exp, current.Keys.ExporterFingerprint); err != nil {
+			problems = append(problems, fmt.Sprintf("role data carries invalid provenance: %s", err.Error()))
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"importable":         len(problems) == 0,
+				exportIDField:        exp.ExportID,
+				"exporter":           "unverified",
+				"explicit_term":      candidate.Usage.ExpiryTimeString(),
+				explicitNumUsesField: candidate.Usage.ExplicitNumUses,
+				"max_qps":            candidate.Keys.MaxQPS,
+				"v2_capable":         candidate.Keys.IsV2Capable(),
+				"v3_capable":         candidate.Keys.IsV3Capable(),
+				forceProxyModeField:  candidate.Keys.ForceProxyMode,
+				exportableField:      candidate.Keys.Exportable,
+				delegationDepthField: candidate.Keys.RemainingDelegationDepth(),
+				"restricted_scope":   len(exp.Scope) > 0,
+				"area_id":            maskIdentifier(candidate.Keys.AreaId),
+				"area_nid":           "",
+			},
+			Warnings: append(problems, describeImportDowngrades(current, &candidate)...),
+		}
+
+		if len(current.Keys.Exporter) > 0 {
+			resp.Data["exporter"] = current.Keys.Exporter
+		}
+		if candidate.Keys.AreaNid > 0 {
+			resp.Data["area_nid"] = maskIdentifier(strconv.Itoa(candidate.Keys.AreaNid))
+		}
+		if exp.Issued > 0 {
+			resp.Data["exported"] = time.Unix(exp.Issued, 0).UTC().Format(time.RFC3339)
+		}
+		if exp.ImportBefore > 0 {
+			resp.Data[importBeforeField] = time.Unix(exp.ImportBefore, 0).UTC().Format(time.RFC3339)
+		}
+		if len(exp.Provenance) > 0 {
+			resp.Data["provenance"] = renderProvenance(exp.Provenance)
+		}
+
+		return resp, nil
+	}
+}
+
+// describeImportDowngrades describes how importing the candidate would replace the credentials of the current role,
+// or narrow what the role can do.
+func describeImportDowngrades(current, candidate *StoredRole) []string {
+	var rv []string
+
+	if len(current.Keys.ApiKey) > 0 || len(current.Keys.Username) > 0 {
+		if current.Keys.Imported {
+			rv = append(rv, "importing replaces the credentials this role has imported earlier")
+		} else {
+			rv = append(rv, "importing replaces the credentials configured for this role, and the role cannot be updated afterwards")
+		}
+	}
+	if current.Keys.AreaId != candidate.Keys.AreaId && len(current.Keys.AreaId) > 0 && len(candidate.Keys.AreaId) > 0 {
+		rv = append(rv, "the imported data belongs to a different area")
+	}
+
+	if current.Keys.IsV2Capable() && !candidate.Keys.IsV2Capable() {
+		rv = append(rv, "the role will no longer be V2 capable")
+	}
+	if current.Keys.IsV3Capable() && !candidate.Keys.IsV3Capable() {
+		rv = append(rv, "the role will no longer be V3 capable")
+	}
+	if !current.Keys.ForceProxyMode && candidate.Keys.ForceProxyMode {
+		rv = append(rv, "the role will be limited to the proxy mode")
+	}
+	if candidate.Keys.MaxQPS > 0 && (current.Keys.MaxQPS == 0 || candidate.Keys.MaxQPS < current.Keys.MaxQPS) {
+		rv = append(rv, fmt.Sprintf("the role will be limited to %d QPS", candidate.Keys.MaxQPS))
+	}
+	if current.Keys.Exportable && !candidate.Keys.Exportable {
+		rv = append(rv, "the role will no longer be exportable")
+	}
+	if candidate.Usage.ExplicitTerm > 0 && (current.Usage.ExplicitTerm == 0 || candidate.Usage.ExplicitTerm < current.Usage.ExplicitTerm) {
+		rv = append(rv, fmt.Sprintf("the role will expire on %s", candidate.Usage.ExpiryTimeString()))
+	}
+	if candidate.Usage.HasUsageQuota() && (!current.Usage.HasUsageQuota() || candidate.Usage.RemainingNumUses < current.Usage.RemainingNumUses) {
+		rv = append(rv, fmt.Sprintf("the role will be limited to %d uses", candidate.Usage.RemainingNumUses))
+	}
+	if len(candidate.Keys.InheritedScope) > len(current.Keys.InheritedScope) {
+		rv = append(rv, "the role will be restricted to the scope set by the exporter")
+	}
+
+	return rv
+}
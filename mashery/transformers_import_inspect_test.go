@@ -0,0 +1,100 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+// setupTestImportInspection exports the role data to a recipient role holding locally configured credentials
+func setupTestImportInspection(t *testing.T, ledgerFor func(exportID string) StoredImportLedger) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleContext], *pem.Block) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	recipientRole, _, pemOut := setupTestRoleDataExportFor(key, createRoleWithFilledRoleKeys(), map[string]interface{}{
+		explicitNumUsesField: 10,
+		explicitQpsField:     5,
+		forceProxyModeField:  true,
+	})
+
+	recipientRole.Name = "recipient"
+	recipientRole.Keys = createRoleWithFilledRoleKeys().Keys
+	emulStore, reqCtx := setupRoleRequestMockHaving(recipientRole)
+	ledger := ledgerFor(pemOut.Headers[roleDataExportIDHeader])
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.importLedgerPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.importLedgerPath(), &ledger), nil)
+
+	return emulStore, reqCtx, pemOut
+}
+
+func TestInspectPEMEncodedExchangeData_ReportsWithoutImporting(t *testing.T) {
+	emulStore, reqCtx, pemOut := setupTestImportInspection(t, func(_ string) StoredImportLedger {
+		return StoredImportLedger{}
+	})
+
+	lr, err := inspectPEMEncodedExchangeData(pemOut)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+
+	assert.Equal(t, true, lr.Data["importable"])
+	assert.Equal(t, pemOut.Headers[roleDataExportIDHeader], lr.Data[exportIDField])
+	assert.Equal(t, "unverified", lr.Data["exporter"])
+	assert.Equal(t, "∞", lr.Data["explicit_term"])
+	assert.Equal(t, int64(10), lr.Data[explicitNumUsesField])
+	assert.Equal(t, 5, lr.Data["max_qps"])
+	assert.Equal(t, true, lr.Data["v2_capable"])
+	assert.Equal(t, true, lr.Data["v3_capable"])
+	assert.Equal(t, true, lr.Data[forceProxyModeField])
+	assert.Equal(t, false, lr.Data[exportableField])
+	assert.Equal(t, "a*****d", lr.Data["area_id"])
+	assert.Equal(t, "***", lr.Data["area_nid"])
+
+	assert.Equal(t, []string{
+		"importing replaces the credentials configured for this role, and the role cannot be updated afterwards",
+		"the role will be limited to the proxy mode",
+		"the role will be limited to 5 QPS",
+		"the role will no longer be exportable",
+		"the role will be limited to 10 uses",
+	}, lr.Warnings)
+
+	// The role itself is left intact
+	keys := reqCtx.heap.GetRole().Keys
+	assert.False(t, keys.Imported)
+	assert.True(t, keys.Exportable)
+	assert.Equal(t, 34, keys.MaxQPS)
+	assert.Equal(t, "", keys.ExportID)
+}
+
+func TestInspectPEMEncodedExchangeData_ReportsConsumedExport(t *testing.T) {
+	_, reqCtx, pemOut := setupTestImportInspection(t, func(exportID string) StoredImportLedger {
+		return StoredImportLedger{Consumed: map[string]ConsumedExport{
+			exportID: {Role: "other", Imported: 1},
+		}}
+	})
+	exportID := pemOut.Headers[roleDataExportIDHeader]
+
+	lr, err := inspectPEMEncodedExchangeData(pemOut)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, false, lr.Data["importable"])
+	assert.Equal(t, "role data of export "+exportID+" was already imported into role other on 1970-01-01T00:00:01Z", lr.Warnings[0])
+}
+
+func TestReadRecipientKeysForInspection_RequiresExistingKey(t *testing.T) {
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{Name: "recipient"})
+	emulStore.On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).Return(nil, nil)
+
+	lr, err := readRecipientKeysForInspection(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role recipient has no recipient key yet; no data could have been exported to it", lr.Error().Error())
+	emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestMaskIdentifier(t *testing.T) {
+	assert.Equal(t, "", maskIdentifier(""))
+	assert.Equal(t, "**", maskIdentifier("12"))
+	assert.Equal(t, "1**4", maskIdentifier("1234"))
+	assert.Equal(t, "ab12cdef-******************3abcdef56", maskIdentifier("ab12cdef-0000-1111-2222-3333abcdef56"))
+}
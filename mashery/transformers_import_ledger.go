@@ -0,0 +1,89 @@
+package mashery
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const (
+	roleDataExportIDHeader     = "Export-ID"
+	roleDataImportBeforeHeader = "Import-Before"
+
+	exportIDLength = 16
+	// Prefix of the import ledger key of the role data that carries no export ID
+	legacyExportKeyPrefix = "sha256:"
+)
+
+func (b *AuthPlugin) importLedgerPath() string {
+	return b.backendUUID + "/import-ledger"
+}
+
+// newExportID generates the unique identifier of the export.
+func newExportID() (string, error) {
+	id := make([]byte, exportIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// importLedgerKey the key of the export in the import ledger. The role data that carries no export ID, e.g. the
+// data exported before the export IDs were introduced, is keyed by the digest of its ciphertext.
+func importLedgerKey(role *StoredRole, pemBlock *pem.Block) string {
+	if len(role.Keys.ExportID) > 0 {
+		return role.Keys.ExportID
+	}
+
+	digest := sha256.Sum256(pemBlock.Bytes)
+	return legacyExportKeyPrefix + hex.EncodeToString(digest[:])
+}
+
+// blockReplayedExport refuses to import the role data whose export was already imported into this mount. The role
+// data that carries no export ID is accepted only if the mount allows the unsigned imports, as its replay can be
+// recognized only by its ciphertext.
+func blockReplayedExport[T RoleContext](pemBlock *pem.Block) TransformerFunc[T] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+		if len(role.Keys.ExportID) == 0 && !reqCtx.plugin.config().AllowUnsignedImports {
+			return logical.ErrorResponse("role data carries no export ID; ask the exporter to export it again, or allow the unsigned imports"), nil
+		}
+
+		ledger := StoredImportLedger{}
+		if _, err := reqCtx.ReadPath(ctx, reqCtx.plugin.importLedgerPath(), &ledger); err != nil {
+			return nil, err
+		}
+
+		key := importLedgerKey(role, pemBlock)
+		if consumed, ok := ledger.Consumed[key]; ok {
+			return logical.ErrorResponse("role data of export %s was already imported into role %s on %s",
+				key, consumed.Role, time.Unix(consumed.Imported, 0).UTC().Format(time.RFC3339)), nil
+		}
+
+		return nil, nil
+	}
+}
+
+// recordImportedExport records the export the role was imported from in the import ledger of this mount. The step
+// precedes saving the role, so that a failure to record the export cannot leave the export importable again.
+func recordImportedExport[T RoleContext](pemBlock *pem.Block) TransformerFunc[T] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+		path := reqCtx.plugin.importLedgerPath()
+
+		ledger := StoredImportLedger{}
+		if _, err := reqCtx.ReadPath(ctx, path, &ledger); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		ledger.Prune(now)
+		ledger.Record(importLedgerKey(role, pemBlock), role, now)
+
+		return nil, reqCtx.WritePath(ctx, path, &ledger)
+	}
+}
@@ -0,0 +1,198 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupTestLedgerExport(exportData map[string]interface{}) (StoredRole, *RequestHandlerContext[RoleContext], error) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	sourceRole, _, pemOut := setupTestRoleDataExportFor(key, createRoleWithFilledRoleKeys(), exportData)
+
+	_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+	_, err := importPEMEncodedExchangeData(pemOut)(context.TODO(), importRoleRequest)
+
+	return sourceRole, importRoleRequest, err
+}
+
+func TestExportRoleData_CarriesUniqueExportID(t *testing.T) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, _, first := setupTestRoleDataExportFor(key, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+	_, _, second := setupTestRoleDataExportFor(key, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+
+	assert.Equal(t, 2*exportIDLength, len(first.Headers[roleDataExportIDHeader]))
+	assert.NotEqual(t, first.Headers[roleDataExportIDHeader], second.Headers[roleDataExportIDHeader])
+	_, hasDeadline := first.Headers[roleDataImportBeforeHeader]
+	assert.False(t, hasDeadline)
+}
+
+func TestImportPEMBlock_CarriesExportID(t *testing.T) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	sourceRole, _, pemOut := setupTestRoleDataExportFor(key, createRoleWithFilledRoleKeys(), map[string]interface{}{
+		importBeforeField: "1h",
+	})
+
+	deadline, err := time.Parse(time.RFC3339, pemOut.Headers[roleDataImportBeforeHeader])
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), deadline.Unix(), 5)
+
+	_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+	lr, err := importPEMEncodedExchangeData(pemOut)(context.TODO(), importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	keys := importRoleRequest.heap.GetRole().Keys
+	assert.Equal(t, pemOut.Headers[roleDataExportIDHeader], keys.ExportID)
+	assert.Equal(t, deadline.Unix(), keys.ExportImportBefore)
+}
+
+func TestImportRoleDataExchange_RejectsPassedDeadline(t *testing.T) {
+	deadline := time.Now().Add(-time.Minute)
+	jsonDat, _ := json.Marshal(&RoleDataExchange{ExportID: "id", ImportBefore: deadline.Unix()})
+
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	lr, err := importRoleDataExchange(reqCtx, GZipCompress(jsonDat))
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data had to be imported before "+deadline.UTC().Format(time.RFC3339), lr.Error().Error())
+}
+
+func TestParseDesiredRoleExport_RejectsPastImportDeadline(t *testing.T) {
+	_, fullData := setupRoleRequestMockWithData(map[string]interface{}{
+		importBeforeField: "-1h",
+	}, pathRoleExportFields)
+
+	_, err := parseDesiredRoleExport(fullData.data)
+	assert.Equal(t, "import_before must lie in the future", err.Error())
+}
+
+func TestBlockReplayedExport_RejectsConsumedExport(t *testing.T) {
+	imported := time.Unix(1643144321, 0)
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{Keys: RoleKeys{ExportID: "abc"}})
+
+	ledger := StoredImportLedger{Consumed: map[string]ConsumedExport{
+		"abc": {Role: "previous", Imported: imported.Unix()},
+	}}
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.importLedgerPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.importLedgerPath(), &ledger), nil)
+
+	lr, err := blockReplayedExport[RoleContext](&pem.Block{})(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data of export abc was already imported into role previous on 2022-01-25T20:58:41Z", lr.Error().Error())
+}
+
+func TestBlockReplayedExport_AcceptsNewExport(t *testing.T) {
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{Keys: RoleKeys{ExportID: "abc"}})
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.importLedgerPath()).Return(nil, nil)
+
+	lr, err := blockReplayedExport[RoleContext](&pem.Block{})(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+}
+
+func TestBlockReplayedExport_RejectsDataWithoutExportID(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+
+	lr, err := blockReplayedExport[RoleContext](&pem.Block{Bytes: []byte("legacy")})(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "role data carries no export ID; ask the exporter to export it again, or allow the unsigned imports", lr.Error().Error())
+}
+
+func TestBlockReplayedExport_KeysLegacyDataByDigest(t *testing.T) {
+	emulStore, reqCtx := setupRoleRequestMockHaving(StoredRole{Name: "testRole"})
+	reqCtx.plugin.cfg.AllowUnsignedImports = true
+	legacy := &pem.Block{Bytes: []byte("legacy")}
+	key := importLedgerKey(reqCtx.heap.GetRole(), legacy)
+	assert.True(t, strings.HasPrefix(key, legacyExportKeyPrefix))
+
+	ledger := StoredImportLedger{Consumed: map[string]ConsumedExport{
+		key: {Role: "previous", Imported: time.Now().Unix()},
+	}}
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.importLedgerPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.importLedgerPath(), &ledger), nil)
+
+	lr, err := blockReplayedExport[RoleContext](legacy)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "role data of export "+key+" was already imported into role previous"))
+
+	// Other legacy data is accepted
+	lr, err = blockReplayedExport[RoleContext](&pem.Block{Bytes: []byte("other")})(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+func TestRecordImportedExport_PrunesExpiredEntries(t *testing.T) {
+	_, reqCtx, err := setupTestLedgerExport(map[string]interface{}{importBeforeField: "1h"})
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	role.Name = "testRole"
+
+	ledger := StoredImportLedger{Consumed: map[string]ConsumedExport{
+		"expired":   {Role: "a", Imported: 1, ImportBefore: 2},
+		"unbounded": {Role: "b", Imported: time.Now().Unix()},
+		"retained":  {Role: "c", Imported: time.Now().Unix() - importLedgerRetention - 60},
+	}}
+
+	emulStore := reqCtx.request.Storage.(*MockedVaultStorageWrapper)
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.importLedgerPath()).
+		Return(createJsonStorageEntryFrom(t, reqCtx.plugin.importLedgerPath(), &ledger), nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(reqCtx.plugin.importLedgerPath())).Return(nil)
+
+	lr, err := recordImportedExport[RoleContext](&pem.Block{})(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	stored := StoredImportLedger{}
+	for _, call := range emulStore.Calls {
+		if call.Method == "Put" {
+			assert.Nil(t, call.Arguments.Get(1).(*logical.StorageEntry).DecodeJSON(&stored))
+		}
+	}
+
+	assert.Equal(t, 2, len(stored.Consumed))
+	assert.Equal(t, "b", stored.Consumed["unbounded"].Role)
+
+	consumed := stored.Consumed[role.Keys.ExportID]
+	assert.Equal(t, "testRole", consumed.Role)
+	assert.Equal(t, role.Keys.ExportImportBefore, consumed.ImportBefore)
+}
+
+func TestStoredImportLedger_PrunesExportsPastTerm(t *testing.T) {
+	now := time.Now()
+	ledger := StoredImportLedger{}
+	ledger.Record("ended", &StoredRole{
+		Name:  "ended",
+		Keys:  RoleKeys{ExportID: "ended"},
+		Usage: StoredRoleUsage{ExplicitTerm: now.Add(-time.Minute).Unix()},
+	}, now)
+	ledger.Record("running", &StoredRole{
+		Name:  "running",
+		Keys:  RoleKeys{ExportID: "running"},
+		Usage: StoredRoleUsage{ExplicitTerm: now.Add(time.Minute).Unix()},
+	}, now)
+	ledger.Record("unbounded", &StoredRole{Name: "unbounded", Keys: RoleKeys{ExportID: "unbounded"}}, now)
+
+	ledger.Prune(now)
+
+	assert.Equal(t, 2, len(ledger.Consumed))
+	assert.Equal(t, now.Add(time.Minute).Unix(), ledger.Consumed["running"].TermExpiry)
+	_, unbounded := ledger.Consumed["unbounded"]
+	assert.True(t, unbounded)
+
+	// The unbounded entries are kept for the retention only
+	ledger.Prune(now.Add(time.Second * time.Duration(importLedgerRetention+60)))
+	_, unbounded = ledger.Consumed["unbounded"]
+	assert.False(t, unbounded)
+}
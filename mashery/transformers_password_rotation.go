@@ -0,0 +1,175 @@
+package mashery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/logical"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	passwordRotationPeriodField = "password_rotation_period"
+	// Shortest rotation period, in seconds, that can be scheduled
+	minPasswordRotationPeriod = 3600
+
+	rotatedPasswordLength = 32
+
+	passwordLowerCaseLetters = "abcdefghijkmnopqrstuvwxyz"
+	passwordUpperCaseLetters = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	passwordDigits           = "23456789"
+	passwordSymbols          = "!#%+-.:=?@_~"
+)
+
+// generateMasheryPassword generates a random password of the specified length that contains lower- and upper-case
+// letters, digits, and symbols, as the password policies of Mashery require.
+func generateMasheryPassword(length int) (string, error) {
+	classes := []string{passwordLowerCaseLetters, passwordUpperCaseLetters, passwordDigits, passwordSymbols}
+	alphabet := strings.Join(classes, "")
+
+	pwd := make([]byte, length)
+	for i := range pwd {
+		// The first characters are drawn from each class, so that each class is present
+		source := alphabet
+		if i < len(classes) {
+			source = classes[i]
+		}
+
+		if c, err := randomCharacterOf(source); err != nil {
+			return "", err
+		} else {
+			pwd[i] = c
+		}
+	}
+
+	// Shuffle, so that the position of the characters of each class is not predictable
+	for i := len(pwd) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		pwd[i], pwd[j.Int64()] = pwd[j.Int64()], pwd[i]
+	}
+
+	return string(pwd), nil
+}
+
+func randomCharacterOf(source string) (byte, error) {
+	idx, err := rand.Int(rand.Reader, big.NewInt(int64(len(source))))
+	if err != nil {
+		return 0, err
+	}
+
+	return source[idx.Int64()], nil
+}
+
+// changeMasheryUserPassword changes the password of the Mashery user of the role through the V3 API. The call is
+// authorized with the supplied access token. Only the change that fails after the password was submitted to Mashery
+// may have been applied.
+func changeMasheryUserPassword(ctx context.Context, b *AuthPlugin, role *StoredRole, accessToken string, pwd string) error {
+	client := b.GetMasheryV3Client(ctx, role)
+	callCtx := v3client.ContextWithAccessToken(ctx, accessToken)
+
+	qs := url.Values{
+		"filter": {"username:" + role.Keys.Username},
+		"fields": {"id,username"},
+	}
+	resp, err := client.FetchAny(callCtx, "/members", &qs)
+	if err != nil {
+		return rejectedChange("%s", err.Error())
+	} else if resp.StatusCode != 200 {
+		return rejectedChange("mashery responded with status %d while looking up user %s", resp.StatusCode, role.Keys.Username)
+	}
+
+	var members []struct {
+		Id       string `json:"id"`
+		Username string `json:"username"`
+	}
+	if body, err := resp.Body(); err != nil {
+		return rejectedChange("%s", err.Error())
+	} else if err = json.Unmarshal(body, &members); err != nil {
+		return rejectedChange("mashery response cannot be parsed (%s)", err.Error())
+	}
+
+	memberId := ""
+	for _, m := range members {
+		if m.Username == role.Keys.Username {
+			memberId = m.Id
+		}
+	}
+	if len(memberId) == 0 {
+		return rejectedChange("user %s is not found in Mashery", role.Keys.Username)
+	}
+
+	resp, err = client.PutAny(callCtx, "/members/"+memberId, map[string]interface{}{
+		"passwdNew": pwd,
+	})
+	if err != nil {
+		return err
+	} else if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+		return rejectedChange("mashery responded with status %d while changing the password of user %s", resp.StatusCode, role.Keys.Username)
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("mashery responded with status %d while changing the password of user %s", resp.StatusCode, role.Keys.Username)
+	}
+
+	return nil
+}
+
+// passwordRotation rotates the password of the Mashery user of the role
+var passwordRotation = credentialRotation{
+	credential: "password",
+	generate: func() (string, error) {
+		return generateMasheryPassword(rotatedPasswordLength)
+	},
+	current: func(keys *RoleKeys) string {
+		return keys.Password
+	},
+	stage: func(pwd string) *PendingRoleKeys {
+		return &PendingRoleKeys{Password: pwd}
+	},
+	change: changeMasheryUserPassword,
+	verify: func(_ context.Context, b *AuthPlugin, candidate *StoredRole) error {
+		creds := candidate.asV3Credentials()
+		_, err := b.GetOAuthHelper(candidate).RetrieveAccessTokenFor(&creds)
+		return err
+	},
+	// The password is exported only to the recipients that may use the V3 API
+	exported: func(rec *RoleExportRecord) bool {
+		return rec.V3Capable
+	},
+}
+
+// rotateScheduledRolePasswords rotates the passwords of the roles whose rotation period has passed.
+func (b *AuthPlugin) rotateScheduledRolePasswords(ctx context.Context, req *logical.Request) error {
+	return b.rotateScheduledRoleCredential(ctx, req, rotationSchedule{
+		credential: passwordRotation.credential,
+		path:       "/rotate-password",
+		due:        (*RoleKeys).PasswordRotationDue,
+		failed: func(keys *RoleKeys, t time.Time) {
+			keys.PasswordRotationFailed = t.Unix()
+		},
+		rotate: b.rotateRolePassword,
+		schema: pathRolePasswordRotateFields,
+	})
+}
+
+func renderRotatedPassword(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"password_changed": time.Unix(role.Keys.PasswordChanged, 0).UTC().Format(time.RFC3339),
+		},
+	}
+	if role.Keys.PasswordRotationPeriod > 0 {
+		next := time.Unix(role.Keys.PasswordChanged+role.Keys.PasswordRotationPeriod, 0)
+		resp.Data["next_rotation"] = next.UTC().Format(time.RFC3339)
+	}
+
+	return resp, nil
+}
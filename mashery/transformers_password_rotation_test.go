@@ -0,0 +1,334 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// testMasheryUser emulates the Mashery user whose password is rotated
+type testMasheryUser struct {
+	password string
+	// Whether Mashery accepts a new password, but does not grant tokens for it
+	rejectNewPasswords bool
+	// Status Mashery responds to the password change with, if not 200. The change is applied on the server errors.
+	changeStatus int
+	// Passwords replaced so far, and the access tokens granted
+	changes []string
+	tokens  map[string]bool
+}
+
+func (u *testMasheryUser) startServer() *httptest.Server {
+	u.tokens = map[string]bool{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/token":
+			_ = r.ParseForm()
+			if r.Form.Get("password") != u.password || (u.rejectNewPasswords && len(u.changes) > 0) {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			tkn := "access-" + strconv.Itoa(len(u.tokens))
+			u.tokens[tkn] = true
+			_, _ = w.Write([]byte(`{"access_token":"` + tkn + `","expires_in":3600}`))
+		case !u.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]:
+			w.WriteHeader(403)
+		case r.Method == "GET" && r.URL.Path == "/members":
+			if r.URL.Query().Get("filter") != "username:user" {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":"member-1","username":"user"}]`))
+		case r.Method == "PUT" && r.URL.Path == "/members/member-1":
+			if u.changeStatus >= 400 && u.changeStatus < 500 {
+				w.WriteHeader(u.changeStatus)
+				return
+			}
+			body := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			u.changes = append(u.changes, u.password)
+			u.password = body["passwdNew"]
+			if u.password == u.changes[0] {
+				// Reverted
+				u.changes = nil
+			}
+			if u.changeStatus > 0 {
+				w.WriteHeader(u.changeStatus)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"member-1","username":"user"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func setupPasswordRotationTest(t *testing.T, user *testMasheryUser) (*httptest.Server, *AuthPlugin, logical.Storage) {
+	srv := user.startServer()
+
+	keys := testPendingActiveKeys()
+	keys.AreaNid = 0
+	keys.Password = user.password
+	keys.OAuthTokenEndpoint = srv.URL + "/token"
+	keys.V3Endpoint = srv.URL
+
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{V3Token: "old-token"})
+	return srv, b, storage
+}
+
+func TestGenerateMasheryPassword(t *testing.T) {
+	pwd, err := generateMasheryPassword(rotatedPasswordLength)
+	assert.Nil(t, err)
+	assert.Equal(t, rotatedPasswordLength, len(pwd))
+
+	assert.True(t, strings.IndexFunc(pwd, unicode.IsLower) >= 0)
+	assert.True(t, strings.IndexFunc(pwd, unicode.IsUpper) >= 0)
+	assert.True(t, strings.IndexFunc(pwd, unicode.IsDigit) >= 0)
+	assert.True(t, strings.ContainsAny(pwd, passwordSymbols))
+
+	other, _ := generateMasheryPassword(rotatedPasswordLength)
+	assert.NotEqual(t, pwd, other)
+}
+
+func TestRotateRolePassword_ChangesAndStoresPassword(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.NotNil(t, lr.Data["password_changed"])
+	assert.Equal(t, []string{"old-pwd"}, user.changes)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, user.password, active.Password)
+	assert.NotEqual(t, "old-pwd", active.Password)
+	assert.True(t, active.PasswordChanged > 0)
+
+	usage := StoredRoleUsage{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+	assert.Equal(t, "", usage.V3Token)
+
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRolePassword_RevertsPasswordNotAccepted(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd", rejectNewPasswords: true}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "the new password of role testRole is not accepted by Mashery, and the password was reverted"))
+	assert.Equal(t, "old-pwd", user.password)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-pwd", active.Password)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRolePassword_KeepsPasswordWhenChangeFails(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.Username = "unknown"
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "password of role testRole was not changed: user unknown is not found in Mashery", lr.Error().Error())
+	assert.Equal(t, 0, len(user.changes))
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRolePassword_KeepsPendingKeysWhenChangeMayHaveApplied(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd", changeStatus: 504}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "password of role testRole may have been changed by Mashery (mashery responded with status 504 while changing the password of user user); the new password is kept as the pending keys of the role: promote these if Mashery accepts the new password, or abort these otherwise", lr.Error().Error())
+	assert.Equal(t, []string{"old-pwd"}, user.changes)
+
+	// The password Mashery has applied is not lost
+	pending := PendingRoleKeys{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &pending))
+	assert.Equal(t, user.password, pending.Password)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-pwd", active.Password)
+}
+
+func TestRotateRolePassword_DiscardsPendingKeysWhenChangeIsRejected(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd", changeStatus: 400}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "password of role testRole was not changed: mashery responded with status 400 while changing the password of user user", lr.Error().Error())
+	assert.Equal(t, "old-pwd", user.password)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRolePassword_RefusesToReplacePendingKeys(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{Password: "staged"})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "role testRole has pending keys; promote or abort these first", lr.Error().Error())
+	assert.Equal(t, 0, len(user.changes))
+}
+
+func putTestRoleExports(t *testing.T, storage logical.Storage) {
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleExportsPathSuffix, &StoredRoleExports{
+		Exports: map[string]RoleExportRecord{
+			"active-v3": {Exported: 1, Recipient: "team-a", V3Capable: true},
+			"active-v2": {Exported: 2, Recipient: "team-b", V2Capable: true},
+			"revoked":   {Exported: 3, Recipient: "team-c", V3Capable: true, Revoked: 4},
+			"expired":   {Exported: 4, Recipient: "team-d", V3Capable: true, TermExpiry: time.Now().Add(-time.Minute).Unix()},
+		},
+	})
+}
+
+func TestRotateRolePassword_RefusesToRotateExportedPassword(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	putTestRoleExports(t, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "exports active-v3 of role testRole carry its password, and cannot be used once the password is rotated; set force to rotate it anyway", lr.Error().Error())
+	assert.Equal(t, 0, len(user.changes))
+}
+
+func TestRotateRolePassword_ForcedRotationWarnsOfExports(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	putTestRoleExports(t, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{forceRotationField: true}, pathRolePasswordRotateFields)
+
+	lr, err := b.rotateRolePassword(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{"old-pwd"}, user.changes)
+	assert.Equal(t, []string{"active-v3"}, lr.Data["affected_exports"])
+	assert.Equal(t, []string{"exports active-v3 carry the previous password, and cannot be used anymore"}, lr.Warnings)
+}
+
+func TestRotateScheduledRolePasswords_RotatesDuePasswords(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.PasswordRotationPeriod = minPasswordRotationPeriod
+	keys.PasswordChanged = time.Now().Unix() - minPasswordRotationPeriod + 60
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req := &logical.Request{Storage: storage}
+
+	// The period has not passed yet
+	assert.Nil(t, b.rotateScheduledRolePasswords(context.TODO(), req))
+	assert.Equal(t, 0, len(user.changes))
+
+	keys.PasswordChanged = time.Now().Unix() - minPasswordRotationPeriod
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	assert.Nil(t, b.rotateScheduledRolePasswords(context.TODO(), req))
+	assert.Equal(t, []string{"old-pwd"}, user.changes)
+
+	rotated := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &rotated)
+	assert.Equal(t, user.password, rotated.Password)
+	assert.False(t, rotated.PasswordRotationDue(time.Now()))
+}
+
+func TestUpdateRoleKeysFromRequest_PasswordRotationPeriod(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{
+		passwordRotationPeriodField: "10m",
+	}, pathRoleFields)
+	reqCtx.heap.CarryRole(&StoredRole{})
+
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "password_rotation_period cannot be shorter than 1h0m0s", lr.Error().Error())
+
+	reqCtx.data.Raw[passwordRotationPeriodField] = "720h"
+	lr, err = updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+	assert.Equal(t, int64(720*3600), reqCtx.heap.GetRole().Keys.PasswordRotationPeriod)
+	assert.True(t, reqCtx.heap.GetRole().Keys.PasswordChanged > 0)
+	assert.False(t, reqCtx.heap.GetRole().Keys.PasswordRotationDue(time.Now()))
+}
+
+func TestRotateScheduledRolePasswords_DoesNotRotateExportedPassword(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	putTestRoleExports(t, storage)
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.PasswordRotationPeriod = minPasswordRotationPeriod
+	keys.PasswordChanged = 1
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	assert.Nil(t, b.rotateScheduledRolePasswords(context.TODO(), &logical.Request{Storage: storage}))
+	assert.Equal(t, 0, len(user.changes))
+
+	// The refused rotation is retried once the retry delay has passed
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	assert.True(t, keys.PasswordRotationFailed > 0)
+	assert.False(t, keys.PasswordRotationDue(time.Now()))
+}
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
+	"crypto"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -22,37 +22,35 @@ import (
 	"time"
 )
 
+// retrievePrivateKey reads the recipient private key of the role. The key is generated if the role has no key
+// yet, or if the key type set for the role differs from the type of the stored key; the replaced key is retired
+// for the default grace period. The key type set for the mount applies only to the roles that have no key yet.
 func retrievePrivateKey[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	keyType := role.Keys.EffectiveRecipientKeyType(reqCtx.plugin.config())
 
 	if found, pkBinary, err := reqCtx.ReadBinaryPath(ctx, rolePrivateKeyPath(reqCtx)); err != nil {
 		return nil, err
 	} else if found {
-		reqCtx.heap.GetRole().PrivateKey = pkBinary
-	} else if !found {
-		if privateKey, err := rsa.GenerateKey(rand.Reader, 4096); err != nil {
-			return nil, err
-		} else {
-			role := reqCtx.heap.GetRole()
-			role.PrivateKey = x509.MarshalPKCS1PrivateKey(privateKey)
-			if err = reqCtx.WriteBinaryPath(ctx, rolePrivateKeyPath(reqCtx), role.PrivateKey); err != nil {
-				return nil, err
-			}
+		role.PrivateKey = pkBinary
+		if len(role.Keys.RecipientKeyType) == 0 {
+			return nil, nil
+		} else if pk, err := parseRecipientKey(pkBinary); err != nil || recipientKeyTypeOf(pk) == keyType {
+			return nil, nil
 		}
+
+		reqCtx.plugin.Logger().Info(fmt.Sprintf("replacing recipient key of role %s with a key of type %s", role.Name, keyType))
 	}
 
-	return nil, nil
+	return nil, replaceRecipientKey(ctx, reqCtx, keyType, defaultRecipientKeyGracePeriod)
 }
 
-func getPrivateKey(storedRole *StoredRole) (*rsa.PrivateKey, error) {
+func getPrivateKey(storedRole *StoredRole) (crypto.PrivateKey, error) {
 	if storedRole.PrivateKey == nil {
 		return nil, errors.New("private key is not initialized for this role")
 	}
 
-	if key, err := x509.ParsePKCS1PrivateKey(storedRole.PrivateKey); err == nil {
-		return key, nil
-	}
-
-	return nil, errors.New("private key data structure is not understood")
+	return parseRecipientKey(storedRole.PrivateKey)
 }
 
 func renderRoleCertificate(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
@@ -82,12 +80,13 @@ func createRoleCertificateTemplate(cn string, certFrom time.Time, certTo time.Ti
 	return template
 }
 
-func createSelfSignedCertificatePEMBlock(template *x509.Certificate, pk *rsa.PrivateKey, headerName string) (string, error) {
-	if derBytes, err := x509.CreateCertificate(rand.Reader, template, template, &pk.PublicKey, pk); err == nil {
+func createSelfSignedCertificatePEMBlock(template *x509.Certificate, pk crypto.PrivateKey, headerName string) (string, error) {
+	if derBytes, err := createRecipientCertificate(template, pk); err == nil {
 
 		headers := map[string]string{
 			"NotAfter":        template.NotAfter.String(),
 			"Common-Name":     template.Subject.CommonName,
+			"Key-Type":        recipientKeyTypeOf(pk),
 			roleNamePEMHeader: headerName,
 		}
 
@@ -129,40 +128,69 @@ func renderRoleCertificateWithTemplate(_ context.Context, _ *RequestHandlerConte
 			},
 		}
 
+		if err = renderRecipientKeyInfo(role, resp); err != nil {
+			return nil, err
+		}
 		return resp, nil
 	}
 }
 
 func readRecipientCertificate(_ context.Context, reqCtx *RequestHandlerContext[RoleExportContext]) (*logical.Response, error) {
-	rawPEM := reqCtx.data.Get(pemContainerField).(string)
+	explicitRole := ""
+	if v, ok := reqCtx.data.GetOk(recipientRoleField); ok {
+		explicitRole = v.(string)
+	}
+
+	cert, recipientRole, err := parseRecipientCertificate(reqCtx.data.Get(pemContainerField).(string), explicitRole)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	reqCtx.heap.CarryRecipientName(recipientRole)
+	reqCtx.heap.CarryRecipientCertificate(cert)
+	return nil, nil
+}
+
+// parseRecipientCertificate parses the PEM-encoded certificate of the recipient, returning it together with the
+// role the data is exported to.
+func parseRecipientCertificate(rawPEM string, explicitRole string) (*x509.Certificate, string, error) {
 	if len(rawPEM) == 0 {
-		return logical.ErrorResponse("no PEM-encoded data received"), nil
+		return nil, "", errors.New("no PEM-encoded data received")
 	}
 	blk, _ := pem.Decode([]byte(rawPEM))
 	if blk == nil {
-		return logical.ErrorResponse("supplied PEM data bears no PEM block"), nil
+		return nil, "", errors.New("supplied PEM data bears no PEM block")
 	} else if blk.Type != masheryRoleRecipientPEMBlockName {
-		return logical.ErrorResponse("input does not contain credentials recipient block"), nil
+		return nil, "", errors.New("input does not contain credentials recipient block")
 	}
 
-	var recipientRole = "---not specified---"
+	var recipientRole = unspecifiedRecipientRole
 	if len(blk.Headers[roleNamePEMHeader]) > 0 {
 		recipientRole = blk.Headers[roleNamePEMHeader]
 	}
-	reqCtx.heap.CarryRecipientName(recipientRole)
+
+	// The certificates of the recipient identities don't name the role the data is imported into
+	if len(explicitRole) > 0 {
+		if !validRoleName.MatchString(explicitRole) {
+			return nil, "", errors.New(fmt.Sprintf("%s is not a valid role name", recipientRoleField))
+		} else if recipientRole != unspecifiedRecipientRole && recipientRole != explicitRole {
+			return nil, "", errors.New(fmt.Sprintf("supplied certificate is issued for role %s", recipientRole))
+		}
+		recipientRole = explicitRole
+	}
 
 	now := time.Now()
-	if cert, err := x509.ParseCertificate(blk.Bytes); err != nil {
-		return logical.ErrorResponse("received unparseable certificate: %s", err.Error()), nil
+	if cert, err := parseRecipientCertificateDER(blk.Bytes); err != nil {
+		return nil, "", errors.New(fmt.Sprintf("received unparseable certificate: %s", err.Error()))
 	} else if now.Before(cert.NotBefore) {
-		return logical.ErrorResponse("supplied certificate is not yet valid"), nil
+		return nil, "", errors.New("supplied certificate is not yet valid")
 	} else if now.After(cert.NotAfter) {
-		return logical.ErrorResponse("supplied certificate has already expired"), nil
+		return nil, "", errors.New("supplied certificate has already expired")
+	} else if !isSupportedRecipientPublicKey(cert.PublicKey) {
+		return nil, "", errors.New(fmt.Sprintf("supplied certificate carries an unsupported %T key", cert.PublicKey))
 	} else {
-		reqCtx.heap.CarryRecipientCertificate(cert)
+		return cert, recipientRole, nil
 	}
-
-	return nil, nil
 }
 
 type DesiredRoleExport struct {
@@ -173,10 +201,13 @@ type DesiredRoleExport struct {
 	desiredOnlyV2         bool
 	desiredOnlyV3         bool
 	desireExportable      bool
+	desiredScope          RoleScope
+	desiredImportWindow   time.Duration
+	desiredDelegation     int
 }
 
 func parseDesiredRoleExport(d *framework.FieldData) (DesiredRoleExport, error) {
-	rv := DesiredRoleExport{
+	return parseDesiredRoleExportOver(DesiredRoleExport{
 		0,
 		-1,
 		-1,
@@ -184,8 +215,14 @@ func parseDesiredRoleExport(d *framework.FieldData) (DesiredRoleExport, error) {
 		false,
 		false,
 		false,
-	}
+		RoleScope{},
+		0,
+		0,
+	}, d)
+}
 
+// parseDesiredRoleExportOver overrides the export settings with the settings supplied in the field data.
+func parseDesiredRoleExportOver(rv DesiredRoleExport, d *framework.FieldData) (DesiredRoleExport, error) {
 	if v, ok := d.GetOk(explicitNumUsesField); ok {
 		rv.desiredNumUses = v.(int)
 	}
@@ -201,8 +238,32 @@ func parseDesiredRoleExport(d *framework.FieldData) (DesiredRoleExport, error) {
 	if v, ok := d.GetOk(forceProxyModeField); ok {
 		rv.desiredForceProxyMode = v.(bool)
 	}
-	if v, ok := d.GetOk(exportableField); ok {
-		rv.desireExportable = v.(bool)
+	exportable, exportableSet := d.GetOk(exportableField)
+	if exportableSet {
+		rv.desireExportable = exportable.(bool)
+		if !rv.desireExportable {
+			rv.desiredDelegation = 0
+		}
+	}
+	if v, ok := d.GetOk(delegationDepthField); ok {
+		if v.(int) < 0 {
+			return rv, errors.New(fmt.Sprintf("%s cannot be negative", delegationDepthField))
+		} else if exportableSet && exportable.(bool) != (v.(int) > 0) {
+			return rv, errors.New(fmt.Sprintf("%s and %s contradict each other", exportableField, delegationDepthField))
+		}
+		rv.desiredDelegation = v.(int)
+		rv.desireExportable = rv.desiredDelegation > 0
+	}
+	// The exportable data that does not specify the depth may be re-exported once
+	if rv.desireExportable && rv.desiredDelegation == 0 {
+		rv.desiredDelegation = 1
+	}
+
+	if err := copyScopeFieldIfDefined(d, roleScopeAllowField, &rv.desiredScope.Allow); err != nil {
+		return rv, err
+	}
+	if err := copyScopeFieldIfDefined(d, roleScopeDenyField, &rv.desiredScope.Deny); err != nil {
+		return rv, err
 	}
 
 	if v, ok := d.GetOk(explicitTermField); ok {
@@ -214,9 +275,57 @@ func parseDesiredRoleExport(d *framework.FieldData) (DesiredRoleExport, error) {
 		}
 	}
 
+	if v, ok := d.GetOk(importBeforeField); ok {
+		if dur, err := ParseUserInputDuration(v.(string)); err != nil {
+			return rv, err
+		} else if dur <= 0 {
+			return rv, errors.New(fmt.Sprintf("%s must lie in the future", importBeforeField))
+		} else {
+			rv.desiredImportWindow = dur
+		}
+	}
+
 	return rv, nil
 }
 
+// narrowDesiredRoleExport narrows the export settings of an imported role to the limits this role was imported
+// with: the re-exported data cannot be used longer, more often, faster, or without the proxy mode, and it cannot be
+// delegated deeper than the role itself may delegate.
+func narrowDesiredRoleExport(role *StoredRole, settings DesiredRoleExport) (DesiredRoleExport, error) {
+	if !role.Keys.Imported {
+		return settings, nil
+	}
+
+	if allowed := role.Keys.RemainingDelegationDepth() - 1; settings.desiredDelegation > allowed {
+		if allowed <= 0 {
+			return settings, errors.New("the recipient of this role cannot be allowed to re-export it further")
+		}
+		return settings, errors.New(fmt.Sprintf("%s cannot exceed %d for this role", delegationDepthField, allowed))
+	}
+
+	if role.Usage.ExplicitTerm > 0 {
+		remaining := time.Until(time.Unix(role.Usage.ExplicitTerm, 0))
+		if settings.desiredTerm == 0 || settings.desiredTerm > remaining {
+			settings.desiredTerm = remaining
+		}
+	}
+	if role.Usage.HasUsageQuota() {
+		if role.Usage.RemainingNumUses <= 0 {
+			return settings, errors.New("this role has depleted its usage quota")
+		} else if settings.desiredNumUses <= 0 || int64(settings.desiredNumUses) > role.Usage.RemainingNumUses {
+			settings.desiredNumUses = int(role.Usage.RemainingNumUses)
+		}
+	}
+	if role.Keys.MaxQPS > 0 && settings.desiredQps > role.Keys.MaxQPS {
+		settings.desiredQps = role.Keys.MaxQPS
+	}
+	if role.Keys.ForceProxyMode {
+		settings.desiredForceProxyMode = true
+	}
+
+	return settings, nil
+}
+
 func GZipDecompress(input []byte) ([]byte, error) {
 	reader := bytes.NewReader(input)
 	gzreader, _ := gzip.NewReader(reader)
@@ -242,18 +351,54 @@ func createRoleDataExchangePEMBlock(dat []byte, headers map[string]string) strin
 }
 
 func renderEncryptedRoleData(_ context.Context, reqCtx *RequestHandlerContext[RoleExportContext]) (*logical.Response, error) {
-
-	role := reqCtx.heap.GetRole()
-
 	// Perform validation fo the parameters
 	settings, err := parseDesiredRoleExport(reqCtx.data)
+	if err == nil {
+		settings, err = narrowDesiredRoleExport(reqCtx.heap.GetRole(), settings)
+	}
 	if err != nil {
-		return logical.ErrorResponse("invalid export configuration: %f", err), nil
+		return logical.ErrorResponse("invalid export configuration: %s", err), nil
+	}
+
+	pemOut, exportID, err := exportRoleDataToRecipient(reqCtx, reqCtx.heap.GetRecipientCertificate(), reqCtx.heap.GetRecipientName(), settings)
+	if err != nil {
+		return nil, err
 	}
 
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			pemContainerField: pemOut,
+			exportIDField:     exportID,
+		},
+	}
+
+	if settings.desiredTerm < 0 {
+		resp.Warnings = []string{"explicit term is in the past"}
+	}
+
+	return resp, nil
+}
+
+// exportRoleDataToRecipient encrypts the role data for the recipient certificate, returning the signed PEM block and
+// the ID of the export.
+func exportRoleDataToRecipient(reqCtx *RequestHandlerContext[RoleExportContext], cert *x509.Certificate, recipientRole string, settings DesiredRoleExport) (string, string, error) {
+	role := reqCtx.heap.GetRole()
+
 	exp := role.CreateRoleDataExchange(settings.desiredTerm)
 	exp.RoleData.ForceProxyMode = settings.desiredForceProxyMode
+
+	// The scope requested for this export narrows the scope of the exported role; it cannot widen it.
+	if !settings.desiredScope.IsEmpty() {
+		exp.Scope = append(exp.Scope, settings.desiredScope)
+	}
+
+	// The recipient needs to talk to the same Mashery tenant as the exporter does
+	cfg := reqCtx.plugin.config()
+	exp.RoleData.V3Endpoint = role.Keys.EffectiveV3Endpoint(cfg)
+	exp.RoleData.OAuthTokenEndpoint = role.Keys.EffectiveOAuthTokenEndpoint(cfg)
+	exp.RoleData.V2Endpoint = role.Keys.EffectiveV2Endpoint(cfg)
 	exp.RoleData.Exportable = settings.desireExportable
+	exp.DelegationDepth = settings.desiredDelegation
 
 	if settings.desiredNumUses > 0 {
 		exp.UsageTerm.ExplicitNumUses = int64(settings.desiredNumUses)
@@ -272,11 +417,53 @@ func renderEncryptedRoleData(_ context.Context, reqCtx *RequestHandlerContext[Ro
 		exp.RoleData.AreaNid = 0
 	}
 
+	exp.OriginRole = role.Name
+	if recipientRole != unspecifiedRecipientRole {
+		exp.RecipientRole = recipientRole
+	}
+
+	// The importers record the export ID, so that the same data cannot be imported twice
+	exportID, err := newExportID()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	exp.ExportID = exportID
+	exp.Issued = now.Unix()
+	if settings.desiredImportWindow > 0 {
+		exp.ImportBefore = now.Add(settings.desiredImportWindow).Unix()
+	}
+
+	// The recipient learns the chain of exports the data was delegated through, this export last
+	exporterFingerprint, err := publicKeyFingerprint(&reqCtx.heap.GetExporterIdentity().PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	exp.Provenance = append(append([]ProvenanceLink{}, role.Keys.Provenance...), ProvenanceLink{
+		Exporter:        exporterFingerprint,
+		ExportID:        exportID,
+		Exported:        exp.Issued,
+		DelegationDepth: exp.DelegationDepth,
+	})
+
 	jsonDat, _ := json.Marshal(&exp)
 
-	dat, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, reqCtx.heap.GetRecipientCertificate().PublicKey.(*rsa.PublicKey), GZipCompress(jsonDat), reqCtx.plugin.cfg.OAEPLabel)
+	format, dat, err := sealRoleDataForRecipient(cert.PublicKey, GZipCompress(jsonDat), cfg.OAEPLabel)
 	if err != nil {
-		return nil, err
+		return "", "", err
+	}
+
+	// The encrypted data is signed, so that the recipient can verify that it was exported by a trusted exporter
+	fingerprint, signature, err := signRoleData(reqCtx.heap.GetExporterIdentity(), format, dat)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Identifies the recipient's block in a bundle of several recipients
+	recipientKey, err := recipientPublicKeyID(cert.PublicKey)
+	if err != nil {
+		return "", "", err
 	}
 
 	var grantedNumUses = "∞"
@@ -288,71 +475,170 @@ func renderEncryptedRoleData(_ context.Context, reqCtx *RequestHandlerContext[Ro
 		grantedTerm = time.Duration(exp.UsageTerm.ExplicitTerm).String()
 	}
 
-	pemOut := createRoleDataExchangePEMBlock(dat, map[string]string{
-		"Date":              time.Now().String(),
-		"Term":              grantedTerm,
-		"Uses":              grantedNumUses,
-		"Recipient":         reqCtx.heap.GetRecipientCertificate().Subject.String(),
-		"Recipient Role":    reqCtx.heap.GetRecipientName(),
-		"Origin Role":       role.Name,
-		"V2 Capable":        strconv.FormatBool(exp.RoleData.IsV2Capable()),
-		"V3 Capable":        strconv.FormatBool(exp.RoleData.IsV3Capable()),
-		"Max QPS":           strconv.Itoa(exp.RoleData.MaxQPS),
-		"Forced Proxy Mode": strconv.FormatBool(exp.RoleData.ForceProxyMode),
+	headers := map[string]string{
+		roleDataFormatHeader:       format,
+		roleDataExporterHeader:     fingerprint,
+		roleDataSignatureHeader:    signature,
+		roleDataRecipientKeyHeader: recipientKey,
+		roleDataExportIDHeader:     exportID,
+		"Date":                     now.String(),
+		"Term":                     grantedTerm,
+		"Uses":                     grantedNumUses,
+		"Recipient":                cert.Subject.String(),
+		recipientRolePEMHeader:     recipientRole,
+		originRolePEMHeader:        role.Name,
+		"V2 Capable":               strconv.FormatBool(exp.RoleData.IsV2Capable()),
+		"V3 Capable":               strconv.FormatBool(exp.RoleData.IsV3Capable()),
+		"Max QPS":                  strconv.Itoa(exp.RoleData.MaxQPS),
+		"Forced Proxy Mode":        strconv.FormatBool(exp.RoleData.ForceProxyMode),
+		"Restricted Scope":         strconv.FormatBool(len(exp.Scope) > 0),
+		"Delegation Depth":         strconv.Itoa(exp.DelegationDepth),
+		"Provenance Depth":         strconv.Itoa(len(exp.Provenance)),
+	}
+	if exp.ImportBefore > 0 {
+		headers[roleDataImportBeforeHeader] = time.Unix(exp.ImportBefore, 0).UTC().Format(time.RFC3339)
+	}
+
+	certFingerprint := sha256.Sum256(cert.Raw)
+	reqCtx.heap.CarryExportRecord(exportID, RoleExportRecord{
+		Exported:             now.Unix(),
+		Recipient:            cert.Subject.CommonName,
+		RecipientFingerprint: hex.EncodeToString(certFingerprint[:]),
+		RecipientRole:        recipientRole,
+		TermExpiry:           exp.UsageTerm.ExplicitTerm,
+		NumUses:              exp.UsageTerm.ExplicitNumUses,
+		MaxQPS:               exp.RoleData.MaxQPS,
+		ImportBefore:         exp.ImportBefore,
+		V2Capable:            exp.RoleData.IsV2Capable(),
+		V3Capable:            exp.RoleData.IsV3Capable(),
+		ForceProxyMode:       exp.RoleData.ForceProxyMode,
+		Exportable:           exp.RoleData.Exportable,
+		DelegationDepth:      exp.DelegationDepth,
+		RestrictedScope:      len(exp.Scope) > 0,
+		EntityID:             reqCtx.request.EntityID,
+		RequestedBy:          reqCtx.request.DisplayName,
 	})
 
-	resp := &logical.Response{
-		Data: map[string]interface{}{
-			pemContainerField: pemOut,
-		},
-	}
-
-	if settings.desiredTerm < 0 {
-		resp.Warnings = []string{"explicit term is in the past"}
-	}
-
-	return resp, nil
+	return createRoleDataExchangePEMBlock(dat, headers), exportID, nil
 }
 
-func retrieveImportPEMBlockFromRequest(d *framework.FieldData) (*pem.Block, error) {
+// retrieveImportPEMBundleFromRequest retrieves the role data blocks from the request. The data exported to several
+// recipients at once is a bundle of blocks, one for each recipient.
+func retrieveImportPEMBundleFromRequest(d *framework.FieldData) ([]*pem.Block, error) {
 	pemStr := d.Get(pemContainerField).(string)
 	if len(pemStr) == 0 {
 		return nil, errors.New("empty PEM data received")
 	}
-	pemBlock, _ := pem.Decode([]byte(pemStr))
-	if pemBlock == nil {
-		return nil, errors.New(fmt.Sprintf("submitted data does not contain a valid PEM block"))
-	}
-	if pemBlock.Type != masheryRoleDataPEMBlockName {
-		return nil, errors.New("incorrect PEM block")
+
+	var rv []*pem.Block
+	rest := []byte(pemStr)
+	for {
+		pemBlock, remainder := pem.Decode(rest)
+		if pemBlock == nil {
+			break
+		} else if pemBlock.Type != masheryRoleDataPEMBlockName {
+			return nil, errors.New("incorrect PEM block")
+		}
+
+		rv = append(rv, pemBlock)
+		rest = remainder
 	}
 
-	return pemBlock, nil
+	if len(rv) == 0 {
+		return nil, errors.New(fmt.Sprintf("submitted data does not contain a valid PEM block"))
+	}
+	return rv, nil
 }
 
 func importPEMEncodedExchangeData(pemBlock *pem.Block) func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
 	return func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
-		pk, err := getPrivateKey(reqCtx.heap.GetRole())
-		if err != nil {
-			return nil, err
+		plainText, lr, err := decryptPEMEncodedExchangeData(reqCtx, pemBlock)
+		if lr != nil || err != nil {
+			return lr, err
 		}
 
-		plainText, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, pk, pemBlock.Bytes, reqCtx.plugin.cfg.OAEPLabel)
-		if err != nil {
-			return logical.ErrorResponse("was unable to decrypt the Mashery role data (%s)", err.Error()), nil
-		}
+		return importRoleDataExchange(reqCtx, plainText)
+	}
+}
 
-		jsonTxt, err := GZipDecompress(plainText)
-		if err != nil {
-			return logical.ErrorResponse("was unable to GZipDecompress Mashery role data (%s)", err.Error()), nil
+// decryptPEMEncodedExchangeData decrypts the role data with the private key of the role, or with one of its
+// retired keys.
+func decryptPEMEncodedExchangeData(reqCtx *RequestHandlerContext[RoleContext], pemBlock *pem.Block) ([]byte, *logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	pk, err := getPrivateKey(role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	format := pemBlock.Headers[roleDataFormatHeader]
+	label := reqCtx.plugin.config().OAEPLabel
+	plainText, err := decryptRoleData(pk, format, pemBlock.Bytes, label)
+	if err != nil {
+		// The data may have been exported to the certificate of a recently rotated key.
+		if plainText = decryptRoleDataWithRetiredKeys(role, format, pemBlock.Bytes, label); plainText == nil {
+			return nil, logical.ErrorResponse("was unable to decrypt the Mashery role data (%s)", err.Error()), nil
 		}
+	}
+
+	return plainText, nil, nil
+}
+
+// verifyRoleDataProvenance verifies that the provenance chain ends with the export the data was received from,
+// signed by the exporter whose signature was verified, and that each export in the chain allowed fewer re-exports
+// than the export before it. The signer is empty if the unsigned role data is imported.
+func verifyRoleDataProvenance(exp RoleDataExchange, signer string) error {
+	if len(exp.Provenance) == 0 {
+		return nil
+	}
 
-		expRole := RoleDataExchange{}
-		if err = json.Unmarshal(jsonTxt, &expRole); err != nil {
-			return logical.ErrorResponse("was unable to parse Mashery role data (%s)", err.Error()), nil
+	last := exp.Provenance[len(exp.Provenance)-1]
+	if last.ExportID != exp.ExportID {
+		return errors.New(fmt.Sprintf("the chain ends with export %s instead of export %s", last.ExportID, exp.ExportID))
+	} else if len(signer) > 0 && last.Exporter != signer {
+		return errors.New(fmt.Sprintf("export %s was signed by sha256:%s, but the chain names sha256:%s as its exporter", exp.ExportID, signer, last.Exporter))
+	} else if last.DelegationDepth != exp.DelegationDepth {
+		return errors.New("the delegation depth does not match the chain")
+	}
+
+	for i := 1; i < len(exp.Provenance); i++ {
+		if exp.Provenance[i].DelegationDepth >= exp.Provenance[i-1].DelegationDepth {
+			return errors.New(fmt.Sprintf("export %s widens the delegation depth of export %s", exp.Provenance[i].ExportID, exp.Provenance[i-1].ExportID))
 		}
+	}
+
+	return nil
+}
 
-		reqCtx.heap.GetRole().Import(expRole)
-		return nil, nil
+// parseRoleDataExchange parses the decrypted role data.
+func parseRoleDataExchange(plainText []byte) (RoleDataExchange, error) {
+	expRole := RoleDataExchange{}
+
+	jsonTxt, err := GZipDecompress(plainText)
+	if err != nil {
+		return expRole, errors.New(fmt.Sprintf("was unable to GZipDecompress Mashery role data (%s)", err.Error()))
 	}
+
+	if err = json.Unmarshal(jsonTxt, &expRole); err != nil {
+		return expRole, errors.New(fmt.Sprintf("was unable to parse Mashery role data (%s)", err.Error()))
+	}
+
+	return expRole, nil
+}
+
+// importRoleDataExchange imports the decrypted role data into the role.
+func importRoleDataExchange[T RoleContext](reqCtx *RequestHandlerContext[T], plainText []byte) (*logical.Response, error) {
+	expRole, err := parseRoleDataExchange(plainText)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if expRole.ImportBefore > 0 && time.Now().Unix() > expRole.ImportBefore {
+		return logical.ErrorResponse("role data had to be imported before %s", time.Unix(expRole.ImportBefore, 0).UTC().Format(time.RFC3339)), nil
+	}
+	if err = verifyRoleDataProvenance(expRole, reqCtx.heap.GetRole().Keys.ExporterFingerprint); err != nil {
+		return logical.ErrorResponse("role data carries invalid provenance: %s", err.Error()), nil
+	}
+
+	reqCtx.heap.GetRole().Import(expRole)
+	return nil, nil
 }
@@ -75,6 +75,9 @@ func TestRetrievePrivateKey_GeneratesKeyIfMissing(t *testing.T) {
 	emulStore.
 		On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).
 		Return(nil, nil)
+	emulStore.
+		On("Get", mock.Anything, roleRecipientKeysPath(reqCtx)).
+		Return(nil, nil)
 	emulStore.
 		On("Put", mock.Anything, storageEntryBearingData()).
 		Return(nil)
@@ -99,7 +102,13 @@ func TestRetrievePrivateKey_WillErrIfPrivateKeyWriteWillFail(t *testing.T) {
 		On("Get", mock.Anything, rolePrivateKeyPath(reqCtx)).
 		Return(nil, nil)
 	emulStore.
-		On("Put", mock.Anything, storageEntryBearingData()).
+		On("Get", mock.Anything, roleRecipientKeysPath(reqCtx)).
+		Return(nil, nil)
+	emulStore.
+		On("Put", mock.Anything, storageEntryAt(roleRecipientKeysPath(reqCtx))).
+		Return(nil)
+	emulStore.
+		On("Put", mock.Anything, storageEntryAt(rolePrivateKeyPath(reqCtx))).
 		Return(errors.New("sample rejection"))
 
 	lr, err := retrievePrivateKey(context.TODO(), reqCtx)
@@ -390,6 +399,9 @@ func TestRenderEncryptedRoleData_HappyFlow(t *testing.T) {
 			RoleContainer: RoleContainer{
 				role: &role,
 			},
+			ExporterIdentityContainer: ExporterIdentityContainer{
+				identity: testExporterIdentity,
+			},
 		},
 		data: map[string]interface{}{
 			pemContainerField: pemBlock,
@@ -429,7 +441,7 @@ func createRoleWithFilledRoleKeys() StoredRole {
 
 func TestRetrieveImportPEMBlockFromRequest_RejectsEmptyString(t *testing.T) {
 	_, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{}, pathRolePemImportFields)
-	block, err := retrieveImportPEMBlockFromRequest(reqCtx.data)
+	block, err := retrieveImportPEMBundleFromRequest(reqCtx.data)
 	assert.Nil(t, block)
 	assert.NotNil(t, err)
 	assert.Equal(t, "empty PEM data received", err.Error())
@@ -439,7 +451,7 @@ func TestRetrieveImportPEMBlockFromRequest_RejectsMalformedString(t *testing.T)
 	_, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{
 		pemContainerField: "malformed-string",
 	}, pathRolePemImportFields)
-	block, err := retrieveImportPEMBlockFromRequest(reqCtx.data)
+	block, err := retrieveImportPEMBundleFromRequest(reqCtx.data)
 	assert.Nil(t, block)
 	assert.NotNil(t, err)
 	assert.Equal(t, "submitted data does not contain a valid PEM block", err.Error())
@@ -451,7 +463,7 @@ func TestRetrieveImportPEMBlockFromRequest_RejectsBlockOfUnexpectedType(t *testi
 	}, pathRolePemImportFields)
 	// The recipient role block is not valid
 
-	block, err := retrieveImportPEMBlockFromRequest(reqCtx.data)
+	block, err := retrieveImportPEMBundleFromRequest(reqCtx.data)
 	assert.Nil(t, block)
 	assert.NotNil(t, err)
 	assert.Equal(t, "incorrect PEM block", err.Error())
@@ -463,8 +475,8 @@ func TestRetrieveImportPEMBlockFromRequest_HappyPatch(t *testing.T) {
 	}, pathRolePemImportFields)
 	// The recipient role block is not valid
 
-	block, err := retrieveImportPEMBlockFromRequest(reqCtx.data)
-	assert.NotNil(t, block)
+	block, err := retrieveImportPEMBundleFromRequest(reqCtx.data)
+	assert.Equal(t, 1, len(block))
 	assert.Nil(t, err)
 }
 
@@ -477,10 +489,20 @@ func TestImportPEMBlock_WillFailOnMissingPrivateKey(t *testing.T) {
 }
 
 func setupTestRoleDataExport() (StoredRole, StoredRole, *pem.Block) {
+	return setupTestRoleDataExportWith(createRoleWithFilledRoleKeys(), map[string]interface{}{})
+}
+
+// setupTestRoleDataExportWith exports the role with the specified export settings
+func setupTestRoleDataExportWith(exportRole StoredRole, exportData map[string]interface{}) (StoredRole, StoredRole, *pem.Block) {
+	return setupTestRoleDataExportFor(randomPrivateKey(), exportRole, exportData)
+}
+
+// setupTestRoleDataExportFor exports the role to the recipient having the specified private key
+func setupTestRoleDataExportFor(recipientKey []byte, exportRole StoredRole, exportData map[string]interface{}) (StoredRole, StoredRole, *pem.Block) {
 	sourceReq := RoleRequestMockBuilder[RoleContext]{
 		container: &RoleContainer{
 			role: &StoredRole{
-				PrivateKey: randomPrivateKey(),
+				PrivateKey: recipientKey,
 			},
 		},
 		fieldSchema: pathRoleExportFields,
@@ -490,17 +512,18 @@ func setupTestRoleDataExport() (StoredRole, StoredRole, *pem.Block) {
 	_, rolePEMReadRequest := sourceReq.Build()
 	lr, _ := renderRoleCertificate(nil, rolePEMReadRequest)
 
-	exportRole := createRoleWithFilledRoleKeys()
+	exportData[pemContainerField] = lr.Data[pemContainerField]
 	exportReq := RoleRequestMockBuilder[RoleExportContext]{
 		container: &RoleExportContainer{
 			RoleContainer: RoleContainer{
 				role: &exportRole,
 			},
+			ExporterIdentityContainer: ExporterIdentityContainer{
+				identity: testExporterIdentity,
+			},
 		},
 		fieldSchema: pathRoleExportFields,
-		data: map[string]interface{}{
-			pemContainerField: lr.Data[pemContainerField],
-		},
+		data:        exportData,
 	}
 
 	_, exportRoleRequest := exportReq.Build()
@@ -557,3 +580,210 @@ func TestImportPEMBlock_WillImport(t *testing.T) {
 
 	assert.True(t, importRoleRequest.heap.GetRole().Usage.IsUnboundedUsage())
 }
+
+func TestParseDesiredRoleExport_WithScope(t *testing.T) {
+	_, fullData := setupRoleRequestMockWithData(map[string]interface{}{
+		roleScopeAllowField: "get services/abc/**",
+		roleScopeDenyField:  "V2 key.*",
+	}, pathRoleExportFields)
+
+	cfg, err := parseDesiredRoleExport(fullData.data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"GET services/abc/**"}, cfg.desiredScope.Allow)
+	assert.Equal(t, []string{"V2 key.*"}, cfg.desiredScope.Deny)
+
+	_, fullData = setupRoleRequestMockWithData(map[string]interface{}{
+		roleScopeAllowField: "services/abc/**",
+	}, pathRoleExportFields)
+	_, err = parseDesiredRoleExport(fullData.data)
+	assert.NotNil(t, err)
+}
+
+func TestImportPEMBlock_WillImportScope(t *testing.T) {
+	exportRole := createRoleWithFilledRoleKeys()
+	exportRole.Keys.DeniedScope = []string{"DELETE **"}
+
+	sourceRole, _, pemOut := setupTestRoleDataExportWith(exportRole, map[string]interface{}{
+		roleScopeAllowField: "* services/abc/**",
+	})
+
+	_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+	lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	importedKeys := importRoleRequest.heap.GetRole().Keys
+	assert.Equal(t, []RoleScope{
+		{Deny: []string{"DELETE **"}},
+		{Allow: []string{"* services/abc/**"}},
+	}, importedKeys.InheritedScope)
+
+	// Both the scope of the exporter's role and the scope requested at export are enforced
+	assert.True(t, importedKeys.PermitsV3Operation("PUT", "/services/abc"))
+	assert.False(t, importedKeys.PermitsV3Operation("DELETE", "/services/abc"))
+	assert.False(t, importedKeys.PermitsV3Operation("GET", "/services/def"))
+	assert.True(t, importedKeys.HasScope())
+	assert.Equal(t, "true", pemOut.Headers["Restricted Scope"])
+}
+
+func TestImportPEMBlock_ReExportRetainsScope(t *testing.T) {
+	exportRole := createRoleWithFilledRoleKeys()
+	exportRole.Keys.Imported = true
+	exportRole.Keys.InheritedScope = []RoleScope{{Allow: []string{"GET services/**"}}}
+
+	sourceRole, _, pemOut := setupTestRoleDataExportWith(exportRole, map[string]interface{}{
+		roleScopeAllowField: "* **",
+	})
+
+	_, importRoleRequest := setupRoleRequestMockHaving(sourceRole)
+	lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	// The wider scope requested at the re-export does not widen the scope sealed by the original exporter
+	importedKeys := importRoleRequest.heap.GetRole().Keys
+	assert.Len(t, importedKeys.InheritedScope, 2)
+	assert.False(t, importedKeys.PermitsV3Operation("PUT", "/services/abc"))
+	assert.True(t, importedKeys.PermitsV3Operation("GET", "/services/abc"))
+}
+
+// importTestRoleDataExport exports the role to a recipient having a P-256 key, and imports it into the recipient
+func importTestRoleDataExport(t *testing.T, exportRole StoredRole, exportData map[string]interface{}) (StoredRole, *pem.Block) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	recipientRole, _, pemOut := setupTestRoleDataExportFor(key, exportRole, exportData)
+
+	_, importRoleRequest := setupRoleRequestMockHaving(recipientRole)
+	lr, err := importPEMEncodedExchangeData(pemOut)(context.TODO(), importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	return *importRoleRequest.heap.GetRole(), pemOut
+}
+
+func TestReExport_CarriesProvenanceChain(t *testing.T) {
+	first, firstPEM := importTestRoleDataExport(t, createRoleWithFilledRoleKeys(), map[string]interface{}{
+		delegationDepthField: 2,
+	})
+	assert.True(t, first.Keys.Exportable)
+	assert.Equal(t, 2, first.Keys.RemainingDelegationDepth())
+	assert.Equal(t, "2", firstPEM.Headers["Delegation Depth"])
+
+	second, secondPEM := importTestRoleDataExport(t, first, map[string]interface{}{
+		exportableField: true,
+	})
+	assert.True(t, second.Keys.Exportable)
+	assert.Equal(t, 1, second.Keys.RemainingDelegationDepth())
+
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+	assert.Equal(t, 2, len(second.Keys.Provenance))
+	assert.Equal(t, ProvenanceLink{
+		Exporter:        fp,
+		ExportID:        firstPEM.Headers[roleDataExportIDHeader],
+		Exported:        first.Keys.Provenance[0].Exported,
+		DelegationDepth: 2,
+	}, second.Keys.Provenance[0])
+	assert.Equal(t, secondPEM.Headers[roleDataExportIDHeader], second.Keys.Provenance[1].ExportID)
+	assert.Equal(t, 1, second.Keys.Provenance[1].DelegationDepth)
+
+	third, _ := importTestRoleDataExport(t, second, map[string]interface{}{})
+	assert.False(t, third.Keys.Exportable)
+	assert.Equal(t, 0, third.Keys.RemainingDelegationDepth())
+	assert.Equal(t, 3, len(third.Keys.Provenance))
+
+	_, err := narrowDesiredRoleExport(&second, DesiredRoleExport{desireExportable: true, desiredDelegation: 1})
+	assert.NotNil(t, err)
+	assert.Equal(t, "the recipient of this role cannot be allowed to re-export it further", err.Error())
+
+	_, err = narrowDesiredRoleExport(&first, DesiredRoleExport{desireExportable: true, desiredDelegation: 2})
+	assert.NotNil(t, err)
+	assert.Equal(t, "delegation_depth cannot exceed 1 for this role", err.Error())
+}
+
+func TestParseDesiredRoleExport_DelegationDepth(t *testing.T) {
+	cases := []struct {
+		data       map[string]interface{}
+		exportable bool
+		depth      int
+		err        string
+	}{
+		{data: map[string]interface{}{}},
+		{data: map[string]interface{}{exportableField: true}, exportable: true, depth: 1},
+		{data: map[string]interface{}{delegationDepthField: 3}, exportable: true, depth: 3},
+		{data: map[string]interface{}{exportableField: true, delegationDepthField: 2}, exportable: true, depth: 2},
+		{data: map[string]interface{}{exportableField: false, delegationDepthField: 2}, err: "exportable and delegation_depth contradict each other"},
+		{data: map[string]interface{}{delegationDepthField: -1}, err: "delegation_depth cannot be negative"},
+	}
+
+	for _, c := range cases {
+		_, reqCtx := setupRoleRequestMockWithData(c.data, pathRoleExportFields)
+		settings, err := parseDesiredRoleExport(reqCtx.data)
+		if len(c.err) > 0 {
+			assert.NotNil(t, err)
+			assert.Equal(t, c.err, err.Error())
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, c.exportable, settings.desireExportable)
+			assert.Equal(t, c.depth, settings.desiredDelegation)
+		}
+	}
+}
+
+func TestNarrowDesiredRoleExport_NarrowsToImportedLimits(t *testing.T) {
+	role := createRoleWithFilledRoleKeys()
+	role.Keys.MaxQPS = 10
+	role.Keys.ForceProxyMode = true
+	role.Usage = StoredRoleUsage{
+		ExplicitTerm:     time.Now().Add(time.Hour).Unix(),
+		ExplicitNumUses:  10,
+		RemainingNumUses: 5,
+	}
+	wide := DesiredRoleExport{desiredTerm: 48 * time.Hour, desiredNumUses: 100, desiredQps: 50}
+
+	// The administrator of the original role is not restricted
+	settings, err := narrowDesiredRoleExport(&role, wide)
+	assert.Nil(t, err)
+	assert.Equal(t, wide, settings)
+
+	role.Keys.Imported = true
+	settings, err = narrowDesiredRoleExport(&role, wide)
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), settings.desiredTerm.Seconds(), 5)
+	assert.Equal(t, 5, settings.desiredNumUses)
+	assert.Equal(t, 10, settings.desiredQps)
+	assert.True(t, settings.desiredForceProxyMode)
+
+	// Unlimited term and uses inherit the limits of the role
+	settings, err = narrowDesiredRoleExport(&role, DesiredRoleExport{desiredNumUses: -1, desiredQps: -1})
+	assert.Nil(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), settings.desiredTerm.Seconds(), 5)
+	assert.Equal(t, 5, settings.desiredNumUses)
+	assert.Equal(t, -1, settings.desiredQps)
+
+	role.Usage.RemainingNumUses = 0
+	_, err = narrowDesiredRoleExport(&role, wide)
+	assert.NotNil(t, err)
+	assert.Equal(t, "this role has depleted its usage quota", err.Error())
+}
+
+func TestVerifyRoleDataProvenance(t *testing.T) {
+	chain := []ProvenanceLink{
+		{Exporter: "a", ExportID: "x1", DelegationDepth: 2},
+		{Exporter: "b", ExportID: "x2", DelegationDepth: 1},
+	}
+
+	assert.Nil(t, verifyRoleDataProvenance(RoleDataExchange{ExportID: "x2", DelegationDepth: 1, Provenance: chain}, "b"))
+	assert.Nil(t, verifyRoleDataProvenance(RoleDataExchange{ExportID: "x3"}, "c"))
+
+	err := verifyRoleDataProvenance(RoleDataExchange{ExportID: "x3", DelegationDepth: 1, Provenance: chain}, "b")
+	assert.Equal(t, "the chain ends with export x2 instead of export x3", err.Error())
+
+	err = verifyRoleDataProvenance(RoleDataExchange{ExportID: "x2", DelegationDepth: 1, Provenance: chain}, "a")
+	assert.Equal(t, "export x2 was signed by sha256:a, but the chain names sha256:b as its exporter", err.Error())
+
+	err = verifyRoleDataProvenance(RoleDataExchange{ExportID: "x2", DelegationDepth: 2, Provenance: chain}, "b")
+	assert.Equal(t, "the delegation depth does not match the chain", err.Error())
+
+	chain[1].DelegationDepth = 2
+	err = verifyRoleDataProvenance(RoleDataExchange{ExportID: "x2", DelegationDepth: 2, Provenance: chain}, "b")
+	assert.Equal(t, "export x2 widens the delegation depth of export x1", err.Error())
+}
@@ -0,0 +1,196 @@
+package mashery
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strings"
+	"time"
+)
+
+const (
+	recipientKeyGracePeriodField = "grace_period"
+
+	// The recipient certificates are valid for 4 hours; the default grace period leaves ample time to import the
+	// data exported to the certificate of the retired key.
+	defaultRecipientKeyGracePeriod = time.Hour * 24
+)
+
+func readRecipientKeys[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) error {
+	_, err := reqCtx.ReadPath(ctx, roleRecipientKeysPath(reqCtx), &reqCtx.heap.GetRole().RecipientKeys)
+	return err
+}
+
+// replaceRecipientKey generates a new recipient key of the specified type. The current key, if any, is retired: it
+// can decrypt the imported data until the grace period ends, after which it is destroyed.
+func replaceRecipientKey[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T], keyType string, grace time.Duration) error {
+	role := reqCtx.heap.GetRole()
+	if err := readRecipientKeys(ctx, reqCtx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keys := &role.RecipientKeys
+	keys.Prune(now)
+	if len(role.PrivateKey) > 0 && grace > 0 {
+		keys.Retired = append(keys.Retired, RetiredRecipientKey{
+			Key:        role.PrivateKey,
+			Created:    keys.CurrentCreated,
+			RetiredAt:  now.Unix(),
+			GraceUntil: now.Add(grace).Unix(),
+		})
+	}
+
+	pkBinary, err := generateRecipientKey(keyType)
+	if err != nil {
+		return err
+	}
+	keys.CurrentCreated = now.Unix()
+
+	// The retired key is saved first, so that a failure cannot lose it.
+	if err = reqCtx.WritePath(ctx, roleRecipientKeysPath(reqCtx), keys); err != nil {
+		return err
+	}
+	if err = reqCtx.WriteBinaryPath(ctx, rolePrivateKeyPath(reqCtx), pkBinary); err != nil {
+		return err
+	}
+
+	role.PrivateKey = pkBinary
+	return nil
+}
+
+// retrieveRetiredRecipientKeys reads the retired recipient keys of the role. The keys whose grace period has ended
+// are destroyed.
+func retrieveRetiredRecipientKeys[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	if err := readRecipientKeys(ctx, reqCtx); err != nil {
+		return nil, err
+	}
+
+	keys := &reqCtx.heap.GetRole().RecipientKeys
+	if keys.Prune(time.Now()) {
+		if err := reqCtx.WritePath(ctx, roleRecipientKeysPath(reqCtx), keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// pruneRetiredRecipientKeys destroys the retired recipient keys of all roles whose grace period has ended, so that
+// these keys do not outlive their grace period in the roles that are not used.
+func (b *AuthPlugin) pruneRetiredRecipientKeys(ctx context.Context, req *logical.Request) error {
+	// The secondaries cannot write the storage; the primary prunes the keys.
+	if sys := b.System(); sys != nil && sys.ReplicationState().HasState(consts.ReplicationPerformanceSecondary|consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	b.recipientKeysLock.Lock()
+	defer b.recipientKeysLock.Unlock()
+
+	names, err := req.Storage.List(ctx, b.rolesStorageRoot())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		path := b.rolesStorageRoot() + strings.TrimSuffix(name, "/") + storedRoleRecipientKeysPathSuffix
+
+		keys := StoredRecipientKeys{}
+		if found, readErr := b.vaultStorage.Read(ctx, req.Storage, path, &keys); readErr != nil {
+			return readErr
+		} else if found && keys.Prune(now) {
+			if writeErr := b.vaultStorage.Persist(ctx, req.Storage, path, &keys); writeErr != nil {
+				return writeErr
+			}
+		}
+	}
+
+	return nil
+}
+
+// rotateRecipientKey replaces the recipient key of the role with a new key, retiring the current key for the
+// requested grace period.
+func rotateRecipientKey(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	grace := time.Second * time.Duration(reqCtx.data.Get(recipientKeyGracePeriodField).(int))
+
+	role := reqCtx.heap.GetRole()
+	keyType := role.Keys.EffectiveRecipientKeyType(reqCtx.plugin.config())
+	if err := replaceRecipientKey(ctx, reqCtx, keyType, grace); err != nil {
+		return nil, err
+	}
+
+	reqCtx.plugin.Logger().Info(fmt.Sprintf("rotated recipient key of role %s; previous key is retained for %s", role.Name, grace))
+	return nil, nil
+}
+
+// renderRecipientKeyInfo adds the identifiers, the creation times, and the remaining grace periods of the role's
+// recipient keys to the response.
+func renderRecipientKeyInfo(role *StoredRole, resp *logical.Response) error {
+	pk, err := getPrivateKey(role)
+	if err != nil {
+		return err
+	}
+	keyID, err := recipientKeyID(pk)
+	if err != nil {
+		return err
+	}
+
+	resp.Data["key_id"] = keyID
+	resp.Data["key_type"] = recipientKeyTypeOf(pk)
+	resp.Data["key_created"] = formatRecipientKeyTime(role.RecipientKeys.CurrentCreated)
+
+	now := time.Now()
+	var retired []map[string]interface{}
+	for _, k := range role.RecipientKeys.Retired {
+		if k.GraceUntil <= now.Unix() {
+			continue
+		}
+
+		retiredKeyID := "malformed"
+		if retiredPK, err := parseRecipientKey(k.Key); err == nil {
+			retiredKeyID, _ = recipientKeyID(retiredPK)
+		}
+
+		retired = append(retired, map[string]interface{}{
+			"key_id":          retiredKeyID,
+			"key_created":     formatRecipientKeyTime(k.Created),
+			"retired":         formatRecipientKeyTime(k.RetiredAt),
+			"grace_remaining": time.Unix(k.GraceUntil, 0).Sub(now).Round(time.Second).String(),
+		})
+	}
+	if len(retired) > 0 {
+		resp.Data["retired_keys"] = retired
+	}
+
+	return nil
+}
+
+func formatRecipientKeyTime(epoch int64) string {
+	if epoch == 0 {
+		return "unknown"
+	}
+	return time.Unix(epoch, 0).UTC().Format(time.RFC3339)
+}
+
+// decryptRoleDataWithRetiredKeys tries decrypting the role data with the retired keys still within their grace
+// period. Returns nil if none of these keys decrypts the data.
+func decryptRoleDataWithRetiredKeys(role *StoredRole, format string, data []byte, label []byte) []byte {
+	now := time.Now().Unix()
+	for _, k := range role.RecipientKeys.Retired {
+		if k.GraceUntil <= now {
+			continue
+		}
+
+		pk, err := parseRecipientKey(k.Key)
+		if err != nil {
+			continue
+		}
+		if plainText, err := decryptRoleData(pk, format, data, label); err == nil {
+			return plainText
+		}
+	}
+
+	return nil
+}
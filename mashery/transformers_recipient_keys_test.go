@@ -0,0 +1,181 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func TestStoredRecipientKeys_Prune(t *testing.T) {
+	now := time.Now()
+	keys := StoredRecipientKeys{
+		Retired: []RetiredRecipientKey{
+			{Key: []byte("expired"), GraceUntil: now.Add(-time.Minute).Unix()},
+			{Key: []byte("valid"), GraceUntil: now.Add(time.Minute).Unix()},
+		},
+	}
+
+	assert.True(t, keys.Prune(now))
+	assert.Equal(t, 1, len(keys.Retired))
+	assert.Equal(t, []byte("valid"), keys.Retired[0].Key)
+
+	assert.False(t, keys.Prune(now))
+}
+
+func setupRecipientKeyRotation(role *StoredRole, grace int) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleContext]) {
+	builder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{role: role},
+		data: map[string]interface{}{
+			recipientKeyGracePeriodField: grace,
+		},
+		fieldSchema: pathRolePemRotateFields,
+	}
+
+	emulStore, reqCtx := builder.Build()
+	reqCtx.plugin.Backend = &framework.Backend{}
+	reqCtx.plugin.cfg.RecipientKeyType = recipientKeyTypeP256
+	emulStore.On("Get", mock.Anything, roleRecipientKeysPath(reqCtx)).Return(nil, nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleRecipientKeysPath(reqCtx))).Return(nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(rolePrivateKeyPath(reqCtx))).Return(nil)
+
+	return emulStore, reqCtx
+}
+
+func TestRotateRecipientKey_RetiresPreviousKey(t *testing.T) {
+	previousKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	emulStore, reqCtx := setupRecipientKeyRotation(&StoredRole{Name: "role", PrivateKey: previousKey}, 3600)
+
+	lr, err := rotateRecipientKey(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	assert.NotEqual(t, previousKey, role.PrivateKey)
+	assert.True(t, role.RecipientKeys.CurrentCreated > 0)
+	assert.Equal(t, 1, len(role.RecipientKeys.Retired))
+
+	retired := role.RecipientKeys.Retired[0]
+	assert.Equal(t, previousKey, retired.Key)
+	assert.Equal(t, int64(3600), retired.GraceUntil-retired.RetiredAt)
+}
+
+func TestRotateRecipientKey_ZeroGraceDestroysPreviousKey(t *testing.T) {
+	previousKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, reqCtx := setupRecipientKeyRotation(&StoredRole{Name: "role", PrivateKey: previousKey}, 0)
+
+	lr, err := rotateRecipientKey(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	assert.NotEqual(t, previousKey, role.PrivateKey)
+	assert.Equal(t, 0, len(role.RecipientKeys.Retired))
+}
+
+func TestImportPEMEncodedExchangeData_UsesRetiredKeyWithinGrace(t *testing.T) {
+	previousKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	currentKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, _, pemOut := setupTestRoleDataExportFor(previousKey, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+
+	_, importRoleRequest := setupRoleRequestMockHaving(StoredRole{
+		PrivateKey: currentKey,
+		RecipientKeys: StoredRecipientKeys{
+			Retired: []RetiredRecipientKey{
+				{Key: previousKey, GraceUntil: time.Now().Add(time.Hour).Unix()},
+			},
+		},
+	})
+
+	lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, createRoleWithFilledRoleKeys().Keys.ApiKey, importRoleRequest.heap.GetRole().Keys.ApiKey)
+}
+
+func TestImportPEMEncodedExchangeData_RejectsRetiredKeyAfterGrace(t *testing.T) {
+	previousKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	currentKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, _, pemOut := setupTestRoleDataExportFor(previousKey, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+
+	_, importRoleRequest := setupRoleRequestMockHaving(StoredRole{
+		PrivateKey: currentKey,
+		RecipientKeys: StoredRecipientKeys{
+			Retired: []RetiredRecipientKey{
+				{Key: previousKey, GraceUntil: time.Now().Add(-time.Second).Unix()},
+			},
+		},
+	})
+
+	lr, err := importPEMEncodedExchangeData(pemOut)(nil, importRoleRequest)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+}
+
+func TestRenderRoleCertificate_ReportsRecipientKeys(t *testing.T) {
+	currentKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	previousKey, _ := generateRecipientKey(recipientKeyTypeP384)
+	created := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	sourceReq := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{
+				PrivateKey: currentKey,
+				RecipientKeys: StoredRecipientKeys{
+					CurrentCreated: created.Unix(),
+					Retired: []RetiredRecipientKey{
+						{Key: previousKey, RetiredAt: created.Unix(), GraceUntil: time.Now().Add(time.Hour).Unix()},
+					},
+				},
+			},
+		},
+		fieldSchema: pathRolePemReadFields,
+	}
+
+	_, reqCtx := sourceReq.Build()
+	lr, err := renderRoleCertificate(nil, reqCtx)
+	assert.Nil(t, err)
+
+	pk, _ := parseRecipientKey(currentKey)
+	keyID, _ := recipientKeyID(pk)
+	assert.Equal(t, keyID, lr.Data["key_id"])
+	assert.Equal(t, recipientKeyTypeP256, lr.Data["key_type"])
+	assert.Equal(t, "2024-03-01T10:00:00Z", lr.Data["key_created"])
+
+	retired := lr.Data["retired_keys"].([]map[string]interface{})
+	assert.Equal(t, 1, len(retired))
+
+	previousPK, _ := parseRecipientKey(previousKey)
+	previousKeyID, _ := recipientKeyID(previousPK)
+	assert.Equal(t, previousKeyID, retired[0]["key_id"])
+	assert.Equal(t, "unknown", retired[0]["key_created"])
+	assert.Equal(t, "2024-03-01T10:00:00Z", retired[0]["retired"])
+	remaining, err := time.ParseDuration(retired[0]["grace_remaining"].(string))
+	assert.Nil(t, err)
+	assert.InDelta(t, float64(time.Hour), float64(remaining), float64(time.Second*2))
+}
+
+func TestPruneRetiredRecipientKeys(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	now := time.Now()
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleRecipientKeysPathSuffix, &StoredRecipientKeys{
+		CurrentCreated: now.Unix(),
+		Retired: []RetiredRecipientKey{
+			{Key: []byte("expired"), GraceUntil: now.Add(-time.Minute).Unix()},
+			{Key: []byte("valid"), GraceUntil: now.Add(time.Minute).Unix()},
+		},
+	})
+
+	req, _ := pendingKeysTestRequest(storage, nil, pathRoleFields)
+	assert.Nil(t, b.pruneRetiredRecipientKeys(context.TODO(), req))
+
+	keys := StoredRecipientKeys{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRoleRecipientKeysPathSuffix, &keys))
+	assert.Equal(t, now.Unix(), keys.CurrentCreated)
+	assert.Equal(t, 1, len(keys.Retired))
+	assert.Equal(t, []byte("valid"), keys.Retired[0].Key)
+}
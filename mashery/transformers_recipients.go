@@ -0,0 +1,219 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"regexp"
+	"time"
+)
+
+const (
+	recipientIdentityPEMHeader = "Recipient-Identity"
+
+	// The recipient identity receives the data of several roles, possibly exported over a longer period than the
+	// export of a single role takes.
+	recipientIdentityCertificateValidity = time.Hour * 24
+)
+
+// validRoleName matches the role names accepted by the role paths
+var validRoleName = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
+func (b *AuthPlugin) recipientIdentitiesRoot() string {
+	return b.backendUUID + "/recipients/"
+}
+
+func (b *AuthPlugin) recipientIdentityPath(name string) string {
+	return b.recipientIdentitiesRoot() + name
+}
+
+func readRecipientIdentity[T RecipientIdentityContext](mustExist bool) TransformerFunc[T] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		name := reqCtx.data.Get(recipientIdentityNameField).(string)
+		if found, err := reqCtx.ReadPath(ctx, reqCtx.plugin.recipientIdentityPath(name), reqCtx.heap.GetRecipientIdentity()); err != nil {
+			return nil, err
+		} else if !found && mustExist {
+			return logical.ErrorResponse("recipient identity %s is not found", name), nil
+		}
+
+		return nil, nil
+	}
+}
+
+func saveRecipientIdentity(ctx context.Context, reqCtx *RequestHandlerContext[RecipientIdentityContext]) (*logical.Response, error) {
+	return nil, reqCtx.WritePath(ctx, reqCtx.storagePath, reqCtx.heap.GetRecipientIdentity())
+}
+
+// generateRecipientIdentityKey generates the key of the recipient identity. The existing key is replaced only if a
+// different key type is requested.
+func generateRecipientIdentityKey(_ context.Context, reqCtx *RequestHandlerContext[RecipientIdentityContext]) (*logical.Response, error) {
+	identity := reqCtx.heap.GetRecipientIdentity()
+
+	keyType := ""
+	if err := copyRecipientKeyTypeFieldIfDefined(reqCtx.data, roleRecipientKeyTypeField, &keyType); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleRecipientKeyTypeField, err.Error()), nil
+	}
+
+	if len(identity.PrivateKey) > 0 {
+		if pk, err := parseRecipientKey(identity.PrivateKey); err != nil {
+			return nil, err
+		} else if len(keyType) == 0 || keyType == recipientKeyTypeOf(pk) {
+			return nil, nil
+		}
+	}
+
+	if len(keyType) == 0 {
+		keyType = reqCtx.plugin.config().EffectiveRecipientKeyType()
+	}
+
+	pkBinary, err := generateRecipientKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.PrivateKey = pkBinary
+	identity.Created = time.Now().Unix()
+	return nil, nil
+}
+
+func renderRecipientIdentity(_ context.Context, reqCtx *RequestHandlerContext[RecipientIdentityContext]) (*logical.Response, error) {
+	identity := reqCtx.heap.GetRecipientIdentity()
+
+	pk, err := parseRecipientKey(identity.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := recipientKeyID(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_id":      keyID,
+			"key_type":    recipientKeyTypeOf(pk),
+			"key_created": formatRecipientKeyTime(identity.Created),
+		},
+	}, nil
+}
+
+// renderRecipientIdentityCertificate renders the certificate of the recipient identity. Unlike the certificate
+// of a role, it doesn't name the role the data is imported into.
+func renderRecipientIdentityCertificate(ctx context.Context, reqCtx *RequestHandlerContext[RecipientIdentityContext]) (*logical.Response, error) {
+	pk, err := parseRecipientKey(reqCtx.heap.GetRecipientIdentity().PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cn := reqCtx.data.Get(pemCommonNameField).(string)
+	template := createRoleCertificateTemplate(cn, time.Now(), time.Now().Add(recipientIdentityCertificateValidity))
+
+	derBytes, err := createRecipientCertificate(&template, pk)
+	if err != nil {
+		return nil, errwrap.Wrapf("cannot generate x509 certificate ({{err}})", err)
+	}
+
+	out := createRecipientRolePEMBock(derBytes, map[string]string{
+		"NotAfter":                 template.NotAfter.String(),
+		"Common-Name":              template.Subject.CommonName,
+		"Key-Type":                 recipientKeyTypeOf(pk),
+		recipientIdentityPEMHeader: reqCtx.data.Get(recipientIdentityNameField).(string),
+	})
+
+	resp, err := renderRecipientIdentity(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data[pemContainerField] = out
+	return resp, nil
+}
+
+// importedRoleName determines the role the data received by a recipient identity is imported into: the role
+// given explicitly, the role the exporter named, or else the role the data was exported from. The latter two are
+// read from the headers of the block, which anyone can alter: the import checks these against the role the
+// encrypted data names.
+func importedRoleName(d *framework.FieldData, pemBlock *pem.Block) (string, error) {
+	name := ""
+	if v, ok := d.GetOk(roleName); ok && len(v.(string)) > 0 {
+		name = v.(string)
+	} else if v := pemBlock.Headers[recipientRolePEMHeader]; len(v) > 0 && v != unspecifiedRecipientRole {
+		name = v
+	} else if v := pemBlock.Headers[originRolePEMHeader]; len(v) > 0 {
+		name = v
+	} else {
+		return "", errors.New(fmt.Sprintf("role data doesn't name the role; specify %s", roleName))
+	}
+
+	if !validRoleName.MatchString(name) {
+		return "", errors.New(fmt.Sprintf("%s is not a valid role name", name))
+	}
+	return name, nil
+}
+
+// createImportedRole creates the role the data received by a recipient identity is imported into. Existing roles
+// are not overwritten.
+func createImportedRole(name string) TransformerFunc[RecipientImportContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RecipientImportContext]) (*logical.Response, error) {
+		if found, err := reqCtx.ReadPath(ctx, roleKeysPath(reqCtx), &RoleKeys{}); err != nil {
+			return nil, err
+		} else if found {
+			return logical.ErrorResponse("role %s already exists", name), nil
+		}
+
+		role := reqCtx.plugin.initialRoleNamed(name)
+		reqCtx.heap.CarryRole(&role)
+		return nil, nil
+	}
+}
+
+// importPEMEncodedExchangeDataForIdentity decrypts the role data with the key of the recipient identity, and imports
+// it into the role. Unless the importer named the role explicitly, the role must be the one the encrypted data names.
+func importPEMEncodedExchangeDataForIdentity(pemBlock *pem.Block, explicitRole bool) TransformerFunc[RecipientImportContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RecipientImportContext]) (*logical.Response, error) {
+		pk, err := parseRecipientKey(reqCtx.heap.GetRecipientIdentity().PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		plainText, err := decryptRoleData(pk, pemBlock.Headers[roleDataFormatHeader], pemBlock.Bytes, reqCtx.plugin.config().OAEPLabel)
+		if err != nil {
+			return logical.ErrorResponse("was unable to decrypt the Mashery role data (%s)", err.Error()), nil
+		}
+
+		if !explicitRole {
+			expRole, parseErr := parseRoleDataExchange(plainText)
+			if parseErr != nil {
+				return logical.ErrorResponse(parseErr.Error()), nil
+			}
+
+			if named := expRole.NamedRole(); len(named) == 0 {
+				return logical.ErrorResponse("role data doesn't name the role; specify %s", roleName), nil
+			} else if named != reqCtx.heap.GetRole().Name {
+				return logical.ErrorResponse("role data names role %s, while its headers name role %s", named, reqCtx.heap.GetRole().Name), nil
+			}
+		}
+
+		return importRoleDataExchange(reqCtx, plainText)
+	}
+}
+
+func renderImportedRole(_ context.Context, reqCtx *RequestHandlerContext[RecipientImportContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	exporter := role.Keys.Exporter
+	if len(exporter) == 0 {
+		exporter = "unverified"
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			roleName:    role.Name,
+			"exporter":  exporter,
+			"export_id": role.Keys.ExportID,
+		},
+	}, nil
+}
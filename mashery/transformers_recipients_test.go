@@ -0,0 +1,249 @@
+package mashery
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func TestImportedRoleName(t *testing.T) {
+	blk := &pem.Block{
+		Headers: map[string]string{
+			recipientRolePEMHeader: unspecifiedRecipientRole,
+			originRolePEMHeader:    "origin",
+		},
+	}
+	d := &framework.FieldData{Raw: map[string]interface{}{}, Schema: pathRecipientImportFields}
+
+	name, err := importedRoleName(d, blk)
+	assert.Nil(t, err)
+	assert.Equal(t, "origin", name)
+
+	blk.Headers[recipientRolePEMHeader] = "named-by-exporter"
+	name, _ = importedRoleName(d, blk)
+	assert.Equal(t, "named-by-exporter", name)
+
+	d.Raw[roleName] = "explicit"
+	name, _ = importedRoleName(d, blk)
+	assert.Equal(t, "explicit", name)
+
+	d.Raw[roleName] = "../other"
+	_, err = importedRoleName(d, blk)
+	assert.Equal(t, "../other is not a valid role name", err.Error())
+}
+
+func setupRecipientIdentityRequest(identity StoredRecipientIdentity, data map[string]interface{}, schema map[string]*framework.FieldSchema) (*MockedVaultStorageWrapper, *RequestHandlerContext[RecipientIdentityContext]) {
+	data[recipientIdentityNameField] = "onboarding"
+	builder := RoleRequestMockBuilder[RecipientIdentityContext]{
+		container:   &RecipientIdentityContainer{identity: identity},
+		data:        data,
+		fieldSchema: schema,
+	}
+
+	return builder.Build()
+}
+
+func TestGenerateRecipientIdentityKey_GeneratesKeyOfConfiguredType(t *testing.T) {
+	_, reqCtx := setupRecipientIdentityRequest(StoredRecipientIdentity{}, map[string]interface{}{}, pathRecipientFields)
+	reqCtx.plugin.cfg.RecipientKeyType = recipientKeyTypeP256
+
+	lr, err := generateRecipientIdentityKey(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	identity := reqCtx.heap.GetRecipientIdentity()
+	pk, err := parseRecipientKey(identity.PrivateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeP256, recipientKeyTypeOf(pk))
+	assert.True(t, identity.Created > 0)
+}
+
+func TestGenerateRecipientIdentityKey_KeepsKeyUnlessTypeChanges(t *testing.T) {
+	der, _ := generateRecipientKey(recipientKeyTypeP256)
+	existing := StoredRecipientIdentity{PrivateKey: der, Created: 1}
+
+	_, reqCtx := setupRecipientIdentityRequest(existing, map[string]interface{}{
+		roleRecipientKeyTypeField: recipientKeyTypeP256,
+	}, pathRecipientFields)
+	reqCtx.plugin.cfg.RecipientKeyType = recipientKeyTypeX25519
+
+	_, _ = generateRecipientIdentityKey(context.TODO(), reqCtx)
+	assert.Equal(t, existing, *reqCtx.heap.GetRecipientIdentity())
+
+	_, reqCtx = setupRecipientIdentityRequest(existing, map[string]interface{}{
+		roleRecipientKeyTypeField: recipientKeyTypeP384,
+	}, pathRecipientFields)
+
+	_, _ = generateRecipientIdentityKey(context.TODO(), reqCtx)
+	pk, _ := parseRecipientKey(reqCtx.heap.GetRecipientIdentity().PrivateKey)
+	assert.Equal(t, recipientKeyTypeP384, recipientKeyTypeOf(pk))
+}
+
+func TestRenderRecipientIdentityCertificate_DoesNotNameRole(t *testing.T) {
+	der, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, reqCtx := setupRecipientIdentityRequest(StoredRecipientIdentity{PrivateKey: der}, map[string]interface{}{}, pathRecipientPemFields)
+
+	lr, err := renderRecipientIdentityCertificate(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, recipientKeyTypeP256, lr.Data["key_type"])
+
+	blk, _ := pem.Decode([]byte(lr.Data[pemContainerField].(string)))
+	assert.Equal(t, masheryRoleRecipientPEMBlockName, blk.Type)
+	assert.Equal(t, "onboarding", blk.Headers[recipientIdentityPEMHeader])
+	_, hasRole := blk.Headers[roleNamePEMHeader]
+	assert.False(t, hasRole)
+
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, "Mashery Recipient", cert.Subject.CommonName)
+}
+
+func TestReadRecipientCertificate_RecipientRole(t *testing.T) {
+	der, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, idReqCtx := setupRecipientIdentityRequest(StoredRecipientIdentity{PrivateKey: der}, map[string]interface{}{}, pathRecipientPemFields)
+	lr, _ := renderRecipientIdentityCertificate(context.TODO(), idReqCtx)
+
+	exportReq := RoleRequestMockBuilder[RoleExportContext]{
+		container: &RoleExportContainer{},
+		data: map[string]interface{}{
+			pemContainerField:  lr.Data[pemContainerField],
+			recipientRoleField: "team-a",
+		},
+		fieldSchema: pathRoleExportFields,
+	}
+	reqCtx := exportReq.Request()
+
+	lr, err := readRecipientCertificate(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+	assert.Equal(t, "team-a", reqCtx.heap.GetRecipientName())
+}
+
+func TestReadRecipientCertificate_RejectsRecipientRoleOtherThanCertificateRole(t *testing.T) {
+	sourceReq := RoleRequestMockBuilder[RoleContext]{
+		container:   &RoleContainer{role: &StoredRole{Name: "team-b", PrivateKey: randomPrivateKey()}},
+		fieldSchema: pathRolePemReadFields,
+	}
+	_, rolePEMReadRequest := sourceReq.Build()
+	lr, _ := renderRoleCertificate(nil, rolePEMReadRequest)
+
+	exportReq := RoleRequestMockBuilder[RoleExportContext]{
+		container: &RoleExportContainer{},
+		data: map[string]interface{}{
+			pemContainerField:  lr.Data[pemContainerField],
+			recipientRoleField: "team-a",
+		},
+		fieldSchema: pathRoleExportFields,
+	}
+
+	lr, err := readRecipientCertificate(context.TODO(), exportReq.Request())
+	assert.Nil(t, err)
+	assert.Equal(t, "supplied certificate is issued for role team-b", lr.Error().Error())
+}
+
+func setupRecipientImport(t *testing.T, identity StoredRecipientIdentity, roleExists bool) (*MockedVaultStorageWrapper, *RequestHandlerContext[RecipientImportContext]) {
+	builder := RoleRequestMockBuilder[RecipientImportContext]{
+		container: &RecipientImportContainer{},
+		data: map[string]interface{}{
+			recipientIdentityNameField: "onboarding",
+		},
+		fieldSchema: pathRecipientImportFields,
+	}
+
+	emulStore, reqCtx := builder.Build()
+	if roleExists {
+		emulStore.On("Get", mock.Anything, roleKeysPath(reqCtx)).
+			Return(createJsonStorageEntryFrom(t, roleKeysPath(reqCtx), &RoleKeys{}), nil)
+	} else {
+		emulStore.On("Get", mock.Anything, roleKeysPath(reqCtx)).Return(nil, nil)
+	}
+
+	identityPath := reqCtx.plugin.recipientIdentityPath("onboarding")
+	emulStore.On("Get", mock.Anything, identityPath).Return(createJsonStorageEntryFrom(t, identityPath, &identity), nil)
+
+	exporterDer, _ := x509.MarshalPKCS8PrivateKey(testExporterIdentity)
+	emulStore.On("Get", mock.Anything, reqCtx.plugin.exporterIdentityPath()).
+		Return(createBinaryStorageEntryFrom(t, reqCtx.plugin.exporterIdentityPath(), exporterDer), nil)
+
+	return emulStore, reqCtx
+}
+
+func TestImportThroughRecipientIdentity_CreatesRole(t *testing.T) {
+	identityKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, _, pemOut := setupTestRoleDataExportFor(identityKey, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+
+	emulStore, reqCtx := setupRecipientImport(t, StoredRecipientIdentity{PrivateKey: identityKey}, false)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleKeysPath(reqCtx))).Return(nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleUsagePath(reqCtx))).Return(nil)
+
+	chain := SimpleChain(
+		createImportedRole("imported"),
+		readRecipientIdentity[RecipientImportContext](true),
+		verifyRoleDataExporter[RecipientImportContext](pemOut),
+		importPEMEncodedExchangeDataForIdentity(pemOut, true),
+		saveRoleKeys[RecipientImportContext],
+		saveRoleUsage[RecipientImportContext],
+		renderImportedRole,
+	)
+
+	lr, err := chain(context.TODO(), reqCtx)
+	emulStore.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, "imported", lr.Data[roleName])
+
+	role := reqCtx.heap.GetRole()
+	assert.Equal(t, "imported", role.Name)
+	assert.True(t, role.Keys.Imported)
+	assert.Equal(t, createRoleWithFilledRoleKeys().Keys.ApiKey, role.Keys.ApiKey)
+}
+
+func TestImportThroughRecipientIdentity_DoesNotOverwriteRole(t *testing.T) {
+	emulStore, reqCtx := setupRecipientImport(t, StoredRecipientIdentity{}, true)
+
+	lr, err := createImportedRole("imported")(context.TODO(), reqCtx)
+	emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+	assert.Nil(t, err)
+	assert.Equal(t, "role imported already exists", lr.Error().Error())
+}
+
+func TestImportThroughRecipientIdentity_RejectsDataForOtherRecipient(t *testing.T) {
+	identityKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	otherKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	_, _, pemOut := setupTestRoleDataExportFor(otherKey, createRoleWithFilledRoleKeys(), map[string]interface{}{})
+
+	_, reqCtx := setupRecipientImport(t, StoredRecipientIdentity{}, false)
+	reqCtx.heap.CarryRole(&StoredRole{})
+	reqCtx.heap.GetRecipientIdentity().PrivateKey = identityKey
+
+	lr, err := importPEMEncodedExchangeDataForIdentity(pemOut, true)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+}
+
+func TestImportThroughRecipientIdentity_ChecksRoleNamedByHeaders(t *testing.T) {
+	identityKey, _ := generateRecipientKey(recipientKeyTypeP256)
+	exportRole := createRoleWithFilledRoleKeys()
+	exportRole.Name = "origin"
+	_, _, pemOut := setupTestRoleDataExportFor(identityKey, exportRole, map[string]interface{}{})
+
+	_, reqCtx := setupRecipientImport(t, StoredRecipientIdentity{}, false)
+	reqCtx.heap.GetRecipientIdentity().PrivateKey = identityKey
+
+	// The headers are altered to import the data into another role
+	pemOut.Headers[originRolePEMHeader] = "admin"
+	reqCtx.heap.CarryRole(&StoredRole{Name: "admin"})
+	lr, err := importPEMEncodedExchangeDataForIdentity(pemOut, false)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "role data names role origin, while its headers name role admin", lr.Error().Error())
+
+	reqCtx.heap.CarryRole(&StoredRole{Name: "origin"})
+	lr, err = importPEMEncodedExchangeDataForIdentity(pemOut, false)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+	assert.Equal(t, "key", reqCtx.heap.GetRole().Keys.ApiKey)
+}
@@ -0,0 +1,193 @@
+package mashery
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+const (
+	// Revocations are retained for the lifetime of the revoked credential when the actual expiry time is not known.
+	v3TokenRevocationRetention     = masheryV3TokenLifetime
+	v2SignatureRevocationRetention = masheryV2SignatureLifetime
+
+	// Number of consecutive seconds the signing of a V2 call tries for the signature that was not revoked
+	v2SigningAttempts = 3
+)
+
+// v2RevocationsContextKey the key of the role revocations in the context of the V2 call
+type v2RevocationsContextKey struct{}
+
+func roleRevocationsPath[T any](reqCtx *RequestHandlerContext[T]) string {
+	return reqCtx.storagePath + storedRoleRevocationsPathSuffix
+}
+
+func readRoleRevocations[T any](ctx context.Context, reqCtx *RequestHandlerContext[T]) (StoredRoleRevocations, error) {
+	rv := StoredRoleRevocations{}
+	_, err := reqCtx.ReadPath(ctx, roleRevocationsPath(reqCtx), &rv)
+
+	return rv, err
+}
+
+// updateRoleRevocations applies the modification to the revocations stored for the role. The revocations of the
+// credentials that have expired are pruned.
+func updateRoleRevocations[T any](ctx context.Context, reqCtx *RequestHandlerContext[T], f func(rev *StoredRoleRevocations)) error {
+	reqCtx.plugin.revocationsLock.Lock()
+	defer reqCtx.plugin.revocationsLock.Unlock()
+
+	rev, err := readRoleRevocations(ctx, reqCtx)
+	if err != nil {
+		return err
+	}
+
+	f(&rev)
+	rev.Prune(time.Now())
+
+	return reqCtx.WritePath(ctx, roleRevocationsPath(reqCtx), &rev)
+}
+
+// discardRevokedV3Token discards the access token cached for the role if this token was revoked, so that
+// the operation will obtain a new token.
+func discardRevokedV3Token[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	if len(role.Usage.V3Token) == 0 {
+		return nil, nil
+	}
+
+	rev, err := readRoleRevocations(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if rev.V3TokenRevoked(role.Usage.V3Token) || rev.IssuedBeforeRevocation(role.Usage.V3TokenObtainedTime()) {
+		reqCtx.plugin.Logger().Info(fmt.Sprintf("cached token of role %s was revoked and will not be used", role.Name))
+
+		role.rejectedV3Token = role.Usage.V3Token
+		role.Usage.ResetToken()
+	}
+
+	return nil, nil
+}
+
+// leasedV3Token the access token carried by the lease being revoked or renewed, and the time the token expires
+func leasedV3Token[T any](reqCtx *RequestHandlerContext[T]) (string, int64) {
+	tkn, _ := reqCtx.request.Data[secretAccessToken].(string)
+
+	expiry := time.Now().Add(v3TokenRevocationRetention).Unix()
+	if exp, err := intKeyOf(reqCtx.request.Secret.InternalData, secretInternalTokenExpiryTime); err == nil {
+		expiry = int64(exp)
+	}
+
+	return tkn, expiry
+}
+
+// revokeV3Token records the revocation of the access token until it expires. If the role has this token cached,
+// the cached token is cleared.
+func revokeV3Token[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T], tkn string, expiry int64) error {
+	if err := updateRoleRevocations(ctx, reqCtx, func(rev *StoredRoleRevocations) {
+		rev.RevokeV3Token(tkn, expiry)
+	}); err != nil {
+		return err
+	}
+
+	role := reqCtx.heap.GetRole()
+	if role.Usage.V3Token == tkn {
+		role.Usage.ResetToken()
+		return reqCtx.WritePath(ctx, roleUsagePath(reqCtx), &role.Usage)
+	}
+
+	return nil
+}
+
+// revokeLeasedV3Token records the revocation of the access token carried by the lease being revoked.
+func revokeLeasedV3Token(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	tkn, expiry := leasedV3Token(reqCtx)
+	if len(tkn) == 0 {
+		return nil, nil
+	}
+
+	return nil, revokeV3Token(ctx, reqCtx, tkn, expiry)
+}
+
+// revokeExchangedV3Token records the revocation of the access token the renewed lease carried until the renewal
+// exchanged it for a new one, so that the lease holder cannot keep using the token it replaced.
+func revokeExchangedV3Token(ctx context.Context, reqCtx *RequestHandlerContext[V3TokenContext]) (*logical.Response, error) {
+	tkn, expiry := leasedV3Token(reqCtx)
+	if len(tkn) == 0 || tkn == reqCtx.heap.GetV3TokenResponse().AccessToken {
+		return nil, nil
+	}
+
+	return nil, revokeV3Token(ctx, reqCtx, tkn, expiry)
+}
+
+// revokeLeasedV2Signature records the revocation of the V2 signature carried by the lease being revoked.
+func revokeLeasedV2Signature(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	sig, _ := reqCtx.request.Data[secretSignedSecretField].(string)
+	if len(sig) == 0 {
+		return nil, nil
+	}
+
+	err := updateRoleRevocations(ctx, reqCtx, func(rev *StoredRoleRevocations) {
+		rev.RevokeV2Signature(sig, time.Now().Add(v2SignatureRevocationRetention).Unix())
+	})
+
+	return nil, err
+}
+
+// revokeAllRoleLeases revokes all credentials issued for the role so far, including the role's cached token.
+func revokeAllRoleLeases(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	err := updateRoleRevocations(ctx, reqCtx, func(rev *StoredRoleRevocations) {
+		rev.RevokeAllIssuedUntil(time.Now())
+		if len(role.Usage.V3Token) > 0 {
+			rev.RevokeV3Token(role.Usage.V3Token, role.Usage.V3TokenExpiry)
+		}
+	})
+
+	return nil, err
+}
+
+// unrevokedV2SigningTime returns the time the V2 signature can be computed at without yielding the signature that
+// was revoked. The signature changes every second, and Mashery accepts the signatures of the times within its clock
+// skew tolerance: the time of the revoked signature is moved to the next second. The last value is false if no
+// such time was found.
+func unrevokedV2SigningTime(rev *StoredRoleRevocations, apiKey, secret string, t time.Time) (time.Time, bool) {
+	for i := 0; i < v2SigningAttempts; i++ {
+		if st := t.Add(time.Second * time.Duration(i)); !rev.V2SignatureRevoked(v2Signature(apiKey, secret, st)) {
+			return st, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// contextWithV2Revocations carries the revocations of the role to the V2 signing authorizer, which refuses to sign
+// the call with the signature that was revoked.
+func contextWithV2Revocations[T any](ctx context.Context, reqCtx *RequestHandlerContext[T]) (context.Context, error) {
+	rev, err := readRoleRevocations(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, v2RevocationsContextKey{}, &rev), nil
+}
+
+// blockRevokedLease blocks the renewal of the lease that was revoked.
+func blockRevokedLease[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	rev, err := readRoleRevocations(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	tkn, _ := reqCtx.request.Data[secretAccessToken].(string)
+	sig, _ := reqCtx.request.Data[secretSignedSecretField].(string)
+	if rev.IssuedBeforeRevocation(reqCtx.request.Secret.IssueTime) ||
+		(len(tkn) > 0 && rev.V3TokenRevoked(tkn)) ||
+		(len(sig) > 0 && rev.V2SignatureRevoked(sig)) {
+		return logical.ErrorResponse("this lease has been revoked"), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,361 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/masherytypes"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+func storageEntryAt(path string) interface{} {
+	return mock.MatchedBy(func(entry *logical.StorageEntry) bool {
+		return entry.Key == path
+	})
+}
+
+func decodeStoredRevocations(t *testing.T, emulStorage *MockedVaultStorageWrapper, path string) StoredRoleRevocations {
+	rv := StoredRoleRevocations{}
+	for _, call := range emulStorage.Calls {
+		if call.Method == "Put" {
+			if entry := call.Arguments.Get(1).(*logical.StorageEntry); entry.Key == path {
+				assert.Nil(t, entry.DecodeJSON(&rv))
+			}
+		}
+	}
+
+	return rv
+}
+
+func TestStoredRoleRevocations_Prune(t *testing.T) {
+	rev := StoredRoleRevocations{}
+	rev.RevokeV3Token("expired", time.Now().Add(-time.Minute).Unix())
+	rev.RevokeV3Token("valid", time.Now().Add(time.Minute).Unix())
+	rev.RevokeV2Signature("expired", time.Now().Add(-time.Minute).Unix())
+
+	rev.Prune(time.Now())
+
+	assert.False(t, rev.V3TokenRevoked("expired"))
+	assert.True(t, rev.V3TokenRevoked("valid"))
+	assert.False(t, rev.V2SignatureRevoked("expired"))
+}
+
+func TestStoredRoleRevocations_Merge(t *testing.T) {
+	now := time.Now()
+
+	rev := StoredRoleRevocations{}
+	rev.RevokeV3Token("token", now.Add(time.Minute).Unix())
+	rev.RevokeAllIssuedUntil(now)
+
+	other := StoredRoleRevocations{}
+	other.RevokeV3Token("token", now.Add(time.Hour).Unix())
+	other.RevokeV2Signature("signature", now.Add(time.Minute).Unix())
+	other.RevokeAllIssuedUntil(now.Add(-time.Hour))
+
+	rev.Merge(other)
+	assert.Equal(t, now.Add(time.Hour).Unix(), rev.V3Tokens[revokedCredentialDigest("token")])
+	assert.True(t, rev.V2SignatureRevoked("signature"))
+	assert.Equal(t, now.UnixNano(), rev.RevokedBeforeNano)
+
+	// The revocation stored with the precision of seconds covers the rest of the second
+	rev.Merge(StoredRoleRevocations{RevokedBefore: now.Unix()})
+	assert.Equal(t, now.Unix(), rev.RevokedBefore)
+	assert.True(t, rev.IssuedBeforeRevocation(time.Unix(now.Unix(), 999999999)))
+}
+
+func TestRevokeLeasedV3Token_ClearsCachedToken(t *testing.T) {
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token:       "access-token",
+			V3TokenExpiry: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	reqCtx.request.Data = map[string]interface{}{
+		secretAccessToken: "access-token",
+	}
+	reqCtx.request.Secret = &logical.Secret{
+		InternalData: map[string]interface{}{
+			// Internal data is received back as JSON numbers
+			secretInternalTokenExpiryTime: json.Number("4102444800"),
+		},
+	}
+
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).Return(nil, nil)
+	emulStorage.On("Put", mock.Anything, storageEntryAt(roleRevocationsPath(reqCtx))).Return(nil)
+	emulStorage.On("Put", mock.Anything, storageEntryAt(roleUsagePath(reqCtx))).Return(nil)
+
+	lr, err := revokeLeasedV3Token(context.TODO(), reqCtx)
+	emulStorage.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	rev := decodeStoredRevocations(t, emulStorage, roleRevocationsPath(reqCtx))
+	assert.True(t, rev.V3TokenRevoked("access-token"))
+	assert.Equal(t, int64(4102444800), rev.V3Tokens[revokedCredentialDigest("access-token")])
+	assert.Equal(t, "", reqCtx.heap.GetRole().Usage.V3Token)
+}
+
+func TestRevokeLeasedV3Token_RetainsOtherCachedToken(t *testing.T) {
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token: "cached-token",
+		},
+	})
+	reqCtx.request.Data = map[string]interface{}{
+		secretAccessToken: "granted-token",
+	}
+	reqCtx.request.Secret = &logical.Secret{}
+
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).Return(nil, nil)
+	emulStorage.On("Put", mock.Anything, storageEntryAt(roleRevocationsPath(reqCtx))).Return(nil)
+
+	_, err := revokeLeasedV3Token(context.TODO(), reqCtx)
+	emulStorage.AssertExpectations(t)
+	assert.Nil(t, err)
+	assert.Equal(t, "cached-token", reqCtx.heap.GetRole().Usage.V3Token)
+}
+
+func TestDiscardRevokedV3Token(t *testing.T) {
+	rev := StoredRoleRevocations{}
+	rev.RevokeV3Token("revoked", time.Now().Add(time.Hour).Unix())
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token:         "revoked",
+			V3TokenObtained: time.Now().Unix(),
+		},
+	})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleRevocationsPath(reqCtx), &rev), nil)
+
+	lr, err := discardRevokedV3Token(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	assert.True(t, role.Usage.V3TokenNeedsRenew())
+	assert.Equal(t, "revoked", role.rejectedV3Token)
+}
+
+func TestDiscardRevokedV3Token_ObtainedBeforeRevokeAll(t *testing.T) {
+	rev := StoredRoleRevocations{}
+	rev.RevokeAllIssuedUntil(time.Now())
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token:         "obtained-earlier",
+			V3TokenObtained: time.Now().Add(-time.Minute).Unix(),
+		},
+	})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleRevocationsPath(reqCtx), &rev), nil)
+
+	_, err := discardRevokedV3Token(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "", reqCtx.heap.GetRole().Usage.V3Token)
+}
+
+func TestDiscardRevokedV3Token_KeepsValidToken(t *testing.T) {
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token:         "valid",
+			V3TokenObtained: time.Now().Unix(),
+		},
+	})
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).Return(nil, nil)
+
+	_, err := discardRevokedV3Token(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "valid", reqCtx.heap.GetRole().Usage.V3Token)
+}
+
+func TestRevokeExchangedV3Token(t *testing.T) {
+	builder := RoleRequestMockBuilder[V3TokenContext]{
+		container: &V3TokenContextContainer{
+			RoleContainer: RoleContainer{role: &StoredRole{}},
+		},
+	}
+	emulStorage, reqCtx := builder.Build()
+	reqCtx.request.Data = map[string]interface{}{
+		secretAccessToken: "access-1",
+	}
+	reqCtx.request.Secret = &logical.Secret{
+		InternalData: map[string]interface{}{
+			secretInternalTokenExpiryTime: json.Number("4102444800"),
+		},
+	}
+	reqCtx.heap.CarryV3TokenResponse(masherytypes.AccessTokenResponse{AccessToken: "access-2"}.ObtainedNow())
+
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).Return(nil, nil)
+	emulStorage.On("Put", mock.Anything, storageEntryAt(roleRevocationsPath(reqCtx))).Return(nil)
+
+	lr, err := revokeExchangedV3Token(context.TODO(), reqCtx)
+	emulStorage.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	rev := decodeStoredRevocations(t, emulStorage, roleRevocationsPath(reqCtx))
+	assert.True(t, rev.V3TokenRevoked("access-1"))
+	assert.False(t, rev.V3TokenRevoked("access-2"))
+}
+
+func TestRevokeExchangedV3Token_KeepsTokenReturnedAgain(t *testing.T) {
+	builder := RoleRequestMockBuilder[V3TokenContext]{
+		container: &V3TokenContextContainer{
+			RoleContainer: RoleContainer{role: &StoredRole{}},
+		},
+	}
+	emulStorage, reqCtx := builder.Build()
+	reqCtx.request.Data = map[string]interface{}{
+		secretAccessToken: "access-1",
+	}
+	reqCtx.request.Secret = &logical.Secret{}
+	reqCtx.heap.CarryV3TokenResponse(masherytypes.AccessTokenResponse{AccessToken: "access-1"}.ObtainedNow())
+
+	lr, err := revokeExchangedV3Token(context.TODO(), reqCtx)
+	emulStorage.AssertExpectations(t)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+}
+
+func TestBlockRevokedLease(t *testing.T) {
+	rev := StoredRoleRevocations{}
+	rev.RevokeAllIssuedUntil(time.Now())
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleRevocationsPath(reqCtx), &rev), nil)
+
+	reqCtx.request.Secret = &logical.Secret{
+		LeaseOptions: logical.LeaseOptions{IssueTime: time.Now().Add(-time.Minute)},
+	}
+	lr, err := blockRevokedLease(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+
+	reqCtx.request.Secret.IssueTime = time.Now().Add(time.Minute)
+	lr, err = blockRevokedLease(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+func TestStoredRoleRevocations_IssuedBeforeRevocation_SubSecond(t *testing.T) {
+	revokedAt := time.Now()
+
+	rev := StoredRoleRevocations{}
+	rev.RevokeAllIssuedUntil(revokedAt)
+	assert.True(t, rev.IssuedBeforeRevocation(revokedAt))
+	assert.True(t, rev.IssuedBeforeRevocation(revokedAt.Add(-time.Millisecond)))
+	assert.False(t, rev.IssuedBeforeRevocation(revokedAt.Add(time.Millisecond)))
+
+	// Revocations stored with the precision of seconds revoke the whole second
+	legacy := StoredRoleRevocations{RevokedBefore: revokedAt.Unix()}
+	assert.True(t, legacy.IssuedBeforeRevocation(time.Unix(revokedAt.Unix(), 999)))
+	assert.False(t, legacy.IssuedBeforeRevocation(time.Unix(revokedAt.Unix()+1, 0)))
+}
+
+func TestDiscardRevokedV3Token_KeepsTokenObtainedAfterRevokeAllInSameSecond(t *testing.T) {
+	revokedAt := time.Unix(time.Now().Unix(), 100)
+	rev := StoredRoleRevocations{}
+	rev.RevokeAllIssuedUntil(revokedAt)
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Usage: StoredRoleUsage{
+			V3Token:             "obtained-later",
+			V3TokenObtained:     revokedAt.Unix(),
+			V3TokenObtainedNano: revokedAt.Add(time.Millisecond).UnixNano(),
+		},
+	})
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleRevocationsPath(reqCtx), &rev), nil)
+
+	_, err := discardRevokedV3Token(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "obtained-later", reqCtx.heap.GetRole().Usage.V3Token)
+}
+
+func TestBlockRevokedLease_RevokedV2Signature(t *testing.T) {
+	rev := StoredRoleRevocations{}
+	rev.RevokeV2Signature("signature", time.Now().Add(time.Minute).Unix())
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+	emulStorage.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleRevocationsPath(reqCtx), &rev), nil)
+
+	reqCtx.request.Secret = &logical.Secret{
+		LeaseOptions: logical.LeaseOptions{IssueTime: time.Now()},
+	}
+	reqCtx.request.Data = map[string]interface{}{
+		secretSignedSecretField: "signature",
+	}
+	lr, err := blockRevokedLease(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+
+	reqCtx.request.Data[secretSignedSecretField] = "other-signature"
+	lr, err = blockRevokedLease(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+func TestRetrieveV2Signature_WillNotIssueRevokedSignature(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+	role := &StoredRole{Name: "testRole", Keys: testPendingActiveKeys()}
+
+	// The signature of the current second and of the next second are revoked
+	now := time.Now()
+	rev := StoredRoleRevocations{}
+	rev.RevokeV2Signature(v2Signature(role.Keys.ApiKey, role.Keys.KeySecret, now), now.Add(time.Minute).Unix())
+	rev.RevokeV2Signature(v2Signature(role.Keys.ApiKey, role.Keys.KeySecret, now.Add(time.Second)), now.Add(time.Minute).Unix())
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleRevocationsPathSuffix, &rev)
+
+	var container V2SignatureContext = &V2SignatureContainer{}
+	container.CarryRole(role)
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleGrantFields)
+	reqCtx := &RequestHandlerContext[V2SignatureContext]{
+		request:     req,
+		data:        data,
+		plugin:      b,
+		storagePath: testPendingRolePath,
+		heap:        container,
+	}
+
+	lr, err := retrieveV2Signature(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	sig := reqCtx.heap.GetV2Signature()
+	assert.True(t, len(sig) > 0)
+	assert.False(t, rev.V2SignatureRevoked(sig))
+}
+
+func TestV2SigningAuthorizer_WillNotSignWithRevokedSignature(t *testing.T) {
+	auth := &v2SigningAuthorizer{apiKey: "key", secret: "secret"}
+
+	// The signatures of the whole tolerated window are revoked
+	now := time.Now()
+	rev := StoredRoleRevocations{}
+	for i := 0; i <= v2SigningAttempts; i++ {
+		rev.RevokeV2Signature(v2Signature("key", "secret", now.Add(time.Second*time.Duration(i))), now.Add(time.Minute).Unix())
+	}
+
+	_, err := auth.QueryStringAuthorization(context.WithValue(context.TODO(), v2RevocationsContextKey{}, &rev))
+	assert.NotNil(t, err)
+
+	// The signatures of the current and of the next second are revoked
+	rev = StoredRoleRevocations{}
+	rev.RevokeV2Signature(v2Signature("key", "secret", now), now.Add(time.Minute).Unix())
+	rev.RevokeV2Signature(v2Signature("key", "secret", now.Add(time.Second)), now.Add(time.Minute).Unix())
+
+	m, err := auth.QueryStringAuthorization(context.WithValue(context.TODO(), v2RevocationsContextKey{}, &rev))
+	assert.Nil(t, err)
+	assert.Equal(t, "key", m["apikey"])
+	assert.True(t, len(m["sig"]) > 0)
+	assert.False(t, rev.V2SignatureRevoked(m["sig"]))
+}
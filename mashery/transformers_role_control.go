@@ -0,0 +1,286 @@
+package mashery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	masheryRoleControlPEMBlockName = "MASHERY ROLE CONTROL"
+
+	roleControlFormatV1 = "1"
+
+	roleControlActionRevoke = "revoke"
+	roleControlActionExtend = "extend"
+
+	roleControlActionField  = "action"
+	roleControlAddUsesField = "add_uses"
+)
+
+// renderRoleControlMessage creates the signed control message concerning the export of the role, and records its
+// effect in the export ledger of the role. The export record is read and updated under the lock of the ledger, so
+// that the concurrent control messages of the same export do not overwrite each other's effect.
+func renderRoleControlMessage(ctx context.Context, reqCtx *RequestHandlerContext[RoleExportContext]) (*logical.Response, error) {
+	exportID := reqCtx.data.Get(exportIDField).(string)
+
+	now := time.Now()
+	msg := RoleControlMessage{
+		ExportID: exportID,
+		Action:   strings.ToLower(strings.TrimSpace(reqCtx.data.Get(roleControlActionField).(string))),
+		Issued:   now.Unix(),
+	}
+
+	var pemOut string
+	if resp, err := updateRoleExports(ctx, reqCtx, func(exports *StoredRoleExports) (*logical.Response, error) {
+		rec, ok := exports.Exports[exportID]
+		if !ok {
+			return logical.ErrorResponse("export %s is not found", exportID), nil
+		} else if rec.Revoked > 0 {
+			return logical.ErrorResponse("export %s was revoked on %s", exportID, formatRecipientKeyTime(rec.Revoked)), nil
+		}
+
+		if resp := applyRoleControlAction(reqCtx, &rec, &msg, now); resp != nil {
+			return resp, nil
+		}
+
+		var err error
+		if msg.ID, err = newExportID(); err != nil {
+			return nil, err
+		} else if pemOut, err = createRoleControlPEMBlock(reqCtx, msg); err != nil {
+			return nil, err
+		}
+
+		exports.Exports[exportID] = rec
+		return nil, nil
+	}); resp != nil || err != nil {
+		return resp, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			pemContainerField:      pemOut,
+			exportIDField:          exportID,
+			roleControlActionField: msg.Action,
+		},
+	}, nil
+}
+
+// applyRoleControlAction applies the action of the control message to the export record. An imported role cannot
+// extend its recipient beyond the term and the uses it holds itself.
+func applyRoleControlAction(reqCtx *RequestHandlerContext[RoleExportContext], rec *RoleExportRecord, msg *RoleControlMessage, now time.Time) *logical.Response {
+	role := reqCtx.heap.GetRole()
+
+	switch msg.Action {
+	case roleControlActionRevoke:
+		rec.Revoked = now.Unix()
+	case roleControlActionExtend:
+		if v, ok := reqCtx.data.GetOk(explicitTermField); ok {
+			if dur, err := ParseUserInputDuration(v.(string)); err != nil {
+				return logical.ErrorResponse("invalid %s: %s", explicitTermField, err.Error())
+			} else if dur <= 0 {
+				return logical.ErrorResponse("%s must lie in the future", explicitTermField)
+			} else {
+				msg.TermExpiry = now.Add(dur).Unix()
+			}
+
+			if role.Keys.Imported && role.Usage.ExplicitTerm > 0 && msg.TermExpiry > role.Usage.ExplicitTerm {
+				if role.Usage.ExplicitTerm <= now.Unix() {
+					return logical.ErrorResponse("the term of this role has ended")
+				}
+				msg.TermExpiry = role.Usage.ExplicitTerm
+			}
+			rec.TermExpiry = msg.TermExpiry
+		}
+		if v, ok := reqCtx.data.GetOk(roleControlAddUsesField); ok {
+			if v.(int) <= 0 {
+				return logical.ErrorResponse("%s must be positive", roleControlAddUsesField)
+			} else if rec.NumUses <= 0 {
+				return logical.ErrorResponse("export %s grants unlimited uses", msg.ExportID)
+			}
+			msg.AddUses = int64(v.(int))
+
+			if role.Keys.Imported && role.Usage.HasUsageQuota() {
+				if role.Usage.RemainingNumUses <= 0 {
+					return logical.ErrorResponse("this role has depleted its usage quota")
+				} else if msg.AddUses > role.Usage.RemainingNumUses {
+					msg.AddUses = role.Usage.RemainingNumUses
+				}
+			}
+			rec.NumUses += msg.AddUses
+		}
+
+		if msg.TermExpiry == 0 && msg.AddUses == 0 {
+			return logical.ErrorResponse("specify %s or %s to extend the export", explicitTermField, roleControlAddUsesField)
+		}
+	default:
+		return logical.ErrorResponse("unsupported action %s; use %s or %s", msg.Action, roleControlActionRevoke, roleControlActionExtend)
+	}
+
+	return nil
+}
+
+func createRoleControlPEMBlock(reqCtx *RequestHandlerContext[RoleExportContext], msg RoleControlMessage) (string, error) {
+	dat, err := json.Marshal(&msg)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint, signature, err := signExportedData(reqCtx.heap.GetExporterIdentity(), masheryRoleControlPEMBlockName, roleControlFormatV1, dat)
+	if err != nil {
+		return "", err
+	}
+
+	headers := map[string]string{
+		roleDataFormatHeader:    roleControlFormatV1,
+		roleDataExporterHeader:  fingerprint,
+		roleDataSignatureHeader: signature,
+		roleDataExportIDHeader:  msg.ExportID,
+		"Action":                msg.Action,
+		"Date":                  time.Unix(msg.Issued, 0).UTC().Format(time.RFC3339),
+	}
+	if msg.TermExpiry > 0 {
+		headers["Term"] = time.Unix(msg.TermExpiry, 0).UTC().Format(time.RFC3339)
+	}
+	if msg.AddUses > 0 {
+		headers["Added Uses"] = strconv.FormatInt(msg.AddUses, 10)
+	}
+
+	out := &bytes.Buffer{}
+	_ = pem.Encode(out, &pem.Block{Type: masheryRoleControlPEMBlockName, Bytes: dat, Headers: headers})
+	return out.String(), nil
+}
+
+// parseRoleControlBlock parses the PEM-encoded control message. The signature of the message is not verified.
+func parseRoleControlBlock(pemStr string) (*pem.Block, *RoleControlMessage, error) {
+	blk, _ := pem.Decode([]byte(pemStr))
+	if blk == nil {
+		return nil, nil, errors.New("input does not contain a valid PEM block")
+	} else if blk.Type != masheryRoleControlPEMBlockName {
+		return nil, nil, errors.New(fmt.Sprintf("unexpected PEM block type %s", blk.Type))
+	}
+
+	msg := RoleControlMessage{}
+	if err := json.Unmarshal(blk.Bytes, &msg); err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("control message cannot be parsed (%s)", err.Error()))
+	}
+
+	return blk, &msg, nil
+}
+
+// verifyRoleControlMessage verifies that the control message concerns the export the role was imported from, and
+// that it is signed by the exporter of the role. Each message is applied only once.
+func verifyRoleControlMessage(blk *pem.Block, msg *RoleControlMessage) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		if !role.Keys.Imported {
+			return logical.ErrorResponse("role %s is not imported", role.Name), nil
+		} else if role.Keys.Revoked {
+			return logical.ErrorResponse("role %s was revoked by its exporter", role.Name), nil
+		} else if msg.ExportID != role.Keys.ExportID {
+			return logical.ErrorResponse("control message concerns export %s, while the role was imported from export %s", msg.ExportID, role.Keys.ExportID), nil
+		}
+
+		for _, id := range role.Keys.AppliedControls {
+			if id == msg.ID {
+				return logical.ErrorResponse("control message %s was already applied", msg.ID), nil
+			}
+		}
+
+		fingerprint := blk.Headers[roleDataExporterHeader]
+		if len(role.Keys.ExporterFingerprint) == 0 {
+			return logical.ErrorResponse("the exporter of role %s is not verified; control messages cannot be applied", role.Name), nil
+		} else if fingerprint != role.Keys.ExporterFingerprint {
+			return logical.ErrorResponse("control message is not signed by the exporter of role %s", role.Name), nil
+		}
+
+		_, exporterKey, err := findTrustedExporterKey(ctx, reqCtx, fingerprint)
+		if err == errTrustedExporterExpired {
+			return logical.ErrorResponse("the certificate of the exporter of role %s has expired (sha256:%s)", role.Name, fingerprint), nil
+		} else if err != nil {
+			return nil, err
+		} else if exporterKey == nil {
+			return logical.ErrorResponse("the exporter of role %s is not trusted anymore (sha256:%s)", role.Name, fingerprint), nil
+		}
+
+		err = verifyExportedDataSignature(exporterKey, masheryRoleControlPEMBlockName, blk.Headers[roleDataFormatHeader], fingerprint, blk.Bytes, blk.Headers[roleDataSignatureHeader])
+		if err == errSignatureMismatch {
+			return logical.ErrorResponse("control message signature is invalid: signature does not match the control message"), nil
+		} else if err != nil {
+			return logical.ErrorResponse("control message signature is invalid: %s", err.Error()), nil
+		}
+
+		return nil, nil
+	}
+}
+
+// applyRoleControlMessage applies the verified control message to the role. The revoked role forgets its
+// credentials, and the leases issued for it are revoked.
+func applyRoleControlMessage(msg *RoleControlMessage) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		switch msg.Action {
+		case roleControlActionRevoke:
+			role.Keys.ApiKey = ""
+			role.Keys.KeySecret = ""
+			role.Keys.Username = ""
+			role.Keys.Password = ""
+			role.Keys.Exportable = false
+			role.Keys.Revoked = true
+
+			if err := updateRoleRevocations(ctx, reqCtx, func(rev *StoredRoleRevocations) {
+				rev.RevokeAllIssuedUntil(time.Now())
+				if len(role.Usage.V3Token) > 0 {
+					rev.RevokeV3Token(role.Usage.V3Token, role.Usage.V3TokenExpiry)
+				}
+			}); err != nil {
+				return nil, err
+			}
+			role.Usage.ResetToken()
+		case roleControlActionExtend:
+			if msg.TermExpiry > 0 {
+				role.Usage.ExplicitTerm = msg.TermExpiry
+			}
+			if msg.AddUses > 0 && role.Usage.HasUsageQuota() {
+				role.Usage.ExplicitNumUses += msg.AddUses
+				role.Usage.RemainingNumUses += msg.AddUses
+			}
+		default:
+			return logical.ErrorResponse("unsupported control message action %s", msg.Action), nil
+		}
+
+		role.Keys.AppliedControls = append(role.Keys.AppliedControls, msg.ID)
+		return nil, nil
+	}
+}
+
+func renderAppliedRoleControl(msg *RoleControlMessage) TransformerFunc[RoleContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				roleControlActionField: msg.Action,
+				exportIDField:          msg.ExportID,
+			},
+		}
+
+		if msg.Action == roleControlActionExtend {
+			resp.Data["term"] = role.Usage.ExpiryTimeString()
+			if role.Usage.HasUsageQuota() {
+				resp.Data["usage_remaining"] = role.Usage.RemainingNumUses
+			}
+		}
+
+		return resp, nil
+	}
+}
@@ -0,0 +1,374 @@
+package mashery
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testControlExportID = "0123456789abcdef0123456789abcdef"
+
+func setupTestRoleControlRequest(t *testing.T, rec RoleExportRecord, data map[string]interface{}) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleExportContext]) {
+	role := createRoleWithFilledRoleKeys()
+	data[exportIDField] = testControlExportID
+
+	builder := RoleRequestMockBuilder[RoleExportContext]{
+		container: &RoleExportContainer{
+			RoleContainer: RoleContainer{
+				role: &role,
+			},
+			ExporterIdentityContainer: ExporterIdentityContainer{
+				identity: testExporterIdentity,
+			},
+		},
+		fieldSchema: pathRoleExportControlFields,
+		data:        data,
+	}
+
+	emulStore, reqCtx := builder.Build()
+	exports := StoredRoleExports{
+		Exports: map[string]RoleExportRecord{testControlExportID: rec},
+	}
+	emulStore.On("Get", mock.Anything, roleExportsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleExportsPath(reqCtx), &exports), nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleExportsPath(reqCtx))).Return(nil)
+
+	return emulStore, reqCtx
+}
+
+func decodeStoredRoleExports(t *testing.T, emulStorage *MockedVaultStorageWrapper, path string) StoredRoleExports {
+	rv := StoredRoleExports{}
+	for _, call := range emulStorage.Calls {
+		if call.Method == "Put" {
+			if entry := call.Arguments.Get(1).(*logical.StorageEntry); entry.Key == path {
+				assert.Nil(t, entry.DecodeJSON(&rv))
+			}
+		}
+	}
+
+	return rv
+}
+
+// createTestRoleControlMessage creates the control message for the test export, signed by the test exporter identity.
+func createTestRoleControlMessage(t *testing.T, data map[string]interface{}) string {
+	data[roleControlActionField] = roleControlActionRevoke
+	_, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{NumUses: 10}, data)
+
+	lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	return lr.Data[pemContainerField].(string)
+}
+
+func setupTestImportedRoleForControl(t *testing.T) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleContext]) {
+	emulStore, reqCtx := setupTrustedTestExporter(t)
+	fp, _ := publicKeyFingerprint(&testExporterIdentity.PublicKey)
+
+	role := reqCtx.heap.GetRole()
+	role.Name = "imported"
+	role.Keys = createRoleWithFilledRoleKeys().Keys
+	role.Keys.Imported = true
+	role.Keys.Exportable = true
+	role.Keys.ExportID = testControlExportID
+	role.Keys.ExporterFingerprint = fp
+	role.Usage = StoredRoleUsage{
+		V3Token:          "token",
+		V3TokenExpiry:    time.Now().Add(time.Hour).Unix(),
+		ExplicitNumUses:  10,
+		RemainingNumUses: 3,
+	}
+
+	return emulStore, reqCtx
+}
+
+func TestRenderRoleControlMessage_RevokesExport(t *testing.T) {
+	emulStore, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{Recipient: "Team A"}, map[string]interface{}{
+		roleControlActionField: roleControlActionRevoke,
+	})
+
+	lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, testControlExportID, lr.Data[exportIDField])
+	assert.Equal(t, roleControlActionRevoke, lr.Data[roleControlActionField])
+
+	blk, msg, err := parseRoleControlBlock(lr.Data[pemContainerField].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, testControlExportID, blk.Headers[roleDataExportIDHeader])
+	assert.Equal(t, testControlExportID, msg.ExportID)
+	assert.Equal(t, roleControlActionRevoke, msg.Action)
+	assert.Equal(t, exportIDLength*2, len(msg.ID))
+
+	rec := decodeStoredRoleExports(t, emulStore, roleExportsPath(reqCtx)).Exports[testControlExportID]
+	assert.Equal(t, "Team A", rec.Recipient)
+	assert.True(t, rec.Revoked > 0)
+}
+
+func TestRenderRoleControlMessage_ExtendsExport(t *testing.T) {
+	emulStore, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{NumUses: 10}, map[string]interface{}{
+		roleControlActionField:  roleControlActionExtend,
+		explicitTermField:       "48h",
+		roleControlAddUsesField: 5,
+	})
+
+	lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	blk, msg, err := parseRoleControlBlock(lr.Data[pemContainerField].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", blk.Headers["Added Uses"])
+	assert.Equal(t, int64(5), msg.AddUses)
+	assert.True(t, msg.TermExpiry > time.Now().Add(47*time.Hour).Unix())
+
+	rec := decodeStoredRoleExports(t, emulStore, roleExportsPath(reqCtx)).Exports[testControlExportID]
+	assert.Equal(t, int64(15), rec.NumUses)
+	assert.Equal(t, msg.TermExpiry, rec.TermExpiry)
+	assert.Equal(t, int64(0), rec.Revoked)
+}
+
+func TestRenderRoleControlMessage_CapsExtensionOfImportedRole(t *testing.T) {
+	emulStore, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{NumUses: 10}, map[string]interface{}{
+		roleControlActionField:  roleControlActionExtend,
+		explicitTermField:       "48h",
+		roleControlAddUsesField: 5,
+	})
+
+	role := reqCtx.heap.GetRole()
+	role.Keys.Imported = true
+	role.Usage.ExplicitTerm = time.Now().Add(24 * time.Hour).Unix()
+	role.Usage.ExplicitNumUses = 10
+	role.Usage.RemainingNumUses = 3
+
+	lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	_, msg, err := parseRoleControlBlock(lr.Data[pemContainerField].(string))
+	assert.Nil(t, err)
+	assert.Equal(t, role.Usage.ExplicitTerm, msg.TermExpiry)
+	assert.Equal(t, int64(3), msg.AddUses)
+
+	rec := decodeStoredRoleExports(t, emulStore, roleExportsPath(reqCtx)).Exports[testControlExportID]
+	assert.Equal(t, int64(13), rec.NumUses)
+	assert.Equal(t, role.Usage.ExplicitTerm, rec.TermExpiry)
+}
+
+func TestRenderRoleControlMessage_RejectsExtensionBeyondDepletedRole(t *testing.T) {
+	emulStore, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{NumUses: 10}, map[string]interface{}{
+		roleControlActionField:  roleControlActionExtend,
+		roleControlAddUsesField: 5,
+	})
+
+	role := reqCtx.heap.GetRole()
+	role.Keys.Imported = true
+	role.Usage.ExplicitNumUses = 10
+	role.Usage.RemainingNumUses = 0
+
+	lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "this role has depleted its usage quota", lr.Error().Error())
+	emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestRenderRoleControlMessage_SerializesConcurrentExtensions(t *testing.T) {
+	storage := &logical.InmemStorage{}
+	plugin := &AuthPlugin{vaultStorage: &VaultStorageImpl{}}
+
+	const extensions = 32
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < extensions; i++ {
+		_, reqCtx := setupTestRoleControlRequest(t, RoleExportRecord{}, map[string]interface{}{
+			roleControlActionField:  roleControlActionExtend,
+			roleControlAddUsesField: 1,
+		})
+		reqCtx.request.Storage = storage
+		reqCtx.plugin = plugin
+		if i == 0 {
+			putTestStorageEntry(t, storage, roleExportsPath(reqCtx), &StoredRoleExports{
+				Exports: map[string]RoleExportRecord{testControlExportID: {NumUses: 10}},
+			})
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+			assert.Nil(t, err)
+			assert.False(t, lr.IsError())
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	exports := StoredRoleExports{}
+	readTestStorageEntry(t, storage, "/backendUUID/testRole"+storedRoleExportsPathSuffix, &exports)
+	assert.Equal(t, int64(10+extensions), exports.Exports[testControlExportID].NumUses)
+}
+
+func TestRenderRoleControlMessage_RejectsInvalidRequests(t *testing.T) {
+	cases := []struct {
+		rec  RoleExportRecord
+		data map[string]interface{}
+		msg  string
+	}{
+		{
+			rec:  RoleExportRecord{Revoked: 1},
+			data: map[string]interface{}{roleControlActionField: roleControlActionRevoke},
+			msg:  "export " + testControlExportID + " was revoked on " + formatRecipientKeyTime(1),
+		},
+		{
+			data: map[string]interface{}{roleControlActionField: roleControlActionExtend},
+			msg:  "specify explicit_term or add_uses to extend the export",
+		},
+		{
+			data: map[string]interface{}{roleControlActionField: roleControlActionExtend, roleControlAddUsesField: 5},
+			msg:  "export " + testControlExportID + " grants unlimited uses",
+		},
+		{
+			rec:  RoleExportRecord{NumUses: 10},
+			data: map[string]interface{}{roleControlActionField: roleControlActionExtend, roleControlAddUsesField: -1},
+			msg:  "add_uses must be positive",
+		},
+		{
+			data: map[string]interface{}{roleControlActionField: roleControlActionExtend, explicitTermField: "-1h"},
+			msg:  "explicit_term must lie in the future",
+		},
+	}
+
+	for _, c := range cases {
+		emulStore, reqCtx := setupTestRoleControlRequest(t, c.rec, c.data)
+
+		lr, err := renderRoleControlMessage(context.TODO(), reqCtx)
+		assert.Nil(t, err)
+		assert.True(t, lr.IsError())
+		assert.Equal(t, c.msg, lr.Error().Error())
+		emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+	}
+}
+
+func TestVerifyRoleControlMessage_AcceptsExporterMessage(t *testing.T) {
+	blk, msg, _ := parseRoleControlBlock(createTestRoleControlMessage(t, map[string]interface{}{}))
+	_, reqCtx := setupTestImportedRoleForControl(t)
+
+	lr, err := verifyRoleControlMessage(blk, msg)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+}
+
+func TestVerifyRoleControlMessage_RejectsTamperedMessage(t *testing.T) {
+	blk, msg, _ := parseRoleControlBlock(createTestRoleControlMessage(t, map[string]interface{}{}))
+	blk.Bytes = []byte(`{"mid":"other"}`)
+	_, reqCtx := setupTestImportedRoleForControl(t)
+
+	lr, err := verifyRoleControlMessage(blk, msg)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "control message signature is invalid: signature does not match the control message", lr.Error().Error())
+}
+
+func TestVerifyRoleControlMessage_RejectsMismatchingRoles(t *testing.T) {
+	blk, msg, _ := parseRoleControlBlock(createTestRoleControlMessage(t, map[string]interface{}{}))
+
+	cases := []struct {
+		modify func(role *StoredRole)
+		msg    string
+	}{
+		{
+			modify: func(role *StoredRole) { role.Keys.Imported = false },
+			msg:    "role imported is not imported",
+		},
+		{
+			modify: func(role *StoredRole) { role.Keys.Revoked = true },
+			msg:    "role imported was revoked by its exporter",
+		},
+		{
+			modify: func(role *StoredRole) { role.Keys.ExportID = "other" },
+			msg:    "control message concerns export " + testControlExportID + ", while the role was imported from export other",
+		},
+		{
+			modify: func(role *StoredRole) { role.Keys.AppliedControls = []string{msg.ID} },
+			msg:    "control message " + msg.ID + " was already applied",
+		},
+		{
+			modify: func(role *StoredRole) { role.Keys.ExporterFingerprint = "" },
+			msg:    "the exporter of role imported is not verified; control messages cannot be applied",
+		},
+		{
+			modify: func(role *StoredRole) { role.Keys.ExporterFingerprint = "00" },
+			msg:    "control message is not signed by the exporter of role imported",
+		},
+	}
+
+	for _, c := range cases {
+		_, reqCtx := setupTestImportedRoleForControl(t)
+		c.modify(reqCtx.heap.GetRole())
+
+		lr, err := verifyRoleControlMessage(blk, msg)(context.TODO(), reqCtx)
+		assert.Nil(t, err)
+		assert.True(t, lr.IsError())
+		assert.Equal(t, c.msg, lr.Error().Error())
+	}
+}
+
+func TestApplyRoleControlMessage_RevokesRole(t *testing.T) {
+	_, msg, _ := parseRoleControlBlock(createTestRoleControlMessage(t, map[string]interface{}{}))
+	emulStore, reqCtx := setupTestImportedRoleForControl(t)
+	emulStore.On("Get", mock.Anything, roleRevocationsPath(reqCtx)).Return(nil, nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleRevocationsPath(reqCtx))).Return(nil)
+
+	lr, err := applyRoleControlMessage(msg)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	assert.True(t, role.Keys.Revoked)
+	assert.False(t, role.Keys.Exportable)
+	assert.Equal(t, "", role.Keys.ApiKey)
+	assert.Equal(t, "", role.Keys.KeySecret)
+	assert.Equal(t, "", role.Keys.Username)
+	assert.Equal(t, "", role.Keys.Password)
+	assert.Equal(t, "", role.Usage.V3Token)
+	assert.Equal(t, []string{msg.ID}, role.Keys.AppliedControls)
+
+	rev := decodeStoredRevocations(t, emulStore, roleRevocationsPath(reqCtx))
+	assert.True(t, rev.V3TokenRevoked("token"))
+	assert.True(t, rev.IssuedBeforeRevocation(time.Now().Add(-time.Minute)))
+
+	lr, _ = blockUsageExceedingLimits(context.TODO(), reqCtx)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "this role was revoked by its exporter", lr.Error().Error())
+}
+
+func TestApplyRoleControlMessage_ExtendsRole(t *testing.T) {
+	term := time.Now().Add(48 * time.Hour).Unix()
+	msg := &RoleControlMessage{ID: "m1", ExportID: testControlExportID, Action: roleControlActionExtend, TermExpiry: term, AddUses: 5}
+	_, reqCtx := setupTestImportedRoleForControl(t)
+
+	lr, err := applyRoleControlMessage(msg)(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	role := reqCtx.heap.GetRole()
+	assert.False(t, role.Keys.Revoked)
+	assert.Equal(t, "token", role.Usage.V3Token)
+	assert.Equal(t, term, role.Usage.ExplicitTerm)
+	assert.Equal(t, int64(15), role.Usage.ExplicitNumUses)
+	assert.Equal(t, int64(8), role.Usage.RemainingNumUses)
+	assert.Equal(t, []string{"m1"}, role.Keys.AppliedControls)
+}
+
+func TestParseRoleControlBlock_RejectsOtherBlocks(t *testing.T) {
+	_, _, err := parseRoleControlBlock(string(pem.EncodeToMemory(&pem.Block{Type: masheryRoleDataPEMBlockName, Bytes: []byte("{}")})))
+	assert.NotNil(t, err)
+	assert.Equal(t, "unexpected PEM block type "+masheryRoleDataPEMBlockName, err.Error())
+}
@@ -0,0 +1,132 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"sort"
+	"time"
+)
+
+func roleExportsPath[T any](reqCtx *RequestHandlerContext[T]) string {
+	return reqCtx.storagePath + storedRoleExportsPathSuffix
+}
+
+func readRoleExports[T any](ctx context.Context, reqCtx *RequestHandlerContext[T]) (StoredRoleExports, error) {
+	rv := StoredRoleExports{}
+	_, err := reqCtx.ReadPath(ctx, roleExportsPath(reqCtx), &rv)
+	return rv, err
+}
+
+// updateRoleExports applies the modification to the export ledger of the role. The ledger is read and written under
+// the lock; the modification returning a response or an error leaves the ledger unchanged.
+func updateRoleExports[T any](ctx context.Context, reqCtx *RequestHandlerContext[T], f func(exp *StoredRoleExports) (*logical.Response, error)) (*logical.Response, error) {
+	reqCtx.plugin.roleExportsLock.Lock()
+	defer reqCtx.plugin.roleExportsLock.Unlock()
+
+	exp, err := readRoleExports(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp, err := f(&exp); resp != nil || err != nil {
+		return resp, err
+	}
+	return nil, reqCtx.WritePath(ctx, roleExportsPath(reqCtx), &exp)
+}
+
+// recordRoleExports records the exports made by the rendering transformer in the export ledger of the role. The
+// exports are recorded only if the role data was actually rendered.
+func recordRoleExports(render TransformerFunc[RoleExportContext]) TransformerFunc[RoleExportContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleExportContext]) (*logical.Response, error) {
+		resp, err := render(ctx, reqCtx)
+		if err != nil || resp == nil || resp.IsError() || len(reqCtx.heap.GetExportRecords()) == 0 {
+			return resp, err
+		}
+
+		if _, err = updateRoleExports(ctx, reqCtx, func(exp *StoredRoleExports) (*logical.Response, error) {
+			if exp.Exports == nil {
+				exp.Exports = map[string]RoleExportRecord{}
+			}
+			for id, rec := range reqCtx.heap.GetExportRecords() {
+				exp.Exports[id] = rec
+			}
+			return nil, nil
+		}); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+func renderRoleExportsList(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	exp, err := readRoleExports(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(exp.Exports))
+	info := map[string]interface{}{}
+	for id, rec := range exp.Exports {
+		ids = append(ids, id)
+		info[id] = map[string]interface{}{
+			"recipient": rec.Recipient,
+			"exported":  formatRecipientKeyTime(rec.Exported),
+		}
+	}
+
+	// The oldest exports are listed first
+	sort.Slice(ids, func(i, j int) bool {
+		return exp.Exports[ids[i]].Exported < exp.Exports[ids[j]].Exported
+	})
+
+	return logical.ListResponseWithInfo(ids, info), nil
+}
+
+func renderRoleExport(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	exp, err := readRoleExports(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	exportID := reqCtx.data.Get(exportIDField).(string)
+	rec, ok := exp.Exports[exportID]
+	if !ok {
+		return nil, nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			exportIDField:           exportID,
+			"exported":              formatRecipientKeyTime(rec.Exported),
+			"recipient":             rec.Recipient,
+			"recipient_cert_sha256": rec.RecipientFingerprint,
+			"explicit_term":         "∞",
+			explicitNumUsesField:    rec.NumUses,
+			"max_qps":               rec.MaxQPS,
+			"v2_capable":            rec.V2Capable,
+			"v3_capable":            rec.V3Capable,
+			forceProxyModeField:     rec.ForceProxyMode,
+			exportableField:         rec.Exportable,
+			delegationDepthField:    rec.DelegationDepth,
+			"restricted_scope":      rec.RestrictedScope,
+			"entity_id":             rec.EntityID,
+			"requested_by":          rec.RequestedBy,
+		},
+	}
+
+	if rec.TermExpiry > 0 {
+		resp.Data["explicit_term"] = time.Unix(rec.TermExpiry, 0).UTC().Format(time.RFC3339)
+	}
+	if rec.ImportBefore > 0 {
+		resp.Data[importBeforeField] = time.Unix(rec.ImportBefore, 0).UTC().Format(time.RFC3339)
+	}
+	if len(rec.RecipientRole) > 0 {
+		resp.Data[recipientRoleField] = rec.RecipientRole
+	}
+	if rec.Revoked > 0 {
+		resp.Data["revoked"] = formatRecipientKeyTime(rec.Revoked)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,146 @@
+package mashery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+// setupTestRoleExportRequest prepares the export of the role to the certificate of a role having a P-256 key
+func setupTestRoleExportRequest(t *testing.T, exportData map[string]interface{}) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleExportContext]) {
+	key, _ := generateRecipientKey(recipientKeyTypeP256)
+	sourceReq := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{PrivateKey: key},
+		},
+		data: map[string]interface{}{
+			pemCommonNameField: "Team A",
+		},
+		fieldSchema: pathRolePemReadFields,
+	}
+	_, rolePEMReadRequest := sourceReq.Build()
+	lr, _ := renderRoleCertificate(nil, rolePEMReadRequest)
+
+	role := createRoleWithFilledRoleKeys()
+	exportData[pemContainerField] = lr.Data[pemContainerField]
+	exportReq := RoleRequestMockBuilder[RoleExportContext]{
+		container: &RoleExportContainer{
+			RoleContainer: RoleContainer{
+				role: &role,
+			},
+			ExporterIdentityContainer: ExporterIdentityContainer{
+				identity: testExporterIdentity,
+			},
+		},
+		fieldSchema: pathRoleExportFields,
+		data:        exportData,
+	}
+
+	emulStore, reqCtx := exportReq.Build()
+	reqCtx.request.EntityID = "entity-1"
+	reqCtx.request.DisplayName = "token-admin"
+
+	lr, err := readRecipientCertificate(nil, reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	return emulStore, reqCtx
+}
+
+func TestRenderEncryptedRoleData_CarriesExportRecord(t *testing.T) {
+	_, reqCtx := setupTestRoleExportRequest(t, map[string]interface{}{
+		explicitNumUsesField: 10,
+		forceProxyModeField:  true,
+	})
+
+	lr, err := renderEncryptedRoleData(nil, reqCtx)
+	assert.Nil(t, err)
+
+	exportID := lr.Data[exportIDField].(string)
+	rec, ok := reqCtx.heap.GetExportRecords()[exportID]
+	assert.True(t, ok)
+
+	certBlock, _ := pem.Decode([]byte(reqCtx.data.Get(pemContainerField).(string)))
+	certFingerprint := sha256.Sum256(certBlock.Bytes)
+
+	assert.Equal(t, "Team A", rec.Recipient)
+	assert.Equal(t, hex.EncodeToString(certFingerprint[:]), rec.RecipientFingerprint)
+	assert.Equal(t, int64(10), rec.NumUses)
+	assert.Equal(t, 34, rec.MaxQPS)
+	assert.True(t, rec.ForceProxyMode)
+	assert.True(t, rec.V2Capable)
+	assert.True(t, rec.V3Capable)
+	assert.Equal(t, "entity-1", rec.EntityID)
+	assert.Equal(t, "token-admin", rec.RequestedBy)
+	assert.True(t, rec.Exported > 0)
+}
+
+func TestRecordRoleExports_SavesRenderedExports(t *testing.T) {
+	emulStore, reqCtx := setupTestRoleExportRequest(t, map[string]interface{}{})
+
+	existing := StoredRoleExports{Exports: map[string]RoleExportRecord{
+		"earlier": {Recipient: "Team B", Exported: 1},
+	}}
+	emulStore.On("Get", mock.Anything, roleExportsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleExportsPath(reqCtx), &existing), nil)
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleExportsPath(reqCtx))).Return(nil)
+
+	lr, err := recordRoleExports(renderEncryptedRoleData)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	emulStore.AssertExpectations(t)
+
+	stored := StoredRoleExports{}
+	for _, call := range emulStore.Calls {
+		if call.Method == "Put" {
+			assert.Nil(t, call.Arguments.Get(1).(*logical.StorageEntry).DecodeJSON(&stored))
+		}
+	}
+
+	assert.Equal(t, 2, len(stored.Exports))
+	assert.Equal(t, "Team B", stored.Exports["earlier"].Recipient)
+	assert.Equal(t, "Team A", stored.Exports[lr.Data[exportIDField].(string)].Recipient)
+}
+
+func TestRecordRoleExports_SkipsFailedExports(t *testing.T) {
+	_, reqCtx := setupTestRoleExportRequest(t, map[string]interface{}{
+		explicitTermField: "garbage time",
+	})
+
+	// The storage is not accessed
+	lr, err := recordRoleExports(renderEncryptedRoleData)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+}
+
+func TestRenderRoleExports(t *testing.T) {
+	emulStore, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{
+		exportIDField: "second",
+	}, pathRoleExportsFields)
+
+	stored := StoredRoleExports{Exports: map[string]RoleExportRecord{
+		"second": {Recipient: "Team B", Exported: 1643144321, NumUses: 5, RecipientRole: "team-b", EntityID: "entity-1"},
+		"first":  {Recipient: "Team A", Exported: 1643144000},
+	}}
+	emulStore.On("Get", mock.Anything, roleExportsPath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleExportsPath(reqCtx), &stored), nil)
+
+	lr, err := renderRoleExportsList(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, lr.Data["keys"])
+	assert.Equal(t, "Team B", lr.Data["key_info"].(map[string]interface{})["second"].(map[string]interface{})["recipient"])
+
+	lr, err = renderRoleExport(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "Team B", lr.Data["recipient"])
+	assert.Equal(t, "2022-01-25T20:58:41Z", lr.Data["exported"])
+	assert.Equal(t, int64(5), lr.Data[explicitNumUsesField])
+	assert.Equal(t, "team-b", lr.Data[recipientRoleField])
+	assert.Equal(t, "∞", lr.Data["explicit_term"])
+	assert.Equal(t, "entity-1", lr.Data["entity_id"])
+}
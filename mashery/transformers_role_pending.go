@@ -0,0 +1,121 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"time"
+)
+
+// readPendingRoleKeys reads the credentials staged for the role, if any.
+func readPendingRoleKeys[T RoleContext](mustBePresent bool) TransformerFunc[T] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		pending := PendingRoleKeys{}
+		if found, err := reqCtx.ReadPath(ctx, rolePendingKeysPath(reqCtx), &pending); err != nil {
+			return nil, err
+		} else if found {
+			role.PendingKeys = &pending
+		} else if mustBePresent {
+			return logical.ErrorResponse("role %s has no pending keys", role.Name), nil
+		}
+
+		return nil, nil
+	}
+}
+
+// stagePendingRoleKeysFromRequest stages the credentials supplied in the request. The keys staged earlier are
+// replaced.
+func stagePendingRoleKeysFromRequest(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	data := reqCtx.data
+	pending := PendingRoleKeys{}
+
+	if areaIdRaw, ok := data.GetOk(roleAreaIdField); ok {
+		pending.AreaId = areaIdRaw.(string)
+	}
+	if areaNidRaw, ok := data.GetOk(roleAreaNidField); ok {
+		pending.AreaNid = areaNidRaw.(int)
+	}
+	if apiKeyRaw, ok := data.GetOk(roleApiKeField); ok {
+		pending.ApiKey = apiKeyRaw.(string)
+	}
+	if keySecretRaw, ok := data.GetOk(roleSecretField); ok {
+		pending.KeySecret = keySecretRaw.(string)
+	}
+	if usernameRaw, ok := data.GetOk(roleUsernameField); ok {
+		pending.Username = usernameRaw.(string)
+	}
+	if passwordRaw, ok := data.GetOk(rolePasswordField); ok {
+		pending.Password = passwordRaw.(string)
+	}
+
+	if len(pending.StagedFields()) == 0 {
+		return logical.ErrorResponse("no credentials to stage; supply at least one of %s, %s, %s, %s, %s, or %s",
+			roleAreaIdField, roleAreaNidField, roleApiKeField, roleSecretField, roleUsernameField, rolePasswordField), nil
+	}
+
+	pending.Staged = time.Now().Unix()
+	reqCtx.heap.GetRole().PendingKeys = &pending
+	return nil, nil
+}
+
+func savePendingRoleKeys(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	err := reqCtx.WritePath(ctx, rolePendingKeysPath(reqCtx), reqCtx.heap.GetRole().PendingKeys)
+	return nil, err
+}
+
+func deletePendingRoleKeys(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	err := reqCtx.request.Storage.Delete(ctx, rolePendingKeysPath(reqCtx))
+	return nil, err
+}
+
+// applyPendingRoleKeys makes the pending keys the active keys of the role. The keys are not saved yet, so that the
+// chain can verify these first.
+func applyPendingRoleKeys(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	role.PendingKeys.ApplyTo(&role.Keys)
+	if len(role.PendingKeys.Password) > 0 {
+		role.Keys.PasswordChanged = time.Now().Unix()
+	}
+	if len(role.PendingKeys.KeySecret) > 0 {
+		role.Keys.SecretChanged = time.Now().Unix()
+	}
+
+	return nil, nil
+}
+
+func renderPendingRoleKeys(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	candidate := role.Keys
+	role.PendingKeys.ApplyTo(&candidate)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"staged":     time.Unix(role.PendingKeys.Staged, 0).UTC().Format(time.RFC3339),
+			"fields":     role.PendingKeys.StagedFields(),
+			"v2_capable": candidate.IsV2Capable(),
+			"v3_capable": candidate.IsV3Capable(),
+		},
+	}, nil
+}
+
+// renderPromotedRoleKeys renders the fields that were promoted and, if the keys were verified before the promotion,
+// the outcome of the verification.
+func renderPromotedRoleKeys(report *CredentialVerification) TransformerFunc[RoleContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"promoted":   role.PendingKeys.StagedFields(),
+				"v2_capable": role.Keys.IsV2Capable(),
+				"v3_capable": role.Keys.IsV3Capable(),
+			},
+		}
+		if report != nil {
+			resp.Data["verification"] = report.asMap()
+		}
+
+		return resp, nil
+	}
+}
@@ -0,0 +1,243 @@
+package mashery
+
+import (
+	"context"
+	v3client "github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const testPendingRolePath = "mnt/role/testRole"
+
+func setupPendingKeysTestPlugin(t *testing.T, keys RoleKeys, usage StoredRoleUsage) (*AuthPlugin, logical.Storage) {
+	storage := &logical.InmemStorage{}
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+
+	plugin := &AuthPlugin{
+		Backend:        &framework.Backend{},
+		backendUUID:    "mnt",
+		vaultStorage:   &VaultStorageImpl{},
+		v2Clients:      newV2ClientPool(),
+		v3Clients:      newV3ClientPool(),
+		v3OAuthHelpers: map[string]*v3client.V3OAuthHelper{},
+	}
+
+	return plugin, storage
+}
+
+func pendingKeysTestRequest(storage logical.Storage, data map[string]interface{}, schema map[string]*framework.FieldSchema) (*logical.Request, *framework.FieldData) {
+	actualData := map[string]interface{}{
+		roleName: "testRole",
+	}
+	for k, v := range data {
+		actualData[k] = v
+	}
+
+	return &logical.Request{Storage: storage, Data: actualData}, &framework.FieldData{Raw: actualData, Schema: schema}
+}
+
+func readTestStorageEntry(t *testing.T, storage logical.Storage, path string, obj interface{}) bool {
+	se, err := storage.Get(context.TODO(), path)
+	assert.Nil(t, err)
+	if se == nil {
+		return false
+	}
+
+	assert.Nil(t, se.DecodeJSON(obj))
+	return true
+}
+
+func testPendingActiveKeys() RoleKeys {
+	return RoleKeys{
+		AreaId:    "area-uuid",
+		AreaNid:   123,
+		ApiKey:    "key",
+		KeySecret: "secret",
+		Username:  "user",
+		Password:  "old-pwd",
+		MaxQPS:    10,
+	}
+}
+
+func TestStagePendingRoleKeys_KeepsActiveKeys(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{V3Token: "old-token"})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		rolePasswordField: "pwd",
+	}, pathRolePendingKeysFields)
+
+	lr, err := b.stagePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{rolePasswordField}, lr.Data["fields"])
+	assert.Equal(t, true, lr.Data["v3_capable"])
+
+	pending := PendingRoleKeys{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &pending))
+	assert.Equal(t, "pwd", pending.Password)
+	assert.True(t, pending.Staged > 0)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-pwd", active.Password)
+}
+
+func TestStagePendingRoleKeys_RequiresCredentials(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePendingKeysFields)
+
+	lr, err := b.stagePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "no credentials to stage; supply at least one of area_id, area_nid, api_key, secret, username, or password", lr.Error().Error())
+}
+
+func TestStagePendingRoleKeys_RejectsImportedRole(t *testing.T) {
+	keys := testPendingActiveKeys()
+	keys.Imported = true
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		rolePasswordField: "pwd",
+	}, pathRolePendingKeysFields)
+
+	lr, err := b.stagePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "operation is not permitted on an imported role", lr.Error().Error())
+}
+
+func TestPromotePendingRoleKeys_SwapsKeysAndResetsToken(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{V3Token: "old-token", V3TokenExpiry: 1})
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{
+		KeySecret: "new-secret",
+		Password:  "pwd",
+		Staged:    1,
+	})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePendingKeysPromote(b).Fields)
+
+	lr, err := b.promotePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{roleSecretField, rolePasswordField}, lr.Data["promoted"])
+	assert.Nil(t, lr.Data["verification"])
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "new-secret", active.KeySecret)
+	assert.Equal(t, "pwd", active.Password)
+	assert.Equal(t, "key", active.ApiKey)
+	assert.Equal(t, 10, active.MaxQPS)
+
+	usage := StoredRoleUsage{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+	assert.Equal(t, "", usage.V3Token)
+
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestPromotePendingRoleKeys_KeepsActiveKeysOnFailedVerification(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	keys := testPendingActiveKeys()
+	keys.OAuthTokenEndpoint = srv.URL + "/token"
+	keys.V2Endpoint = srv.URL + "/v2"
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{V3Token: "old-token"})
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{
+		Password: "typo",
+		Staged:   1,
+	})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		verifyField: true,
+	}, pathRolePendingKeysPromote(b).Fields)
+
+	lr, err := b.promotePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, 1, len(lr.Warnings))
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-pwd", active.Password)
+
+	usage := StoredRoleUsage{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+	assert.Equal(t, "old-token", usage.V3Token)
+
+	pending := PendingRoleKeys{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &pending))
+	assert.Equal(t, "typo", pending.Password)
+}
+
+func TestPromotePendingRoleKeys_PromotesVerifiedKeys(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	keys := testPendingActiveKeys()
+	keys.OAuthTokenEndpoint = srv.URL + "/token"
+	keys.V2Endpoint = srv.URL + "/v2"
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{})
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{
+		Password: "pwd",
+		Staged:   1,
+	})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		verifyField: true,
+	}, pathRolePendingKeysPromote(b).Fields)
+
+	lr, err := b.promotePendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, true, lr.Data["verification"].(map[string]interface{})["verified"])
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "pwd", active.Password)
+}
+
+func TestAbortPendingRoleKeys(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{
+		Password: "pwd",
+		Staged:   1,
+	})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRolePendingKeysAbort(b).Fields)
+
+	lr, err := b.abortPendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+
+	lr, err = b.abortPendingRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "role testRole has no pending keys", lr.Error().Error())
+}
+
+func TestUpdateRoleData_RefusedWhileKeysArePending(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{
+		Password: "pwd",
+		Staged:   1,
+	})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		roleUsernameField: "other-user",
+	}, pathRoleFields)
+
+	lr, err := b.handleUpdateRoleData(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "role testRole has pending keys; promote or abort these first", lr.Error().Error())
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "user", active.Username)
+}
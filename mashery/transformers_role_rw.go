@@ -13,6 +13,12 @@ const (
 	storedRoleKeyPathSuffix        = "/key"
 	storedRolePrivateKeyPathSuffix = "/pk"
 	storedRoleUsageKeyPathSuffix   = "/usage"
+
+	storedRoleRevocationsPathSuffix   = "/revocations"
+	storedRoleRecipientKeysPathSuffix = "/pk-history"
+	storedRoleExportsPathSuffix       = "/exports"
+	storedRolePendingKeysPathSuffix   = "/pending"
+	storedRoleVersionsPathSuffix      = "/versions"
 )
 
 func roleKeysPath[T any](reqCtx *RequestHandlerContext[T]) string {
@@ -27,6 +33,14 @@ func rolePrivateKeyPath[T any](reqCtx *RequestHandlerContext[T]) string {
 	return reqCtx.storagePath + storedRolePrivateKeyPathSuffix
 }
 
+func roleRecipientKeysPath[T any](reqCtx *RequestHandlerContext[T]) string {
+	return reqCtx.storagePath + storedRoleRecipientKeysPathSuffix
+}
+
+func rolePendingKeysPath[T any](reqCtx *RequestHandlerContext[T]) string {
+	return reqCtx.storagePath + storedRolePendingKeysPathSuffix
+}
+
 func readRoleDo[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T], requireRole bool) (*logical.Response, error) {
 	sr := reqCtx.plugin.InitialRole(reqCtx.data)
 
@@ -73,18 +87,61 @@ func updateRoleKeysFromRequest(_ context.Context, reqCtx *RequestHandlerContext[
 	}
 	if keySecretRaw, ok := data.GetOk(roleSecretField); ok {
 		retVal.KeySecret = keySecretRaw.(string)
+		retVal.SecretChanged = time.Now().Unix()
 	}
 	if usernameRaw, ok := data.GetOk(roleUsernameField); ok {
 		retVal.Username = usernameRaw.(string)
 	}
 	if passwordRaw, ok := data.GetOk(rolePasswordField); ok {
 		retVal.Password = passwordRaw.(string)
+		retVal.PasswordChanged = time.Now().Unix()
+	}
+
+	if err := copyRotationPeriodFieldIfDefined(data, passwordRotationPeriodField, minPasswordRotationPeriod, &retVal.PasswordRotationPeriod, &retVal.PasswordChanged); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if err := copyRotationPeriodFieldIfDefined(data, secretRotationPeriodField, minSecretRotationPeriod, &retVal.SecretRotationPeriod, &retVal.SecretChanged); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
 	if secretQpsRaw, ok := data.GetOk(roleQpsField); ok {
 		retVal.MaxQPS = secretQpsRaw.(int)
 	}
 
+	if err := copyEndpointFieldIfDefined(data, roleV3EndpointField, &retVal.V3Endpoint); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleV3EndpointField, err.Error()), nil
+	}
+	if err := copyEndpointFieldIfDefined(data, roleOAuthTokenEndpointField, &retVal.OAuthTokenEndpoint); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleOAuthTokenEndpointField, err.Error()), nil
+	}
+	if err := copyEndpointFieldIfDefined(data, roleV2EndpointField, &retVal.V2Endpoint); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleV2EndpointField, err.Error()), nil
+	}
+
+	copyDurationFieldIfDefined(data, roleDefaultTTLField, &retVal.LeaseDefaultTTL)
+	copyDurationFieldIfDefined(data, roleMaxTTLField, &retVal.LeaseMaxTTL)
+	copyDurationFieldIfDefined(data, roleRenewIncrementField, &retVal.LeaseRenewIncrement)
+
+	if err := copyRecipientKeyTypeFieldIfDefined(data, roleRecipientKeyTypeField, &retVal.RecipientKeyType); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleRecipientKeyTypeField, err.Error()), nil
+	}
+
+	if err := copyScopeFieldIfDefined(data, roleScopeAllowField, &retVal.AllowedScope); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleScopeAllowField, err.Error()), nil
+	}
+	if err := copyScopeFieldIfDefined(data, roleScopeDenyField, &retVal.DeniedScope); err != nil {
+		return logical.ErrorResponse("invalid %s: %s", roleScopeDenyField, err.Error()), nil
+	}
+
+	shape := retVal.EffectiveLeaseShape(reqCtx.plugin.config())
+	if err := shape.Validate(); err != nil {
+		return logical.ErrorResponse("invalid lease settings: %s", err.Error()), nil
+	}
+	if retVal.LeaseMaxTTL > 0 && role.Usage.AfterExpiryTerm(time.Now().Add(shape.MaxTTL)) {
+		return logical.ErrorResponse("invalid lease settings: %s (%s) exceeds the term of this role (%s)",
+			roleMaxTTLField, shape.MaxTTL, role.Usage.ExpiryTimeString()), nil
+	}
+
 	return nil, nil
 }
 
@@ -104,11 +161,23 @@ func blockOperationOnForceProxyRole[T RoleContext](_ context.Context, reqCtx *Re
 	}
 }
 
+// blockOperationOnScopedRole blocks handing out the credentials of the role that restricts its scope, as these
+// credentials would allow the recipient to escape the scope.
+func blockOperationOnScopedRole[T RoleContext](_ context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	if reqCtx.heap.GetRole().Keys.HasScope() {
+		return logical.ErrorResponse("operation is not permitted as this role restricts its scope; use proxy mode"), nil
+	} else {
+		return nil, nil
+	}
+}
+
 func blockUsageExceedingLimits[T RoleContext](_ context.Context,
 	reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
 
 	role := reqCtx.heap.GetRole()
-	if role.Usage.Expired() {
+	if role.Keys.Revoked {
+		return logical.ErrorResponse("this role was revoked by its exporter"), nil
+	} else if role.Usage.Expired() {
 		return logical.ErrorResponse("this role has expired (granted until %s)", role.Usage.ExpiryTimeString()), nil
 	} else if role.Usage.Depleted() {
 		return logical.ErrorResponse("this role has depleted its usage quota"), nil
@@ -185,6 +254,13 @@ func saveRoleKeys[T RoleContext](ctx context.Context, reqCtx *RequestHandlerCont
 	return nil, err
 }
 
+// evictPooledRoleClients closes the pooled clients of the role, so that these will be re-created from the
+// updated role data.
+func evictPooledRoleClients[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	reqCtx.plugin.evictRoleClients(ctx, reqCtx.heap.GetRole().Name)
+	return nil, nil
+}
+
 func setInitialRoleUsage[T RoleContext](_ context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
 	role := reqCtx.heap.GetRole()
 
@@ -271,5 +347,100 @@ func renderRole(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (
 		},
 	}
 
+	if len(role.Keys.V3Endpoint) > 0 {
+		resp.Data[roleV3EndpointField] = role.Keys.V3Endpoint
+	}
+	if len(role.Keys.OAuthTokenEndpoint) > 0 {
+		resp.Data[roleOAuthTokenEndpointField] = role.Keys.OAuthTokenEndpoint
+	}
+	if len(role.Keys.V2Endpoint) > 0 {
+		resp.Data[roleV2EndpointField] = role.Keys.V2Endpoint
+	}
+	if role.Keys.LeaseDefaultTTL > 0 {
+		resp.Data[roleDefaultTTLField] = formatLeaseDuration(role.Keys.LeaseDefaultTTL)
+	}
+	if role.Keys.LeaseMaxTTL > 0 {
+		resp.Data[roleMaxTTLField] = formatLeaseDuration(role.Keys.LeaseMaxTTL)
+	}
+	if role.Keys.LeaseRenewIncrement > 0 {
+		resp.Data[roleRenewIncrementField] = formatLeaseDuration(role.Keys.LeaseRenewIncrement)
+	}
+	if len(role.Keys.RecipientKeyType) > 0 {
+		resp.Data[roleRecipientKeyTypeField] = role.Keys.RecipientKeyType
+	}
+	if role.Keys.PasswordRotationPeriod > 0 {
+		resp.Data[passwordRotationPeriodField] = formatLeaseDuration(role.Keys.PasswordRotationPeriod)
+	}
+	if role.Keys.PasswordChanged > 0 {
+		resp.Data["password_changed"] = time.Unix(role.Keys.PasswordChanged, 0).UTC().Format(time.RFC3339)
+	}
+	if role.Keys.SecretRotationPeriod > 0 {
+		resp.Data[secretRotationPeriodField] = formatLeaseDuration(role.Keys.SecretRotationPeriod)
+	}
+	if role.Keys.SecretChanged > 0 {
+		resp.Data["secret_changed"] = time.Unix(role.Keys.SecretChanged, 0).UTC().Format(time.RFC3339)
+	}
+	// The failure is shown until the credential is changed
+	if role.Keys.PasswordRotationFailed > role.Keys.PasswordChanged {
+		resp.Data["password_rotation_failed"] = time.Unix(role.Keys.PasswordRotationFailed, 0).UTC().Format(time.RFC3339)
+	}
+	if role.Keys.SecretRotationFailed > role.Keys.SecretChanged {
+		resp.Data["secret_rotation_failed"] = time.Unix(role.Keys.SecretRotationFailed, 0).UTC().Format(time.RFC3339)
+	}
+	if len(role.Keys.AllowedScope) > 0 {
+		resp.Data[roleScopeAllowField] = role.Keys.AllowedScope
+	}
+	if len(role.Keys.DeniedScope) > 0 {
+		resp.Data[roleScopeDenyField] = role.Keys.DeniedScope
+	}
+	if len(role.Keys.InheritedScope) > 0 {
+		resp.Data["inherited_scope"] = renderInheritedScope(role.Keys.InheritedScope)
+	}
+	if role.PendingKeys != nil {
+		resp.Data["pending_keys_staged"] = time.Unix(role.PendingKeys.Staged, 0).UTC().Format(time.RFC3339)
+	}
+	if role.Keys.Imported {
+		if len(role.Keys.Exporter) > 0 {
+			resp.Data["exporter"] = role.Keys.Exporter
+		} else {
+			resp.Data["exporter"] = "unverified"
+		}
+		if len(role.Keys.ExportID) > 0 {
+			resp.Data["export_id"] = role.Keys.ExportID
+		}
+		resp.Data["revoked"] = role.Keys.Revoked
+		resp.Data[delegationDepthField] = role.Keys.RemainingDelegationDepth()
+		if len(role.Keys.Provenance) > 0 {
+			resp.Data["provenance"] = renderProvenance(role.Keys.Provenance)
+		}
+	}
+
 	return resp, nil
 }
+
+// renderProvenance renders the chain of exports the role data was delegated through, the original export first
+func renderProvenance(chain []ProvenanceLink) []map[string]interface{} {
+	rv := make([]map[string]interface{}, len(chain))
+	for i, link := range chain {
+		rv[i] = map[string]interface{}{
+			"exporter":           "sha256:" + link.Exporter,
+			exportIDField:        link.ExportID,
+			"exported":           time.Unix(link.Exported, 0).UTC().Format(time.RFC3339),
+			delegationDepthField: link.DelegationDepth,
+		}
+	}
+
+	return rv
+}
+
+func renderInheritedScope(scopes []RoleScope) []map[string]interface{} {
+	var rv []map[string]interface{}
+	for _, sc := range scopes {
+		rv = append(rv, map[string]interface{}{
+			"allow": sc.Allow,
+			"deny":  sc.Deny,
+		})
+	}
+
+	return rv
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestRoleKeysPath(t *testing.T) {
@@ -193,6 +194,111 @@ func TestUpdateRoleKeysFromRequest(t *testing.T) {
 	assert.Equal(t, 15, sr.Keys.MaxQPS)
 }
 
+func TestUpdateRoleKeysFromRequest_Endpoints(t *testing.T) {
+	mockBuilder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{
+				Keys: RoleKeys{
+					V2Endpoint: "https://previous/v2/json-rpc",
+				},
+			},
+		},
+		data: map[string]interface{}{
+			roleV3EndpointField: "http://localhost:8080/v3/rest",
+			roleV2EndpointField: "",
+		},
+		fieldSchema: pathRoleFields,
+	}
+
+	reqCtx := mockBuilder.Request()
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	sr := reqCtx.heap.GetRole()
+	assert.Equal(t, "http://localhost:8080/v3/rest", sr.Keys.V3Endpoint)
+	assert.Empty(t, sr.Keys.OAuthTokenEndpoint)
+	assert.Empty(t, sr.Keys.V2Endpoint)
+
+	mockBuilder.data = map[string]interface{}{
+		roleOAuthTokenEndpointField: "localhost",
+	}
+	reqCtx = mockBuilder.Request()
+	lr, err = updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.NotNil(t, lr)
+	assert.Equal(t, "invalid oauth_token_endpoint: endpoint URL localhost must use http or https scheme", lr.Error().Error())
+}
+
+func TestUpdateRoleKeysFromRequest_LeaseSettings(t *testing.T) {
+	mockBuilder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{},
+		},
+		data: map[string]interface{}{
+			roleDefaultTTLField:     "10m",
+			roleMaxTTLField:         "8h",
+			roleRenewIncrementField: 1800,
+		},
+		fieldSchema: pathRoleFields,
+	}
+
+	reqCtx := mockBuilder.Request()
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	shape := reqCtx.heap.GetRole().Keys.EffectiveLeaseShape(&reqCtx.plugin.cfg)
+	assert.Equal(t, time.Minute*10, shape.DefaultTTL)
+	assert.Equal(t, time.Hour*8, shape.MaxTTL)
+	assert.Equal(t, time.Minute*30, shape.RenewIncrement)
+
+	mockBuilder.data = map[string]interface{}{
+		roleDefaultTTLField: "2h",
+	}
+	reqCtx = mockBuilder.Request()
+	lr, err = updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "invalid lease settings: default_ttl (2h0m0s) exceeds the lifetime of Mashery access token (1h0m0s)", lr.Error().Error())
+
+	mockBuilder.data = map[string]interface{}{
+		roleDefaultTTLField: "30m",
+		roleMaxTTLField:     "20m",
+	}
+	reqCtx = mockBuilder.Request()
+	lr, err = updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "invalid lease settings: default_ttl (30m0s) exceeds max_ttl (20m0s)", lr.Error().Error())
+}
+
+func TestUpdateRoleKeysFromRequest_MaxTTLBeyondRoleTerm(t *testing.T) {
+	mockBuilder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{
+				Usage: StoredRoleUsage{
+					ExplicitTerm: time.Now().Add(time.Hour).Unix(),
+				},
+			},
+		},
+		data: map[string]interface{}{
+			roleMaxTTLField: "8h",
+		},
+		fieldSchema: pathRoleFields,
+	}
+
+	lr, err := updateRoleKeysFromRequest(context.TODO(), mockBuilder.Request())
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+}
+
+func TestV2AreaEndpoint(t *testing.T) {
+	keys := RoleKeys{AreaNid: 345}
+	assert.Empty(t, keys.v2AreaEndpoint(&BackendConfiguration{}))
+
+	keys.V2Endpoint = "http://localhost:8080/v2/json-rpc/"
+	assert.Equal(t, "http://localhost:8080/v2/json-rpc/345", keys.v2AreaEndpoint(&BackendConfiguration{}))
+}
+
 func TestUpdateRoleKeysFromRequest_IsResilientToTypeError(t *testing.T) {
 	mockBuilder := RoleRequestMockBuilder[RoleContext]{
 		data: map[string]interface{}{
@@ -730,3 +836,49 @@ func setupRoleRequestMockWithData(data map[string]interface{}, schema map[string
 
 	return builder.Build()
 }
+
+func TestUpdateRoleKeysFromRequest_Scope(t *testing.T) {
+	mockBuilder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{},
+		},
+		data: map[string]interface{}{
+			roleScopeAllowField: "get services/**,V2 object.query",
+			roleScopeDenyField:  []string{"DELETE **"},
+		},
+		fieldSchema: pathRoleFields,
+	}
+
+	reqCtx := mockBuilder.Request()
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	keys := reqCtx.heap.GetRole().Keys
+	assert.Equal(t, []string{"GET services/**", "V2 object.query"}, keys.AllowedScope)
+	assert.Equal(t, []string{"DELETE **"}, keys.DeniedScope)
+
+	mockBuilder.data = map[string]interface{}{
+		roleScopeDenyField: "PATCH services",
+	}
+	lr, err = updateRoleKeysFromRequest(context.TODO(), mockBuilder.Request())
+	assert.Nil(t, err)
+	assert.Equal(t, "invalid scope_deny: rule 'PATCH services' specifies unsupported method PATCH", lr.Error().Error())
+}
+
+func TestBlockOperationOnScopedRole(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{})
+
+	lr, err := blockOperationOnScopedRole(nil, reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	_, reqCtx = setupRoleRequestMockHaving(StoredRole{
+		Keys: RoleKeys{
+			DeniedScope: []string{"DELETE **"},
+		},
+	})
+	lr, err = blockOperationOnScopedRole(nil, reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "operation is not permitted as this role restricts its scope; use proxy mode", lr.Error().Error())
+}
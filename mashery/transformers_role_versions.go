@@ -0,0 +1,382 @@
+package mashery
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/vault/sdk/logical"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	roleVersionField = "version"
+
+	// Number of the most recent versions of the role keys that are retained
+	maxRoleKeysVersions = 10
+
+	roleVersionsKeySize = 32
+)
+
+func roleVersionsPath[T any](reqCtx *RequestHandlerContext[T]) string {
+	return reqCtx.storagePath + storedRoleVersionsPathSuffix
+}
+
+func (b *AuthPlugin) roleVersionsSealPath() string {
+	return b.backendUUID + "/versions-seal"
+}
+
+func readRoleVersions[T any](ctx context.Context, reqCtx *RequestHandlerContext[T]) (StoredRoleVersions, error) {
+	rv := StoredRoleVersions{}
+	_, err := reqCtx.ReadPath(ctx, roleVersionsPath(reqCtx), &rv)
+	return rv, err
+}
+
+// readRoleVersionsSeal reads the secret of this mount the keys encrypting the versions are derived from. The secret
+// is stored apart from the roles, and is not included in the backups of the mount. If the secret does not exist
+// yet, it is generated when generate is true; otherwise nil is returned.
+func readRoleVersionsSeal[T any](ctx context.Context, reqCtx *RequestHandlerContext[T], generate bool) ([]byte, error) {
+	path := reqCtx.plugin.roleVersionsSealPath()
+
+	found, seal, err := reqCtx.ReadBinaryPath(ctx, path)
+	if err != nil {
+		return nil, err
+	} else if found {
+		return seal, nil
+	} else if !generate {
+		return nil, nil
+	}
+
+	seal = make([]byte, roleVersionsKeySize)
+	if _, err = io.ReadFull(rand.Reader, seal); err != nil {
+		return nil, err
+	}
+	if err = reqCtx.WriteBinaryPath(ctx, path, seal); err != nil {
+		return nil, err
+	}
+
+	return seal, nil
+}
+
+// deriveRoleVersionsKey derives the key of the role for the specified purpose from the secret of this mount
+func deriveRoleVersionsKey(seal []byte, purpose string, name string) []byte {
+	mac := hmac.New(sha256.New, seal)
+	mac.Write([]byte(purpose + "/" + name))
+	return mac.Sum(nil)
+}
+
+// readRoleVersionsKeys reads the keys the versions of the role keys are encrypted and masked with. If the secret
+// these are derived from does not exist yet, it is generated when generate is true; otherwise nil keys are returned.
+func readRoleVersionsKeys[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T], generate bool) ([]byte, []byte, error) {
+	seal, err := readRoleVersionsSeal(ctx, reqCtx, generate)
+	if err != nil || seal == nil {
+		return nil, nil, err
+	}
+
+	name := reqCtx.heap.GetRole().Name
+	return deriveRoleVersionsKey(seal, "encrypt", name), deriveRoleVersionsKey(seal, "mask", name), nil
+}
+
+func newRoleVersionsCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != roleVersionsKeySize {
+		return nil, errors.New(fmt.Sprintf("versions key has unexpected size %d", len(key)))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// roleVersionAdditionalData binds the encrypted keys to the role and to the version, so that the encrypted keys
+// cannot be swapped between the versions.
+func roleVersionAdditionalData(name string, version int) []byte {
+	return []byte(name + "/" + strconv.Itoa(version))
+}
+
+func sealRoleKeysVersion(key []byte, name string, version int, keys *RoleKeys) ([]byte, error) {
+	gcm, err := newRoleVersionsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, payload, roleVersionAdditionalData(name, version)), nil
+}
+
+// errRoleVersionUndecryptable the version was not encrypted with the key of this mount for this role, or is damaged
+var errRoleVersionUndecryptable = errors.New("cannot be decrypted")
+
+func openRoleKeysVersion(key []byte, name string, v *RoleKeysVersion) (*RoleKeys, error) {
+	gcm, err := newRoleVersionsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(v.Keys) < gcm.NonceSize() {
+		return nil, fmt.Errorf("version %d %w", v.Version, errRoleVersionUndecryptable)
+	}
+
+	payload, err := gcm.Open(nil, v.Keys[:gcm.NonceSize()], v.Keys[gcm.NonceSize():], roleVersionAdditionalData(name, v.Version))
+	if err != nil {
+		return nil, fmt.Errorf("version %d %w", v.Version, errRoleVersionUndecryptable)
+	}
+
+	rv := &RoleKeys{}
+	if err = json.Unmarshal(payload, rv); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
+
+// saveRoleKeysVersion records the saved keys of the role as the latest version; the oldest versions are discarded.
+// The step follows each saving of the role keys. The lock also serializes the generation of the secret of the mount.
+func saveRoleKeysVersion[T RoleContext](ctx context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+	reqCtx.plugin.roleVersionsLock.Lock()
+	defer reqCtx.plugin.roleVersionsLock.Unlock()
+
+	role := reqCtx.heap.GetRole()
+
+	versions, err := readRoleVersions(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	key, _, err := readRoleVersionsKeys(ctx, reqCtx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := sealRoleKeysVersion(key, role.Name, versions.Latest+1, &role.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	versions.Append(RoleKeysVersion{
+		Saved:        time.Now().Unix(),
+		Path:         reqCtx.request.Path,
+		Operation:    string(reqCtx.request.Operation),
+		EntityID:     reqCtx.request.EntityID,
+		RequestedBy:  reqCtx.request.DisplayName,
+		RestoredFrom: role.restoredVersion,
+		Keys:         sealed,
+	}, maxRoleKeysVersions)
+
+	err = reqCtx.WritePath(ctx, roleVersionsPath(reqCtx), &versions)
+	return nil, err
+}
+
+// maskRoleCredential masks the credential with its keyed fingerprint. The fingerprints of the same credential are
+// equal across the versions of the role, so that the changed credentials can be spotted, but the credential
+// cannot be recovered from its fingerprint.
+func maskRoleCredential(key []byte, value string) string {
+	if len(value) == 0 {
+		return "---NOT-SET---"
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func renderRoleVersionsList(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	versions, err := readRoleVersions(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The oldest versions are listed first
+	ids := make([]string, 0, len(versions.Versions))
+	info := map[string]interface{}{}
+	for _, v := range versions.Versions {
+		id := strconv.Itoa(v.Version)
+		ids = append(ids, id)
+
+		vInfo := map[string]interface{}{
+			"saved":        formatRecipientKeyTime(v.Saved),
+			"requested_by": v.RequestedBy,
+			"path":         v.Path,
+		}
+		if v.RestoredFrom > 0 {
+			vInfo["restored_from"] = v.RestoredFrom
+		}
+		info[id] = vInfo
+	}
+
+	return logical.ListResponseWithInfo(ids, info), nil
+}
+
+func renderRoleVersion(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	versions, err := readRoleVersions(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	v := versions.Find(reqCtx.data.Get(roleVersionField).(int))
+	if v == nil {
+		return nil, nil
+	}
+
+	key, maskKey, err := readRoleVersionsKeys(ctx, reqCtx, false)
+	if err != nil {
+		return nil, err
+	} else if key == nil {
+		return nil, errors.New("versions secret of this mount is missing")
+	}
+	keys, err := openRoleKeysVersion(key, role.Name, v)
+	if errors.Is(err, errRoleVersionUndecryptable) {
+		return logical.ErrorResponse(err.Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			roleVersionField:    v.Version,
+			"latest":            v.Version == versions.Latest,
+			"saved":             formatRecipientKeyTime(v.Saved),
+			"path":              v.Path,
+			"operation":         v.Operation,
+			"entity_id":         v.EntityID,
+			"requested_by":      v.RequestedBy,
+			roleAreaIdField:     keys.AreaId,
+			roleAreaNidField:    keys.AreaNid,
+			roleApiKeField:      maskRoleCredential(maskKey, keys.ApiKey),
+			roleSecretField:     maskRoleCredential(maskKey, keys.KeySecret),
+			roleUsernameField:   maskRoleCredential(maskKey, keys.Username),
+			rolePasswordField:   maskRoleCredential(maskKey, keys.Password),
+			"qps":               keys.MaxQPS,
+			"v2_capable":        keys.IsV2Capable(),
+			"v3_capable":        keys.IsV3Capable(),
+			"exportable":        keys.Exportable,
+			"forced_proxy_mode": keys.ForceProxyMode,
+			"imported":          keys.Imported,
+		},
+	}
+	if v.RestoredFrom > 0 {
+		resp.Data["restored_from"] = v.RestoredFrom
+	}
+	if keys.Imported {
+		resp.Data["export_id"] = keys.ExportID
+		resp.Data["revoked"] = keys.Revoked
+	}
+
+	return resp, nil
+}
+
+// restoreRoleKeysVersion replaces the keys of the role with the version chosen in the request. The keys are not
+// saved yet, so that the chain can verify these first. As the usage of the role is not versioned, the version
+// carrying another export than the role is not restored. The export that its exporter has revoked is not restored
+// either, and the control messages applied to the role remain applied.
+func restoreRoleKeysVersion(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	version := reqCtx.data.Get(roleVersionField).(int)
+
+	versions, err := readRoleVersions(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+	v := versions.Find(version)
+	if v == nil {
+		return logical.ErrorResponse("version %d of role %s is not retained", version, role.Name), nil
+	} else if version == versions.Latest {
+		return logical.ErrorResponse("version %d is the current version of role %s", version, role.Name), nil
+	}
+
+	key, _, err := readRoleVersionsKeys(ctx, reqCtx, false)
+	if err != nil {
+		return nil, err
+	} else if key == nil {
+		return nil, errors.New("versions secret of this mount is missing")
+	}
+	keys, err := openRoleKeysVersion(key, role.Name, v)
+	if errors.Is(err, errRoleVersionUndecryptable) {
+		return logical.ErrorResponse(err.Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// The usage of the role, e.g. the term and the uses of an export, is not versioned
+	if keys.ExportID != role.Keys.ExportID {
+		return logical.ErrorResponse("version %d of role %s carries %s, while the role carries %s; the keys cannot be rolled back across exports",
+			version, role.Name, describeRoleExport(keys.ExportID), describeRoleExport(role.Keys.ExportID)), nil
+	}
+
+	if keys.Imported {
+		revoked := role.Keys.Revoked && role.Keys.ExportID == keys.ExportID
+		for i := range versions.Versions {
+			if other, openErr := openRoleKeysVersion(key, role.Name, &versions.Versions[i]); errors.Is(openErr, errRoleVersionUndecryptable) {
+				return logical.ErrorResponse(openErr.Error()), nil
+			} else if openErr != nil {
+				return nil, openErr
+			} else if other.Revoked && other.ExportID == keys.ExportID {
+				revoked = true
+			}
+		}
+
+		if revoked {
+			return logical.ErrorResponse("export %s was revoked by its exporter; version %d of role %s cannot be restored", keys.ExportID, version, role.Name), nil
+		}
+	}
+
+	// The control messages of the export must not be applied again
+	applied := map[string]bool{}
+	for _, id := range keys.AppliedControls {
+		applied[id] = true
+	}
+	for _, id := range role.Keys.AppliedControls {
+		if !applied[id] {
+			keys.AppliedControls = append(keys.AppliedControls, id)
+		}
+	}
+
+	role.Keys = *keys
+	role.restoredVersion = version
+	return nil, nil
+}
+
+func describeRoleExport(id string) string {
+	if len(id) == 0 {
+		return "no export"
+	}
+	return "export " + id
+}
+
+// renderRestoredRoleKeys renders the version the keys were restored from and, if the keys were verified before
+// these were saved, the outcome of the verification.
+func renderRestoredRoleKeys(report *CredentialVerification) TransformerFunc[RoleContext] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"restored_from": role.restoredVersion,
+				"v2_capable":    role.Keys.IsV2Capable(),
+				"v3_capable":    role.Keys.IsV3Capable(),
+			},
+		}
+		if report != nil {
+			resp.Data["verification"] = report.asMap()
+		}
+
+		return resp, nil
+	}
+}
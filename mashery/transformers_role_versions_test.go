@@ -0,0 +1,286 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// updateTestRolePassword saves a new password of the role through the role update operation
+func updateTestRolePassword(t *testing.T, b *AuthPlugin, storage logical.Storage, pwd string) {
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{
+		rolePasswordField: pwd,
+	}, pathRoleFields)
+	req.Path = "roles/testRole"
+	req.Operation = logical.UpdateOperation
+	req.DisplayName = "token-admin"
+
+	lr, err := b.handleUpdateRoleData(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+// recordTestRoleVersion saves the keys of the role, and records these as the latest version
+func recordTestRoleVersion(t *testing.T, b *AuthPlugin, storage logical.Storage, keys RoleKeys) {
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleFields)
+	lr, err := handleRoleBoundOperation(context.TODO(), b, req, data, SimpleChain(
+		readRole[RoleContext](true),
+		saveRoleKeysVersion[RoleContext],
+	))
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+func TestStoredRoleVersions_RetainsMostRecentVersions(t *testing.T) {
+	versions := StoredRoleVersions{}
+	for i := 0; i < maxRoleKeysVersions+2; i++ {
+		versions.Append(RoleKeysVersion{}, maxRoleKeysVersions)
+	}
+
+	assert.Equal(t, maxRoleKeysVersions+2, versions.Latest)
+	assert.Equal(t, maxRoleKeysVersions, len(versions.Versions))
+	assert.Equal(t, 3, versions.Versions[0].Version)
+	assert.Nil(t, versions.Find(2))
+	assert.NotNil(t, versions.Find(versions.Latest))
+}
+
+func TestRoleKeysVersion_IsBoundToRoleAndVersion(t *testing.T) {
+	key := make([]byte, roleVersionsKeySize)
+	keys := testPendingActiveKeys()
+
+	sealed, err := sealRoleKeysVersion(key, "testRole", 1, &keys)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(sealed), "old-pwd"))
+
+	opened, err := openRoleKeysVersion(key, "testRole", &RoleKeysVersion{Version: 1, Keys: sealed})
+	assert.Nil(t, err)
+	assert.Equal(t, keys, *opened)
+
+	_, err = openRoleKeysVersion(key, "testRole", &RoleKeysVersion{Version: 2, Keys: sealed})
+	assert.Equal(t, "version 2 cannot be decrypted", err.Error())
+	_, err = openRoleKeysVersion(key, "otherRole", &RoleKeysVersion{Version: 1, Keys: sealed})
+	assert.NotNil(t, err)
+}
+
+func TestRoleVersions_RecordedOnUpdate(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	updateTestRolePassword(t, b, storage, "pwd-1")
+	updateTestRolePassword(t, b, storage, "pwd-2")
+
+	versions := StoredRoleVersions{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRoleVersionsPathSuffix, &versions))
+	assert.Equal(t, 2, versions.Latest)
+	assert.Equal(t, "token-admin", versions.Versions[0].RequestedBy)
+	assert.Equal(t, "roles/testRole", versions.Versions[0].Path)
+	assert.False(t, strings.Contains(string(versions.Versions[1].Keys), "pwd-2"))
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleVersionsFields)
+	lr, err := b.listRoleVersions(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, lr.Data["keys"])
+}
+
+func TestRoleVersions_ReadMasksCredentials(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	updateTestRolePassword(t, b, storage, "pwd-1")
+	updateTestRolePassword(t, b, storage, "pwd-2")
+
+	read := func(version string) map[string]interface{} {
+		req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: version}, pathRoleVersionsFields)
+		lr, err := b.readRoleVersion(context.TODO(), req, data)
+		assert.Nil(t, err)
+		return lr.Data
+	}
+
+	first, second := read("1"), read("2")
+	assert.Equal(t, false, first["latest"])
+	assert.Equal(t, true, second["latest"])
+	assert.Equal(t, "area-uuid", first[roleAreaIdField])
+
+	// The unchanged credentials have the same fingerprint; the changed password has not
+	assert.Equal(t, first[roleSecretField], second[roleSecretField])
+	assert.NotEqual(t, first[rolePasswordField], second[rolePasswordField])
+	for _, fld := range []string{roleApiKeField, roleSecretField, roleUsernameField, rolePasswordField} {
+		assert.True(t, strings.HasPrefix(first[fld].(string), "hmac-sha256:"))
+	}
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: "3"}, pathRoleVersionsFields)
+	lr, err := b.readRoleVersion(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+}
+
+func TestRollbackRoleKeys_RestoresVersion(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{V3Token: "token"})
+
+	updateTestRolePassword(t, b, storage, "pwd-1")
+	updateTestRolePassword(t, b, storage, "typo")
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err := b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 1, lr.Data["restored_from"])
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "pwd-1", active.Password)
+
+	usage := StoredRoleUsage{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+	assert.Equal(t, "", usage.V3Token)
+
+	versions := StoredRoleVersions{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleVersionsPathSuffix, &versions)
+	assert.Equal(t, 3, versions.Latest)
+	assert.Equal(t, 1, versions.Find(3).RestoredFrom)
+
+	// The current version, and the versions that were never saved, cannot be restored
+	for version, msg := range map[int]string{
+		3: "version 3 is the current version of role testRole",
+		9: "version 9 of role testRole is not retained",
+	} {
+		req, data = pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: version}, pathRoleRollback(b).Fields)
+		lr, err = b.rollbackRoleKeys(context.TODO(), req, data)
+		assert.Nil(t, err)
+		assert.Equal(t, msg, lr.Error().Error())
+	}
+}
+
+func TestRollbackRoleKeys_DoesNotRestoreRevokedExport(t *testing.T) {
+	keys := testPendingActiveKeys()
+	keys.Imported = true
+	keys.ExportID = "export-1"
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{})
+
+	// Version 1 holds the export before its revocation, version 2 the revoked role
+	recordTestRoleVersion(t, b, storage, keys)
+	revoked := keys
+	revoked.ApiKey, revoked.KeySecret, revoked.Username, revoked.Password = "", "", "", ""
+	revoked.Revoked = true
+	recordTestRoleVersion(t, b, storage, revoked)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err := b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "export export-1 was revoked by its exporter; version 1 of role testRole cannot be restored", lr.Error().Error())
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.True(t, active.Revoked)
+}
+
+func TestRollbackRoleKeys_KeepsAppliedControls(t *testing.T) {
+	keys := testPendingActiveKeys()
+	keys.Imported = true
+	keys.ExportID = "export-1"
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{})
+
+	// The role is re-imported from the same export, then a control message is applied to it
+	recordTestRoleVersion(t, b, storage, keys)
+	controlled := keys
+	controlled.AppliedControls = []string{"msg-1"}
+	recordTestRoleVersion(t, b, storage, controlled)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err := b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, []string{"msg-1"}, active.AppliedControls)
+}
+
+func TestRoleVersions_RecordScheduledRotation(t *testing.T) {
+	user := &testMasheryUser{password: "old-pwd"}
+	srv, b, storage := setupPasswordRotationTest(t, user)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.PasswordRotationPeriod = minPasswordRotationPeriod
+	keys.PasswordChanged = 1
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	assert.Nil(t, b.rotateScheduledRolePasswords(context.TODO(), &logical.Request{Storage: storage, Operation: logical.RollbackOperation}))
+
+	versions := StoredRoleVersions{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRoleVersionsPathSuffix, &versions))
+	assert.Equal(t, 1, versions.Latest)
+	assert.Equal(t, "roles/testRole/rotate-password", versions.Versions[0].Path)
+	assert.Equal(t, string(logical.UpdateOperation), versions.Versions[0].Operation)
+}
+
+func TestRoleVersions_EncryptedWithMountSecret(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	updateTestRolePassword(t, b, storage, "pwd-1")
+
+	// The secret is kept by the mount, apart from the role
+	seal, err := storage.Get(context.TODO(), b.roleVersionsSealPath())
+	assert.Nil(t, err)
+	assert.Equal(t, roleVersionsKeySize, len(seal.Value))
+	stored, _ := storage.List(context.TODO(), testPendingRolePath+"/")
+	assert.ElementsMatch(t, []string{"key", "usage", "versions"}, stored)
+
+	// The versions cannot be read with the secret of another mount
+	seal.Value = make([]byte, roleVersionsKeySize)
+	assert.Nil(t, storage.Put(context.TODO(), seal))
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: "1"}, pathRoleVersionsFields)
+	lr, err := b.readRoleVersion(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "version 1 cannot be decrypted", lr.Error().Error())
+
+	updateTestRolePassword(t, b, storage, "pwd-2")
+	req, data = pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err = b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "version 1 cannot be decrypted", lr.Error().Error())
+}
+
+func TestRollbackRoleKeys_DoesNotRestoreOtherExport(t *testing.T) {
+	keys := testPendingActiveKeys()
+	keys.Imported = true
+	keys.ExportID = "export-1"
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{})
+
+	// The role is replaced with another export, whose term and uses the role tracks now
+	recordTestRoleVersion(t, b, storage, keys)
+	replaced := keys
+	replaced.ExportID = "export-2"
+	recordTestRoleVersion(t, b, storage, replaced)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err := b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "version 1 of role testRole carries export export-1, while the role carries export export-2; the keys cannot be rolled back across exports", lr.Error().Error())
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "export-2", active.ExportID)
+}
+
+func TestRollbackRoleKeys_RefusedWhileKeysArePending(t *testing.T) {
+	b, storage := setupPendingKeysTestPlugin(t, testPendingActiveKeys(), StoredRoleUsage{})
+
+	updateTestRolePassword(t, b, storage, "pwd-1")
+	updateTestRolePassword(t, b, storage, "pwd-2")
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{Password: "staged"})
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{roleVersionField: 1}, pathRoleRollback(b).Fields)
+	lr, err := b.rollbackRoleKeys(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "role testRole has pending keys; promote or abort these first", lr.Error().Error())
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "pwd-2", active.Password)
+}
@@ -0,0 +1,28 @@
+package mashery
+
+import (
+	"context"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// blockV3OperationOutOfScope blocks the V3 operation that the scope of the role does not permit.
+func blockV3OperationOutOfScope[T RoleContext](method string, path string) TransformerFunc[T] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		if !reqCtx.heap.GetRole().Keys.PermitsV3Operation(method, path) {
+			return logical.ErrorResponse("%s %s is outside of the scope of this role", method, cleanV3ScopePath(path)), nil
+		}
+
+		return nil, nil
+	}
+}
+
+// blockV2MethodOutOfScope blocks the V2 method call that the scope of the role does not permit.
+func blockV2MethodOutOfScope[T RoleContext](method string) TransformerFunc[T] {
+	return func(_ context.Context, reqCtx *RequestHandlerContext[T]) (*logical.Response, error) {
+		if !reqCtx.heap.GetRole().Keys.PermitsV2Method(method) {
+			return logical.ErrorResponse("V2 method %s is outside of the scope of this role", method), nil
+		}
+
+		return nil, nil
+	}
+}
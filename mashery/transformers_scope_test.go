@@ -0,0 +1,39 @@
+package mashery
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBlockV3OperationOutOfScope(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Keys: RoleKeys{
+			AllowedScope: []string{"GET services/**"},
+		},
+	})
+
+	lr, err := blockV3OperationOutOfScope[RoleContext]("GET", "/services/abc")(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	lr, err = blockV3OperationOutOfScope[RoleContext]("DELETE", "/services/abc/")(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "DELETE services/abc is outside of the scope of this role", lr.Error().Error())
+}
+
+func TestBlockV2MethodOutOfScope(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Keys: RoleKeys{
+			DeniedScope: []string{"V2 key.delete"},
+		},
+	})
+
+	lr, err := blockV2MethodOutOfScope[RoleContext]("object.query")(context.TODO(), reqCtx)
+	assert.Nil(t, lr)
+	assert.Nil(t, err)
+
+	lr, err = blockV2MethodOutOfScope[RoleContext]("key.delete")(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "V2 method key.delete is outside of the scope of this role", lr.Error().Error())
+}
@@ -0,0 +1,176 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/logical"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	secretRotationPeriodField = "secret_rotation_period"
+	// Shortest rotation period, in seconds, that can be scheduled
+	minSecretRotationPeriod = 3600
+
+	rotatedSecretLength = 16
+)
+
+// secretPropagationDelays the delays after which the verification of the new secret is repeated. Mashery propagates
+// the changed package keys to its traffic managers with a delay, during which the new secret may not be accepted yet.
+var secretPropagationDelays = []time.Duration{time.Second * 2, time.Second * 5, time.Second * 10, time.Second * 20}
+
+// generateMasherySecret generates a random package key secret of the specified length. The secret consists of
+// letters and digits, like the secrets Mashery generates.
+func generateMasherySecret(length int) (string, error) {
+	alphabet := passwordLowerCaseLetters + passwordUpperCaseLetters + passwordDigits
+
+	secret := make([]byte, length)
+	for i := range secret {
+		if c, err := randomCharacterOf(alphabet); err != nil {
+			return "", err
+		} else {
+			secret[i] = c
+		}
+	}
+
+	return string(secret), nil
+}
+
+// changeMasheryKeySecret changes the secret of the package key of the role through the V3 API. The call is
+// authorized with the supplied access token. Only the change that fails after the secret was submitted to Mashery
+// may have been applied.
+func changeMasheryKeySecret(ctx context.Context, b *AuthPlugin, role *StoredRole, accessToken string, secret string) error {
+	client := b.GetMasheryV3Client(ctx, role)
+	callCtx := v3client.ContextWithAccessToken(ctx, accessToken)
+
+	qs := url.Values{
+		"filter": {"apikey:" + role.Keys.ApiKey},
+		"fields": {"id,apikey"},
+	}
+	resp, err := client.FetchAny(callCtx, "/packageKeys", &qs)
+	if err != nil {
+		return rejectedChange("%s", err.Error())
+	} else if resp.StatusCode != 200 {
+		return rejectedChange("mashery responded with status %d while looking up the package key", resp.StatusCode)
+	}
+
+	var keys []struct {
+		Id     string `json:"id"`
+		ApiKey string `json:"apikey"`
+	}
+	if body, err := resp.Body(); err != nil {
+		return rejectedChange("%s", err.Error())
+	} else if err = json.Unmarshal(body, &keys); err != nil {
+		return rejectedChange("mashery response cannot be parsed (%s)", err.Error())
+	}
+
+	keyId := ""
+	for _, k := range keys {
+		if k.ApiKey == role.Keys.ApiKey {
+			keyId = k.Id
+		}
+	}
+	if len(keyId) == 0 {
+		return rejectedChange("package key is not found in Mashery")
+	}
+
+	resp, err = client.PutAny(callCtx, "/packageKeys/"+keyId, map[string]interface{}{
+		"secret": secret,
+	})
+	if err != nil {
+		return err
+	} else if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+		return rejectedChange("mashery responded with status %d while changing the secret of the package key", resp.StatusCode)
+	} else if resp.StatusCode != 200 {
+		return fmt.Errorf("mashery responded with status %d while changing the secret of the package key", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// secretRejectionCodes the error codes with which Mashery refuses the package key secret: the OAuth error of the
+// token request, and the error code of the unauthorized V2 call.
+var secretRejectionCodes = map[string]bool{
+	"invalid_client":         true,
+	"ERR_403_NOT_AUTHORIZED": true,
+}
+
+// secretRejections the verifications of the capabilities that failed because Mashery refused the package key
+// secret. The verification that failed for another reason, e.g. a timeout or the V2 method that is not available
+// in the area, says nothing about the secret.
+func secretRejections(report *CredentialVerification) *CredentialVerification {
+	rv := &CredentialVerification{}
+	if report.V3 != nil && !report.V3.Verified && secretRejectionCodes[report.V3.ErrorCode] {
+		rv.V3 = report.V3
+	}
+	if report.V2 != nil && !report.V2.Verified && secretRejectionCodes[report.V2.ErrorCode] {
+		rv.V2 = report.V2
+	}
+
+	return rv
+}
+
+// secretRotation rotates the secret of the package key of the role. The new secret is used both for obtaining the
+// V3 access tokens and for signing the V2 calls. The secret is changed back only if Mashery still refuses it for
+// either capability once the propagation delays have passed; the other failures of the verification are logged.
+var secretRotation = credentialRotation{
+	credential: "secret",
+	generate: func() (string, error) {
+		return generateMasherySecret(rotatedSecretLength)
+	},
+	current: func(keys *RoleKeys) string {
+		return keys.KeySecret
+	},
+	stage: func(secret string) *PendingRoleKeys {
+		return &PendingRoleKeys{KeySecret: secret}
+	},
+	change: changeMasheryKeySecret,
+	verify: func(ctx context.Context, b *AuthPlugin, candidate *StoredRole) error {
+		report := verifyRoleCredentials(ctx, b, candidate)
+		if rejections := secretRejections(report).failures(); len(rejections) > 0 {
+			return fmt.Errorf("%s", strings.Join(rejections, "; "))
+		} else if !report.Verified() {
+			b.Logger().Warn("new secret was not verified for reasons other than the secret", "role", candidate.Name, "failures", strings.Join(report.failures(), "; "))
+		}
+		return nil
+	},
+	propagation: secretPropagationDelays,
+	// Every export carries the secret, as both the V2 signatures and the V3 access tokens are obtained with it
+	exported: func(*RoleExportRecord) bool {
+		return true
+	},
+}
+
+// rotateScheduledRoleKeySecrets rotates the package key secrets of the roles whose rotation period has passed.
+func (b *AuthPlugin) rotateScheduledRoleKeySecrets(ctx context.Context, req *logical.Request) error {
+	return b.rotateScheduledRoleCredential(ctx, req, rotationSchedule{
+		credential: secretRotation.credential,
+		path:       "/rotate-secret",
+		due:        (*RoleKeys).SecretRotationDue,
+		failed: func(keys *RoleKeys, t time.Time) {
+			keys.SecretRotationFailed = t.Unix()
+		},
+		rotate: b.rotateRoleKeySecret,
+		schema: pathRoleSecretRotateFields,
+	})
+}
+
+func renderRotatedSecret(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"secret_changed": time.Unix(role.Keys.SecretChanged, 0).UTC().Format(time.RFC3339),
+		},
+	}
+	if role.Keys.SecretRotationPeriod > 0 {
+		next := time.Unix(role.Keys.SecretChanged+role.Keys.SecretRotationPeriod, 0)
+		resp.Data["next_rotation"] = next.UTC().Format(time.RFC3339)
+	}
+
+	return resp, nil
+}
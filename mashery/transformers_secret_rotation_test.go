@@ -0,0 +1,422 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+)
+
+// testMasheryPackageKey emulates the Mashery package key whose secret is rotated
+type testMasheryPackageKey struct {
+	secret string
+	// Whether Mashery accepts a new secret, but does not grant tokens for it
+	rejectNewSecrets bool
+	// Number of token requests refused after a change while Mashery propagates the new secret
+	propagationAttempts int
+	unpropagated        int
+	// Status Mashery responds to the secret change with, if not 200. The change is applied on the server errors.
+	changeStatus int
+	// Error code the V2 calls are refused with, if any
+	v2ErrorCode string
+	// Secrets replaced so far, the number of changes submitted, and the access tokens granted
+	changes   []string
+	submitted int
+	tokens    map[string]bool
+}
+
+func (k *testMasheryPackageKey) startServer() *httptest.Server {
+	k.tokens = map[string]bool{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/token":
+			_, secret, _ := r.BasicAuth()
+			if secret == k.secret && k.unpropagated > 0 {
+				k.unpropagated--
+				secret = ""
+			}
+			if secret != k.secret || (k.rejectNewSecrets && len(k.changes) > 0) {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+				return
+			}
+			tkn := "access-" + strconv.Itoa(len(k.tokens))
+			k.tokens[tkn] = true
+			_, _ = w.Write([]byte(`{"access_token":"` + tkn + `","expires_in":3600}`))
+		case r.URL.Path == "/v2/123":
+			if len(k.v2ErrorCode) > 0 {
+				w.Header().Set(masheryErrorCodeHeader, k.v2ErrorCode)
+				w.WriteHeader(403)
+				return
+			}
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":["vault"]}`))
+		case !k.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]:
+			w.WriteHeader(403)
+		case r.Method == "GET" && r.URL.Path == "/packageKeys":
+			if r.URL.Query().Get("filter") != "apikey:key" {
+				_, _ = w.Write([]byte(`[]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[{"id":"key-1","apikey":"key"}]`))
+		case r.Method == "PUT" && r.URL.Path == "/packageKeys/key-1":
+			k.submitted++
+			if k.changeStatus >= 400 && k.changeStatus < 500 {
+				w.WriteHeader(k.changeStatus)
+				return
+			}
+			body := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			k.changes = append(k.changes, k.secret)
+			k.secret = body["secret"]
+			if k.secret == k.changes[0] {
+				// Reverted
+				k.changes = nil
+			}
+			k.unpropagated = k.propagationAttempts
+			if k.changeStatus > 0 {
+				w.WriteHeader(k.changeStatus)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":"key-1","apikey":"key"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func setupSecretRotationTest(t *testing.T, key *testMasheryPackageKey) (*httptest.Server, *AuthPlugin, logical.Storage) {
+	srv := key.startServer()
+
+	propagation := secretRotation.propagation
+	secretRotation.propagation = []time.Duration{time.Millisecond, time.Millisecond}
+	t.Cleanup(func() {
+		secretRotation.propagation = propagation
+	})
+
+	keys := testPendingActiveKeys()
+	keys.AreaNid = 0
+	keys.Password = "old-pwd"
+	keys.KeySecret = key.secret
+	keys.OAuthTokenEndpoint = srv.URL + "/token"
+	keys.V3Endpoint = srv.URL
+
+	b, storage := setupPendingKeysTestPlugin(t, keys, StoredRoleUsage{V3Token: "old-token", V3TokenExpiry: time.Now().Unix() + 600})
+	return srv, b, storage
+}
+
+func TestGenerateMasherySecret(t *testing.T) {
+	secret, err := generateMasherySecret(rotatedSecretLength)
+	assert.Nil(t, err)
+	assert.Equal(t, rotatedSecretLength, len(secret))
+	assert.Equal(t, -1, strings.IndexFunc(secret, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+
+	other, _ := generateMasherySecret(rotatedSecretLength)
+	assert.NotEqual(t, secret, other)
+}
+
+func TestRotateRoleKeySecret_ChangesSecretAndKeepsToken(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.NotNil(t, lr.Data["secret_changed"])
+	assert.Nil(t, lr.Data["next_rotation"])
+	assert.Equal(t, []string{"old-secret"}, key.changes)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, key.secret, active.KeySecret)
+	assert.NotEqual(t, "old-secret", active.KeySecret)
+	assert.True(t, active.SecretChanged > 0)
+
+	// The token obtained before the rotation remains in use
+	usage := StoredRoleUsage{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleUsageKeyPathSuffix, &usage)
+	assert.Equal(t, "old-token", usage.V3Token)
+
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRoleKeySecret_RefusesToRotateExportedSecret(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	putTestRoleExports(t, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "exports active-v3, active-v2 of role testRole carry its secret, and cannot be used once the secret is rotated; set force to rotate it anyway", lr.Error().Error())
+	assert.Equal(t, 0, key.submitted)
+}
+
+func TestRotateRoleKeySecret_ForcedRotationWarnsOfExports(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	putTestRoleExports(t, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{forceRotationField: true}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, []string{"old-secret"}, key.changes)
+	assert.Equal(t, []string{"active-v3", "active-v2"}, lr.Data["affected_exports"])
+	assert.Equal(t, []string{"exports active-v3, active-v2 carry the previous secret, and cannot be used anymore"}, lr.Warnings)
+}
+
+func TestRotateRoleKeySecret_RevertsSecretNotAccepted(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", rejectNewSecrets: true}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "the new secret of role testRole is not accepted by Mashery, and the secret was reverted: v3: invalid_client"))
+	assert.Equal(t, "old-secret", key.secret)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-secret", active.KeySecret)
+	assert.Equal(t, int64(0), active.SecretChanged)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+// enableTestV2Capability makes the rotated role V2 capable, its V2 calls served by the test server
+func enableTestV2Capability(t *testing.T, srv *httptest.Server, storage logical.Storage) {
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.AreaNid = 123
+	keys.V2Endpoint = srv.URL + "/v2"
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+}
+
+func TestRotateRoleKeySecret_RevertsSecretRefusedForV2(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", v2ErrorCode: "ERR_403_NOT_AUTHORIZED"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+	enableTestV2Capability(t, srv, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "the new secret of role testRole is not accepted by Mashery, and the secret was reverted: v2: ERR_403_NOT_AUTHORIZED"))
+	assert.Equal(t, "old-secret", key.secret)
+}
+
+func TestRotateRoleKeySecret_KeepsSecretWhenV2FailsForOtherReasons(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", v2ErrorCode: "ERR_403_DEVELOPER_INACTIVE"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+	enableTestV2Capability(t, srv, storage)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 1, key.submitted)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, key.secret, active.KeySecret)
+	assert.NotEqual(t, "old-secret", active.KeySecret)
+}
+
+func TestRotateRoleKeySecret_WaitsForSecretPropagation(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", propagationAttempts: 2}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, 0, key.unpropagated)
+	assert.Equal(t, []string{"old-secret"}, key.changes)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, key.secret, active.KeySecret)
+}
+
+func TestRotateRoleKeySecret_RevertsSecretNotPropagatedInTime(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", propagationAttempts: 3}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "the new secret of role testRole is not accepted by Mashery, and the secret was reverted"))
+	assert.Equal(t, "old-secret", key.secret)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRoleKeySecret_KeepsPendingKeysWhenChangeMayHaveApplied(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", changeStatus: 502}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.True(t, strings.HasPrefix(lr.Error().Error(), "secret of role testRole may have been changed by Mashery (mashery responded with status 502 while changing the secret of the package key)"))
+
+	// The secret Mashery has applied is not lost
+	pending := PendingRoleKeys{}
+	assert.True(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &pending))
+	assert.Equal(t, key.secret, pending.KeySecret)
+
+	active := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &active)
+	assert.Equal(t, "old-secret", active.KeySecret)
+}
+
+func TestRotateRoleKeySecret_DiscardsPendingKeysWhenChangeIsRejected(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", changeStatus: 403}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "secret of role testRole was not changed: mashery responded with status 403 while changing the secret of the package key", lr.Error().Error())
+	assert.Equal(t, "old-secret", key.secret)
+	assert.False(t, readTestStorageEntry(t, storage, testPendingRolePath+storedRolePendingKeysPathSuffix, &PendingRoleKeys{}))
+}
+
+func TestRotateRoleKeySecret_KeepsSecretWhenKeyIsUnknown(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.ApiKey = "unknown"
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req, data := pendingKeysTestRequest(storage, map[string]interface{}{}, pathRoleSecretRotateFields)
+
+	lr, err := b.rotateRoleKeySecret(context.TODO(), req, data)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "secret of role testRole was not changed: package key is not found in Mashery", lr.Error().Error())
+	assert.Equal(t, 0, len(key.changes))
+}
+
+func TestRotateScheduledRoleKeySecrets_RotatesDueSecrets(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret"}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.SecretRotationPeriod = minSecretRotationPeriod
+	keys.SecretChanged = time.Now().Unix() - minSecretRotationPeriod + 60
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req := &logical.Request{Storage: storage}
+
+	// The period has not passed yet
+	assert.Nil(t, b.rotateScheduledRoleKeySecrets(context.TODO(), req))
+	assert.Equal(t, 0, len(key.changes))
+
+	keys.SecretChanged = time.Now().Unix() - minSecretRotationPeriod
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	assert.Nil(t, b.rotateScheduledRoleKeySecrets(context.TODO(), req))
+	assert.Equal(t, []string{"old-secret"}, key.changes)
+
+	rotated := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &rotated)
+	assert.Equal(t, key.secret, rotated.KeySecret)
+	assert.Equal(t, "old-pwd", rotated.Password)
+	assert.False(t, rotated.SecretRotationDue(time.Now()))
+}
+
+func TestRotateScheduledRoleKeySecrets_BacksOffAfterFailure(t *testing.T) {
+	key := &testMasheryPackageKey{secret: "old-secret", rejectNewSecrets: true}
+	srv, b, storage := setupSecretRotationTest(t, key)
+	defer srv.Close()
+
+	keys := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+	keys.SecretRotationPeriod = minSecretRotationPeriod
+	keys.SecretChanged = time.Now().Unix() - minSecretRotationPeriod
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &keys)
+
+	req := &logical.Request{Storage: storage}
+
+	// The rotation Mashery refuses is reverted, and recorded
+	assert.Nil(t, b.rotateScheduledRoleKeySecrets(context.TODO(), req))
+	assert.Equal(t, 2, key.submitted)
+	assert.Equal(t, "old-secret", key.secret)
+
+	failed := RoleKeys{}
+	readTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &failed)
+	assert.Equal(t, "old-secret", failed.KeySecret)
+	assert.True(t, failed.SecretRotationFailed > 0)
+	assert.False(t, failed.SecretRotationDue(time.Now()))
+	assert.True(t, failed.SecretRotationDue(time.Now().Add(time.Second*time.Duration(scheduledRotationRetryDelay))))
+
+	// The next runs do not retry the rotation until the retry delay has passed
+	assert.Nil(t, b.rotateScheduledRoleKeySecrets(context.TODO(), req))
+	assert.Equal(t, 2, key.submitted)
+
+	failed.SecretRotationFailed = time.Now().Unix() - scheduledRotationRetryDelay
+	putTestStorageEntry(t, storage, testPendingRolePath+storedRoleKeyPathSuffix, &failed)
+	assert.Nil(t, b.rotateScheduledRoleKeySecrets(context.TODO(), req))
+	assert.Equal(t, 4, key.submitted)
+}
+
+func TestUpdateRoleKeysFromRequest_SecretRotationPeriod(t *testing.T) {
+	_, reqCtx := setupRoleRequestMockWithData(map[string]interface{}{
+		secretRotationPeriodField: "59m",
+	}, pathRoleFields)
+	reqCtx.heap.CarryRole(&StoredRole{})
+
+	lr, err := updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret_rotation_period cannot be shorter than 1h0m0s", lr.Error().Error())
+
+	reqCtx.data.Raw[secretRotationPeriodField] = "2160h"
+	lr, err = updateRoleKeysFromRequest(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Nil(t, lr)
+	assert.Equal(t, int64(2160*3600), reqCtx.heap.GetRole().Keys.SecretRotationPeriod)
+	assert.True(t, reqCtx.heap.GetRole().Keys.SecretChanged > 0)
+}
@@ -30,8 +30,20 @@ func (c *V2SignatureContainer) CarryV2Signature(s string) {
 	c.sig = s
 }
 
-func retrieveV2Signature(_ context.Context, reqCtx *RequestHandlerContext[V2SignatureContext]) (*logical.Response, error) {
-	reqCtx.heap.CarryV2Signature(reqCtx.plugin.v2SignatureFor(reqCtx.heap.GetRole()))
+// retrieveV2Signature computes the V2 signature of the role. The signature that was revoked is not issued again.
+func retrieveV2Signature(ctx context.Context, reqCtx *RequestHandlerContext[V2SignatureContext]) (*logical.Response, error) {
+	rev, err := readRoleRevocations(ctx, reqCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	role := reqCtx.heap.GetRole()
+	t, ok := unrevokedV2SigningTime(&rev, role.Keys.ApiKey, role.Keys.KeySecret, time.Now())
+	if !ok {
+		return logical.ErrorResponse("V2 signature of role %s has been revoked; retry the request later", role.Name), nil
+	}
+
+	reqCtx.heap.CarryV2Signature(v2Signature(role.Keys.ApiKey, role.Keys.KeySecret, t))
 	return nil, nil
 }
 
@@ -44,14 +56,63 @@ func renderV2LeaseResponse(_ context.Context, reqCtx *RequestHandlerContext[V2Si
 		roleQpsField:            role.Keys.MaxQPS,
 		roleApiKeField:          role.Keys.ApiKey,
 		secretSignedSecretField: signature,
-	}, map[string]interface{}{})
+	}, map[string]interface{}{
+		secretInternalRoleStoragePath: role.StoragePath,
+	})
 
-	resp.Secret.TTL = time.Minute * 1
-	resp.Secret.MaxTTL = time.Minute * 2
+	shape := role.Keys.EffectiveLeaseShape(reqCtx.plugin.config())
+	resp.Secret.MaxTTL, resp.Secret.TTL = v2LeaseTTLs(shape, role)
 
 	return resp, nil
 }
 
+// renderV2LeaseRenewal renders the renewed V2 lease. The signature carried by the lease is not changed, so the
+// lease is not extended beyond the maximum TTL counted from its issue time.
+func renderV2LeaseRenewal(_ context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	secret := reqCtx.request.Secret
+
+	shape := role.Keys.EffectiveLeaseShape(reqCtx.plugin.config())
+	maxTTL, ttl := v2LeaseTTLs(shape, role)
+	if secret.Increment > 0 {
+		ttl = secret.Increment
+	}
+
+	elapsed := time.Since(secret.IssueTime)
+	if secret.MaxTTL > 0 && secret.MaxTTL-elapsed < maxTTL {
+		maxTTL = secret.MaxTTL - elapsed
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	secret.TTL = ttl.Round(time.Second)
+
+	return &logical.Response{Secret: secret}, nil
+}
+
+// v2LeaseTTLs computes the maximum and the default TTL of the V2 lease. The lease may not outlive the time
+// Mashery accepts the signature for and the term of the role.
+func v2LeaseTTLs(shape LeaseShape, role *StoredRole) (time.Duration, time.Duration) {
+	maxTTL := shape.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = v2LeaseMaxTTL
+	}
+	if maxTTL > masheryV2SignatureLifetime {
+		maxTTL = masheryV2SignatureLifetime
+	}
+	maxTTL = capLeaseToRoleTerm(time.Now(), maxTTL, role)
+
+	ttl := shape.DefaultTTL
+	if ttl <= 0 {
+		ttl = v2LeaseDefaultTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+
+	return maxTTL, ttl
+}
+
 func renderV2PlainResponse(_ context.Context, reqCtx *RequestHandlerContext[V2SignatureContext]) (*logical.Response, error) {
 	role := reqCtx.heap.GetRole()
 	signature := reqCtx.heap.GetV2Signature()
@@ -134,8 +195,13 @@ type WildcardAPIResponseContext APIResponseContext[*transport.WrappedResponse]
 
 func executeV2CallToRawResponseUsing(v2Request v2client.V2Request) func(context.Context, *RequestHandlerContext[WildcardAPIResponseContext]) (*logical.Response, error) {
 	return func(ctx context.Context, reqCtx *RequestHandlerContext[WildcardAPIResponseContext]) (*logical.Response, error) {
-		cl := reqCtx.plugin.GetMasheryV2Client(reqCtx.heap.GetRole())
-		resp, err := cl.GetRawResponse(ctx, v2Request)
+		callCtx, err := contextWithV2Revocations(ctx, reqCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		cl := reqCtx.plugin.GetMasheryV2Client(ctx, reqCtx.heap.GetRole())
+		resp, err := cl.GetRawResponse(callCtx, v2Request)
 
 		reqCtx.heap.CarryAPIResponse(resp)
 		return nil, err
@@ -144,8 +210,13 @@ func executeV2CallToRawResponseUsing(v2Request v2client.V2Request) func(context.
 
 func executeV2CallUsing(v2Request v2client.V2Request) func(context.Context, *RequestHandlerContext[APIResponseContext[v2client.V2Result]]) (*logical.Response, error) {
 	return func(ctx context.Context, reqCtx *RequestHandlerContext[APIResponseContext[v2client.V2Result]]) (*logical.Response, error) {
-		cl := reqCtx.plugin.GetMasheryV2Client(reqCtx.heap.GetRole())
-		resp, err := cl.InvokeDirect(ctx, v2Request)
+		callCtx, err := contextWithV2Revocations(ctx, reqCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		cl := reqCtx.plugin.GetMasheryV2Client(ctx, reqCtx.heap.GetRole())
+		resp, err := cl.InvokeDirect(callCtx, v2Request)
 		reqCtx.heap.CarryAPIResponse(resp)
 
 		return nil, err
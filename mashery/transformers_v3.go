@@ -130,14 +130,16 @@ func (b *AuthPlugin) doFetchWithErrorHandling(ctx context.Context, reqCtx *Reque
 			return nil, tknRefreshErr
 		}
 
-		client := b.GetMasheryV3Client(reqCtx.heap.GetRole())
+		client := b.GetMasheryV3Client(ctx, reqCtx.heap.GetRole())
 
 		callCtx := v3client.ContextWithAccessToken(ctx, reqCtx.heap.GetRole().Usage.V3Token)
 		if resp, err := fetchFunc(callCtx, client); err != nil {
 			return nil, err
 		} else if resp.StatusCode == 403 {
 			if errCode := resp.Header.Get("X-Mashery-Error-Code"); errCode == "ERR_403_DEVELOPER_INACTIVE" {
-				reqCtx.heap.GetRole().Usage.ResetToken()
+				role := reqCtx.heap.GetRole()
+				role.rejectedV3Token = role.Usage.V3Token
+				role.Usage.ResetToken()
 				continue
 			} else {
 				return nil, errors.New("mashery denies access to the selected resource")
@@ -354,32 +356,68 @@ func ensureAccessTokenValidForRole[T any](ctx context.Context, b *AuthPlugin, re
 	}
 
 	if role.Usage.V3TokenNeedsRenew() {
-		b.Logger().Info(fmt.Sprintf("stored token for role %s needs to be renewed for further operations", role.Name))
-
-		creds := role.asV3Credentials()
+		// Concurrent requests for the same role share a single token refresh. The refresh is not bound to the
+		// cancellation of the request that leads it, as the other requests wait for its result.
+		refreshCtx := context.WithoutCancel(ctx)
+		usage, err := b.v3TokenRefreshes.Do(reqCtx.storagePath, func() (StoredRoleUsage, error) {
+			return refreshRoleAccessToken(refreshCtx, b, reqCtx, role)
+		})
 
-		if tkn, err := b.GetOAuthHelper().RetrieveAccessTokenFor(&creds); err != nil {
-			b.Logger().Error(fmt.Sprintf("attempt to renew token for role %s failed: %s", role.Name, err.Error()))
+		if err != nil {
 			return err
-		} else {
-			role.Usage.ReplaceAccessToken(tkn.AccessToken, tkn.ExpiryTime().Unix())
-			b.Logger().Info(fmt.Sprintf("successfully renewed token for role %s", role.Name))
-
-			if writeErr := reqCtx.WritePath(ctx, roleUsagePath(reqCtx), &role.Usage); writeErr != nil {
-				b.Logger().Error(fmt.Sprintf("failed to persist acquired token for role %s: %s", role.Name, writeErr.Error()))
-				return writeErr
+		} else if len(role.rejectedV3Token) > 0 && usage.V3Token == role.rejectedV3Token {
+			// The refresh led by another request has returned the token Mashery rejected for this request
+			if usage, err = refreshRoleAccessToken(refreshCtx, b, reqCtx, role); err != nil {
+				return err
 			}
 		}
+
+		role.Usage.V3Token = usage.V3Token
+		role.Usage.V3TokenExpiry = usage.V3TokenExpiry
+		role.Usage.V3TokenObtained = usage.V3TokenObtained
+		role.Usage.V3TokenObtainedNano = usage.V3TokenObtainedNano
 	}
 
 	return nil
 }
 
+// refreshRoleAccessToken obtains a new access token for the role, unless a valid token was already stored by
+// a request that has completed the refresh earlier. The latest stored usage is updated, so that the refresh
+// does not overwrite the usage changes made by the concurrent requests.
+func refreshRoleAccessToken[T any](ctx context.Context, b *AuthPlugin, reqCtx *RequestHandlerContext[T], role *StoredRole) (StoredRoleUsage, error) {
+	usage := role.Usage
+	if found, err := reqCtx.ReadPath(ctx, roleUsagePath(reqCtx), &usage); err != nil {
+		return usage, err
+	} else if found && !usage.V3TokenNeedsRenew() && usage.V3Token != role.rejectedV3Token {
+		b.Logger().Info(fmt.Sprintf("token for role %s was already renewed", role.Name))
+		return usage, nil
+	}
+
+	b.Logger().Info(fmt.Sprintf("stored token for role %s needs to be renewed for further operations", role.Name))
+
+	creds := role.asV3Credentials()
+
+	if tkn, err := b.GetOAuthHelper(role).RetrieveAccessTokenFor(&creds); err != nil {
+		b.Logger().Error(fmt.Sprintf("attempt to renew token for role %s failed: %s", role.Name, err.Error()))
+		return usage, err
+	} else {
+		usage.ReplaceAccessToken(tkn.AccessToken, tkn.ExpiryTime().Unix())
+		b.Logger().Info(fmt.Sprintf("successfully renewed token for role %s", role.Name))
+
+		if writeErr := reqCtx.WritePath(ctx, roleUsagePath(reqCtx), &usage); writeErr != nil {
+			b.Logger().Error(fmt.Sprintf("failed to persist acquired token for role %s: %s", role.Name, writeErr.Error()))
+			return usage, writeErr
+		}
+	}
+
+	return usage, nil
+}
+
 func retrieveV3AccessToken(_ context.Context, reqCtx *RequestHandlerContext[V3TokenContext]) (*logical.Response, error) {
 	role := reqCtx.heap.GetRole()
 
 	v3Credentials := role.asV3Credentials()
-	if tkn, err := reqCtx.plugin.GetOAuthHelper().RetrieveAccessTokenFor(&v3Credentials); err != nil {
+	if tkn, err := reqCtx.plugin.GetOAuthHelper(role).RetrieveAccessTokenFor(&v3Credentials); err != nil {
 		return nil, errwrap.Wrapf("access token was not granted by Mashery: {{err}}", err)
 	} else if tkn.ServerTime.Unix() > 0 && role.Usage.AfterExpiryTerm(tkn.ServerTime) {
 		return nil, errors.New("your system's clock is skewed. Mashery response is after expiry term of your role grant")
@@ -389,6 +427,34 @@ func retrieveV3AccessToken(_ context.Context, reqCtx *RequestHandlerContext[V3To
 	}
 }
 
+// exchangeV3RefreshToken exchanges the refresh token carried by the renewed lease for a new access token.
+func exchangeV3RefreshToken(_ context.Context, reqCtx *RequestHandlerContext[V3TokenContext]) (*logical.Response, error) {
+	if reqCtx.request.Secret == nil {
+		return nil, errors.New("refresh token exchange requires a lease to renew")
+	}
+
+	refreshToken, _ := reqCtx.request.Secret.InternalData[secretInternalRefreshToken].(string)
+	if len(refreshToken) == 0 {
+		return logical.ErrorResponse("this lease does not carry a refresh token; request a new lease instead"), nil
+	}
+
+	role := reqCtx.heap.GetRole()
+
+	v3Credentials := role.asV3Credentials()
+	if tkn, err := reqCtx.plugin.GetOAuthHelper(role).ExchangeRefreshToken(&v3Credentials, refreshToken); err != nil {
+		return nil, errwrap.Wrapf("refresh token was not exchanged by Mashery: {{err}}", err)
+	} else if tkn.ServerTime.Unix() > 0 && role.Usage.AfterExpiryTerm(tkn.ServerTime) {
+		return nil, errors.New("your system's clock is skewed. Mashery response is after expiry term of your role grant")
+	} else {
+		reqCtx.heap.CarryV3TokenResponse(tkn)
+		return nil, nil
+	}
+}
+
+func renderV3LeaseRenewal(_ context.Context, reqCtx *RequestHandlerContext[V3TokenContext]) (*logical.Response, error) {
+	return reqCtx.plugin.createV3LeaseRenewal(reqCtx.request.Secret, reqCtx.heap.GetV3TokenResponse(), reqCtx.heap.GetRole()), nil
+}
+
 func renderV3LeaseResponse(_ context.Context, reqCtx *RequestHandlerContext[V3TokenContext]) (*logical.Response, error) {
 	return reqCtx.plugin.createV3LeasedResponse(reqCtx.heap.GetV3TokenResponse(), reqCtx.heap.GetRole()), nil
 }
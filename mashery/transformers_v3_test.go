@@ -4,8 +4,11 @@ import (
 	"context"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/transport"
 	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -49,7 +52,7 @@ func (dm *FetchWithErrorHandlingMock) FetchFunctionMock(ctx context.Context, cli
 //	}), mock.Anything).Return(okRequestResponse(), nil).Once()
 //
 //	b := AuthPlugin{
-//		v3Clients: map[string]V3ClientAndAuthorizer{},
+//		v3Clients: newV3ClientPool(),
 //	}
 //	wr, err := b.doFetchWithErrorHandling(context.TODO(), reqCtx, dm.MockTokenRefresh, dm.FetchFunctionMock)
 //
@@ -83,7 +86,7 @@ func TestFetchWithErrorHandlingWillResetAccessTokenAfter403(t *testing.T) {
 	}), mock.Anything).Return(okRequestResponse(), nil).Once()
 
 	b := AuthPlugin{
-		v3Clients: map[string]V3ClientAndAuthorizer{},
+		v3Clients: newV3ClientPool(),
 	}
 	wr, err := b.doFetchWithErrorHandling(context.TODO(), reqCtx, dm.MockTokenRefresh, dm.FetchFunctionMock)
 
@@ -107,7 +110,7 @@ func TestFetchWithErrorHandlingWillReturnErrorOnDeniedAccess(t *testing.T) {
 	}), mock.Anything).Return(accessDeniedAccessTokenRequestResponse(), nil).Once()
 
 	b := AuthPlugin{
-		v3Clients: map[string]V3ClientAndAuthorizer{},
+		v3Clients: newV3ClientPool(),
 	}
 	wr, err := b.doFetchWithErrorHandling(context.TODO(), reqCtx, dm.MockTokenRefresh, dm.FetchFunctionMock)
 
@@ -118,6 +121,76 @@ func TestFetchWithErrorHandlingWillReturnErrorOnDeniedAccess(t *testing.T) {
 	dm.AssertExpectations(t)
 }
 
+func TestEnsureAccessTokenValidForRole_WillReuseTokenRenewedByConcurrentRequest(t *testing.T) {
+	renewedUsage := StoredRoleUsage{
+		V3Token:          "renewed",
+		V3TokenExpiry:    time.Now().Add(time.Hour).Unix(),
+		RemainingNumUses: 17,
+	}
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{Name: "role"})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	emulStorage.
+		On("Get", mock.Anything, roleUsagePath(reqCtx)).
+		Return(createJsonStorageEntryFrom(t, roleUsagePath(reqCtx), &renewedUsage), nil)
+
+	role := reqCtx.heap.GetRole()
+	err := ensureAccessTokenValidForRole(context.TODO(), reqCtx.plugin, reqCtx, role)
+	emulStorage.AssertExpectations(t)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "renewed", role.Usage.V3Token)
+	assert.Equal(t, renewedUsage.V3TokenExpiry, role.Usage.V3TokenExpiry)
+	// Only the token is taken over from the storage
+	assert.Equal(t, int64(0), role.Usage.RemainingNumUses)
+}
+
+func TestEnsureAccessTokenValidForRole_WillNotTakeOverRejectedToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-1","expires_in":3600}`))
+	}))
+	defer srv.Close()
+
+	emulStorage, reqCtx := setupRoleRequestMockHaving(StoredRole{
+		Name: "role",
+		Keys: RoleKeys{
+			ApiKey:             "key",
+			KeySecret:          "secret",
+			Username:           "user",
+			Password:           "pwd",
+			OAuthTokenEndpoint: srv.URL,
+		},
+		rejectedV3Token: "rejected",
+	})
+	reqCtx.plugin.Backend = &framework.Backend{}
+	reqCtx.plugin.v3OAuthHelpers = map[string]*v3client.V3OAuthHelper{}
+	emulStorage.On("Get", mock.Anything, roleUsagePath(reqCtx)).Return(nil, nil)
+	emulStorage.On("Put", mock.Anything, storageEntryAt(roleUsagePath(reqCtx))).Return(nil)
+
+	// Another request leads a refresh that ends with the token Mashery has rejected for this request
+	release := make(chan struct{})
+	go func() {
+		_, _ = reqCtx.plugin.v3TokenRefreshes.Do(reqCtx.storagePath, func() (StoredRoleUsage, error) {
+			<-release
+			return StoredRoleUsage{V3Token: "rejected", V3TokenExpiry: time.Now().Add(time.Hour).Unix()}, nil
+		})
+	}()
+	time.Sleep(time.Millisecond * 20)
+
+	role := reqCtx.heap.GetRole()
+	done := make(chan error)
+	go func() {
+		done <- ensureAccessTokenValidForRole(context.TODO(), reqCtx.plugin, reqCtx, role)
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+
+	assert.Nil(t, <-done)
+	assert.Equal(t, "access-1", role.Usage.V3Token)
+}
+
 func refreshAccessTokenTo(val string) func(args mock.Arguments) {
 	return func(args mock.Arguments) {
 		ctx := args.Get(1).(*RequestHandlerContext[WildcardAPIResponseContext])
@@ -0,0 +1,229 @@
+package mashery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/v2client"
+	"github.com/hashicorp/vault/sdk/logical"
+	"strconv"
+	"strings"
+)
+
+const (
+	verifyField = "verify"
+
+	masheryErrorCodeHeader = "X-Mashery-Error-Code"
+	// V2 method that echoes its parameters back, changing nothing in the area
+	v2VerificationMethod = "test.echo"
+
+	areaVerificationMatch      = "match"
+	areaVerificationMismatch   = "mismatch"
+	areaVerificationUnverified = "unverified"
+)
+
+// CredentialVerification outcome of verifying the credentials of a role against Mashery
+type CredentialVerification struct {
+	V3   *CapabilityVerification
+	V2   *CapabilityVerification
+	Area string
+}
+
+// CapabilityVerification outcome of verifying a single API capability. The error code is the code Mashery
+// responded with, if any.
+type CapabilityVerification struct {
+	Verified  bool
+	ErrorCode string
+	Error     string
+}
+
+func (cv *CapabilityVerification) asMap() map[string]interface{} {
+	rv := map[string]interface{}{
+		"verified": cv.Verified,
+	}
+	if len(cv.ErrorCode) > 0 {
+		rv["error_code"] = cv.ErrorCode
+	}
+	if len(cv.Error) > 0 {
+		rv["error"] = cv.Error
+	}
+
+	return rv
+}
+
+// Verified whether each capability of the role was verified, and the area UUID and NID do not contradict each other.
+func (cv *CredentialVerification) Verified() bool {
+	return (cv.V3 == nil || cv.V3.Verified) && (cv.V2 == nil || cv.V2.Verified) && cv.Area != areaVerificationMismatch
+}
+
+// failures describes each failed verification, e.g. "v3: invalid_client: ..."
+func (cv *CredentialVerification) failures() []string {
+	var rv []string
+	describe := func(capability string, v *CapabilityVerification) {
+		if v == nil || v.Verified {
+			return
+		}
+		if len(v.ErrorCode) > 0 {
+			rv = append(rv, fmt.Sprintf("%s: %s: %s", capability, v.ErrorCode, v.Error))
+		} else {
+			rv = append(rv, fmt.Sprintf("%s: %s", capability, v.Error))
+		}
+	}
+
+	describe("v3", cv.V3)
+	describe("v2", cv.V2)
+	if cv.Area == areaVerificationMismatch {
+		rv = append(rv, "area: the area UUID and the area NID identify different areas")
+	}
+
+	return rv
+}
+
+func (cv *CredentialVerification) asMap() map[string]interface{} {
+	rv := map[string]interface{}{
+		"verified": cv.Verified(),
+		"area":     cv.Area,
+	}
+	if cv.V3 != nil {
+		rv["v3"] = cv.V3.asMap()
+	}
+	if cv.V2 != nil {
+		rv["v2"] = cv.V2.asMap()
+	}
+
+	return rv
+}
+
+// verifyRoleCredentials verifies the credentials of the role: the V3 credentials by obtaining an access token,
+// the V2 credentials by a signed call that changes nothing. A package key belongs to a single area. When the same
+// key is accepted for the area UUID and for the area NID, both identify the same area.
+func verifyRoleCredentials(ctx context.Context, b *AuthPlugin, role *StoredRole) *CredentialVerification {
+	rv := &CredentialVerification{Area: areaVerificationUnverified}
+
+	if role.Keys.IsV3Capable() {
+		rv.V3 = &CapabilityVerification{}
+
+		creds := role.asV3Credentials()
+		if tkn, err := b.GetOAuthHelper(role).RetrieveAccessTokenFor(&creds); err != nil {
+			rv.V3.Error = err.Error()
+			rv.V3.ErrorCode = oauthErrorCode(err.Error())
+		} else if len(tkn.Scope) > 0 && tkn.Scope != role.Keys.AreaId {
+			rv.V3.Error = fmt.Sprintf("access token was granted for area %s", tkn.Scope)
+			rv.Area = areaVerificationMismatch
+		} else {
+			rv.V3.Verified = true
+		}
+	}
+
+	if role.Keys.IsV2Capable() {
+		rv.V2 = verifyV2Credentials(ctx, b, role)
+	}
+
+	if rv.Area != areaVerificationMismatch && rv.V3 != nil && rv.V3.Verified && rv.V2 != nil && rv.V2.Verified {
+		rv.Area = areaVerificationMatch
+	}
+
+	return rv
+}
+
+func verifyV2Credentials(ctx context.Context, b *AuthPlugin, role *StoredRole) *CapabilityVerification {
+	rv := &CapabilityVerification{}
+
+	req := v2client.V2Request{
+		Id:      1,
+		Method:  v2VerificationMethod,
+		Version: "2.0",
+		Params:  []interface{}{"vault"},
+	}
+
+	resp, err := b.GetMasheryV2Client(ctx, role).GetRawResponse(ctx, req)
+	if err != nil {
+		rv.Error = err.Error()
+		return rv
+	} else if resp.StatusCode != 200 {
+		rv.ErrorCode = resp.Header.Get(masheryErrorCodeHeader)
+		if len(rv.ErrorCode) == 0 {
+			rv.ErrorCode = strconv.Itoa(resp.StatusCode)
+		}
+		rv.Error = fmt.Sprintf("mashery responded with status %d", resp.StatusCode)
+		return rv
+	}
+
+	result := v2client.V2Result{}
+	if body, err := resp.Body(); err != nil {
+		rv.Error = err.Error()
+	} else if err = json.Unmarshal(body, &result); err != nil {
+		rv.Error = fmt.Sprintf("mashery response cannot be parsed (%s)", err.Error())
+	} else if result.Error != nil {
+		rv.ErrorCode = strconv.Itoa(result.Error.Code)
+		rv.Error = result.Error.Message
+	} else {
+		rv.Verified = true
+	}
+
+	return rv
+}
+
+// oauthErrorCode extracts the OAuth error code, e.g. invalid_client, from the error of the token request.
+func oauthErrorCode(msg string) string {
+	idx := strings.Index(msg, "{")
+	if idx < 0 {
+		return ""
+	}
+
+	body := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal([]byte(msg[idx:]), &body); err != nil {
+		return ""
+	}
+
+	return body.Error
+}
+
+// verifyRoleCredentialsInto verifies the credentials of the role before these are saved. Failed verification stops
+// the chain, so that the stored credentials remain untouched.
+func verifyRoleCredentialsInto(report *CredentialVerification) TransformerFunc[RoleContext] {
+	return func(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		role := reqCtx.heap.GetRole()
+		if !role.Keys.IsV2Capable() && !role.Keys.IsV3Capable() {
+			return logical.ErrorResponse("role %s has no credentials to verify", role.Name), nil
+		}
+
+		*report = *verifyRoleCredentials(ctx, reqCtx.plugin, role)
+		if !report.Verified() {
+			// Vault treats a response carrying anything beyond the error as a success; the details are warnings
+			resp := logical.ErrorResponse("credentials of role %s were not verified by Mashery; the role was not changed", role.Name)
+			for _, f := range report.failures() {
+				resp.AddWarning(f)
+			}
+			return resp, nil
+		}
+
+		return nil, nil
+	}
+}
+
+// renderCredentialVerification renders the outcome of verifying the credentials of the role.
+func renderCredentialVerification(report *CredentialVerification) TransformerFunc[RoleContext] {
+	return func(_ context.Context, _ *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"verification": report.asMap(),
+			},
+		}, nil
+	}
+}
+
+// renderRoleCredentialVerification verifies the stored credentials of the role and renders the outcome, whether
+// the credentials were verified or not.
+func renderRoleCredentialVerification(ctx context.Context, reqCtx *RequestHandlerContext[RoleContext]) (*logical.Response, error) {
+	role := reqCtx.heap.GetRole()
+	if !role.Keys.IsV2Capable() && !role.Keys.IsV3Capable() {
+		return logical.ErrorResponse("role %s has no credentials to verify", role.Name), nil
+	}
+
+	return &logical.Response{
+		Data: verifyRoleCredentials(ctx, reqCtx.plugin, role).asMap(),
+	}, nil
+}
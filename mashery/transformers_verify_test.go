@@ -0,0 +1,177 @@
+package mashery
+
+import (
+	"context"
+	"github.com/aliakseiyanchuk/mashery-v3-go-client/v3client"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// startTestMasheryServer emulates the Mashery OAuth token endpoint and the V2 endpoint of area 123
+func startTestMasheryServer(tokenScope string, v2Status int, v2ErrorCode string, v2Body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/token":
+			_, _, _ = r.BasicAuth()
+			_ = r.ParseForm()
+			if r.Form.Get("password") != "pwd" {
+				w.WriteHeader(400)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"bad password"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"access-1","expires_in":3600,"scope":"` + tokenScope + `"}`))
+		case "/v2/123":
+			if len(v2ErrorCode) > 0 {
+				w.Header().Set(masheryErrorCodeHeader, v2ErrorCode)
+			}
+			w.WriteHeader(v2Status)
+			_, _ = w.Write([]byte(v2Body))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func setupTestCredentialVerification(srv *httptest.Server, data map[string]interface{}) (*MockedVaultStorageWrapper, *RequestHandlerContext[RoleContext]) {
+	builder := RoleRequestMockBuilder[RoleContext]{
+		container: &RoleContainer{
+			role: &StoredRole{
+				Name: "testRole",
+				Keys: RoleKeys{
+					AreaId:             "area-uuid",
+					AreaNid:            123,
+					ApiKey:             "key",
+					KeySecret:          "secret",
+					Username:           "user",
+					Password:           "pwd",
+					MaxQPS:             10,
+					OAuthTokenEndpoint: srv.URL + "/token",
+					V2Endpoint:         srv.URL + "/v2",
+				},
+			},
+		},
+		data:        data,
+		fieldSchema: pathRoleFields,
+	}
+
+	emulStore, reqCtx := builder.Build()
+	reqCtx.plugin.Backend = &framework.Backend{}
+	reqCtx.plugin.v3OAuthHelpers = map[string]*v3client.V3OAuthHelper{}
+	reqCtx.plugin.v2Clients = newV2ClientPool()
+
+	return emulStore, reqCtx
+}
+
+func TestVerifyRoleCredentials_VerifiesBothCapabilities(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	_, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{})
+
+	lr, err := renderRoleCredentialVerification(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.Equal(t, true, lr.Data["verified"])
+	assert.Equal(t, areaVerificationMatch, lr.Data["area"])
+	assert.Equal(t, map[string]interface{}{"verified": true}, lr.Data["v3"])
+	assert.Equal(t, map[string]interface{}{"verified": true}, lr.Data["v2"])
+}
+
+func TestVerifyRoleCredentials_ReportsMasheryErrorCodes(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 403, "ERR_403_DEVELOPER_INACTIVE", `{}`)
+	defer srv.Close()
+
+	_, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{})
+	reqCtx.heap.GetRole().Keys.Password = "typo"
+
+	report := verifyRoleCredentials(context.TODO(), reqCtx.plugin, reqCtx.heap.GetRole())
+	assert.False(t, report.Verified())
+	assert.Equal(t, areaVerificationUnverified, report.Area)
+	assert.False(t, report.V3.Verified)
+	assert.Equal(t, "invalid_grant", report.V3.ErrorCode)
+	assert.False(t, report.V2.Verified)
+	assert.Equal(t, "ERR_403_DEVELOPER_INACTIVE", report.V2.ErrorCode)
+}
+
+func TestVerifyRoleCredentials_ReportsV2RPCError(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"error":{"code":4000,"message":"Not authorized"}}`)
+	defer srv.Close()
+
+	_, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{})
+	reqCtx.heap.GetRole().Keys.AreaId = ""
+
+	report := verifyRoleCredentials(context.TODO(), reqCtx.plugin, reqCtx.heap.GetRole())
+	assert.Nil(t, report.V3)
+	assert.Equal(t, &CapabilityVerification{ErrorCode: "4000", Error: "Not authorized"}, report.V2)
+}
+
+func TestVerifyRoleCredentials_DetectsAreaMismatch(t *testing.T) {
+	srv := startTestMasheryServer("other-area", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	_, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{})
+
+	report := verifyRoleCredentials(context.TODO(), reqCtx.plugin, reqCtx.heap.GetRole())
+	assert.False(t, report.Verified())
+	assert.Equal(t, areaVerificationMismatch, report.Area)
+	assert.Equal(t, "access token was granted for area other-area", report.V3.Error)
+	assert.True(t, report.V2.Verified)
+	assert.Equal(t, []string{
+		"v3: access token was granted for area other-area",
+		"area: the area UUID and the area NID identify different areas",
+	}, report.failures())
+}
+
+func TestOAuthErrorCode(t *testing.T) {
+	assert.Equal(t, "invalid_client", oauthErrorCode(`server returned unexpected error code 401 with message {"error":"invalid_client"}`))
+	assert.Equal(t, "", oauthErrorCode("connection refused"))
+	assert.Equal(t, "", oauthErrorCode("message {not json"))
+}
+
+func TestHandleWriteRoleKeys_KeepsKeysOnFailedVerification(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	emulStore, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{
+		rolePasswordField: "typo",
+	})
+
+	report := &CredentialVerification{}
+	chain := SimpleChain(
+		updateRoleKeysFromRequest,
+		verifyRoleCredentialsInto(report),
+		saveRoleKeys[RoleContext],
+	)
+
+	lr, err := chain(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.True(t, lr.IsError())
+	assert.Equal(t, "credentials of role testRole were not verified by Mashery; the role was not changed", lr.Error().Error())
+	assert.Equal(t, []string{
+		`v3: invalid_grant: server returned unexpected error code 400 with message {"error":"invalid_grant","error_description":"bad password"}`,
+	}, lr.Warnings)
+	emulStore.AssertNotCalled(t, "Put", mock.Anything, mock.Anything)
+}
+
+func TestHandleWriteRoleKeys_SavesVerifiedKeys(t *testing.T) {
+	srv := startTestMasheryServer("area-uuid", 200, "", `{"jsonrpc":"2.0","id":1,"result":["vault"]}`)
+	defer srv.Close()
+
+	emulStore, reqCtx := setupTestCredentialVerification(srv, map[string]interface{}{
+		roleQpsField: 5,
+		verifyField:  true,
+	})
+	emulStore.On("Put", mock.Anything, storageEntryAt(roleKeysPath(reqCtx))).Return(nil)
+
+	steps := appendRoleKeysSaving(reqCtx.data, []TransformerFunc[RoleContext]{updateRoleKeysFromRequest}, saveRoleKeys[RoleContext])
+	lr, err := SimpleChain(steps...)(context.TODO(), reqCtx)
+	assert.Nil(t, err)
+	assert.False(t, lr.IsError())
+	assert.Equal(t, true, lr.Data["verification"].(map[string]interface{})["verified"])
+	emulStore.AssertExpectations(t)
+}